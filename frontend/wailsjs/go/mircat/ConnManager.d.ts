@@ -1,5 +1,22 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
+import {codec, mircat} from '../models';
+
+export function BotStart():Promise<boolean>;
+
+export function BotStop():Promise<boolean>;
+
+export function CapturePath():Promise<string>;
+
+export function CaptureStart(arg1:string):Promise<boolean>;
+
+export function CaptureStop():Promise<boolean>;
+
+export function CatalogGet():Promise<codec.Catalog>;
+
+export function CatalogReload():Promise<boolean>;
+
+export function CatalogSet(arg1:codec.Catalog):Promise<boolean>;
 
 export function ClientTcpClose(arg1:number):Promise<void>;
 
@@ -9,22 +26,136 @@ export function ClientTcpOpen():Promise<number>;
 
 export function ClientTcpSend(arg1:number,arg2:string):Promise<void>;
 
+export function ClientUdpClose(arg1:number):Promise<void>;
+
+export function ClientUdpCloseAll():Promise<void>;
+
+export function ClientUdpOpen():Promise<number>;
+
+export function ClientUdpSend(arg1:number,arg2:string):Promise<void>;
+
+export function DecodeMirPacket(arg1:string):Promise<codec.Packet>;
+
+export function EncodeMirPacket(arg1:codec.Packet):Promise<string>;
+
+export function GameSessions():Promise<Array<string>>;
+
+export function GameStateGet(arg1:string):Promise<mircat.GameState>;
+
+export function GameStateReset(arg1:string):Promise<void>;
+
+export function PcapFlows(arg1:string):Promise<Array<mircat.PcapFlow>>;
+
+export function PcapImport(arg1:string,arg2:mircat.PcapFilter):Promise<number>;
+
+export function ReplayStart():Promise<boolean>;
+
+export function ReplayStop():Promise<boolean>;
+
 export function ServerBroadcastMessage(arg1:string):Promise<void>;
 
+export function ServerBroadcastMessageInstance(arg1:string,arg2:string):Promise<void>;
+
+export function ServerInstances():Promise<Array<string>>;
+
 export function ServerSendMessage(arg1:string,arg2:string):Promise<void>;
 
+export function ServerSendMessageInstance(arg1:string,arg2:string,arg3:string):Promise<void>;
+
 export function ServerTcpStart():Promise<boolean>;
 
+export function ServerTcpStartInstance(arg1:string):Promise<boolean>;
+
 export function ServerTcpStop():Promise<boolean>;
 
+export function ServerTcpStopInstance(arg1:string):Promise<boolean>;
+
+export function ServerUdpBroadcastMessage(arg1:string):Promise<void>;
+
+export function ServerUdpPeers():Promise<Array<string>>;
+
+export function ServerUdpSendMessage(arg1:string,arg2:string):Promise<void>;
+
+export function ServerUdpStart():Promise<boolean>;
+
+export function ServerUdpStop():Promise<boolean>;
+
+export function TlsCaExport():Promise<string>;
+
 export function TransferBroadcastToClient(arg1:string):Promise<void>;
 
+export function TransferBroadcastToClientInstance(arg1:string,arg2:string):Promise<void>;
+
 export function TransferBroadcastToServer(arg1:string):Promise<void>;
 
+export function TransferBroadcastToServerInstance(arg1:string,arg2:string):Promise<void>;
+
+export function TransferGetForwardMode(arg1:string):Promise<string>;
+
+export function TransferGetForwardModeInstance(arg1:string,arg2:string):Promise<string>;
+
+export function TransferInstances():Promise<Array<string>>;
+
+export function TransferInterceptDrop(arg1:number):Promise<boolean>;
+
+export function TransferInterceptDropInstance(arg1:string,arg2:number):Promise<boolean>;
+
+export function TransferInterceptEdit(arg1:number,arg2:string):Promise<boolean>;
+
+export function TransferInterceptEditInstance(arg1:string,arg2:number,arg3:string):Promise<boolean>;
+
+export function TransferInterceptForward(arg1:number):Promise<boolean>;
+
+export function TransferInterceptForwardAll(arg1:string):Promise<void>;
+
+export function TransferInterceptForwardAllInstance(arg1:string,arg2:string):Promise<void>;
+
+export function TransferInterceptForwardInstance(arg1:string,arg2:number):Promise<boolean>;
+
+export function TransferInterceptGet(arg1:string):Promise<mircat.InterceptSettings>;
+
+export function TransferInterceptGetInstance(arg1:string,arg2:string):Promise<mircat.InterceptSettings>;
+
+export function TransferInterceptList(arg1:string):Promise<Array<mircat.InterceptedPacket>>;
+
+export function TransferInterceptListInstance(arg1:string,arg2:string):Promise<Array<mircat.InterceptedPacket>>;
+
+export function TransferInterceptSet(arg1:string,arg2:mircat.InterceptSettings):Promise<boolean>;
+
+export function TransferInterceptSetInstance(arg1:string,arg2:string,arg3:mircat.InterceptSettings):Promise<boolean>;
+
 export function TransferSendToClient(arg1:string,arg2:string):Promise<void>;
 
+export function TransferSendToClientInstance(arg1:string,arg2:string,arg3:string):Promise<void>;
+
 export function TransferSendToServer(arg1:string,arg2:string):Promise<void>;
 
+export function TransferSendToServerInstance(arg1:string,arg2:string,arg3:string):Promise<void>;
+
+export function TransferSetForwardMode(arg1:string,arg2:string):Promise<boolean>;
+
+export function TransferSetForwardModeInstance(arg1:string,arg2:string,arg3:string):Promise<boolean>;
+
+export function TransferShapingGet(arg1:string):Promise<mircat.TrafficShaping>;
+
+export function TransferShapingGetInstance(arg1:string,arg2:string):Promise<mircat.TrafficShaping>;
+
+export function TransferShapingSet(arg1:string,arg2:mircat.TrafficShaping):Promise<boolean>;
+
+export function TransferShapingSetInstance(arg1:string,arg2:string,arg3:mircat.TrafficShaping):Promise<boolean>;
+
 export function TransferTcpStart():Promise<boolean>;
 
+export function TransferTcpStartInstance(arg1:string):Promise<boolean>;
+
 export function TransferTcpStop():Promise<boolean>;
+
+export function TransferTcpStopInstance(arg1:string):Promise<boolean>;
+
+export function TransferUdpSendToClient(arg1:string,arg2:string):Promise<void>;
+
+export function TransferUdpSendToServer(arg1:string,arg2:string):Promise<void>;
+
+export function TransferUdpStart():Promise<boolean>;
+
+export function TransferUdpStop():Promise<boolean>;
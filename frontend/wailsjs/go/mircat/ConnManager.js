@@ -2,6 +2,38 @@
 // Cynhyrchwyd y ffeil hon yn awtomatig. PEIDIWCH Â MODIWL
 // This file is automatically generated. DO NOT EDIT
 
+export function BotStart() {
+  return window['go']['mircat']['ConnManager']['BotStart']();
+}
+
+export function BotStop() {
+  return window['go']['mircat']['ConnManager']['BotStop']();
+}
+
+export function CapturePath() {
+  return window['go']['mircat']['ConnManager']['CapturePath']();
+}
+
+export function CaptureStart(arg1) {
+  return window['go']['mircat']['ConnManager']['CaptureStart'](arg1);
+}
+
+export function CaptureStop() {
+  return window['go']['mircat']['ConnManager']['CaptureStop']();
+}
+
+export function CatalogGet() {
+  return window['go']['mircat']['ConnManager']['CatalogGet']();
+}
+
+export function CatalogReload() {
+  return window['go']['mircat']['ConnManager']['CatalogReload']();
+}
+
+export function CatalogSet(arg1) {
+  return window['go']['mircat']['ConnManager']['CatalogSet'](arg1);
+}
+
 export function ClientTcpClose(arg1) {
   return window['go']['mircat']['ConnManager']['ClientTcpClose'](arg1);
 }
@@ -18,42 +50,270 @@ export function ClientTcpSend(arg1, arg2) {
   return window['go']['mircat']['ConnManager']['ClientTcpSend'](arg1, arg2);
 }
 
+export function ClientUdpClose(arg1) {
+  return window['go']['mircat']['ConnManager']['ClientUdpClose'](arg1);
+}
+
+export function ClientUdpCloseAll() {
+  return window['go']['mircat']['ConnManager']['ClientUdpCloseAll']();
+}
+
+export function ClientUdpOpen() {
+  return window['go']['mircat']['ConnManager']['ClientUdpOpen']();
+}
+
+export function ClientUdpSend(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['ClientUdpSend'](arg1, arg2);
+}
+
+export function DecodeMirPacket(arg1) {
+  return window['go']['mircat']['ConnManager']['DecodeMirPacket'](arg1);
+}
+
+export function EncodeMirPacket(arg1) {
+  return window['go']['mircat']['ConnManager']['EncodeMirPacket'](arg1);
+}
+
+export function GameSessions() {
+  return window['go']['mircat']['ConnManager']['GameSessions']();
+}
+
+export function GameStateGet(arg1) {
+  return window['go']['mircat']['ConnManager']['GameStateGet'](arg1);
+}
+
+export function GameStateReset(arg1) {
+  return window['go']['mircat']['ConnManager']['GameStateReset'](arg1);
+}
+
+export function PcapFlows(arg1) {
+  return window['go']['mircat']['ConnManager']['PcapFlows'](arg1);
+}
+
+export function PcapImport(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['PcapImport'](arg1, arg2);
+}
+
+export function ReplayStart() {
+  return window['go']['mircat']['ConnManager']['ReplayStart']();
+}
+
+export function ReplayStop() {
+  return window['go']['mircat']['ConnManager']['ReplayStop']();
+}
+
 export function ServerBroadcastMessage(arg1) {
   return window['go']['mircat']['ConnManager']['ServerBroadcastMessage'](arg1);
 }
 
+export function ServerBroadcastMessageInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['ServerBroadcastMessageInstance'](arg1, arg2);
+}
+
+export function ServerInstances() {
+  return window['go']['mircat']['ConnManager']['ServerInstances']();
+}
+
 export function ServerSendMessage(arg1, arg2) {
   return window['go']['mircat']['ConnManager']['ServerSendMessage'](arg1, arg2);
 }
 
+export function ServerSendMessageInstance(arg1, arg2, arg3) {
+  return window['go']['mircat']['ConnManager']['ServerSendMessageInstance'](arg1, arg2, arg3);
+}
+
 export function ServerTcpStart() {
   return window['go']['mircat']['ConnManager']['ServerTcpStart']();
 }
 
+export function ServerTcpStartInstance(arg1) {
+  return window['go']['mircat']['ConnManager']['ServerTcpStartInstance'](arg1);
+}
+
 export function ServerTcpStop() {
   return window['go']['mircat']['ConnManager']['ServerTcpStop']();
 }
 
+export function ServerTcpStopInstance(arg1) {
+  return window['go']['mircat']['ConnManager']['ServerTcpStopInstance'](arg1);
+}
+
+export function ServerUdpBroadcastMessage(arg1) {
+  return window['go']['mircat']['ConnManager']['ServerUdpBroadcastMessage'](arg1);
+}
+
+export function ServerUdpPeers() {
+  return window['go']['mircat']['ConnManager']['ServerUdpPeers']();
+}
+
+export function ServerUdpSendMessage(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['ServerUdpSendMessage'](arg1, arg2);
+}
+
+export function ServerUdpStart() {
+  return window['go']['mircat']['ConnManager']['ServerUdpStart']();
+}
+
+export function ServerUdpStop() {
+  return window['go']['mircat']['ConnManager']['ServerUdpStop']();
+}
+
+export function TlsCaExport() {
+  return window['go']['mircat']['ConnManager']['TlsCaExport']();
+}
+
 export function TransferBroadcastToClient(arg1) {
   return window['go']['mircat']['ConnManager']['TransferBroadcastToClient'](arg1);
 }
 
+export function TransferBroadcastToClientInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferBroadcastToClientInstance'](arg1, arg2);
+}
+
 export function TransferBroadcastToServer(arg1) {
   return window['go']['mircat']['ConnManager']['TransferBroadcastToServer'](arg1);
 }
 
+export function TransferBroadcastToServerInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferBroadcastToServerInstance'](arg1, arg2);
+}
+
+export function TransferGetForwardMode(arg1) {
+  return window['go']['mircat']['ConnManager']['TransferGetForwardMode'](arg1);
+}
+
+export function TransferGetForwardModeInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferGetForwardModeInstance'](arg1, arg2);
+}
+
+export function TransferInstances() {
+  return window['go']['mircat']['ConnManager']['TransferInstances']();
+}
+
+export function TransferInterceptDrop(arg1) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptDrop'](arg1);
+}
+
+export function TransferInterceptDropInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptDropInstance'](arg1, arg2);
+}
+
+export function TransferInterceptEdit(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptEdit'](arg1, arg2);
+}
+
+export function TransferInterceptEditInstance(arg1, arg2, arg3) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptEditInstance'](arg1, arg2, arg3);
+}
+
+export function TransferInterceptForward(arg1) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptForward'](arg1);
+}
+
+export function TransferInterceptForwardAll(arg1) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptForwardAll'](arg1);
+}
+
+export function TransferInterceptForwardAllInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptForwardAllInstance'](arg1, arg2);
+}
+
+export function TransferInterceptForwardInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptForwardInstance'](arg1, arg2);
+}
+
+export function TransferInterceptGet(arg1) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptGet'](arg1);
+}
+
+export function TransferInterceptGetInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptGetInstance'](arg1, arg2);
+}
+
+export function TransferInterceptList(arg1) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptList'](arg1);
+}
+
+export function TransferInterceptListInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptListInstance'](arg1, arg2);
+}
+
+export function TransferInterceptSet(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptSet'](arg1, arg2);
+}
+
+export function TransferInterceptSetInstance(arg1, arg2, arg3) {
+  return window['go']['mircat']['ConnManager']['TransferInterceptSetInstance'](arg1, arg2, arg3);
+}
+
 export function TransferSendToClient(arg1, arg2) {
   return window['go']['mircat']['ConnManager']['TransferSendToClient'](arg1, arg2);
 }
 
+export function TransferSendToClientInstance(arg1, arg2, arg3) {
+  return window['go']['mircat']['ConnManager']['TransferSendToClientInstance'](arg1, arg2, arg3);
+}
+
 export function TransferSendToServer(arg1, arg2) {
   return window['go']['mircat']['ConnManager']['TransferSendToServer'](arg1, arg2);
 }
 
+export function TransferSendToServerInstance(arg1, arg2, arg3) {
+  return window['go']['mircat']['ConnManager']['TransferSendToServerInstance'](arg1, arg2, arg3);
+}
+
+export function TransferSetForwardMode(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferSetForwardMode'](arg1, arg2);
+}
+
+export function TransferSetForwardModeInstance(arg1, arg2, arg3) {
+  return window['go']['mircat']['ConnManager']['TransferSetForwardModeInstance'](arg1, arg2, arg3);
+}
+
+export function TransferShapingGet(arg1) {
+  return window['go']['mircat']['ConnManager']['TransferShapingGet'](arg1);
+}
+
+export function TransferShapingGetInstance(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferShapingGetInstance'](arg1, arg2);
+}
+
+export function TransferShapingSet(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferShapingSet'](arg1, arg2);
+}
+
+export function TransferShapingSetInstance(arg1, arg2, arg3) {
+  return window['go']['mircat']['ConnManager']['TransferShapingSetInstance'](arg1, arg2, arg3);
+}
+
 export function TransferTcpStart() {
   return window['go']['mircat']['ConnManager']['TransferTcpStart']();
 }
 
+export function TransferTcpStartInstance(arg1) {
+  return window['go']['mircat']['ConnManager']['TransferTcpStartInstance'](arg1);
+}
+
 export function TransferTcpStop() {
   return window['go']['mircat']['ConnManager']['TransferTcpStop']();
 }
+
+export function TransferTcpStopInstance(arg1) {
+  return window['go']['mircat']['ConnManager']['TransferTcpStopInstance'](arg1);
+}
+
+export function TransferUdpSendToClient(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferUdpSendToClient'](arg1, arg2);
+}
+
+export function TransferUdpSendToServer(arg1, arg2) {
+  return window['go']['mircat']['ConnManager']['TransferUdpSendToServer'](arg1, arg2);
+}
+
+export function TransferUdpStart() {
+  return window['go']['mircat']['ConnManager']['TransferUdpStart']();
+}
+
+export function TransferUdpStop() {
+  return window['go']['mircat']['ConnManager']['TransferUdpStop']();
+}
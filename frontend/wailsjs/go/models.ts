@@ -1,42 +1,874 @@
+export namespace codec {
+	
+	export class DefaultMessage {
+	    recog: number;
+	    ident: number;
+	    param: number;
+	    tag: number;
+	    series: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new DefaultMessage(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.recog = source["recog"];
+	        this.ident = source["ident"];
+	        this.param = source["param"];
+	        this.tag = source["tag"];
+	        this.series = source["series"];
+	    }
+	}
+	export class FieldSpec {
+	    name: string;
+	    type?: string;
+	    size?: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new FieldSpec(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.type = source["type"];
+	        this.size = source["size"];
+	    }
+	}
+	export class MessageSpec {
+	    ident: number;
+	    name: string;
+	    direction?: string;
+	    header?: {[key: string]: string};
+	    body?: string;
+	    separator?: string;
+	    fields?: FieldSpec[];
+	    repeat?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MessageSpec(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.ident = source["ident"];
+	        this.name = source["name"];
+	        this.direction = source["direction"];
+	        this.header = source["header"];
+	        this.body = source["body"];
+	        this.separator = source["separator"];
+	        this.fields = this.convertValues(source["fields"], FieldSpec);
+	        this.repeat = source["repeat"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Catalog {
+	    messages: MessageSpec[];
+	
+	    static createFrom(source: any = {}) {
+	        return new Catalog(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.messages = this.convertValues(source["messages"], MessageSpec);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Packet {
+	    seq: number;
+	    message: DefaultMessage;
+	    body: number[];
+	    parts?: number[][];
+	    text: string;
+	    encoded: string;
+	    name?: string;
+	    fields?: {[key: string]: any};
+	    fieldError?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new Packet(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.seq = source["seq"];
+	        this.message = this.convertValues(source["message"], DefaultMessage);
+	        this.body = source["body"];
+	        this.parts = source["parts"];
+	        this.text = source["text"];
+	        this.encoded = source["encoded"];
+	        this.name = source["name"];
+	        this.fields = source["fields"];
+	        this.fieldError = source["fieldError"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Range {
+	    min: number;
+	    max: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Range(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.min = source["min"];
+	        this.max = source["max"];
+	    }
+	}
+	export class StdItem {
+	    name: string;
+	    stdMode: number;
+	    shape: number;
+	    weight: number;
+	    aniCount: number;
+	    source: number;
+	    reserved: number;
+	    needIdentify: number;
+	    looks: number;
+	    duraMax: number;
+	    ac: Range;
+	    mac: Range;
+	    dc: Range;
+	    mc: Range;
+	    sc: Range;
+	    need: number;
+	    needLevel: number;
+	    price: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new StdItem(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.stdMode = source["stdMode"];
+	        this.shape = source["shape"];
+	        this.weight = source["weight"];
+	        this.aniCount = source["aniCount"];
+	        this.source = source["source"];
+	        this.reserved = source["reserved"];
+	        this.needIdentify = source["needIdentify"];
+	        this.looks = source["looks"];
+	        this.duraMax = source["duraMax"];
+	        this.ac = this.convertValues(source["ac"], Range);
+	        this.mac = this.convertValues(source["mac"], Range);
+	        this.dc = this.convertValues(source["dc"], Range);
+	        this.mc = this.convertValues(source["mc"], Range);
+	        this.sc = this.convertValues(source["sc"], Range);
+	        this.need = source["need"];
+	        this.needLevel = source["needLevel"];
+	        this.price = source["price"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ClientItem {
+	    item: StdItem;
+	    makeIndex: number;
+	    dura: number;
+	    duraMax: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ClientItem(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.item = this.convertValues(source["item"], StdItem);
+	        this.makeIndex = source["makeIndex"];
+	        this.dura = source["dura"];
+	        this.duraMax = source["duraMax"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class Ability {
+	    level: number;
+	    ac: Range;
+	    mac: Range;
+	    dc: Range;
+	    mc: Range;
+	    sc: Range;
+	    hp: number;
+	    mp: number;
+	    maxHp: number;
+	    maxMp: number;
+	    exp: number;
+	    maxExp: number;
+	    weight: number;
+	    maxWeight: number;
+	    wearWeight: number;
+	    maxWearWeight: number;
+	    handWeight: number;
+	    maxHandWeight: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new Ability(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.level = source["level"];
+	        this.ac = this.convertValues(source["ac"], Range);
+	        this.mac = this.convertValues(source["mac"], Range);
+	        this.dc = this.convertValues(source["dc"], Range);
+	        this.mc = this.convertValues(source["mc"], Range);
+	        this.sc = this.convertValues(source["sc"], Range);
+	        this.hp = source["hp"];
+	        this.mp = source["mp"];
+	        this.maxHp = source["maxHp"];
+	        this.maxMp = source["maxMp"];
+	        this.exp = source["exp"];
+	        this.maxExp = source["maxExp"];
+	        this.weight = source["weight"];
+	        this.maxWeight = source["maxWeight"];
+	        this.wearWeight = source["wearWeight"];
+	        this.maxWearWeight = source["maxWearWeight"];
+	        this.handWeight = source["handWeight"];
+	        this.maxHandWeight = source["maxHandWeight"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	
+
+}
+
 export namespace mircat {
 	
-	export class ClientConfig {
-	    ServerIp: string;
-	    ServerPort: string;
+	export class BotStep {
+	    action: string;
+	    x: number;
+	    y: number;
+	    direction: number;
+	    text: string;
+	    message: codec.DefaultMessage;
+	    expect: string;
+	    timeout: number;
+	    delay: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new BotStep(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.action = source["action"];
+	        this.x = source["x"];
+	        this.y = source["y"];
+	        this.direction = source["direction"];
+	        this.text = source["text"];
+	        this.message = this.convertValues(source["message"], codec.DefaultMessage);
+	        this.expect = source["expect"];
+	        this.timeout = source["timeout"];
+	        this.delay = source["delay"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class BotConfig {
+	    loginAddr: string;
+	    account: string;
+	    password: string;
+	    server: string;
+	    character: string;
+	    clientVersion: number;
+	    timeout: number;
+	    script: BotStep[];
+	
+	    static createFrom(source: any = {}) {
+	        return new BotConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.loginAddr = source["loginAddr"];
+	        this.account = source["account"];
+	        this.password = source["password"];
+	        this.server = source["server"];
+	        this.character = source["character"];
+	        this.clientVersion = source["clientVersion"];
+	        this.timeout = source["timeout"];
+	        this.script = this.convertValues(source["script"], BotStep);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ApiConfig {
+	    enabled: boolean;
+	    addr: string;
+	    token: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ApiConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.addr = source["addr"];
+	        this.token = source["token"];
+	    }
+	}
+	export class ShapingSettings {
+	    latency: number;
+	    jitter: number;
+	    bandwidth: number;
+	    stallRate: number;
+	    stall: number;
+	    resetRate: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new ShapingSettings(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.latency = source["latency"];
+	        this.jitter = source["jitter"];
+	        this.bandwidth = source["bandwidth"];
+	        this.stallRate = source["stallRate"];
+	        this.stall = source["stall"];
+	        this.resetRate = source["resetRate"];
+	    }
+	}
+	export class TrafficShaping {
+	    src: ShapingSettings;
+	    dst: ShapingSettings;
+	
+	    static createFrom(source: any = {}) {
+	        return new TrafficShaping(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.src = this.convertValues(source["src"], ShapingSettings);
+	        this.dst = this.convertValues(source["dst"], ShapingSettings);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class UpstreamProxy {
+	    type: string;
+	    addr: string;
+	    username: string;
+	    password: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new UpstreamProxy(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.type = source["type"];
+	        this.addr = source["addr"];
+	        this.username = source["username"];
+	        this.password = source["password"];
+	    }
+	}
+	export class TLSClientConfig {
+	    enabled: boolean;
+	    serverName: string;
+	    caFile: string;
+	    insecureSkipVerify: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new TLSClientConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.serverName = source["serverName"];
+	        this.caFile = source["caFile"];
+	        this.insecureSkipVerify = source["insecureSkipVerify"];
+	    }
+	}
+	export class TLSServerConfig {
+	    enabled: boolean;
+	    certFile: string;
+	    keyFile: string;
+	    hosts: string[];
+	    mitm: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new TLSServerConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.certFile = source["certFile"];
+	        this.keyFile = source["keyFile"];
+	        this.hosts = source["hosts"];
+	        this.mitm = source["mitm"];
+	    }
+	}
+	export class RedirectConfig {
+	    enabled: boolean;
+	    idents: number[];
+	    pattern: string;
+	    listenAddr: string;
+	    advertiseAddr: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new RedirectConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.idents = source["idents"];
+	        this.pattern = source["pattern"];
+	        this.listenAddr = source["listenAddr"];
+	        this.advertiseAddr = source["advertiseAddr"];
+	    }
+	}
+	export class FramerConfig {
+	    type: string;
+	    lengthSize: number;
+	    bigEndian: boolean;
+	    lengthOffset: number;
+	    lengthIncludesHeader: boolean;
+	    delimiter: string;
+	    fixedSize: number;
+	    maxSize: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new FramerConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.type = source["type"];
+	        this.lengthSize = source["lengthSize"];
+	        this.bigEndian = source["bigEndian"];
+	        this.lengthOffset = source["lengthOffset"];
+	        this.lengthIncludesHeader = source["lengthIncludesHeader"];
+	        this.delimiter = source["delimiter"];
+	        this.fixedSize = source["fixedSize"];
+	        this.maxSize = source["maxSize"];
+	    }
+	}
+	export class TransferConfig {
+	    srcAddr: string;
+	    srcPort: string;
+	    dstAddr: string;
+	    dstPort: string;
+	    udpSrcAddr: string;
+	    udpSrcPort: string;
+	    udpDstAddr: string;
+	    udpDstPort: string;
+	    udpIdleTimeout: number;
+	    forwardMode: string;
+	    srcFramer: FramerConfig;
+	    dstFramer: FramerConfig;
+	    seqRewrite: boolean;
+	    redirect: RedirectConfig;
+	    srcTls: TLSServerConfig;
+	    dstTls: TLSClientConfig;
+	    proxy: boolean;
+	    upstream: UpstreamProxy[];
+	    shaping: TrafficShaping;
+	
+	    static createFrom(source: any = {}) {
+	        return new TransferConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.srcAddr = source["srcAddr"];
+	        this.srcPort = source["srcPort"];
+	        this.dstAddr = source["dstAddr"];
+	        this.dstPort = source["dstPort"];
+	        this.udpSrcAddr = source["udpSrcAddr"];
+	        this.udpSrcPort = source["udpSrcPort"];
+	        this.udpDstAddr = source["udpDstAddr"];
+	        this.udpDstPort = source["udpDstPort"];
+	        this.udpIdleTimeout = source["udpIdleTimeout"];
+	        this.forwardMode = source["forwardMode"];
+	        this.srcFramer = this.convertValues(source["srcFramer"], FramerConfig);
+	        this.dstFramer = this.convertValues(source["dstFramer"], FramerConfig);
+	        this.seqRewrite = source["seqRewrite"];
+	        this.redirect = this.convertValues(source["redirect"], RedirectConfig);
+	        this.srcTls = this.convertValues(source["srcTls"], TLSServerConfig);
+	        this.dstTls = this.convertValues(source["dstTls"], TLSClientConfig);
+	        this.proxy = source["proxy"];
+	        this.upstream = this.convertValues(source["upstream"], UpstreamProxy);
+	        this.shaping = this.convertValues(source["shaping"], TrafficShaping);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class MirResponse {
+	    message: string;
+	    fields: {[key: string]: string};
+	    body: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MirResponse(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.message = source["message"];
+	        this.fields = source["fields"];
+	        this.body = source["body"];
+	    }
+	}
+	export class MirMap {
+	    name: string;
+	    title: string;
+	    width: number;
+	    height: number;
+	    startX: number;
+	    startY: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new MirMap(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.title = source["title"];
+	        this.width = source["width"];
+	        this.height = source["height"];
+	        this.startX = source["startX"];
+	        this.startY = source["startY"];
+	    }
+	}
+	export class MirCharacter {
+	    name: string;
+	    job: number;
+	    hair: number;
+	    level: number;
+	    sex: number;
+	    gold: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new MirCharacter(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.name = source["name"];
+	        this.job = source["job"];
+	        this.hair = source["hair"];
+	        this.level = source["level"];
+	        this.sex = source["sex"];
+	        this.gold = source["gold"];
+	    }
+	}
+	export class MirAccount {
+	    account: string;
+	    password: string;
+	    characters: MirCharacter[];
+	
+	    static createFrom(source: any = {}) {
+	        return new MirAccount(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.account = source["account"];
+	        this.password = source["password"];
+	        this.characters = this.convertValues(source["characters"], MirCharacter);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class MirServerConfig {
+	    enabled: boolean;
+	    serverName: string;
+	    advertiseAddr: string;
+	    accounts: MirAccount[];
+	    map: MirMap;
+	    notice: string;
+	    responses: {[key: string]: MirResponse[]};
+	
+	    static createFrom(source: any = {}) {
+	        return new MirServerConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.enabled = source["enabled"];
+	        this.serverName = source["serverName"];
+	        this.advertiseAddr = source["advertiseAddr"];
+	        this.accounts = this.convertValues(source["accounts"], MirAccount);
+	        this.map = this.convertValues(source["map"], MirMap);
+	        this.notice = source["notice"];
+	        this.responses = this.convertValues(source["responses"], MirResponse, true);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class PcapFilter {
+	    client: string;
+	    server: string;
+	    from: number;
+	    to: number;
 	
 	    static createFrom(source: any = {}) {
-	        return new ClientConfig(source);
+	        return new PcapFilter(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
-	        this.ServerIp = source["ServerIp"];
-	        this.ServerPort = source["ServerPort"];
+	        this.client = source["client"];
+	        this.server = source["server"];
+	        this.from = source["from"];
+	        this.to = source["to"];
 	    }
 	}
-	export class TransferConfig {
-	    srcAddr: string;
-	    srcPort: string;
-	    dstAddr: string;
-	    dstPort: string;
+	export class MockConfig {
+	    enabled: boolean;
+	    path: string;
+	    filter: PcapFilter;
+	    match: string;
+	    mask: string;
 	
 	    static createFrom(source: any = {}) {
-	        return new TransferConfig(source);
+	        return new MockConfig(source);
 	    }
 	
 	    constructor(source: any = {}) {
 	        if ('string' === typeof source) source = JSON.parse(source);
-	        this.srcAddr = source["srcAddr"];
-	        this.srcPort = source["srcPort"];
-	        this.dstAddr = source["dstAddr"];
-	        this.dstPort = source["dstPort"];
+	        this.enabled = source["enabled"];
+	        this.path = source["path"];
+	        this.filter = this.convertValues(source["filter"], PcapFilter);
+	        this.match = source["match"];
+	        this.mask = source["mask"];
 	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
 	}
 	export class ServerConfig {
 	    tcpAddr: string;
 	    tcpPort: string;
 	    udpAddr: string;
 	    udpPort: string;
+	    framer: FramerConfig;
+	    mock: MockConfig;
+	    mir: MirServerConfig;
+	    tls: TLSServerConfig;
 	
 	    static createFrom(source: any = {}) {
 	        return new ServerConfig(source);
@@ -48,12 +880,124 @@ export namespace mircat {
 	        this.tcpPort = source["tcpPort"];
 	        this.udpAddr = source["udpAddr"];
 	        this.udpPort = source["udpPort"];
+	        this.framer = this.convertValues(source["framer"], FramerConfig);
+	        this.mock = this.convertValues(source["mock"], MockConfig);
+	        this.mir = this.convertValues(source["mir"], MirServerConfig);
+	        this.tls = this.convertValues(source["tls"], TLSServerConfig);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ReplayConfig {
+	    path: string;
+	    filter: PcapFilter;
+	    target: string;
+	    mode: string;
+	    speed: number;
+	    responseTimeout: number;
+	    srcFramer: FramerConfig;
+	    dstFramer: FramerConfig;
+	
+	    static createFrom(source: any = {}) {
+	        return new ReplayConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.path = source["path"];
+	        this.filter = this.convertValues(source["filter"], PcapFilter);
+	        this.target = source["target"];
+	        this.mode = source["mode"];
+	        this.speed = source["speed"];
+	        this.responseTimeout = source["responseTimeout"];
+	        this.srcFramer = this.convertValues(source["srcFramer"], FramerConfig);
+	        this.dstFramer = this.convertValues(source["dstFramer"], FramerConfig);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ClientConfig {
+	    ServerIp: string;
+	    ServerPort: string;
+	    UdpServerPort: string;
+	    Framer: FramerConfig;
+	    TLS: TLSClientConfig;
+	    Upstream: UpstreamProxy[];
+	
+	    static createFrom(source: any = {}) {
+	        return new ClientConfig(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.ServerIp = source["ServerIp"];
+	        this.ServerPort = source["ServerPort"];
+	        this.UdpServerPort = source["UdpServerPort"];
+	        this.Framer = this.convertValues(source["Framer"], FramerConfig);
+	        this.TLS = this.convertValues(source["TLS"], TLSClientConfig);
+	        this.Upstream = this.convertValues(source["Upstream"], UpstreamProxy);
 	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
 	}
 	export class Config {
 	    Server: ServerConfig;
 	    Transfer: TransferConfig;
 	    Client: ClientConfig;
+	    Replay: ReplayConfig;
+	    Servers: {[key: string]: ServerConfig};
+	    Transfers: {[key: string]: TransferConfig};
+	    Api: ApiConfig;
+	    Bot: BotConfig;
+	    CatalogFile: string;
 	
 	    static createFrom(source: any = {}) {
 	        return new Config(source);
@@ -64,6 +1008,246 @@ export namespace mircat {
 	        this.Server = this.convertValues(source["Server"], ServerConfig);
 	        this.Transfer = this.convertValues(source["Transfer"], TransferConfig);
 	        this.Client = this.convertValues(source["Client"], ClientConfig);
+	        this.Replay = this.convertValues(source["Replay"], ReplayConfig);
+	        this.Servers = this.convertValues(source["Servers"], ServerConfig, true);
+	        this.Transfers = this.convertValues(source["Transfers"], TransferConfig, true);
+	        this.Api = this.convertValues(source["Api"], ApiConfig);
+	        this.Bot = this.convertValues(source["Bot"], BotConfig);
+	        this.CatalogFile = source["CatalogFile"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class ChatLine {
+	    time: any;
+	    kind: string;
+	    actor: number;
+	    message: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new ChatLine(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.time = source["time"];
+	        this.kind = source["kind"];
+	        this.actor = source["actor"];
+	        this.message = source["message"];
+	    }
+	}
+	export class GameObject {
+	    id: number;
+	    name: string;
+	    x: number;
+	    y: number;
+	    direction: number;
+	    race: number;
+	    feature: number;
+	    status: number;
+	    hp: number;
+	    maxHp: number;
+	    dead: boolean;
+	    seen: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new GameObject(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.name = source["name"];
+	        this.x = source["x"];
+	        this.y = source["y"];
+	        this.direction = source["direction"];
+	        this.race = source["race"];
+	        this.feature = source["feature"];
+	        this.status = source["status"];
+	        this.hp = source["hp"];
+	        this.maxHp = source["maxHp"];
+	        this.dead = source["dead"];
+	        this.seen = source["seen"];
+	    }
+	}
+	export class PlayerState {
+	    id: number;
+	    character: string;
+	    map: string;
+	    mapTitle: string;
+	    x: number;
+	    y: number;
+	    direction: number;
+	    hp: number;
+	    mp: number;
+	    maxHp: number;
+	    maxMp: number;
+	    level: number;
+	    exp: number;
+	    gold: number;
+	    ability: codec.Ability;
+	
+	    static createFrom(source: any = {}) {
+	        return new PlayerState(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.character = source["character"];
+	        this.map = source["map"];
+	        this.mapTitle = source["mapTitle"];
+	        this.x = source["x"];
+	        this.y = source["y"];
+	        this.direction = source["direction"];
+	        this.hp = source["hp"];
+	        this.mp = source["mp"];
+	        this.maxHp = source["maxHp"];
+	        this.maxMp = source["maxMp"];
+	        this.level = source["level"];
+	        this.exp = source["exp"];
+	        this.gold = source["gold"];
+	        this.ability = this.convertValues(source["ability"], codec.Ability);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class GameState {
+	    session: string;
+	    player: PlayerState;
+	    objects: GameObject[];
+	    inventory: codec.ClientItem[];
+	    chat: ChatLine[];
+	    updated: any;
+	    closed: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new GameState(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.session = source["session"];
+	        this.player = this.convertValues(source["player"], PlayerState);
+	        this.objects = this.convertValues(source["objects"], GameObject);
+	        this.inventory = this.convertValues(source["inventory"], codec.ClientItem);
+	        this.chat = this.convertValues(source["chat"], ChatLine);
+	        this.updated = source["updated"];
+	        this.closed = source["closed"];
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class PcapFlow {
+	    client: string;
+	    server: string;
+	    start: number;
+	    end: number;
+	    packets: number;
+	    clientBytes: number;
+	    serverBytes: number;
+	    missingBytes: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PcapFlow(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.client = source["client"];
+	        this.server = source["server"];
+	        this.start = source["start"];
+	        this.end = source["end"];
+	        this.packets = source["packets"];
+	        this.clientBytes = source["clientBytes"];
+	        this.serverBytes = source["serverBytes"];
+	        this.missingBytes = source["missingBytes"];
+	    }
+	}
+	export class InterceptRule {
+	    direction: string;
+	    prefix: string;
+	    contains: string;
+	    regexp: string;
+	    minLength: number;
+	    maxLength: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new InterceptRule(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.direction = source["direction"];
+	        this.prefix = source["prefix"];
+	        this.contains = source["contains"];
+	        this.regexp = source["regexp"];
+	        this.minLength = source["minLength"];
+	        this.maxLength = source["maxLength"];
+	    }
+	}
+	export class InterceptSettings {
+	    src: boolean;
+	    dst: boolean;
+	    rules: InterceptRule[];
+	
+	    static createFrom(source: any = {}) {
+	        return new InterceptSettings(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.src = source["src"];
+	        this.dst = source["dst"];
+	        this.rules = this.convertValues(source["rules"], InterceptRule);
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -84,6 +1268,26 @@ export namespace mircat {
 		    return a;
 		}
 	}
+	export class InterceptedPacket {
+	    id: number;
+	    client: string;
+	    direction: string;
+	    data: number[];
+	    time: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new InterceptedPacket(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.client = source["client"];
+	        this.direction = source["direction"];
+	        this.data = source["data"];
+	        this.time = source["time"];
+	    }
+	}
 	
 
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/options/assetserver"
 	"log"
 	"net/http"
+	"os"
 
 	_ "github.com/mkevac/debugcharts"
 	"github.com/wailsapp/wails/v2"
@@ -12,6 +13,8 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/options"
 	"github.com/wailsapp/wails/v2/pkg/options/mac"
 	"github.com/wailsapp/wails/v2/pkg/options/windows"
+	"mir-cat/pkg/api"
+	"mir-cat/pkg/cli"
 	"mir-cat/pkg/mircat"
 )
 
@@ -22,6 +25,11 @@ var assets embed.FS
 var icon []byte
 
 func main() {
+	// Run headless when started with a subcommand such as "mircat transfer"
+	// 带子命令启动时以无界面模式运行
+	if len(os.Args) > 1 && cli.IsCommand(os.Args[1]) {
+		os.Exit(cli.Run(os.Args[1:]))
+	}
 	go func() {
 		log.Println(http.ListenAndServe("localhost:6060", nil))
 	}()
@@ -30,6 +38,17 @@ func main() {
 	app := mircat.NewApp()
 	cfg := mircat.NewConfig()
 	connManager := mircat.NewConnManager(app, cfg)
+	if cfg.Api.Enabled {
+		// Serve the bound methods and the events over HTTP as well
+		// 同时通过 HTTP 提供绑定的方法和事件
+		apiServer := api.NewServer(cfg.Api)
+		apiServer.Bind("ConnManager", connManager)
+		apiServer.Bind("Config", cfg)
+		app.SetSink(mircat.NewMultiSink(mircat.NewWailsSink(app), apiServer))
+		if err := apiServer.Start(); err != nil {
+			log.Println(err)
+		}
+	}
 
 	// Create application with options
 	// 使用选项创建应用
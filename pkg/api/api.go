@@ -0,0 +1,345 @@
+// Package api exposes the methods bound to the Wails front-end over HTTP.
+//
+// Every exported method of a bound object is callable with
+//
+//	POST /api/<Object>/<Method>
+//
+// whose body is the JSON array of the arguments, as the front-end passes them,
+// e.g. ["127.0.0.1:7000", "aGVsbG8="]. The response is {"result": value} or
+// {"error": "message"}. GET /api lists the objects and their methods.
+//
+// GET /events upgrades to a WebSocket carrying the events the front-end
+// receives, one JSON object {"time", "event", "data"} per message. The query
+// parameter "events" restricts the stream to a comma separated list of names.
+//
+// Every request must carry the token in an "Authorization: Bearer <token>"
+// header or in the "token" query parameter. When none is configured a random
+// one is generated and printed at start. Calls must be sent with the
+// "Content-Type: application/json" header, and requests coming from a browser
+// page are only accepted from a localhost origin.
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"mime"
+	"mir-cat/pkg/mircat"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EVENT_QUEUE_SIZE is the number of events buffered for a WebSocket client before it is dropped as too slow.
+const EVENT_QUEUE_SIZE = 1024
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+type eventClient struct {
+	queue  chan []byte
+	filter map[string]bool
+}
+
+// Server is the HTTP API, it is also the EventSink feeding the WebSocket clients.
+type Server struct {
+	cfg       mircat.ApiConfig
+	generated bool
+	objects   map[string]reflect.Value
+	upgrader  websocket.Upgrader
+	mutex     sync.Mutex
+	clients   map[*eventClient]bool
+	server    *http.Server
+}
+
+// NewServer creates the API, the objects to expose are added with Bind.
+// A random token is generated when cfg has none.
+func NewServer(cfg mircat.ApiConfig) *Server {
+	generated := cfg.Token == ""
+	if generated {
+		cfg.Token = randomToken()
+	}
+	s := &Server{
+		cfg:       cfg,
+		generated: generated,
+		objects:   map[string]reflect.Value{},
+		clients:   map[*eventClient]bool{},
+		upgrader: websocket.Upgrader{
+			// authorize already rejected the foreign origins
+			CheckOrigin: func(r *http.Request) bool { return localOrigin(r) },
+		},
+	}
+	return s
+}
+
+// Token returns the token the requests must carry, the configured one or the generated one.
+func (s *Server) Token() string {
+	return s.cfg.Token
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// localOrigin tells whether a request comes from a script, which sends no Origin, or from a page served by
+// the local machine. Any other web page open in the browser could otherwise drive the API.
+func localOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Bind exposes the exported methods of object under /api/<name>/, it has to be called before Start.
+func (s *Server) Bind(name string, object interface{}) {
+	s.objects[name] = reflect.ValueOf(object)
+}
+
+// Start listens on the configured address and serves the API in the background.
+func (s *Server) Start() error {
+	addr := s.cfg.Addr
+	if addr == "" {
+		addr = mircat.API_ADDR
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.server = &http.Server{Handler: s.handler()}
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("API server stopped: %v\n", err)
+		}
+	}()
+	fmt.Printf("API listening on %s\n", listener.Addr())
+	if s.generated {
+		fmt.Printf("API token: %s\n", s.cfg.Token)
+	}
+	return nil
+}
+
+// handler routes the API and the event stream behind the origin and token checks.
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api", s.handleList)
+	mux.HandleFunc("/api/", s.handleCall)
+	mux.HandleFunc("/events", s.handleEvents)
+	return s.authorize(mux)
+}
+
+// Stop closes the listener and the WebSocket clients.
+func (s *Server) Stop() {
+	if s.server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s.server.Shutdown(ctx)
+	s.mutex.Lock()
+	for client := range s.clients {
+		close(client.queue)
+		delete(s.clients, client)
+	}
+	s.mutex.Unlock()
+}
+
+// Emit sends an event to the WebSocket clients.
+func (s *Server) Emit(eventName string, optionalData ...interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.clients) == 0 {
+		return
+	}
+	message, err := json.Marshal(mircat.EventLine{Time: time.Now(), Event: eventName, Data: optionalData})
+	if err != nil {
+		return
+	}
+	for client := range s.clients {
+		if client.filter != nil && !client.filter[eventName] {
+			continue
+		}
+		select {
+		case client.queue <- message:
+		default:
+			// a client that does not keep up is dropped rather than slowing the traffic down
+			close(client.queue)
+			delete(s.clients, client)
+		}
+	}
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !localOrigin(r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "origin not allowed"})
+			return
+		}
+		token := r.URL.Query().Get("token")
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			token = strings.TrimPrefix(header, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	list := map[string][]string{}
+	for name, object := range s.objects {
+		methods := []string{}
+		for i := 0; i < object.NumMethod(); i++ {
+			methods = append(methods, object.Type().Method(i).Name)
+		}
+		sort.Strings(methods)
+		list[name] = methods
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+		return
+	}
+	// a page can send a cross-site POST with a text/plain body without a preflight, but not a JSON one
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "use Content-Type: application/json"})
+		return
+	}
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	if len(path) != 2 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "use /api/<Object>/<Method>"})
+		return
+	}
+	object, ok := s.objects[path[0]]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown object %s", path[0])})
+		return
+	}
+	method := object.MethodByName(path[1])
+	if !method.IsValid() {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("unknown method %s.%s", path[0], path[1])})
+		return
+	}
+	raw := []json.RawMessage{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("arguments must be a JSON array: %v", err)})
+			return
+		}
+	}
+	args, err := arguments(method.Type(), raw)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	result, err := call(method, args)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"result": result})
+}
+
+// arguments decodes the JSON arguments into the parameter types of a method.
+func arguments(method reflect.Type, raw []json.RawMessage) ([]reflect.Value, error) {
+	if len(raw) != method.NumIn() {
+		return nil, fmt.Errorf("expected %d arguments, got %d", method.NumIn(), len(raw))
+	}
+	args := make([]reflect.Value, len(raw))
+	for i := range raw {
+		arg := reflect.New(method.In(i))
+		if err := json.Unmarshal(raw[i], arg.Interface()); err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i+1, err)
+		}
+		args[i] = arg.Elem()
+	}
+	return args, nil
+}
+
+// call invokes a method the way Wails does: a trailing error is reported as the error
+// of the call, and the remaining value, if any, is the result.
+func call(method reflect.Value, args []reflect.Value) (interface{}, error) {
+	out := method.Call(args)
+	if n := len(out); n > 0 && method.Type().Out(n-1) == errorType {
+		if !out[n-1].IsNil() {
+			return nil, out[n-1].Interface().(error)
+		}
+		out = out[:n-1]
+	}
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return out[0].Interface(), nil
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	client := &eventClient{queue: make(chan []byte, EVENT_QUEUE_SIZE)}
+	if names := r.URL.Query().Get("events"); names != "" {
+		client.filter = map[string]bool{}
+		for _, name := range strings.Split(names, ",") {
+			client.filter[strings.TrimSpace(name)] = true
+		}
+	}
+	s.mutex.Lock()
+	s.clients[client] = true
+	s.mutex.Unlock()
+
+	// the reader only notices the client going away
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				s.mutex.Lock()
+				if s.clients[client] {
+					close(client.queue)
+					delete(s.clients, client)
+				}
+				s.mutex.Unlock()
+				return
+			}
+		}
+	}()
+	for message := range client.queue {
+		if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			break
+		}
+	}
+	conn.Close()
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
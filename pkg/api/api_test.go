@@ -0,0 +1,185 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"mir-cat/pkg/mircat"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type calculator struct{}
+
+func (c *calculator) Add(a int, b int) int {
+	return a + b
+}
+
+func (c *calculator) Divide(a int, b int) (int, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
+func startServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	s := NewServer(mircat.ApiConfig{Token: "secret"})
+	s.Bind("Calc", &calculator{})
+	server := httptest.NewServer(s.handler())
+	t.Cleanup(server.Close)
+	return s, server
+}
+
+// post calls a method and returns the status and the decoded response.
+func post(t *testing.T, url string, body string, header map[string]string) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	response := map[string]interface{}{}
+	json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response
+}
+
+func TestCall(t *testing.T) {
+	_, server := startServer(t)
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		result interface{}
+	}{
+		{"result", "/api/Calc/Add", "[2, 3]", http.StatusOK, 5.0},
+		{"result before a nil error", "/api/Calc/Divide", "[9, 3]", http.StatusOK, 3.0},
+		{"error", "/api/Calc/Divide", "[1, 0]", http.StatusInternalServerError, nil},
+		{"argument count", "/api/Calc/Add", "[1]", http.StatusBadRequest, nil},
+		{"argument type", "/api/Calc/Add", `["1", 2]`, http.StatusBadRequest, nil},
+		{"not an array", "/api/Calc/Add", `{"a": 1}`, http.StatusBadRequest, nil},
+		{"unknown object", "/api/Nope/Add", "[]", http.StatusNotFound, nil},
+		{"unknown method", "/api/Calc/Nope", "[]", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response := post(t, server.URL+tt.path, tt.body, nil)
+			if status != tt.status || response["result"] != tt.result {
+				t.Fatalf("status %d response %v", status, response)
+			}
+			if status != http.StatusOK && response["error"] == nil {
+				t.Fatalf("no error in %v", response)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	_, server := startServer(t)
+	if status, _ := post(t, server.URL+"/api/Calc/Add", "[1, 1]", map[string]string{"Authorization": ""}); status != http.StatusUnauthorized {
+		t.Fatalf("no token gave %d", status)
+	}
+	if status, _ := post(t, server.URL+"/api/Calc/Add", "[1, 1]", map[string]string{"Authorization": "Bearer guess"}); status != http.StatusUnauthorized {
+		t.Fatalf("a wrong token gave %d", status)
+	}
+	if status, _ := post(t, server.URL+"/api/Calc/Add?token=secret", "[1, 1]", map[string]string{"Authorization": ""}); status != http.StatusOK {
+		t.Fatalf("the query token gave %d", status)
+	}
+
+	// a page of another site is refused even with the token, local pages are not
+	if status, _ := post(t, server.URL+"/api/Calc/Add", "[1, 1]", map[string]string{"Origin": "https://evil.example"}); status != http.StatusForbidden {
+		t.Fatalf("a foreign origin gave %d", status)
+	}
+	for _, origin := range []string{"http://localhost:34115", "http://127.0.0.1:8080", "http://[::1]"} {
+		if status, _ := post(t, server.URL+"/api/Calc/Add", "[1, 1]", map[string]string{"Origin": origin}); status != http.StatusOK {
+			t.Errorf("origin %s gave %d", origin, status)
+		}
+	}
+
+	// the simple requests a page can send without a preflight are refused
+	if status, _ := post(t, server.URL+"/api/Calc/Add", "[1, 1]", map[string]string{"Content-Type": "text/plain"}); status != http.StatusUnsupportedMediaType {
+		t.Fatalf("a text/plain call gave %d", status)
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/Calc/Add", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("a GET call gave %d", resp.StatusCode)
+	}
+}
+
+func TestGeneratedToken(t *testing.T) {
+	a, b := NewServer(mircat.ApiConfig{}), NewServer(mircat.ApiConfig{})
+	if len(a.Token()) != 32 || a.Token() == b.Token() {
+		t.Fatalf("tokens %q and %q", a.Token(), b.Token())
+	}
+	if token := NewServer(mircat.ApiConfig{Token: "set"}).Token(); token != "set" {
+		t.Fatalf("token %q", token)
+	}
+}
+
+func TestList(t *testing.T) {
+	_, server := startServer(t)
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	list := map[string][]string{}
+	json.NewDecoder(resp.Body).Decode(&list)
+	if methods := list["Calc"]; len(methods) != 2 || methods[0] != "Add" || methods[1] != "Divide" {
+		t.Fatalf("list %v", list)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s, server := startServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/events?token=secret&events=server-tcp-data"
+	if _, _, err := websocket.DefaultDialer.Dial(url, map[string][]string{"Origin": {"https://evil.example"}}); err == nil {
+		t.Fatal("a foreign page opened the event stream")
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(time.Millisecond) {
+		s.mutex.Lock()
+		connected := len(s.clients) == 1
+		s.mutex.Unlock()
+		if connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the client was not registered")
+		}
+	}
+
+	// only the events asked for are sent
+	s.Emit("server-tcp-info", "1.2.3.4:5", "connected")
+	s.Emit("server-tcp-data", "1.2.3.4:5", []byte("hi"))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line := mircat.EventLine{}
+	if err := conn.ReadJSON(&line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Event != "server-tcp-data" || len(line.Data) != 2 || line.Data[1] != "aGk=" {
+		t.Fatalf("event %+v", line)
+	}
+}
@@ -0,0 +1,334 @@
+// Package cli runs MirCat without the Wails window.
+//
+// The headless mode drives a ConnManager configured by config.json, prints its
+// events to stdout as text or JSON lines and sends the lines read from stdin.
+// A line starting with "@<key> " is sent to a single connection, the key being
+// the client index of "client" or the client address of "server" and
+// "transfer"; other lines go to every connection. "bot" runs the bot of the
+// configuration and exits with status 0 when its whole script passed.
+package cli
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"mir-cat/pkg/api"
+	"mir-cat/pkg/mircat"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// FORMAT_TEXT prints one readable line per event.
+	FORMAT_TEXT = "text"
+	// FORMAT_JSON prints one JSON object per event.
+	FORMAT_JSON = "json"
+
+	// INPUT_TEXT sends stdin lines as they are.
+	INPUT_TEXT = "text"
+	// INPUT_HEX sends stdin lines decoded from hex.
+	INPUT_HEX = "hex"
+	// INPUT_BASE64 sends stdin lines decoded from base64.
+	INPUT_BASE64 = "base64"
+)
+
+// Commands are the subcommands of the headless mode.
+var Commands = []string{"server", "client", "transfer", "bot"}
+
+// IsCommand tells whether the first argument selects the headless mode.
+func IsCommand(name string) bool {
+	for _, command := range Commands {
+		if command == name {
+			return true
+		}
+	}
+	return false
+}
+
+// textSink prints one readable line per event.
+type textSink struct {
+	mutex sync.Mutex
+	out   io.Writer
+	data  string
+}
+
+func (p *textSink) Emit(eventName string, optionalData ...interface{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	fields := []string{time.Now().Format("15:04:05.000"), eventName}
+	for _, data := range optionalData {
+		fields = append(fields, p.text(data))
+	}
+	fmt.Fprintln(p.out, strings.Join(fields, " "))
+}
+
+func (p *textSink) text(data interface{}) string {
+	switch v := data.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case []byte:
+		if p.data == INPUT_HEX {
+			return hex.EncodeToString(v)
+		}
+		return strconv.Quote(string(v))
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Sprintf("%v", data)
+	}
+	return string(b)
+}
+
+// Run executes a subcommand and returns the exit code of the process.
+func Run(args []string) int {
+	if len(args) == 0 || !IsCommand(args[0]) {
+		fmt.Fprintf(os.Stderr, "usage: mircat %s [flags]\n", strings.Join(Commands, "|"))
+		return 2
+	}
+	command := args[0]
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	format := flags.String("format", FORMAT_TEXT, "event output format: text or json")
+	input := flags.String("input", INPUT_TEXT, "encoding of the stdin lines: text, hex or base64")
+	data := flags.String("data", INPUT_TEXT, "how text output shows binary data: text or hex")
+	to := flags.String("to", "server", "transfer only, the side stdin lines are sent to: server or client")
+	id := flags.String("id", "", "server and transfer only, comma separated instances of the Servers or Transfers section to run instead of the single one; stdin lines go to the first")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *format != FORMAT_TEXT && *format != FORMAT_JSON {
+		fmt.Fprintf(os.Stderr, "unknown format %s\n", *format)
+		return 2
+	}
+	if *input != INPUT_TEXT && *input != INPUT_HEX && *input != INPUT_BASE64 {
+		fmt.Fprintf(os.Stderr, "unknown input %s\n", *input)
+		return 2
+	}
+	if *data != INPUT_TEXT && *data != INPUT_HEX {
+		fmt.Fprintf(os.Stderr, "unknown data display %s\n", *data)
+		return 2
+	}
+	if *to != "server" && *to != "client" {
+		fmt.Fprintf(os.Stderr, "unknown side %s\n", *to)
+		return 2
+	}
+
+	// the components log to the console with fmt.Printf, keep stdout for the events
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = out }()
+
+	var sink mircat.EventSink = &textSink{out: out, data: *data}
+	if *format == FORMAT_JSON {
+		sink = mircat.NewJSONLinesSink(out)
+	}
+	// the bot ends the process once its run is over
+	var finished chan bool
+	if command == "bot" {
+		finished = make(chan bool, 1)
+		sink = mircat.NewMultiSink(sink, mircat.EventSinkFunc(func(eventName string, optionalData ...interface{}) {
+			if eventName != "bot-finished" || len(optionalData) < 2 {
+				return
+			}
+			summary, _ := optionalData[1].(mircat.BotSummary)
+			finished <- summary.Passed
+		}))
+	}
+	cfg := mircat.NewConfig()
+	var apiServer *api.Server
+	if cfg.Api.Enabled {
+		apiServer = api.NewServer(cfg.Api)
+		sink = mircat.NewMultiSink(sink, apiServer)
+	}
+	app := mircat.NewAppWithSink(sink)
+	manager := mircat.NewConnManager(app, cfg)
+	if apiServer != nil {
+		apiServer.Bind("ConnManager", manager)
+		apiServer.Bind("Config", cfg)
+		if err := apiServer.Start(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start the API: %v\n", err)
+			return 1
+		}
+		defer apiServer.Stop()
+	}
+
+	var send func(key string, base64Data string)
+	var stop func()
+	switch command {
+	case "server":
+		ids := instances(*id)
+		if ids == nil {
+			if !manager.ServerTcpStart() {
+				return 1
+			}
+			send = func(key string, base64Data string) {
+				if key == "" {
+					manager.ServerBroadcastMessage(base64Data)
+					return
+				}
+				manager.ServerSendMessage(key, base64Data)
+			}
+			stop = func() { manager.ServerTcpStop() }
+			break
+		}
+		for i, instance := range ids {
+			if !manager.ServerTcpStartInstance(instance) {
+				for _, started := range ids[:i] {
+					manager.ServerTcpStopInstance(started)
+				}
+				return 1
+			}
+		}
+		send = func(key string, base64Data string) {
+			if key == "" {
+				manager.ServerBroadcastMessageInstance(ids[0], base64Data)
+				return
+			}
+			manager.ServerSendMessageInstance(ids[0], key, base64Data)
+		}
+		stop = func() {
+			for _, instance := range ids {
+				manager.ServerTcpStopInstance(instance)
+			}
+		}
+	case "client":
+		if manager.ClientTcpOpen() < 0 {
+			return 1
+		}
+		send = func(key string, base64Data string) {
+			index := 0
+			if key != "" {
+				var err error
+				if index, err = strconv.Atoi(key); err != nil {
+					app.EventsEmit("client-tcp-error", -1, fmt.Sprintf("invalid client index %s", key))
+					return
+				}
+			}
+			manager.ClientTcpSend(index, base64Data)
+		}
+		stop = manager.ClientTcpCloseAll
+	case "transfer":
+		ids := instances(*id)
+		if ids == nil {
+			if !manager.TransferTcpStart() {
+				return 1
+			}
+			send = func(key string, base64Data string) {
+				switch {
+				case key == "" && *to == "server":
+					manager.TransferBroadcastToServer(base64Data)
+				case key == "":
+					manager.TransferBroadcastToClient(base64Data)
+				case *to == "server":
+					manager.TransferSendToServer(key, base64Data)
+				default:
+					manager.TransferSendToClient(key, base64Data)
+				}
+			}
+			stop = func() { manager.TransferTcpStop() }
+			break
+		}
+		for i, instance := range ids {
+			if !manager.TransferTcpStartInstance(instance) {
+				for _, started := range ids[:i] {
+					manager.TransferTcpStopInstance(started)
+				}
+				return 1
+			}
+		}
+		send = func(key string, base64Data string) {
+			switch {
+			case key == "" && *to == "server":
+				manager.TransferBroadcastToServerInstance(ids[0], base64Data)
+			case key == "":
+				manager.TransferBroadcastToClientInstance(ids[0], base64Data)
+			case *to == "server":
+				manager.TransferSendToServerInstance(ids[0], key, base64Data)
+			default:
+				manager.TransferSendToClientInstance(ids[0], key, base64Data)
+			}
+		}
+		stop = func() {
+			for _, instance := range ids {
+				manager.TransferTcpStopInstance(instance)
+			}
+		}
+	case "bot":
+		if !manager.BotStart() {
+			return 1
+		}
+		stop = func() { manager.BotStop() }
+	}
+
+	// the bot plays its script and does not read stdin
+	if send != nil {
+		go readInput(os.Stdin, *input, send, app)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-signals:
+		stop()
+	case passed := <-finished:
+		if !passed {
+			return 1
+		}
+	}
+	return 0
+}
+
+// instances splits the -id flag, it returns nil when no instance is given.
+func instances(flag string) []string {
+	var ids []string
+	for _, id := range strings.Split(flag, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// readInput sends every line of r until the end of the input. The process keeps running
+// afterwards, so a relay started with a closed stdin is not stopped.
+func readInput(r io.Reader, input string, send func(key string, base64Data string), app *mircat.App) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), mircat.MAX_FRAME_SIZE)
+	for scanner.Scan() {
+		line := scanner.Text()
+		key := ""
+		if strings.HasPrefix(line, "@") {
+			i := strings.IndexByte(line, ' ')
+			if i < 0 {
+				app.EventsEmit("input-error", line, "missing data after the key")
+				continue
+			}
+			key, line = line[1:i], line[i+1:]
+		}
+		var data []byte
+		var err error
+		switch input {
+		case INPUT_HEX:
+			data, err = hex.DecodeString(strings.ReplaceAll(line, " ", ""))
+		case INPUT_BASE64:
+			data, err = base64.StdEncoding.DecodeString(line)
+		default:
+			data = []byte(line)
+		}
+		if err != nil {
+			app.EventsEmit("input-error", key, fmt.Sprintf("%v", err))
+			continue
+		}
+		send(key, base64.StdEncoding.EncodeToString(data))
+	}
+}
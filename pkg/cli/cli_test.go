@@ -0,0 +1,86 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mir-cat/pkg/mircat"
+	"strings"
+	"testing"
+)
+
+func TestReadInput(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		lines string
+		sent  string
+	}{
+		{"text to all", INPUT_TEXT, "hello\n", "=hello"},
+		{"text to a key", INPUT_TEXT, "@1.2.3.4:5 hi there\n", "1.2.3.4:5=hi there"},
+		{"hex", INPUT_HEX, "23 31 21\n", "=#1!"},
+		{"base64", INPUT_BASE64, "@0 aGk=\n", "0=hi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := []string{}
+			readInput(strings.NewReader(tt.lines), tt.input, func(key string, base64Data string) {
+				data, _ := base64.StdEncoding.DecodeString(base64Data)
+				sent = append(sent, key+"="+string(data))
+			}, mircat.NewAppWithSink(mircat.NewEventRecorder()))
+			if strings.Join(sent, ",") != tt.sent {
+				t.Fatalf("sent %q, want %q", sent, tt.sent)
+			}
+		})
+	}
+}
+
+func TestReadInputReportsBadLines(t *testing.T) {
+	rec := mircat.NewEventRecorder()
+	sent := 0
+	readInput(strings.NewReader("@nodata\nzz\n@k 41\n"), INPUT_HEX, func(key string, base64Data string) { sent++ }, mircat.NewAppWithSink(rec))
+	errors := rec.Events("input-error")
+	if sent != 1 || len(errors) != 2 || errors[0].Data[1] != "missing data after the key" {
+		t.Fatalf("sent %d, errors %+v", sent, errors)
+	}
+}
+
+func TestTextSink(t *testing.T) {
+	out := &bytes.Buffer{}
+	sink := &textSink{out: out, data: INPUT_HEX}
+	sink.Emit("server-tcp-data", "1.2.3.4:5", []byte("#!"), 7, map[string]int{"a": 1})
+	line := out.String()
+	// the line starts with the time
+	if !strings.HasSuffix(line, ` server-tcp-data 1.2.3.4:5 2321 7 {"a":1}`+"\n") {
+		t.Fatalf("line %q", line)
+	}
+	out.Reset()
+	sink.data = INPUT_TEXT
+	sink.Emit("client-tcp-data", []byte("a\"b"))
+	if !strings.HasSuffix(out.String(), ` client-tcp-data "a\"b"`+"\n") {
+		t.Fatalf("line %q", out.String())
+	}
+}
+
+func TestInstances(t *testing.T) {
+	if ids := instances(" "); ids != nil {
+		t.Fatalf("ids %q", ids)
+	}
+	if ids := instances("login, game,,"); len(ids) != 2 || ids[0] != "login" || ids[1] != "game" {
+		t.Fatalf("ids %q", ids)
+	}
+}
+
+func TestRunRejectsBadArguments(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"proxy"},
+		{"server", "-format", "xml"},
+		{"server", "-input", "octal"},
+		{"transfer", "-to", "both"},
+		{"client", "-unknown"},
+	} {
+		if code := Run(args); code != 2 {
+			t.Errorf("%q exited with %d", args, code)
+		}
+	}
+}
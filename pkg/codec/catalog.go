@@ -0,0 +1,557 @@
+package codec
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// FROM_CLIENT marks the messages sent by the client, the CM_ identifiers.
+	FROM_CLIENT = "client"
+	// FROM_SERVER marks the messages sent by the server, the SM_ identifiers.
+	FROM_SERVER = "server"
+
+	// BODY_TEXT splits the body text on a separator, one field per value.
+	BODY_TEXT = "text"
+	// BODY_BINARY reads the fields one after the other from the decoded body.
+	BODY_BINARY = "binary"
+	// BODY_PARTS reads one field from every '/' separated encoded block of the body.
+	BODY_PARTS = "parts"
+)
+
+//go:embed catalog.json
+var defaultCatalog []byte
+
+// FieldSpec describes a field of a message body.
+type FieldSpec struct {
+	Name string `json:"name" yaml:"name"`
+	// Type is the type of the value: "string" and "int" for text bodies; "int8", "uint8", "int16",
+	// "uint16", "int32", "uint32", "bool", "bytes", "string", "shortstring" and the records "TStdItem",
+	// "TClientItem", "TUserItem", "TCharDesc", "TMessageBodyW", "TMessageBodyWL" and "TAbility" for
+	// binary and parts bodies. Strings are converted from GBK.
+	Type string `json:"type,omitempty" yaml:"type,omitempty"`
+	// Size is the length of "bytes", "string" and "shortstring" fields. A "bytes" or "string" field
+	// without size takes the rest of the body.
+	Size int `json:"size,omitempty" yaml:"size,omitempty"`
+}
+
+// MessageSpec names a Mir message and describes its header and body.
+type MessageSpec struct {
+	Ident uint16 `json:"ident" yaml:"ident"`
+	Name  string `json:"name" yaml:"name"`
+	// Direction is "client" or "server", the identifiers of both sides overlap. A message without
+	// direction matches both.
+	Direction string `json:"direction,omitempty" yaml:"direction,omitempty"`
+	// Header names the header values: the keys are "recog", "param", "tag" and "series", or
+	// "recogLo", "recogHi", "seriesLo" and "seriesHi" for their low and high halves.
+	Header map[string]string `json:"header,omitempty" yaml:"header,omitempty"`
+	// Body is the layout of the body, "text", "binary" or "parts", the body is not parsed when empty.
+	Body string `json:"body,omitempty" yaml:"body,omitempty"`
+	// Separator splits text bodies, "/" when empty. The last field takes the rest of the text.
+	Separator string `json:"separator,omitempty" yaml:"separator,omitempty"`
+	// Fields are the values of the body, in order.
+	Fields []FieldSpec `json:"fields,omitempty" yaml:"fields,omitempty"`
+	// Repeat, when set, reads the fields again until the body is exhausted and stores the
+	// records in a list under this name.
+	Repeat string `json:"repeat,omitempty" yaml:"repeat,omitempty"`
+}
+
+// Catalog maps the Mir identifiers to their names and layouts. Every server fork numbers some
+// messages differently, the catalog is data so it can be adapted without a new build.
+type Catalog struct {
+	Messages []MessageSpec `json:"messages" yaml:"messages"`
+	index    map[catalogKey]*MessageSpec
+	names    map[string]*MessageSpec
+}
+
+type catalogKey struct {
+	ident     uint16
+	direction string
+}
+
+// FieldDecoder reads a field of a binary body and returns its value and the number of bytes used.
+type FieldDecoder func(data []byte, size int) (interface{}, int, error)
+
+var fieldDecoders = map[string]FieldDecoder{
+	"int8":        fixedField(1, func(b []byte) interface{} { return int8(b[0]) }),
+	"uint8":       fixedField(1, func(b []byte) interface{} { return b[0] }),
+	"bool":        fixedField(1, func(b []byte) interface{} { return b[0] != 0 }),
+	"int16":       fixedField(2, func(b []byte) interface{} { return int16(binary.LittleEndian.Uint16(b)) }),
+	"uint16":      fixedField(2, func(b []byte) interface{} { return binary.LittleEndian.Uint16(b) }),
+	"int32":       fixedField(4, func(b []byte) interface{} { return int32(binary.LittleEndian.Uint32(b)) }),
+	"uint32":      fixedField(4, func(b []byte) interface{} { return binary.LittleEndian.Uint32(b) }),
+	"bytes":       decodeBytes,
+	"string":      decodeString,
+	"shortstring": decodeShortString,
+
+	"TStdItem":       recordField(STD_ITEM_SIZE, func(b []byte) (interface{}, error) { return DecodeStdItem(b) }),
+	"TClientItem":    recordField(CLIENT_ITEM_SIZE, func(b []byte) (interface{}, error) { return DecodeClientItem(b) }),
+	"TUserItem":      recordField(USER_ITEM_SIZE, func(b []byte) (interface{}, error) { return DecodeUserItem(b) }),
+	"TCharDesc":      recordField(CHAR_DESC_SIZE, func(b []byte) (interface{}, error) { return DecodeCharDesc(b) }),
+	"TMessageBodyW":  recordField(MESSAGE_BODY_W_SIZE, func(b []byte) (interface{}, error) { return DecodeMessageBodyW(b) }),
+	"TMessageBodyWL": recordField(MESSAGE_BODY_WL_SIZE, func(b []byte) (interface{}, error) { return DecodeMessageBodyWL(b) }),
+	"TAbility":       recordField(ABILITY_SIZE, func(b []byte) (interface{}, error) { return DecodeAbility(b) }),
+}
+
+// fieldDecodersMutex guards fieldDecoders, RegisterFieldType may add to it while packets are decoded.
+var fieldDecodersMutex sync.RWMutex
+
+var headerKeys = map[string]bool{
+	"recog": true, "recogLo": true, "recogHi": true, "param": true, "tag": true,
+	"series": true, "seriesLo": true, "seriesHi": true,
+}
+
+var current = struct {
+	sync.RWMutex
+	catalog *Catalog
+}{}
+
+func init() {
+	catalog, err := DefaultCatalog()
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in catalog: %v", err))
+	}
+	current.catalog = catalog
+}
+
+// RegisterFieldType adds a type usable by the fields of binary and parts bodies.
+// It has to be called before the catalogs using it are loaded.
+func RegisterFieldType(name string, decoder FieldDecoder) {
+	fieldDecodersMutex.Lock()
+	fieldDecoders[name] = decoder
+	fieldDecodersMutex.Unlock()
+}
+
+// fieldDecoder returns the decoder of a field type, or nil when the type is unknown.
+func fieldDecoder(name string) FieldDecoder {
+	fieldDecodersMutex.RLock()
+	defer fieldDecodersMutex.RUnlock()
+	return fieldDecoders[name]
+}
+
+// CurrentCatalog returns the catalog used by the decoders.
+func CurrentCatalog() *Catalog {
+	current.RLock()
+	defer current.RUnlock()
+	return current.catalog
+}
+
+// SetCatalog replaces the catalog used by the decoders, the packets decoded afterwards use it.
+func SetCatalog(catalog *Catalog) error {
+	if err := catalog.compile(); err != nil {
+		return err
+	}
+	current.Lock()
+	current.catalog = catalog
+	current.Unlock()
+	return nil
+}
+
+// DefaultCatalog returns the built-in catalog of the messages shared by the common servers.
+func DefaultCatalog() (*Catalog, error) {
+	return ParseCatalog(defaultCatalog, false)
+}
+
+// ParseCatalog reads a catalog in JSON, or in YAML when isYaml is set.
+func ParseCatalog(data []byte, isYaml bool) (*Catalog, error) {
+	catalog := &Catalog{}
+	var err error
+	if isYaml {
+		err = yaml.Unmarshal(data, catalog)
+	} else {
+		err = json.Unmarshal(data, catalog)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := catalog.compile(); err != nil {
+		return nil, err
+	}
+	return catalog, nil
+}
+
+// LoadCatalog reads a catalog file, in YAML when its extension is .yaml or .yml and in JSON otherwise.
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCatalog(data, isYamlFile(path))
+}
+
+// Save writes the catalog to a file, in the format given by its extension.
+func (c *Catalog) Save(path string) error {
+	var data []byte
+	var err error
+	if isYamlFile(path) {
+		data, err = yaml.Marshal(c)
+	} else {
+		data, err = json.MarshalIndent(c, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func isYamlFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Validate checks the specs and indexes them, SetCatalog does it as well.
+func (c *Catalog) Validate() error {
+	return c.compile()
+}
+
+// compile checks the specs and indexes them.
+func (c *Catalog) compile() error {
+	index := map[catalogKey]*MessageSpec{}
+	names := map[string]*MessageSpec{}
+	for i := range c.Messages {
+		spec := &c.Messages[i]
+		if spec.Name == "" {
+			return fmt.Errorf("message %d has no name", spec.Ident)
+		}
+		if spec.Direction != "" && spec.Direction != FROM_CLIENT && spec.Direction != FROM_SERVER {
+			return fmt.Errorf("%s: unknown direction %s", spec.Name, spec.Direction)
+		}
+		key := catalogKey{spec.Ident, spec.Direction}
+		if other, ok := index[key]; ok {
+			return fmt.Errorf("%s and %s share the identifier %d", other.Name, spec.Name, spec.Ident)
+		}
+		index[key] = spec
+		names[spec.Name] = spec
+		if spec.Body != "" && spec.Body != BODY_TEXT && spec.Body != BODY_BINARY && spec.Body != BODY_PARTS {
+			return fmt.Errorf("%s: unknown body layout %s", spec.Name, spec.Body)
+		}
+		for name := range spec.Header {
+			if !headerKeys[name] {
+				return fmt.Errorf("%s: unknown header value %s", spec.Name, name)
+			}
+		}
+		for _, field := range spec.Fields {
+			if field.Name == "" {
+				return fmt.Errorf("%s: field without name", spec.Name)
+			}
+			switch spec.Body {
+			case BODY_TEXT:
+				if field.Type != "" && field.Type != "string" && field.Type != "int" {
+					return fmt.Errorf("%s: unknown text field type %s", spec.Name, field.Type)
+				}
+			case BODY_BINARY, BODY_PARTS:
+				if fieldDecoder(field.Type) == nil {
+					return fmt.Errorf("%s: unknown field type %s", spec.Name, field.Type)
+				}
+			default:
+				return fmt.Errorf("%s: fields need a body layout", spec.Name)
+			}
+		}
+	}
+	c.index = index
+	c.names = names
+	return nil
+}
+
+// Lookup returns the spec of a message, or nil when the catalog does not know it. A message
+// of unknown direction matches the spec without direction first, then any spec.
+func (c *Catalog) Lookup(ident uint16, direction string) *MessageSpec {
+	if spec, ok := c.index[catalogKey{ident, direction}]; ok {
+		return spec
+	}
+	if direction != "" {
+		return c.index[catalogKey{ident, ""}]
+	}
+	if spec, ok := c.index[catalogKey{ident, FROM_CLIENT}]; ok {
+		return spec
+	}
+	return c.index[catalogKey{ident, FROM_SERVER}]
+}
+
+// Find returns the spec of the message with the given name, or nil when the catalog does not know it.
+func (c *Catalog) Find(name string) *MessageSpec {
+	return c.names[name]
+}
+
+// Annotate sets the name and the fields of a packet from its spec. A body that does not match
+// the layout leaves the fields read so far and the reason in FieldError.
+func (c *Catalog) Annotate(p *Packet, direction string) {
+	if p.Message == nil {
+		return
+	}
+	spec := c.Lookup(p.Message.Ident, direction)
+	if spec == nil {
+		return
+	}
+	p.Name = spec.Name
+	p.Fields = map[string]interface{}{}
+	spec.header(*p.Message, p.Fields)
+	if len(spec.Fields) == 0 {
+		return
+	}
+	var err error
+	if spec.Repeat == "" {
+		err = spec.body(p, p.Fields)
+	} else {
+		records := []map[string]interface{}{}
+		err = spec.repeat(p, &records)
+		p.Fields[spec.Repeat] = records
+	}
+	if err != nil {
+		p.FieldError = err.Error()
+	}
+}
+
+func (s *MessageSpec) header(msg DefaultMessage, fields map[string]interface{}) {
+	for key, name := range s.Header {
+		switch key {
+		case "recog":
+			fields[name] = msg.Recog
+		case "recogLo":
+			fields[name] = uint16(msg.Recog)
+		case "recogHi":
+			fields[name] = uint16(uint32(msg.Recog) >> 16)
+		case "param":
+			fields[name] = msg.Param
+		case "tag":
+			fields[name] = msg.Tag
+		case "series":
+			fields[name] = msg.Series
+		case "seriesLo":
+			fields[name] = uint8(msg.Series)
+		case "seriesHi":
+			fields[name] = uint8(msg.Series >> 8)
+		}
+	}
+}
+
+func (s *MessageSpec) body(p *Packet, fields map[string]interface{}) error {
+	switch s.Body {
+	case BODY_TEXT:
+		values := strings.SplitN(p.Text, s.separator(), len(s.Fields))
+		for i, value := range values {
+			if err := s.textField(s.Fields[i], value, fields); err != nil {
+				return err
+			}
+		}
+	case BODY_BINARY:
+		_, err := s.binaryFields(p.Body, fields)
+		return err
+	case BODY_PARTS:
+		parts := p.Parts
+		if parts == nil {
+			parts = [][]byte{p.Body}
+		}
+		for i, part := range parts {
+			if i == len(s.Fields) {
+				break
+			}
+			if err := s.partField(s.Fields[i], part, fields); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MessageSpec) repeat(p *Packet, records *[]map[string]interface{}) error {
+	switch s.Body {
+	case BODY_TEXT:
+		values := strings.Split(p.Text, s.separator())
+		// bodies of records usually end with the separator
+		if len(values) > 0 && values[len(values)-1] == "" {
+			values = values[:len(values)-1]
+		}
+		for i := 0; i < len(values); i += len(s.Fields) {
+			record := map[string]interface{}{}
+			*records = append(*records, record)
+			for j, field := range s.Fields {
+				if i+j == len(values) {
+					break
+				}
+				if err := s.textField(field, values[i+j], record); err != nil {
+					return err
+				}
+			}
+		}
+	case BODY_BINARY:
+		data := p.Body
+		for len(data) > 0 {
+			record := map[string]interface{}{}
+			*records = append(*records, record)
+			n, err := s.binaryFields(data, record)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			data = data[n:]
+		}
+	case BODY_PARTS:
+		parts := p.Parts
+		if parts == nil && len(p.Body) > 0 {
+			parts = [][]byte{p.Body}
+		}
+		if len(parts) > 0 && len(parts[len(parts)-1]) == 0 {
+			parts = parts[:len(parts)-1]
+		}
+		for i := 0; i < len(parts); i += len(s.Fields) {
+			record := map[string]interface{}{}
+			*records = append(*records, record)
+			for j, field := range s.Fields {
+				if i+j == len(parts) {
+					break
+				}
+				if err := s.partField(field, parts[i+j], record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Message builds the header of the message from named values, the reverse of the header
+// fields set by Annotate. Values the header does not name are ignored.
+func (s *MessageSpec) Message(values map[string]int64) DefaultMessage {
+	msg := DefaultMessage{Ident: s.Ident}
+	for key, name := range s.Header {
+		value, ok := values[name]
+		if !ok {
+			continue
+		}
+		switch key {
+		case "recog":
+			msg.Recog = int32(value)
+		case "recogLo":
+			msg.Recog = int32(uint32(msg.Recog)&0xFFFF0000 | uint32(uint16(value)))
+		case "recogHi":
+			msg.Recog = int32(uint32(msg.Recog)&0xFFFF | uint32(uint16(value))<<16)
+		case "param":
+			msg.Param = uint16(value)
+		case "tag":
+			msg.Tag = uint16(value)
+		case "series":
+			msg.Series = uint16(value)
+		case "seriesLo":
+			msg.Series = msg.Series&0xFF00 | uint16(uint8(value))
+		case "seriesHi":
+			msg.Series = msg.Series&0xFF | uint16(uint8(value))<<8
+		}
+	}
+	return msg
+}
+
+// Text builds the text body of the message from records of named values, the reverse of
+// the text layout. Messages with Repeat take any number of records and end each one with
+// the separator, the others use the first record only.
+func (s *MessageSpec) Text(records ...map[string]string) string {
+	if s.Repeat == "" && len(records) > 1 {
+		records = records[:1]
+	}
+	text := strings.Builder{}
+	for _, record := range records {
+		for i, field := range s.Fields {
+			if i > 0 {
+				text.WriteString(s.separator())
+			}
+			text.WriteString(record[field.Name])
+		}
+		if s.Repeat != "" {
+			text.WriteString(s.separator())
+		}
+	}
+	return text.String()
+}
+
+func (s *MessageSpec) separator() string {
+	if s.Separator == "" {
+		return "/"
+	}
+	return s.Separator
+}
+
+func (s *MessageSpec) textField(field FieldSpec, value string, fields map[string]interface{}) error {
+	if field.Type != "int" {
+		fields[field.Name] = DecodeText([]byte(value))
+		return nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("%s: %s is not a number", field.Name, value)
+	}
+	fields[field.Name] = n
+	return nil
+}
+
+// binaryFields reads the fields from data and returns the number of bytes used.
+func (s *MessageSpec) binaryFields(data []byte, fields map[string]interface{}) (int, error) {
+	used := 0
+	for _, field := range s.Fields {
+		value, n, err := fieldDecoder(field.Type)(data[used:], field.Size)
+		if err != nil {
+			return used, fmt.Errorf("%s: %v", field.Name, err)
+		}
+		fields[field.Name] = value
+		used += n
+	}
+	return used, nil
+}
+
+func (s *MessageSpec) partField(field FieldSpec, part []byte, fields map[string]interface{}) error {
+	value, _, err := fieldDecoder(field.Type)(part, field.Size)
+	if err != nil {
+		return fmt.Errorf("%s: %v", field.Name, err)
+	}
+	fields[field.Name] = value
+	return nil
+}
+
+func fixedField(size int, read func(b []byte) interface{}) FieldDecoder {
+	return func(data []byte, _ int) (interface{}, int, error) {
+		if len(data) < size {
+			return nil, 0, fmt.Errorf("needs %d bytes, got %d", size, len(data))
+		}
+		return read(data[:size]), size, nil
+	}
+}
+
+func decodeBytes(data []byte, size int) (interface{}, int, error) {
+	if size == 0 {
+		return append([]byte{}, data...), len(data), nil
+	}
+	if len(data) < size {
+		return nil, 0, fmt.Errorf("needs %d bytes, got %d", size, len(data))
+	}
+	return append([]byte{}, data[:size]...), size, nil
+}
+
+func decodeString(data []byte, size int) (interface{}, int, error) {
+	value, n, err := decodeBytes(data, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	return DecodeText(bytes.TrimRight(value.([]byte), "\x00")), n, nil
+}
+
+// decodeShortString reads a Delphi String[size], String[255] when size is 0.
+func decodeShortString(data []byte, size int) (interface{}, int, error) {
+	if size == 0 {
+		size = 255
+	}
+	value, err := DecodeShortString(data, size)
+	if err != nil {
+		return nil, 0, err
+	}
+	return value, size + 1, nil
+}
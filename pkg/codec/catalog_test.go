@@ -0,0 +1,201 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const testCatalog = `
+messages:
+  - ident: 10
+    name: CM_SAY
+    direction: client
+    header: {recog: actor, seriesLo: lo, seriesHi: hi}
+    body: text
+    fields: [{name: who}, {name: level, type: int}, {name: text}]
+  - ident: 10
+    name: SM_BAG
+    direction: server
+    body: binary
+    repeat: items
+    fields: [{name: id, type: uint16}, {name: name, type: string, size: 3}]
+  - ident: 11
+    name: SM_PARTS
+    body: parts
+    fields: [{name: first, type: uint8}, {name: second, type: bytes}]
+`
+
+func parseTestCatalog(t *testing.T) *Catalog {
+	t.Helper()
+	catalog, err := ParseCatalog([]byte(testCatalog), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return catalog
+}
+
+func TestCatalogLookup(t *testing.T) {
+	catalog := parseTestCatalog(t)
+	if spec := catalog.Lookup(10, FROM_CLIENT); spec == nil || spec.Name != "CM_SAY" {
+		t.Fatalf("client 10 is %+v", spec)
+	}
+	if spec := catalog.Lookup(10, FROM_SERVER); spec == nil || spec.Name != "SM_BAG" {
+		t.Fatalf("server 10 is %+v", spec)
+	}
+	// a spec without direction matches both sides, an unknown side prefers the client
+	if spec := catalog.Lookup(11, FROM_CLIENT); spec == nil || spec.Name != "SM_PARTS" {
+		t.Fatalf("client 11 is %+v", spec)
+	}
+	if spec := catalog.Lookup(10, ""); spec == nil || spec.Name != "CM_SAY" {
+		t.Fatalf("10 is %+v", spec)
+	}
+	if catalog.Lookup(12, FROM_SERVER) != nil || catalog.Find("SM_NONE") != nil {
+		t.Fatal("found an unknown message")
+	}
+	if spec := catalog.Find("SM_BAG"); spec == nil || spec.Ident != 10 {
+		t.Fatalf("SM_BAG is %+v", spec)
+	}
+}
+
+func TestCatalogAnnotate(t *testing.T) {
+	catalog := parseTestCatalog(t)
+	say := &Packet{Message: &DefaultMessage{Ident: 10, Recog: 7, Series: 0x0201}, Text: "bob/12/hello/there"}
+	catalog.Annotate(say, FROM_CLIENT)
+	if say.Name != "CM_SAY" || say.FieldError != "" {
+		t.Fatalf("packet %+v", say)
+	}
+	// the last text field takes the rest
+	if say.Fields["actor"] != int32(7) || say.Fields["lo"] != uint8(1) || say.Fields["hi"] != uint8(2) ||
+		say.Fields["who"] != "bob" || say.Fields["level"] != 12 || say.Fields["text"] != "hello/there" {
+		t.Fatalf("fields %v", say.Fields)
+	}
+
+	body := []byte{1, 0, 'a', 'b', 0, 2, 0, 'c', 'd', 'e'}
+	bag := &Packet{Message: &DefaultMessage{Ident: 10}, Body: body}
+	catalog.Annotate(bag, FROM_SERVER)
+	items := bag.Fields["items"].([]map[string]interface{})
+	if len(items) != 2 || items[0]["id"] != uint16(1) || items[0]["name"] != "ab" || items[1]["name"] != "cde" {
+		t.Fatalf("items %v", items)
+	}
+	// a body cut short keeps what was read and tells why
+	bag = &Packet{Message: &DefaultMessage{Ident: 10}, Body: body[:7]}
+	catalog.Annotate(bag, FROM_SERVER)
+	if len(bag.Fields["items"].([]map[string]interface{})) != 2 || bag.FieldError == "" {
+		t.Fatalf("fields %v, error %q", bag.Fields, bag.FieldError)
+	}
+
+	parts := &Packet{Message: &DefaultMessage{Ident: 11}, Parts: [][]byte{{9}, []byte("xy")}}
+	catalog.Annotate(parts, FROM_SERVER)
+	if parts.Fields["first"] != uint8(9) || string(parts.Fields["second"].([]byte)) != "xy" {
+		t.Fatalf("fields %v", parts.Fields)
+	}
+
+	bad := &Packet{Message: &DefaultMessage{Ident: 10}, Text: "bob/high/hi"}
+	catalog.Annotate(bad, FROM_CLIENT)
+	if bad.FieldError != "level: high is not a number" {
+		t.Fatalf("error %q", bad.FieldError)
+	}
+}
+
+func TestCatalogBuildsMessages(t *testing.T) {
+	catalog := parseTestCatalog(t)
+	say := catalog.Find("CM_SAY")
+	msg := say.Message(map[string]int64{"actor": 5, "lo": 3, "hi": 4, "unused": 1})
+	if msg != (DefaultMessage{Ident: 10, Recog: 5, Series: 0x0403}) {
+		t.Fatalf("message %+v", msg)
+	}
+	if text := say.Text(map[string]string{"who": "bob", "level": "1", "text": "hi"}, map[string]string{"who": "x"}); text != "bob/1/hi" {
+		t.Fatalf("text %q", text)
+	}
+	repeated := &MessageSpec{Name: "SM_LIST", Repeat: "list", Fields: []FieldSpec{{Name: "a"}, {Name: "b"}}}
+	if text := repeated.Text(map[string]string{"a": "1", "b": "2"}, map[string]string{"a": "3", "b": "4"}); text != "1/2/3/4/" {
+		t.Fatalf("text %q", text)
+	}
+}
+
+func TestCatalogRejectsInvalidSpecs(t *testing.T) {
+	for _, spec := range []string{
+		`{"ident": 1}`,
+		`{"ident": 1, "name": "A", "direction": "up"}`,
+		`{"ident": 1, "name": "A", "body": "xml"}`,
+		`{"ident": 1, "name": "A", "header": {"ident": "x"}}`,
+		`{"ident": 1, "name": "A", "fields": [{"name": "x"}]}`,
+		`{"ident": 1, "name": "A", "body": "text", "fields": [{"name": "x", "type": "float"}]}`,
+		`{"ident": 1, "name": "A", "body": "binary", "fields": [{"name": "x", "type": "int"}]}`,
+		`{"ident": 1, "name": "A", "body": "binary", "fields": [{"type": "uint8"}]}`,
+		`{"ident": 1, "name": "A"}, {"ident": 1, "name": "B"}`,
+	} {
+		if _, err := ParseCatalog([]byte(`{"messages": [`+spec+`]}`), false); err == nil {
+			t.Errorf("%s accepted", spec)
+		}
+	}
+}
+
+func TestCatalogSaveAndLoad(t *testing.T) {
+	catalog := parseTestCatalog(t)
+	for _, name := range []string{"catalog.json", "catalog.yml"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := catalog.Save(path); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadCatalog(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(loaded.Messages) != 3 || loaded.Find("SM_BAG").Repeat != "items" {
+			t.Fatalf("%s loaded %+v", name, loaded.Messages)
+		}
+	}
+}
+
+func TestSetCatalog(t *testing.T) {
+	defer SetCatalog(CurrentCatalog())
+	if err := SetCatalog(&Catalog{Messages: []MessageSpec{{Ident: 1}}}); err == nil {
+		t.Fatal("an invalid catalog was applied")
+	}
+	if err := SetCatalog(parseTestCatalog(t)); err != nil {
+		t.Fatal(err)
+	}
+	d := &Decoder{Direction: FROM_CLIENT}
+	packets := d.Feed(EncodeMessage(DefaultMessage{Ident: 10}, []byte("bob/1/hi")))
+	if len(packets) != 1 || packets[0].Name != "CM_SAY" || packets[0].Fields["who"] != "bob" {
+		t.Fatalf("packets %+v", packets)
+	}
+}
+
+func TestRegisterFieldTypeWhileDecoding(t *testing.T) {
+	RegisterFieldType("point", func(data []byte, _ int) (interface{}, int, error) {
+		if len(data) < 4 {
+			return nil, 0, fmt.Errorf("needs 4 bytes, got %d", len(data))
+		}
+		return [2]uint16{binary.LittleEndian.Uint16(data), binary.LittleEndian.Uint16(data[2:])}, 4, nil
+	})
+	catalog, err := ParseCatalog([]byte(`{"messages": [{"ident": 1, "name": "SM_AT", "body": "binary", "fields": [{"name": "at", "type": "point"}]}]}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			RegisterFieldType(fmt.Sprintf("type%d", i), decodeBytes)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			p := &Packet{Message: &DefaultMessage{Ident: 1}, Body: []byte{1, 0, 2, 0}}
+			catalog.Annotate(p, FROM_SERVER)
+			if p.Fields["at"] != [2]uint16{1, 2} {
+				t.Errorf("fields %v", p.Fields)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,293 @@
+// Package codec implements the wire format of Legend of Mir.
+//
+// A Mir packet is framed by '#' and '!'. Packets sent by the client carry a
+// rolling counter digit after '#'. The payload is a 12-byte DefaultMessage
+// followed by an optional body, both encoded with Mir's 6-bit encoding which
+// maps every 6 bits to a printable character starting at '<'.
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// PACKET_START starts every Mir packet.
+	PACKET_START = '#'
+	// PACKET_END ends every Mir packet.
+	PACKET_END = '!'
+	// KEEP_ALIVE is sent by the client between packets.
+	KEEP_ALIVE = '*'
+	// ACTION_RESULT prefixes the plain text answers of the server to player actions, e.g. "#+GOOD/…!".
+	ACTION_RESULT = '+'
+
+	// DEFAULT_MESSAGE_SIZE is the size of the DefaultMessage header.
+	DEFAULT_MESSAGE_SIZE = 12
+	// ENCODED_MESSAGE_SIZE is the size of the 6-bit encoded DefaultMessage header.
+	ENCODED_MESSAGE_SIZE = 16
+
+	// MAX_PACKET_SIZE bounds the data buffered while waiting for the end of a packet.
+	MAX_PACKET_SIZE = 64 * 1024
+
+	encodeBase = 0x3C
+)
+
+// DefaultMessage is the header of every Mir message.
+type DefaultMessage struct {
+	Recog  int32  `json:"recog"`
+	Ident  uint16 `json:"ident"`
+	Param  uint16 `json:"param"`
+	Tag    uint16 `json:"tag"`
+	Series uint16 `json:"series"`
+}
+
+// Bytes returns the little-endian wire representation of the message.
+func (m DefaultMessage) Bytes() []byte {
+	b := make([]byte, DEFAULT_MESSAGE_SIZE)
+	binary.LittleEndian.PutUint32(b[0:], uint32(m.Recog))
+	binary.LittleEndian.PutUint16(b[4:], m.Ident)
+	binary.LittleEndian.PutUint16(b[6:], m.Param)
+	binary.LittleEndian.PutUint16(b[8:], m.Tag)
+	binary.LittleEndian.PutUint16(b[10:], m.Series)
+	return b
+}
+
+// ParseDefaultMessage reads a DefaultMessage from its wire representation.
+func ParseDefaultMessage(b []byte) (DefaultMessage, error) {
+	if len(b) < DEFAULT_MESSAGE_SIZE {
+		return DefaultMessage{}, fmt.Errorf("default message needs %d bytes, got %d", DEFAULT_MESSAGE_SIZE, len(b))
+	}
+	return DefaultMessage{
+		Recog:  int32(binary.LittleEndian.Uint32(b[0:])),
+		Ident:  binary.LittleEndian.Uint16(b[4:]),
+		Param:  binary.LittleEndian.Uint16(b[6:]),
+		Tag:    binary.LittleEndian.Uint16(b[8:]),
+		Series: binary.LittleEndian.Uint16(b[10:]),
+	}, nil
+}
+
+// Encode6Bit encodes bytes with Mir's 6-bit encoding.
+func Encode6Bit(src []byte) []byte {
+	dst := make([]byte, 0, (len(src)*4+2)/3)
+	var rest byte
+	restCount := 0
+	for _, ch := range src {
+		made := (rest | (ch >> (2 + restCount))) & 0x3F
+		rest = ((ch << (8 - (2 + restCount))) >> 2) & 0x3F
+		restCount += 2
+		if restCount < 6 {
+			dst = append(dst, made+encodeBase)
+			continue
+		}
+		dst = append(dst, made+encodeBase, rest+encodeBase)
+		restCount = 0
+		rest = 0
+	}
+	if restCount > 0 {
+		dst = append(dst, rest+encodeBase)
+	}
+	return dst
+}
+
+// Decode6Bit decodes data produced by Encode6Bit.
+func Decode6Bit(src []byte) ([]byte, error) {
+	masks := [7]byte{2: 0xFC, 3: 0xF8, 4: 0xF0, 5: 0xE0, 6: 0xC0}
+	dst := make([]byte, 0, len(src)*3/4)
+	bitPos := 2
+	madeBit := 0
+	var tmp byte
+	for i, c := range src {
+		if c < encodeBase || c >= encodeBase+0x40 {
+			return nil, fmt.Errorf("invalid character 0x%02x at %d", c, i)
+		}
+		ch := c - encodeBase
+		if madeBit+6 >= 8 {
+			dst = append(dst, tmp|((ch&0x3F)>>(6-bitPos)))
+			madeBit = 0
+			if bitPos < 6 {
+				bitPos += 2
+			} else {
+				bitPos = 2
+				continue
+			}
+		}
+		tmp = (ch << bitPos) & masks[bitPos]
+		madeBit += 8 - bitPos
+	}
+	return dst, nil
+}
+
+// Packet is a decoded Mir packet.
+type Packet struct {
+	// Seq is the rolling counter of client packets, 1 to 9, or 0 when the packet has none.
+	Seq int `json:"seq"`
+	// Message is the header of the packet, nil for packets without one such as
+	// the game login string or the action results of the server.
+	Message *DefaultMessage `json:"message"`
+	// Body is the decoded body.
+	Body []byte `json:"body"`
+	// Parts holds the separately decoded parts of a body made of '/' separated encoded blocks.
+	Parts [][]byte `json:"parts,omitempty"`
+	// Text is the body as a string.
+	Text string `json:"text"`
+	// Encoded is the 6-bit encoded body as it appears on the wire.
+	Encoded string `json:"encoded"`
+	// Name is the name of the message in the catalog, empty when it is unknown.
+	Name string `json:"name,omitempty"`
+	// Fields are the named header values and body fields of the message, following its catalog layout.
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// FieldError tells why the body did not match its layout.
+	FieldError string `json:"fieldError,omitempty"`
+}
+
+// EncodePacket builds the wire representation of a packet. The body is taken
+// from Body, or from Text when Body is empty. The counter is written when Seq
+// is between 1 and 9, other values leave the packet without counter.
+func EncodePacket(p *Packet) []byte {
+	body := p.Body
+	if len(body) == 0 {
+		body = []byte(p.Text)
+	}
+	buf := bytes.Buffer{}
+	buf.WriteByte(PACKET_START)
+	if p.Seq >= 1 && p.Seq <= 9 {
+		buf.WriteByte(byte('0' + p.Seq))
+	}
+	if p.Message == nil && bytes.HasPrefix(body, []byte{ACTION_RESULT}) {
+		buf.Write(body)
+		buf.WriteByte(PACKET_END)
+		return buf.Bytes()
+	}
+	if p.Message != nil {
+		buf.Write(Encode6Bit(p.Message.Bytes()))
+	}
+	buf.Write(Encode6Bit(body))
+	buf.WriteByte(PACKET_END)
+	return buf.Bytes()
+}
+
+// EncodeMessage builds the wire representation of a header and a body without counter.
+func EncodeMessage(msg DefaultMessage, body []byte) []byte {
+	return EncodePacket(&Packet{Message: &msg, Body: body})
+}
+
+// DecodePacket decodes a single packet, with or without its '#' and '!' delimiters.
+func DecodePacket(frame []byte) (*Packet, error) {
+	payload := bytes.TrimPrefix(frame, []byte{PACKET_START})
+	payload = bytes.TrimSuffix(payload, []byte{PACKET_END})
+	p := &Packet{}
+	if len(payload) > 0 && payload[0] >= '0' && payload[0] <= '9' {
+		p.Seq = int(payload[0] - '0')
+		payload = payload[1:]
+	}
+	if len(payload) > 0 && payload[0] == ACTION_RESULT {
+		p.Body = append([]byte{}, payload...)
+		p.Text = string(payload)
+		return p, nil
+	}
+	if len(payload) < ENCODED_MESSAGE_SIZE {
+		body, err := Decode6Bit(payload)
+		if err != nil {
+			return nil, err
+		}
+		p.Body = body
+		p.Text = string(body)
+		p.Encoded = string(payload)
+		return p, nil
+	}
+	header, err := Decode6Bit(payload[:ENCODED_MESSAGE_SIZE])
+	if err != nil {
+		return nil, err
+	}
+	// the login string of the game server is sent without header
+	if bytes.HasPrefix(header, []byte("**")) {
+		body, err := Decode6Bit(payload)
+		if err != nil {
+			return nil, err
+		}
+		p.Body = body
+		p.Text = string(body)
+		p.Encoded = string(payload)
+		return p, nil
+	}
+	msg, err := ParseDefaultMessage(header)
+	if err != nil {
+		return nil, err
+	}
+	p.Message = &msg
+	encoded := payload[ENCODED_MESSAGE_SIZE:]
+	p.Encoded = string(encoded)
+	if bytes.IndexByte(encoded, '/') >= 0 {
+		for _, part := range bytes.Split(encoded, []byte{'/'}) {
+			decoded, err := Decode6Bit(part)
+			if err != nil {
+				return nil, err
+			}
+			p.Parts = append(p.Parts, decoded)
+		}
+		p.Body = bytes.Join(p.Parts, []byte{'/'})
+	} else {
+		p.Body, err = Decode6Bit(encoded)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.Text = string(p.Body)
+	return p, nil
+}
+
+// SplitPackets returns the complete '#'..'!' frames found in data and the
+// trailing bytes of an incomplete frame. Bytes outside of frames, such as
+// keep-alive characters, are skipped.
+func SplitPackets(data []byte) ([][]byte, []byte) {
+	frames := [][]byte{}
+	for {
+		start := bytes.IndexByte(data, PACKET_START)
+		if start < 0 {
+			return frames, nil
+		}
+		data = data[start:]
+		end := bytes.IndexByte(data, PACKET_END)
+		if end < 0 {
+			return frames, data
+		}
+		// a new start before the end means the previous frame was truncated
+		if next := bytes.IndexByte(data[1:end], PACKET_START); next >= 0 {
+			data = data[next+1:]
+			continue
+		}
+		frames = append(frames, data[:end+1])
+		data = data[end+1:]
+	}
+}
+
+// Decoder decodes the packets of a stream that arrives in arbitrary chunks.
+type Decoder struct {
+	// Direction is the side sending the stream, FROM_CLIENT or FROM_SERVER, it selects
+	// the catalog entries naming the packets.
+	Direction string
+	pending   []byte
+}
+
+// Feed appends a chunk of the stream and returns the packets completed by it.
+// Frames that cannot be decoded are skipped.
+func (d *Decoder) Feed(data []byte) []*Packet {
+	buf := append(d.pending, data...)
+	frames, rest := SplitPackets(buf)
+	if len(rest) > MAX_PACKET_SIZE {
+		rest = nil
+	}
+	d.pending = append([]byte{}, rest...)
+	packets := []*Packet{}
+	catalog := CurrentCatalog()
+	for _, frame := range frames {
+		p, err := DecodePacket(frame)
+		if err != nil {
+			continue
+		}
+		catalog.Annotate(p, d.Direction)
+		packets = append(packets, p)
+	}
+	return packets
+}
@@ -0,0 +1,156 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncode6Bit(t *testing.T) {
+	if got := string(Encode6Bit([]byte{0, 0, 0})); got != "<<<<" {
+		t.Errorf("zeros encoded as %q", got)
+	}
+	if got := string(Encode6Bit([]byte{0xFF})); got != "{l" {
+		t.Errorf("0xFF encoded as %q", got)
+	}
+	if got := len(Encode6Bit(make([]byte, DEFAULT_MESSAGE_SIZE))); got != ENCODED_MESSAGE_SIZE {
+		t.Errorf("a header encodes to %d characters, want %d", got, ENCODED_MESSAGE_SIZE)
+	}
+
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	for size := 0; size <= len(all); size++ {
+		encoded := Encode6Bit(all[:size])
+		for _, c := range encoded {
+			if c < encodeBase || c >= encodeBase+0x40 {
+				t.Fatalf("%d bytes encoded with character 0x%02x", size, c)
+			}
+		}
+		decoded, err := Decode6Bit(encoded)
+		if err != nil || !bytes.Equal(decoded, all[:size]) {
+			t.Fatalf("%d bytes decoded as %v, %v", size, decoded, err)
+		}
+	}
+}
+
+func TestDecode6BitRejectsForeignCharacters(t *testing.T) {
+	for _, data := range []string{"<<;", "<<|", "#<<"} {
+		if _, err := Decode6Bit([]byte(data)); err == nil {
+			t.Errorf("decoded %q", data)
+		}
+	}
+}
+
+func TestDefaultMessageBytes(t *testing.T) {
+	msg := DefaultMessage{Recog: -2, Ident: 0x0102, Param: 3, Tag: 4, Series: 0xFFFF}
+	want := []byte{0xFE, 0xFF, 0xFF, 0xFF, 0x02, 0x01, 3, 0, 4, 0, 0xFF, 0xFF}
+	if got := msg.Bytes(); !bytes.Equal(got, want) {
+		t.Fatalf("bytes %v, want %v", got, want)
+	}
+	parsed, err := ParseDefaultMessage(want)
+	if err != nil || parsed != msg {
+		t.Fatalf("parsed %+v, %v", parsed, err)
+	}
+	if _, err := ParseDefaultMessage(want[:11]); err == nil {
+		t.Fatal("parsed a short header")
+	}
+}
+
+func TestDecodePacket(t *testing.T) {
+	header := DefaultMessage{Recog: 7, Ident: 100, Param: 1, Tag: 2, Series: 3}
+	frame := func(payload ...[]byte) []byte {
+		return append(append([]byte("#"), bytes.Join(payload, nil)...), '!')
+	}
+	tests := []struct {
+		name    string
+		frame   []byte
+		seq     int
+		message *DefaultMessage
+		body    string
+	}{
+		{"header and body", EncodeMessage(header, []byte("name")), 0, &header, "name"},
+		{"header only", EncodeMessage(header, nil), 0, &header, ""},
+		{"counter", EncodePacket(&Packet{Seq: 4, Message: &header, Body: []byte("x")}), 4, &header, "x"},
+		{"without delimiters", bytes.Trim(EncodeMessage(header, []byte("y")), "#!"), 0, &header, "y"},
+		{"body without header", frame(Encode6Bit([]byte("abc"))), 0, nil, "abc"},
+		{"game login string", frame([]byte("1"), Encode6Bit([]byte("**login/char/1/2/0"))), 1, nil, "**login/char/1/2/0"},
+		{"action result", []byte("#+GOOD/123!"), 0, nil, "+GOOD/123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := DecodePacket(tt.frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Seq != tt.seq {
+				t.Errorf("seq %d, want %d", p.Seq, tt.seq)
+			}
+			if (p.Message == nil) != (tt.message == nil) || (p.Message != nil && *p.Message != *tt.message) {
+				t.Errorf("message %+v, want %+v", p.Message, tt.message)
+			}
+			if string(p.Body) != tt.body || p.Text != tt.body {
+				t.Errorf("body %q text %q, want %q", p.Body, p.Text, tt.body)
+			}
+		})
+	}
+
+	// a body of '/' separated blocks is decoded block by block
+	p, err := DecodePacket(frame(Encode6Bit(header.Bytes()), Encode6Bit([]byte("a")), []byte("/"), Encode6Bit([]byte("bc"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Parts) != 2 || string(p.Parts[0]) != "a" || string(p.Parts[1]) != "bc" || p.Text != "a/bc" {
+		t.Fatalf("parts %q text %q", p.Parts, p.Text)
+	}
+}
+
+func TestEncodePacketCounter(t *testing.T) {
+	header := DefaultMessage{Ident: 2}
+	for seq := 1; seq <= 9; seq++ {
+		p, err := DecodePacket(EncodePacket(&Packet{Seq: seq, Message: &header}))
+		if err != nil || p.Seq != seq {
+			t.Fatalf("counter %d decoded as %d, %v", seq, p.Seq, err)
+		}
+	}
+	// values that do not fit the single digit are left out rather than wrapped
+	for _, seq := range []int{-1, 10, 15} {
+		encoded := EncodePacket(&Packet{Seq: seq, Message: &header})
+		if !bytes.Equal(encoded, EncodeMessage(header, nil)) {
+			t.Errorf("counter %d encoded as %q", seq, encoded)
+		}
+	}
+	if got := string(EncodePacket(&Packet{Seq: 3, Text: "+FAIL/1"})); got != "#3+FAIL/1!" {
+		t.Errorf("action result encoded as %q", got)
+	}
+}
+
+func TestSplitPackets(t *testing.T) {
+	frames, rest := SplitPackets([]byte("*#a!**#b!#c"))
+	if len(frames) != 2 || string(frames[0]) != "#a!" || string(frames[1]) != "#b!" || string(rest) != "#c" {
+		t.Fatalf("frames %q rest %q", frames, rest)
+	}
+	// a start before the end means the previous frame was cut
+	frames, rest = SplitPackets([]byte("#ab#cd!"))
+	if len(frames) != 1 || string(frames[0]) != "#cd!" || rest != nil {
+		t.Fatalf("frames %q rest %q", frames, rest)
+	}
+	if frames, rest = SplitPackets([]byte("***")); len(frames) != 0 || rest != nil {
+		t.Fatalf("keep-alives gave frames %q rest %q", frames, rest)
+	}
+}
+
+func TestDecoderFeed(t *testing.T) {
+	frame := EncodeMessage(DefaultMessage{Ident: 3}, []byte("chunked"))
+	d := &Decoder{Direction: FROM_SERVER}
+	if packets := d.Feed(frame[:5]); len(packets) != 0 {
+		t.Fatalf("half a frame gave %d packets", len(packets))
+	}
+	stream := append(append([]byte{}, frame[5:]...), "#\x01!"...)
+	stream = append(stream, frame...)
+	packets := d.Feed(stream)
+	// the frame that cannot be decoded is skipped
+	if len(packets) != 2 || string(packets[0].Body) != "chunked" || string(packets[1].Body) != "chunked" {
+		t.Fatalf("packets %+v", packets)
+	}
+}
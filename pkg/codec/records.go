@@ -0,0 +1,369 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"unicode/utf8"
+)
+
+// The sizes of the packed Delphi records sent in Mir bodies.
+const (
+	STD_ITEM_SIZE         = 42
+	CLIENT_ITEM_SIZE      = STD_ITEM_SIZE + 8
+	USER_ITEM_SIZE        = 24
+	CHAR_DESC_SIZE        = 8
+	MESSAGE_BODY_W_SIZE   = 8
+	MESSAGE_BODY_WL_SIZE  = 16
+	ABILITY_SIZE          = 36
+	ITEM_NAME_SIZE        = 14
+	USER_ITEM_VALUES_SIZE = 14
+)
+
+// Range is a Delphi word holding a minimum in its low byte and a maximum in its high byte,
+// such as the AC and DC of items.
+type Range struct {
+	Min uint8 `json:"min"`
+	Max uint8 `json:"max"`
+}
+
+// StdItem is TStdItem, the definition of an item shared by every copy of it.
+type StdItem struct {
+	Name         string `json:"name"`
+	StdMode      uint8  `json:"stdMode"`
+	Shape        uint8  `json:"shape"`
+	Weight       uint8  `json:"weight"`
+	AniCount     uint8  `json:"aniCount"`
+	Source       int8   `json:"source"`
+	Reserved     uint8  `json:"reserved"`
+	NeedIdentify uint8  `json:"needIdentify"`
+	Looks        uint16 `json:"looks"`
+	DuraMax      uint16 `json:"duraMax"`
+	AC           Range  `json:"ac"`
+	MAC          Range  `json:"mac"`
+	DC           Range  `json:"dc"`
+	MC           Range  `json:"mc"`
+	SC           Range  `json:"sc"`
+	Need         uint8  `json:"need"`
+	NeedLevel    uint8  `json:"needLevel"`
+	Price        int32  `json:"price"`
+}
+
+// ClientItem is TClientItem, an item as the client sees it: its definition and the state of this copy.
+type ClientItem struct {
+	Item      StdItem `json:"item"`
+	MakeIndex int32   `json:"makeIndex"`
+	Dura      uint16  `json:"dura"`
+	DuraMax   uint16  `json:"duraMax"`
+}
+
+// UserItem is TUserItem, a copy of an item as the server stores it.
+type UserItem struct {
+	MakeIndex int32                        `json:"makeIndex"`
+	Index     uint16                       `json:"index"`
+	Dura      uint16                       `json:"dura"`
+	DuraMax   uint16                       `json:"duraMax"`
+	Values    [USER_ITEM_VALUES_SIZE]uint8 `json:"values"`
+}
+
+// CharDesc is TCharDesc, the look and the state of a character. Feature packs the race,
+// the weapon, the hair and the dress into its four bytes.
+type CharDesc struct {
+	Feature int32 `json:"feature"`
+	Status  int32 `json:"status"`
+	Race    uint8 `json:"race"`
+	Weapon  uint8 `json:"weapon"`
+	Hair    uint8 `json:"hair"`
+	Dress   uint8 `json:"dress"`
+}
+
+// MessageBodyW is TMessageBodyW, four extra word parameters.
+type MessageBodyW struct {
+	Param1 uint16 `json:"param1"`
+	Param2 uint16 `json:"param2"`
+	Tag1   uint16 `json:"tag1"`
+	Tag2   uint16 `json:"tag2"`
+}
+
+// MessageBodyWL is TMessageBodyWL, four extra integer parameters.
+type MessageBodyWL struct {
+	Param1 int32 `json:"param1"`
+	Param2 int32 `json:"param2"`
+	Tag1   int32 `json:"tag1"`
+	Tag2   int32 `json:"tag2"`
+}
+
+// Ability is TAbility, the level and the statistics of the player.
+type Ability struct {
+	Level         uint16 `json:"level"`
+	AC            Range  `json:"ac"`
+	MAC           Range  `json:"mac"`
+	DC            Range  `json:"dc"`
+	MC            Range  `json:"mc"`
+	SC            Range  `json:"sc"`
+	HP            uint16 `json:"hp"`
+	MP            uint16 `json:"mp"`
+	MaxHP         uint16 `json:"maxHp"`
+	MaxMP         uint16 `json:"maxMp"`
+	Exp           uint32 `json:"exp"`
+	MaxExp        uint32 `json:"maxExp"`
+	Weight        uint16 `json:"weight"`
+	MaxWeight     uint16 `json:"maxWeight"`
+	WearWeight    uint8  `json:"wearWeight"`
+	MaxWearWeight uint8  `json:"maxWearWeight"`
+	HandWeight    uint8  `json:"handWeight"`
+	MaxHandWeight uint8  `json:"maxHandWeight"`
+}
+
+// DecodeText converts a string of the game to UTF-8. Mir servers send GBK, which is
+// decoded unless the bytes already are valid UTF-8, as sent by some forks.
+func DecodeText(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	text, err := simplifiedchinese.GBK.NewDecoder().Bytes(b)
+	if err != nil {
+		return string(b)
+	}
+	return string(text)
+}
+
+// DecodeShortString reads a Delphi String[size]: a length byte followed by size bytes of GBK text.
+func DecodeShortString(b []byte, size int) (string, error) {
+	if len(b) < size+1 {
+		return "", fmt.Errorf("short string needs %d bytes, got %d", size+1, len(b))
+	}
+	length := int(b[0])
+	if length > size {
+		length = size
+	}
+	return DecodeText(b[1 : 1+length]), nil
+}
+
+// recordReader reads the little-endian fields of a packed record.
+type recordReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *recordReader) u8() uint8 {
+	v := r.b[r.pos]
+	r.pos++
+	return v
+}
+
+func (r *recordReader) u16() uint16 {
+	v := binary.LittleEndian.Uint16(r.b[r.pos:])
+	r.pos += 2
+	return v
+}
+
+func (r *recordReader) u32() uint32 {
+	v := binary.LittleEndian.Uint32(r.b[r.pos:])
+	r.pos += 4
+	return v
+}
+
+func (r *recordReader) rng() Range {
+	v := r.u16()
+	return Range{Min: uint8(v), Max: uint8(v >> 8)}
+}
+
+func (r *recordReader) shortString(size int) string {
+	s, _ := DecodeShortString(r.b[r.pos:], size)
+	r.pos += size + 1
+	return s
+}
+
+func checkSize(name string, b []byte, size int) error {
+	if len(b) < size {
+		return fmt.Errorf("%s needs %d bytes, got %d", name, size, len(b))
+	}
+	return nil
+}
+
+// DecodeStdItem reads a TStdItem.
+func DecodeStdItem(b []byte) (StdItem, error) {
+	if err := checkSize("TStdItem", b, STD_ITEM_SIZE); err != nil {
+		return StdItem{}, err
+	}
+	r := &recordReader{b: b}
+	return StdItem{
+		Name:         r.shortString(ITEM_NAME_SIZE),
+		StdMode:      r.u8(),
+		Shape:        r.u8(),
+		Weight:       r.u8(),
+		AniCount:     r.u8(),
+		Source:       int8(r.u8()),
+		Reserved:     r.u8(),
+		NeedIdentify: r.u8(),
+		Looks:        r.u16(),
+		DuraMax:      r.u16(),
+		AC:           r.rng(),
+		MAC:          r.rng(),
+		DC:           r.rng(),
+		MC:           r.rng(),
+		SC:           r.rng(),
+		Need:         r.u8(),
+		NeedLevel:    r.u8(),
+		Price:        int32(r.u32()),
+	}, nil
+}
+
+// DecodeClientItem reads a TClientItem.
+func DecodeClientItem(b []byte) (ClientItem, error) {
+	if err := checkSize("TClientItem", b, CLIENT_ITEM_SIZE); err != nil {
+		return ClientItem{}, err
+	}
+	item, _ := DecodeStdItem(b)
+	r := &recordReader{b: b, pos: STD_ITEM_SIZE}
+	return ClientItem{
+		Item:      item,
+		MakeIndex: int32(r.u32()),
+		Dura:      r.u16(),
+		DuraMax:   r.u16(),
+	}, nil
+}
+
+// DecodeUserItem reads a TUserItem.
+func DecodeUserItem(b []byte) (UserItem, error) {
+	if err := checkSize("TUserItem", b, USER_ITEM_SIZE); err != nil {
+		return UserItem{}, err
+	}
+	r := &recordReader{b: b}
+	item := UserItem{
+		MakeIndex: int32(r.u32()),
+		Index:     r.u16(),
+		Dura:      r.u16(),
+		DuraMax:   r.u16(),
+	}
+	copy(item.Values[:], b[r.pos:])
+	return item, nil
+}
+
+// DecodeCharDesc reads a TCharDesc.
+func DecodeCharDesc(b []byte) (CharDesc, error) {
+	if err := checkSize("TCharDesc", b, CHAR_DESC_SIZE); err != nil {
+		return CharDesc{}, err
+	}
+	r := &recordReader{b: b}
+	desc := CharDesc{Feature: int32(r.u32()), Status: int32(r.u32())}
+	desc.Race, desc.Weapon, desc.Hair, desc.Dress = b[0], b[1], b[2], b[3]
+	return desc, nil
+}
+
+// DecodeMessageBodyW reads a TMessageBodyW.
+func DecodeMessageBodyW(b []byte) (MessageBodyW, error) {
+	if err := checkSize("TMessageBodyW", b, MESSAGE_BODY_W_SIZE); err != nil {
+		return MessageBodyW{}, err
+	}
+	r := &recordReader{b: b}
+	return MessageBodyW{Param1: r.u16(), Param2: r.u16(), Tag1: r.u16(), Tag2: r.u16()}, nil
+}
+
+// DecodeMessageBodyWL reads a TMessageBodyWL.
+func DecodeMessageBodyWL(b []byte) (MessageBodyWL, error) {
+	if err := checkSize("TMessageBodyWL", b, MESSAGE_BODY_WL_SIZE); err != nil {
+		return MessageBodyWL{}, err
+	}
+	r := &recordReader{b: b}
+	return MessageBodyWL{Param1: int32(r.u32()), Param2: int32(r.u32()), Tag1: int32(r.u32()), Tag2: int32(r.u32())}, nil
+}
+
+// DecodeAbility reads a TAbility.
+func DecodeAbility(b []byte) (Ability, error) {
+	if err := checkSize("TAbility", b, ABILITY_SIZE); err != nil {
+		return Ability{}, err
+	}
+	r := &recordReader{b: b}
+	return Ability{
+		Level:         r.u16(),
+		AC:            r.rng(),
+		MAC:           r.rng(),
+		DC:            r.rng(),
+		MC:            r.rng(),
+		SC:            r.rng(),
+		HP:            r.u16(),
+		MP:            r.u16(),
+		MaxHP:         r.u16(),
+		MaxMP:         r.u16(),
+		Exp:           r.u32(),
+		MaxExp:        r.u32(),
+		Weight:        r.u16(),
+		MaxWeight:     r.u16(),
+		WearWeight:    r.u8(),
+		MaxWearWeight: r.u8(),
+		HandWeight:    r.u8(),
+		MaxHandWeight: r.u8(),
+	}, nil
+}
+
+// recordWriter writes the little-endian fields of a packed record.
+type recordWriter struct {
+	b   []byte
+	pos int
+}
+
+func (w *recordWriter) u8(v uint8) {
+	w.b[w.pos] = v
+	w.pos++
+}
+
+func (w *recordWriter) u16(v uint16) {
+	binary.LittleEndian.PutUint16(w.b[w.pos:], v)
+	w.pos += 2
+}
+
+func (w *recordWriter) u32(v uint32) {
+	binary.LittleEndian.PutUint32(w.b[w.pos:], v)
+	w.pos += 4
+}
+
+func (w *recordWriter) rng(v Range) {
+	w.u16(uint16(v.Min) | uint16(v.Max)<<8)
+}
+
+// Bytes returns the wire representation of the record.
+func (b MessageBodyWL) Bytes() []byte {
+	w := &recordWriter{b: make([]byte, MESSAGE_BODY_WL_SIZE)}
+	w.u32(uint32(b.Param1))
+	w.u32(uint32(b.Param2))
+	w.u32(uint32(b.Tag1))
+	w.u32(uint32(b.Tag2))
+	return w.b
+}
+
+// Bytes returns the wire representation of the record.
+func (a Ability) Bytes() []byte {
+	w := &recordWriter{b: make([]byte, ABILITY_SIZE)}
+	w.u16(a.Level)
+	w.rng(a.AC)
+	w.rng(a.MAC)
+	w.rng(a.DC)
+	w.rng(a.MC)
+	w.rng(a.SC)
+	w.u16(a.HP)
+	w.u16(a.MP)
+	w.u16(a.MaxHP)
+	w.u16(a.MaxMP)
+	w.u32(a.Exp)
+	w.u32(a.MaxExp)
+	w.u16(a.Weight)
+	w.u16(a.MaxWeight)
+	w.u8(a.WearWeight)
+	w.u8(a.MaxWearWeight)
+	w.u8(a.HandWeight)
+	w.u8(a.MaxHandWeight)
+	return w.b
+}
+
+// recordField turns a record decoder into a FieldDecoder for the catalog.
+func recordField(size int, decode func(b []byte) (interface{}, error)) FieldDecoder {
+	return func(data []byte, _ int) (interface{}, int, error) {
+		value, err := decode(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		return value, size, nil
+	}
+}
@@ -0,0 +1,129 @@
+package codec
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// gbkSword is "木剑" in GBK.
+var gbkSword = []byte{0xC4, 0xBE, 0xBD, 0xA3}
+
+func TestDecodeText(t *testing.T) {
+	if got := DecodeText(gbkSword); got != "木剑" {
+		t.Errorf("GBK decoded as %q", got)
+	}
+	// forks sending UTF-8 are left alone
+	if got := DecodeText([]byte("木剑 ok")); got != "木剑 ok" {
+		t.Errorf("UTF-8 decoded as %q", got)
+	}
+}
+
+func TestDecodeShortString(t *testing.T) {
+	b := append([]byte{4}, gbkSword...)
+	b = append(b, 'x', 'x')
+	if got, err := DecodeShortString(b, 6); err != nil || got != "木剑" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	// a length beyond the declared size is cut to it
+	if got, err := DecodeShortString([]byte{9, 'a', 'b'}, 2); err != nil || got != "ab" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := DecodeShortString([]byte{1, 'a'}, 2); err == nil {
+		t.Fatal("read past the data")
+	}
+}
+
+// clientItem builds a TClientItem named sword with a few recognizable values.
+func clientItem() []byte {
+	b := make([]byte, CLIENT_ITEM_SIZE)
+	b[0] = byte(len(gbkSword))
+	copy(b[1:], gbkSword)
+	b[15] = 5                                      // StdMode
+	b[19] = 0xFF                                   // Source
+	binary.LittleEndian.PutUint16(b[22:], 300)     // Looks
+	binary.LittleEndian.PutUint16(b[26:], 0x0201)  // AC
+	b[37] = 22                                     // NeedLevel
+	binary.LittleEndian.PutUint32(b[38:], 1500)    // Price
+	binary.LittleEndian.PutUint32(b[42:], 0x12345) // MakeIndex
+	binary.LittleEndian.PutUint16(b[46:], 4000)    // Dura
+	binary.LittleEndian.PutUint16(b[48:], 5000)    // DuraMax
+	return b
+}
+
+func TestDecodeClientItem(t *testing.T) {
+	item, err := DecodeClientItem(clientItem())
+	if err != nil {
+		t.Fatal(err)
+	}
+	std := item.Item
+	if std.Name != "木剑" || std.StdMode != 5 || std.Source != -1 || std.Looks != 300 || std.AC != (Range{Min: 1, Max: 2}) ||
+		std.NeedLevel != 22 || std.Price != 1500 {
+		t.Fatalf("item %+v", std)
+	}
+	if item.MakeIndex != 0x12345 || item.Dura != 4000 || item.DuraMax != 5000 {
+		t.Fatalf("item %+v", item)
+	}
+	if _, err := DecodeClientItem(clientItem()[:CLIENT_ITEM_SIZE-1]); err == nil {
+		t.Fatal("decoded a short record")
+	}
+}
+
+func TestDecodeSmallRecords(t *testing.T) {
+	user := make([]byte, USER_ITEM_SIZE)
+	binary.LittleEndian.PutUint32(user, 7)
+	binary.LittleEndian.PutUint16(user[4:], 8)
+	user[10], user[23] = 1, 14
+	if item, err := DecodeUserItem(user); err != nil || item.MakeIndex != 7 || item.Index != 8 || item.Values[0] != 1 || item.Values[13] != 14 {
+		t.Fatalf("user item %+v, %v", item, err)
+	}
+
+	desc, err := DecodeCharDesc([]byte{1, 2, 3, 4, 9, 0, 0, 0})
+	if err != nil || desc.Feature != 0x04030201 || desc.Status != 9 || desc.Race != 1 || desc.Weapon != 2 || desc.Hair != 3 || desc.Dress != 4 {
+		t.Fatalf("char desc %+v, %v", desc, err)
+	}
+
+	w, err := DecodeMessageBodyW([]byte{1, 0, 2, 0, 3, 0, 4, 0})
+	if err != nil || w != (MessageBodyW{Param1: 1, Param2: 2, Tag1: 3, Tag2: 4}) {
+		t.Fatalf("message body %+v, %v", w, err)
+	}
+	for _, decode := range []func([]byte) error{
+		func(b []byte) error { _, err := DecodeUserItem(b); return err },
+		func(b []byte) error { _, err := DecodeCharDesc(b); return err },
+		func(b []byte) error { _, err := DecodeMessageBodyW(b); return err },
+		func(b []byte) error { _, err := DecodeMessageBodyWL(b); return err },
+		func(b []byte) error { _, err := DecodeAbility(b); return err },
+		func(b []byte) error { _, err := DecodeStdItem(b); return err },
+	} {
+		if decode(make([]byte, 7)) == nil {
+			t.Error("decoded a short record")
+		}
+	}
+}
+
+func TestRecordBytes(t *testing.T) {
+	wl := MessageBodyWL{Param1: -1, Param2: 2, Tag1: 3, Tag2: 1 << 30}
+	if decoded, err := DecodeMessageBodyWL(wl.Bytes()); err != nil || decoded != wl {
+		t.Fatalf("message body %+v, %v", decoded, err)
+	}
+	ability := Ability{Level: 40, DC: Range{Min: 10, Max: 25}, HP: 500, MaxHP: 600, Exp: 123456, MaxExp: 1 << 31, MaxWeight: 90, MaxHandWeight: 30}
+	b := ability.Bytes()
+	if len(b) != ABILITY_SIZE {
+		t.Fatalf("%d bytes", len(b))
+	}
+	if decoded, err := DecodeAbility(b); err != nil || decoded != ability {
+		t.Fatalf("ability %+v, %v", decoded, err)
+	}
+}
+
+func TestRecordFieldsInCatalog(t *testing.T) {
+	catalog, err := ParseCatalog([]byte(`{"messages": [{"ident": 200, "name": "SM_BAGITEMS", "body": "binary", "repeat": "items", "fields": [{"name": "item", "type": "TClientItem"}]}]}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Packet{Message: &DefaultMessage{Ident: 200}, Body: append(clientItem(), clientItem()...)}
+	catalog.Annotate(p, FROM_SERVER)
+	items := p.Fields["items"].([]map[string]interface{})
+	if len(items) != 2 || items[1]["item"].(ClientItem).Item.Name != "木剑" || p.FieldError != "" {
+		t.Fatalf("fields %v, error %q", p.Fields, p.FieldError)
+	}
+}
@@ -2,17 +2,31 @@ package mircat
 
 import (
 	"context"
-	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
 // App struct
 type App struct {
-	ctx context.Context
+	ctx  context.Context
+	sink EventSink
 }
 
 // NewApp creates a new App application struct
 func NewApp() *App {
-	return &App{}
+	a := &App{}
+	a.sink = NewWailsSink(a)
+	return a
+}
+
+// NewAppWithSink creates an App that delivers its events to sink instead of the Wails runtime,
+// for running without the Wails window
+func NewAppWithSink(sink EventSink) *App {
+	return &App{sink: sink}
+}
+
+// SetSink replaces the destination of the events, e.g. with NewMultiSink(NewWailsSink(app), other).
+// It has to be called before any connection is started.
+func (a *App) SetSink(sink EventSink) {
+	a.sink = sink
 }
 
 // Startup is called at application startup
@@ -41,5 +55,10 @@ func (a *App) Shutdown(ctx context.Context) {
 
 // EventsEmit pass through
 func (a *App) EventsEmit(eventName string, optionalData ...interface{}) {
-	runtime.EventsEmit(a.ctx, eventName, optionalData)
+	a.sink.Emit(eventName, optionalData...)
+}
+
+// Emit makes the App an EventSink
+func (a *App) Emit(eventName string, optionalData ...interface{}) {
+	a.sink.Emit(eventName, optionalData...)
 }
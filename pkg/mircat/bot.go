@@ -0,0 +1,489 @@
+package mircat
+
+import (
+	"errors"
+	"fmt"
+	"mir-cat/pkg/codec"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// BOT_ACTION_WALK walks to X, Y facing Direction.
+	BOT_ACTION_WALK = "walk"
+	// BOT_ACTION_RUN runs to X, Y facing Direction.
+	BOT_ACTION_RUN = "run"
+	// BOT_ACTION_TURN turns to Direction at X, Y.
+	BOT_ACTION_TURN = "turn"
+	// BOT_ACTION_SAY says Text.
+	BOT_ACTION_SAY = "say"
+	// BOT_ACTION_USE uses the inventory item named Text.
+	BOT_ACTION_USE = "use"
+	// BOT_ACTION_SEND sends Message with Text as body.
+	BOT_ACTION_SEND = "send"
+	// BOT_ACTION_WAIT only waits for Expect.
+	BOT_ACTION_WAIT = "wait"
+	// BOT_ACTION_SLEEP pauses for Delay milliseconds.
+	BOT_ACTION_SLEEP = "sleep"
+
+	// BOT_TIMEOUT is the default time to wait for an expected message.
+	BOT_TIMEOUT = 10 * time.Second
+)
+
+var errBotStopped = errors.New("bot stopped")
+
+// BotConfig describes a bot logging into a Mir server and playing a script.
+type BotConfig struct {
+	// LoginAddr is the address of the login server.
+	LoginAddr string `json:"loginAddr"`
+	Account   string `json:"account"`
+	Password  string `json:"password"`
+	// Server is the name of the server selected after login, the first one listed when empty.
+	Server string `json:"server"`
+	// Character is the character entering the game, the first one listed when empty.
+	Character string `json:"character"`
+	// ClientVersion is the version sent to the game server in the login string.
+	ClientVersion int `json:"clientVersion"`
+	// Timeout is the number of milliseconds to wait for each expected message, 0 means 10000.
+	Timeout int `json:"timeout"`
+	// Script is played once the character is in the game.
+	Script []BotStep `json:"script"`
+}
+
+// BotStep is an action of a bot script.
+type BotStep struct {
+	// Action is "walk", "run", "turn", "say", "use", "send", "wait" or "sleep".
+	Action    string `json:"action"`
+	X         int    `json:"x"`
+	Y         int    `json:"y"`
+	Direction int    `json:"direction"`
+	// Text is what "say" says, the name of the item "use" uses, or the body "send" sends.
+	Text string `json:"text"`
+	// Message is the header "send" sends.
+	Message codec.DefaultMessage `json:"message"`
+	// Expect is the name of a server message that has to arrive after the action for the step to pass.
+	Expect string `json:"expect"`
+	// Timeout overrides the timeout of the bot for this step, in milliseconds.
+	Timeout int `json:"timeout"`
+	// Delay is the pause of "sleep", in milliseconds.
+	Delay int `json:"delay"`
+}
+
+// BotResult tells how a step of the script went.
+type BotResult struct {
+	Step   int    `json:"step"`
+	Action string `json:"action"`
+	Passed bool   `json:"passed"`
+	// Elapsed is the number of milliseconds between the action and the expected message.
+	Elapsed int64  `json:"elapsed"`
+	Error   string `json:"error"`
+}
+
+// BotSummary is the outcome of a bot run, Error is set when the login failed or the run was interrupted.
+type BotSummary struct {
+	Passed bool   `json:"passed"`
+	Steps  int    `json:"steps"`
+	Failed int    `json:"failed"`
+	Error  string `json:"error"`
+}
+
+// Bot logs into a Mir server like the game client, through the login, character-select and game
+// servers, then plays a script. The packets it exchanges feed the game tracker under the session
+// "bot <account>". It emits "bot-info" and "bot-error" events, a "bot-result" event per step and a
+// final "bot-finished" event with the summary.
+type Bot struct {
+	mutex   sync.Mutex
+	stop    chan bool
+	capture *Capture
+	tracker *GameTracker
+	events  EventSink
+}
+
+func NewBot(capture *Capture, tracker *GameTracker, events EventSink) *Bot {
+	return &Bot{
+		capture: capture,
+		tracker: tracker,
+		events:  events,
+	}
+}
+
+// Start checks the configuration and runs the bot in the background.
+func (b *Bot) Start(cfg BotConfig) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.stop != nil {
+		return fmt.Errorf("bot already running")
+	}
+	if cfg.LoginAddr == "" || cfg.Account == "" {
+		return fmt.Errorf("the bot needs a login address and an account")
+	}
+	for i, step := range cfg.Script {
+		switch step.Action {
+		case BOT_ACTION_WALK, BOT_ACTION_RUN, BOT_ACTION_TURN, BOT_ACTION_SAY, BOT_ACTION_USE, BOT_ACTION_SEND, BOT_ACTION_SLEEP:
+		case BOT_ACTION_WAIT:
+			if step.Expect == "" {
+				return fmt.Errorf("step %d waits without expected message", i)
+			}
+		default:
+			return fmt.Errorf("step %d: unknown action %s", i, step.Action)
+		}
+	}
+	b.stop = make(chan bool)
+	go b.run(cfg, b.stop)
+	return nil
+}
+
+// Stop interrupts the running bot.
+func (b *Bot) Stop() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.stop == nil {
+		return false
+	}
+	close(b.stop)
+	b.stop = nil
+	return true
+}
+
+func (b *Bot) run(cfg BotConfig, stop chan bool) {
+	timeout := BOT_TIMEOUT
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout) * time.Millisecond
+	}
+	summary := BotSummary{Steps: len(cfg.Script)}
+	var conn *botConn
+	defer func() {
+		if conn != nil {
+			conn.close()
+		}
+		b.mutex.Lock()
+		if b.stop == stop {
+			b.stop = nil
+		}
+		b.mutex.Unlock()
+		b.events.Emit("bot-finished", cfg.Account, summary)
+	}()
+
+	conn, err := b.login(cfg, timeout, stop)
+	if err != nil {
+		summary.Error = err.Error()
+		b.events.Emit("bot-error", cfg.Account, fmt.Sprintf("login failed: %v", err))
+		return
+	}
+	for i, step := range cfg.Script {
+		result := b.play(conn, step, timeout, stop)
+		result.Step = i
+		if !result.Passed {
+			summary.Failed++
+		}
+		b.events.Emit("bot-result", cfg.Account, result)
+		if result.Error == errBotStopped.Error() || conn.isClosed() {
+			summary.Error = result.Error
+			summary.Failed += len(cfg.Script) - i - 1
+			return
+		}
+	}
+	summary.Passed = summary.Failed == 0
+	b.events.Emit("bot-info", cfg.Account, fmt.Sprintf("script finished: %d of %d steps passed", summary.Steps-summary.Failed, summary.Steps))
+}
+
+// login goes through the login and character-select servers and returns the connection to the game server.
+func (b *Bot) login(cfg BotConfig, timeout time.Duration, stop chan bool) (*botConn, error) {
+	session := "bot " + cfg.Account
+	loginHost, _, err := net.SplitHostPort(cfg.LoginAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := b.connect(cfg.LoginAddr, session)
+	if err != nil {
+		return nil, err
+	}
+	b.events.Emit("bot-info", cfg.Account, fmt.Sprintf("connected to the login server %s", cfg.LoginAddr))
+	if err := conn.send("CM_IDPASSWORD", codec.DefaultMessage{}, cfg.Account+"/"+cfg.Password); err != nil {
+		conn.close()
+		return nil, err
+	}
+	p, err := conn.expect("SM_PASSOK_SELECTSERVER", timeout, stop, true)
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	server := cfg.Server
+	if servers, _ := p.Fields["servers"].([]map[string]interface{}); server == "" && len(servers) > 0 {
+		server = stringField(servers[0], "name")
+	}
+	if err := conn.send("CM_SELECTSERVER", codec.DefaultMessage{}, server); err != nil {
+		conn.close()
+		return nil, err
+	}
+	p, err = conn.expect("SM_SELECTSERVER_OK", timeout, stop, true)
+	conn.close()
+	if err != nil {
+		return nil, err
+	}
+	certification := stringField(p.Fields, "certification")
+	selectAddr := botAddr(p.Fields, loginHost)
+
+	conn, err = b.connect(selectAddr, session)
+	if err != nil {
+		return nil, err
+	}
+	b.events.Emit("bot-info", cfg.Account, fmt.Sprintf("selected %s, connected to the character-select server %s", server, selectAddr))
+	if err := conn.send("CM_QUERYCHR", codec.DefaultMessage{}, cfg.Account+"/"+certification); err != nil {
+		conn.close()
+		return nil, err
+	}
+	p, err = conn.expect("SM_QUERYCHR", timeout, stop, true)
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	character := cfg.Character
+	if chars, _ := p.Fields["chars"].([]map[string]interface{}); character == "" && len(chars) > 0 {
+		// the selected character is marked with '*'
+		character = strings.TrimPrefix(stringField(chars[0], "name"), "*")
+	}
+	if character == "" {
+		conn.close()
+		return nil, fmt.Errorf("the account has no character")
+	}
+	if err := conn.send("CM_SELCHR", codec.DefaultMessage{}, cfg.Account+"/"+character); err != nil {
+		conn.close()
+		return nil, err
+	}
+	p, err = conn.expect("SM_STARTPLAY", timeout, stop, true)
+	conn.close()
+	if err != nil {
+		return nil, err
+	}
+	gameAddr := botAddr(p.Fields, loginHost)
+
+	conn, err = b.connect(gameAddr, session)
+	if err != nil {
+		return nil, err
+	}
+	b.events.Emit("bot-info", cfg.Account, fmt.Sprintf("selected %s, connected to the game server %s", character, gameAddr))
+	// the game server is greeted with a login string instead of a message
+	login := fmt.Sprintf("**%s/%s/%s/%d/0", cfg.Account, character, certification, cfg.ClientVersion)
+	conn.write(&codec.Packet{Body: []byte(login)})
+	// the notices do not extend the wait, the character has to enter the game within one timeout
+	deadline := time.Now().Add(timeout)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			conn.close()
+			return nil, fmt.Errorf("no SM_LOGON within %v", timeout)
+		}
+		p, err = conn.expect("", remaining, stop, true)
+		if err != nil {
+			conn.close()
+			if time.Now().After(deadline) && err != errBotStopped && !conn.isClosed() {
+				return nil, fmt.Errorf("no SM_LOGON within %v", timeout)
+			}
+			return nil, err
+		}
+		switch p.Name {
+		case "SM_SENDNOTICE":
+			if err := conn.send("CM_LOGINNOTICEOK", codec.DefaultMessage{}, ""); err != nil {
+				conn.close()
+				return nil, err
+			}
+		case "SM_LOGON":
+			b.events.Emit("bot-info", cfg.Account, fmt.Sprintf("%s entered the game", character))
+			// like the game client, ask for the inventory the script may use
+			if err := conn.send("CM_QUERYBAGITEMS", codec.DefaultMessage{}, ""); err != nil {
+				conn.close()
+				return nil, err
+			}
+			if _, err := conn.expect("SM_BAGITEMS", timeout, stop, false); err != nil {
+				if err == errBotStopped {
+					conn.close()
+					return nil, err
+				}
+				b.events.Emit("bot-info", cfg.Account, fmt.Sprintf("inventory unknown: %v", err))
+			}
+			return conn, nil
+		}
+	}
+}
+
+// play runs a step of the script.
+func (b *Bot) play(conn *botConn, step BotStep, timeout time.Duration, stop chan bool) BotResult {
+	result := BotResult{Action: step.Action}
+	if step.Timeout > 0 {
+		timeout = time.Duration(step.Timeout) * time.Millisecond
+	}
+	conn.drain()
+	start := time.Now()
+	var err error
+	switch step.Action {
+	case BOT_ACTION_WALK, BOT_ACTION_RUN, BOT_ACTION_TURN:
+		msg := codec.DefaultMessage{Recog: int32(uint32(uint16(step.X)) | uint32(uint16(step.Y))<<16), Tag: uint16(step.Direction)}
+		err = conn.send("CM_"+strings.ToUpper(step.Action), msg, "")
+	case BOT_ACTION_SAY:
+		err = conn.send("CM_SAY", codec.DefaultMessage{}, step.Text)
+	case BOT_ACTION_USE:
+		err = fmt.Errorf("no item named %s in the inventory", step.Text)
+		state, _ := b.tracker.Snapshot(conn.session)
+		for _, item := range state.Inventory {
+			if item.Item.Name == step.Text {
+				err = conn.send("CM_EAT", codec.DefaultMessage{Recog: item.MakeIndex}, step.Text)
+				break
+			}
+		}
+	case BOT_ACTION_SEND:
+		message := step.Message
+		conn.write(&codec.Packet{Message: &message, Body: []byte(step.Text)})
+	case BOT_ACTION_SLEEP:
+		select {
+		case <-time.After(time.Duration(step.Delay) * time.Millisecond):
+		case <-stop:
+			err = errBotStopped
+		}
+	}
+	if err == nil && step.Expect != "" {
+		_, err = conn.expect(step.Expect, timeout, stop, false)
+	}
+	result.Elapsed = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Passed = true
+	return result
+}
+
+// botAddr returns the address in the host and port fields of a redirect message, the host of the
+// login server replacing a missing or unspecified host.
+func botAddr(fields map[string]interface{}, loginHost string) string {
+	host := strings.TrimSpace(stringField(fields, "host"))
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = loginHost
+	}
+	return net.JoinHostPort(host, strings.TrimSpace(stringField(fields, "port")))
+}
+
+// botConn is a connection of the bot to one of the servers.
+type botConn struct {
+	session string
+	client  *TcpClient
+	packets chan *codec.Packet
+	closed  chan error
+	done    chan bool
+	once    sync.Once
+	out     codec.Decoder
+	seq     int
+	tracker *GameTracker
+}
+
+func (b *Bot) connect(address string, session string) (*botConn, error) {
+	conn := &botConn{
+		session: session,
+		packets: make(chan *codec.Packet, 1024),
+		closed:  make(chan error, 1),
+		done:    make(chan bool),
+		out:     codec.Decoder{Direction: codec.FROM_CLIENT},
+		tracker: b.tracker,
+	}
+	in := codec.Decoder{Direction: codec.FROM_SERVER}
+	client, err := newTcpClient(address, FramerConfig{}, nil, b.capture, &tcpClientHandler{
+		onData: func(frame []byte) {
+			packets := in.Feed(frame)
+			conn.tracker.Feed(session, packets)
+			for _, p := range packets {
+				select {
+				case conn.packets <- p:
+				case <-conn.done:
+					return
+				}
+			}
+		},
+		onClose: func(err error) { conn.closed <- err },
+	}, b.events)
+	if err != nil {
+		return nil, err
+	}
+	conn.client = client
+	return conn, nil
+}
+
+// send sends the message of the client catalog named name.
+func (c *botConn) send(name string, msg codec.DefaultMessage, body string) error {
+	spec := codec.CurrentCatalog().Find(name)
+	if spec == nil {
+		return fmt.Errorf("%s is not in the catalog", name)
+	}
+	msg.Ident = spec.Ident
+	c.write(&codec.Packet{Message: &msg, Body: []byte(body)})
+	return nil
+}
+
+// write sends a packet with the next counter, nothing is sent once the server closed the connection.
+func (c *botConn) write(p *codec.Packet) {
+	if c.isClosed() {
+		return
+	}
+	c.seq = c.seq%9 + 1
+	p.Seq = c.seq
+	data := codec.EncodePacket(p)
+	c.tracker.Feed(c.session, c.out.Feed(data))
+	c.client.Send(data)
+}
+
+// expect waits for the server message named name, or for any named message when name is empty.
+// During the login a message reporting a failure ends the wait with an error, whatever name is.
+func (c *botConn) expect(name string, timeout time.Duration, stop chan bool, failFast bool) (*codec.Packet, error) {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case p := <-c.packets:
+			if p.Name == "" {
+				continue
+			}
+			if p.Name == name {
+				return p, nil
+			}
+			if failFast && (strings.Contains(p.Name, "FAIL") || p.Name == "SM_ID_NOTFOUND" || p.Name == "SM_OUTOFCONNECTION") {
+				return nil, fmt.Errorf("the server answered %s", p.Name)
+			}
+			if name == "" {
+				return p, nil
+			}
+		case err := <-c.closed:
+			c.closed <- err
+			return nil, fmt.Errorf("connection closed: %v", err)
+		case <-deadline:
+			if name == "" {
+				return nil, fmt.Errorf("no answer within %v", timeout)
+			}
+			return nil, fmt.Errorf("no %s within %v", name, timeout)
+		case <-stop:
+			return nil, errBotStopped
+		}
+	}
+}
+
+// drain forgets the messages received so far, so an expectation only matches what follows the action.
+func (c *botConn) drain() {
+	for {
+		select {
+		case <-c.packets:
+		default:
+			return
+		}
+	}
+}
+
+func (c *botConn) isClosed() bool {
+	return len(c.closed) > 0
+}
+
+func (c *botConn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.client.Shutdown()
+	})
+}
@@ -0,0 +1,118 @@
+package mircat
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// startMirServer runs a TCP server in Mir mode on a free port and returns its address.
+func startMirServer(t *testing.T, cfg MirServerConfig) string {
+	t.Helper()
+	mir, err := NewMirServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewTCPServer(nil, NewEventRecorder())
+	server.SetMirServer(mir)
+	if err := server.Start("127.0.0.1:0", FramerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	address := server.listener.Addr().String()
+	t.Cleanup(server.Stop)
+	return address
+}
+
+func heroServer() MirServerConfig {
+	return MirServerConfig{
+		Accounts: []MirAccount{{Account: "hero", Password: "secret", Characters: []MirCharacter{{Name: "Hero", Level: 3}}}},
+		Map:      MirMap{Name: "3", Title: "Mongchon", StartX: 10, StartY: 10},
+	}
+}
+
+// runBot runs the bot to the end and returns its summary and the events it emitted.
+func runBot(t *testing.T, cfg BotConfig) (BotSummary, *EventRecorder, *GameTracker) {
+	t.Helper()
+	rec := NewEventRecorder()
+	tracker := NewGameTracker(NewEventRecorder())
+	bot := NewBot(nil, tracker, rec)
+	if err := bot.Start(cfg); err != nil {
+		t.Fatal(err)
+	}
+	finished, ok := rec.Wait("bot-finished", 1, 5*time.Second)
+	if !ok {
+		bot.Stop()
+		t.Fatalf("the bot did not finish: %+v", rec.Events("bot-info"))
+	}
+	return finished[0].Data[1].(BotSummary), rec, tracker
+}
+
+func TestBotPlaysScript(t *testing.T) {
+	addr := startMirServer(t, heroServer())
+	summary, rec, tracker := runBot(t, BotConfig{
+		LoginAddr: addr, Account: "hero", Password: "secret", Timeout: 2000,
+		Script: []BotStep{
+			{Action: BOT_ACTION_SAY, Text: "hello", Expect: "SM_HEAR"},
+			{Action: BOT_ACTION_USE, Text: "potion"},
+			{Action: BOT_ACTION_SLEEP, Delay: 10},
+		},
+	})
+	if summary.Passed || summary.Steps != 3 || summary.Failed != 1 || summary.Error != "" {
+		t.Fatalf("summary %+v", summary)
+	}
+	results := rec.Events("bot-result")
+	if len(results) != 3 || !results[0].Data[1].(BotResult).Passed || results[1].Data[1].(BotResult).Error != "no item named potion in the inventory" {
+		t.Fatalf("results %+v", results)
+	}
+
+	state, ok := tracker.Snapshot("bot hero")
+	if !ok || state.Player.Character != "Hero" || state.Player.Map != "3" || state.Player.MapTitle != "Mongchon" || state.Player.Level != 3 {
+		t.Fatalf("state %+v", state)
+	}
+	if len(state.Chat) != 2 || state.Chat[1].Message != "Hero: hello" {
+		t.Fatalf("chat %+v", state.Chat)
+	}
+}
+
+func TestBotLoginFailures(t *testing.T) {
+	addr := startMirServer(t, heroServer())
+	summary, _, _ := runBot(t, BotConfig{LoginAddr: addr, Account: "hero", Password: "guess", Timeout: 2000})
+	if summary.Error != "the server answered SM_PASSWD_FAIL" {
+		t.Fatalf("summary %+v", summary)
+	}
+
+	// a selection the server never certified is refused by the game server
+	forged := heroServer()
+	forged.Responses = map[string][]MirResponse{"CM_SELCHR": {{Message: "SM_STARTPLAY", Body: "{host}/{port}"}}}
+	summary, _, _ = runBot(t, BotConfig{LoginAddr: startMirServer(t, forged), Account: "hero", Password: "secret", Timeout: 2000})
+	if summary.Error != "the server answered SM_CERTIFICATION_FAIL" {
+		t.Fatalf("summary %+v", summary)
+	}
+
+	// notices shown over and over do not keep the bot waiting
+	endless := heroServer()
+	endless.Responses = map[string][]MirResponse{"CM_LOGINNOTICEOK": {{Message: "SM_SENDNOTICE", Body: "again"}}}
+	start := time.Now()
+	summary, _, _ = runBot(t, BotConfig{LoginAddr: startMirServer(t, endless), Account: "hero", Password: "secret", Timeout: 300})
+	if !strings.HasPrefix(summary.Error, "no SM_LOGON within") || time.Since(start) > 2*time.Second {
+		t.Fatalf("summary %+v after %v", summary, time.Since(start))
+	}
+}
+
+func TestBotRejectsBadConfig(t *testing.T) {
+	bot := NewBot(nil, NewGameTracker(NewEventRecorder()), NewEventRecorder())
+	for _, cfg := range []BotConfig{
+		{Account: "hero"},
+		{LoginAddr: "127.0.0.1:7000"},
+		{LoginAddr: "127.0.0.1:7000", Account: "hero", Script: []BotStep{{Action: BOT_ACTION_WAIT}}},
+		{LoginAddr: "127.0.0.1:7000", Account: "hero", Script: []BotStep{{Action: "fly"}}},
+	} {
+		if bot.Start(cfg) == nil {
+			bot.Stop()
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	if bot.Stop() {
+		t.Fatal("stopped a bot that never ran")
+	}
+}
@@ -0,0 +1,352 @@
+package mircat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	pcapngSectionHeader   = 0x0A0D0D0A
+	pcapngInterface       = 0x00000001
+	pcapngEnhancedPacket  = 0x00000006
+	pcapngByteOrderMagic  = 0x1A2B3C4D
+	pcapngLinkTypeEther   = 1
+	pcapngOptEnd          = 0
+	pcapngOptComment      = 1
+	pcapngOptIfName       = 2
+	pcapngOptShbUserAppl  = 4
+	pcapngOptIfTsResol    = 9
+	captureMaxSegmentSize = 65000
+
+	tcpFlagFin = 0x01
+	tcpFlagSyn = 0x02
+	tcpFlagPsh = 0x08
+	tcpFlagAck = 0x10
+)
+
+// Capture records the traffic of the connections it wraps into a pcapng file.
+// Every block is written as soon as it is produced so a crash only loses the
+// packet being written.
+type Capture struct {
+	mutex      sync.Mutex
+	file       *os.File
+	path       string
+	generation int
+}
+
+func NewCapture() *Capture {
+	return &Capture{}
+}
+
+// Start creates the pcapng file and starts recording.
+func (c *Capture) Start(path string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file != nil {
+		return fmt.Errorf("capture already running to %s", c.path)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	shb := pcapngOptions(
+		pcapngOption(pcapngOptShbUserAppl, []byte("MirCat")),
+	)
+	body := make([]byte, 16)
+	binary.LittleEndian.PutUint32(body[0:], pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(body[4:], 1)
+	binary.LittleEndian.PutUint16(body[6:], 0)
+	binary.LittleEndian.PutUint64(body[8:], 0xFFFFFFFFFFFFFFFF)
+	if _, err = file.Write(pcapngBlock(pcapngSectionHeader, append(body, shb...))); err != nil {
+		file.Close()
+		return err
+	}
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:], pcapngLinkTypeEther)
+	binary.LittleEndian.PutUint32(idb[4:], 0)
+	idb = append(idb, pcapngOptions(
+		pcapngOption(pcapngOptIfName, []byte("mircat")),
+		pcapngOption(pcapngOptIfTsResol, []byte{6}),
+	)...)
+	if _, err = file.Write(pcapngBlock(pcapngInterface, idb)); err != nil {
+		file.Close()
+		return err
+	}
+	c.file = file
+	c.path = path
+	c.generation++
+	return nil
+}
+
+// Stop closes the pcapng file.
+func (c *Capture) Stop() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file == nil {
+		return fmt.Errorf("capture not running")
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
+
+// Path returns the file being written, or an empty string when the capture is stopped.
+func (c *Capture) Path() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file == nil {
+		return ""
+	}
+	return c.path
+}
+
+// Wrap returns a connection that records what is read from and written to conn.
+// The label is written as a comment on the first packet of the connection, and
+// dialed tells whether the local end opened the connection.
+func (c *Capture) Wrap(conn net.Conn, label string, dialed bool) net.Conn {
+	if c == nil {
+		return conn
+	}
+	return &captureConn{Conn: conn, capture: c, label: label, dialed: dialed}
+}
+
+func (c *Capture) record(flow *captureFlow, fromClient bool, flags byte, data []byte, comment string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.file == nil {
+		return
+	}
+	if flow.generation != c.generation {
+		flow.generation = c.generation
+		flow.seq = [2]uint32{1000, 5000}
+		c.writeSegment(flow, true, tcpFlagSyn, nil, comment)
+		c.writeSegment(flow, false, tcpFlagSyn|tcpFlagAck, nil, "")
+		c.writeSegment(flow, true, tcpFlagAck, nil, "")
+	}
+	if len(data) == 0 {
+		c.writeSegment(flow, fromClient, flags, nil, "")
+		return
+	}
+	for len(data) > 0 {
+		n := len(data)
+		if n > captureMaxSegmentSize {
+			n = captureMaxSegmentSize
+		}
+		c.writeSegment(flow, fromClient, flags, data[:n], "")
+		data = data[n:]
+	}
+}
+
+// writeSegment must be called with the capture mutex held.
+func (c *Capture) writeSegment(flow *captureFlow, fromClient bool, flags byte, payload []byte, comment string) {
+	src, dst := flow.server, flow.client
+	from, to := 1, 0
+	if fromClient {
+		src, dst = flow.client, flow.server
+		from, to = 0, 1
+	}
+	frame := synthesizeFrame(src, dst, flow.seq[from], flow.seq[to], flags, payload)
+	flow.seq[from] += uint32(len(payload))
+	if flags&(tcpFlagSyn|tcpFlagFin) != 0 {
+		flow.seq[from]++
+	}
+
+	now := time.Now().UnixMicro()
+	epb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(epb[0:], 0)
+	binary.LittleEndian.PutUint32(epb[4:], uint32(uint64(now)>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(uint64(now)))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(len(frame)))
+	epb = append(epb, pcapngPad(frame)...)
+	if comment != "" {
+		epb = append(epb, pcapngOptions(pcapngOption(pcapngOptComment, []byte(comment)))...)
+	}
+	_, err := c.file.Write(pcapngBlock(pcapngEnhancedPacket, epb))
+	if err != nil {
+		fmt.Printf("Error writing capture %s: %s\n", c.path, err.Error())
+	}
+}
+
+// captureFlow keeps the synthesized TCP state of a connection, seq holds the
+// next sequence number of the client and of the server.
+type captureFlow struct {
+	client     *net.TCPAddr
+	server     *net.TCPAddr
+	generation int
+	seq        [2]uint32
+}
+
+type captureConn struct {
+	net.Conn
+	capture *Capture
+	label   string
+	dialed  bool
+	once    sync.Once
+	flow    *captureFlow
+}
+
+func (c *captureConn) getFlow() *captureFlow {
+	c.once.Do(func() {
+		local, remote := tcpAddr(c.Conn.LocalAddr()), tcpAddr(c.Conn.RemoteAddr())
+		if c.dialed {
+			c.flow = &captureFlow{client: local, server: remote}
+		} else {
+			c.flow = &captureFlow{client: remote, server: local}
+		}
+	})
+	return c.flow
+}
+
+func (c *captureConn) comment() string {
+	return fmt.Sprintf("%s %s <-> %s", c.label, c.Conn.LocalAddr(), c.Conn.RemoteAddr())
+}
+
+func (c *captureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.capture.record(c.getFlow(), !c.dialed, tcpFlagPsh|tcpFlagAck, b[:n], c.comment())
+	}
+	return n, err
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.capture.record(c.getFlow(), c.dialed, tcpFlagPsh|tcpFlagAck, b[:n], c.comment())
+	}
+	return n, err
+}
+
+func (c *captureConn) Close() error {
+	flow := c.getFlow()
+	c.capture.mutex.Lock()
+	recorded := c.capture.file != nil && flow.generation == c.capture.generation
+	c.capture.mutex.Unlock()
+	if recorded {
+		c.capture.record(flow, c.dialed, tcpFlagFin|tcpFlagAck, nil, "")
+	}
+	return c.Conn.Close()
+}
+
+func tcpAddr(addr net.Addr) *net.TCPAddr {
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp
+	}
+	tcp, err := net.ResolveTCPAddr("tcp", addr.String())
+	if err != nil {
+		return &net.TCPAddr{IP: net.IPv4zero}
+	}
+	return tcp
+}
+
+// synthesizeFrame builds an Ethernet frame carrying a TCP segment between two addresses.
+func synthesizeFrame(src *net.TCPAddr, dst *net.TCPAddr, seq uint32, ack uint32, flags byte, payload []byte) []byte {
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], uint16(src.Port))
+	binary.BigEndian.PutUint16(tcp[2:], uint16(dst.Port))
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	if flags&tcpFlagAck != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], ack)
+	}
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	tcp = append(tcp, payload...)
+
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	ether := []byte{0x02, 0, 0, 0, 0, 0x02, 0x02, 0, 0, 0, 0, 0x01, 0x08, 0x00}
+	var ip []byte
+	var pseudo []byte
+	if srcIP != nil && dstIP != nil {
+		ip = make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+		ip[8] = 64
+		ip[9] = 6
+		copy(ip[12:], srcIP)
+		copy(ip[16:], dstIP)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+		pseudo = make([]byte, 12)
+		copy(pseudo[0:], srcIP)
+		copy(pseudo[4:], dstIP)
+		pseudo[9] = 6
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(tcp)))
+	} else {
+		ether[12], ether[13] = 0x86, 0xDD
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6] = 6
+		ip[7] = 64
+		copy(ip[8:], src.IP.To16())
+		copy(ip[24:], dst.IP.To16())
+		pseudo = make([]byte, 40)
+		copy(pseudo[0:], src.IP.To16())
+		copy(pseudo[16:], dst.IP.To16())
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(tcp)))
+		pseudo[39] = 6
+	}
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, sum(pseudo)))
+
+	frame := make([]byte, 0, len(ether)+len(ip)+len(tcp))
+	frame = append(frame, ether...)
+	frame = append(frame, ip...)
+	return append(frame, tcp...)
+}
+
+func sum(b []byte) uint32 {
+	var s uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	return s
+}
+
+func checksum(b []byte, initial uint32) uint16 {
+	s := initial + sum(b)
+	for s>>16 != 0 {
+		s = s&0xFFFF + s>>16
+	}
+	return ^uint16(s)
+}
+
+func pcapngPad(b []byte) []byte {
+	if len(b)%4 == 0 {
+		return b
+	}
+	return append(append([]byte{}, b...), make([]byte, 4-len(b)%4)...)
+}
+
+func pcapngOption(code uint16, value []byte) []byte {
+	opt := make([]byte, 4)
+	binary.LittleEndian.PutUint16(opt[0:], code)
+	binary.LittleEndian.PutUint16(opt[2:], uint16(len(value)))
+	return append(opt, pcapngPad(value)...)
+}
+
+func pcapngOptions(options ...[]byte) []byte {
+	b := []byte{}
+	for _, opt := range options {
+		b = append(b, opt...)
+	}
+	return append(b, pcapngOption(pcapngOptEnd, nil)...)
+}
+
+func pcapngBlock(blockType uint32, body []byte) []byte {
+	total := uint32(12 + len(body))
+	block := make([]byte, 8, total)
+	binary.LittleEndian.PutUint32(block[0:], blockType)
+	binary.LittleEndian.PutUint32(block[4:], total)
+	block = append(block, body...)
+	tail := make([]byte, 4)
+	binary.LittleEndian.PutUint32(tail, total)
+	return append(block, tail...)
+}
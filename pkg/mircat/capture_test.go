@@ -0,0 +1,110 @@
+package mircat
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestCaptureRoundTrip(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b := make([]byte, 16)
+		for {
+			n, err := conn.Read(b)
+			if err != nil {
+				return
+			}
+			conn.Write(bytes.ToUpper(b[:n]))
+		}
+	}()
+
+	capture := NewCapture()
+	path := filepath.Join(t.TempDir(), "session.pcapng")
+	if err := capture.Start(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := capture.Start(path); err == nil {
+		t.Fatal("started a capture twice")
+	}
+	dialed, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := capture.Wrap(dialed, "client", true)
+	b := make([]byte, 5)
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, b)
+	conn.Write([]byte("bye"))
+	io.ReadFull(conn, b[:3])
+	conn.Close()
+	if err := capture.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if capture.Path() != "" {
+		t.Fatal("the capture still reports a file after Stop")
+	}
+
+	sessions, err := LoadPcap(path, PcapFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("%d sessions", len(sessions))
+	}
+	flow := sessions[0].Flow
+	if flow.Client != dialed.LocalAddr().String() || flow.Server != dialed.RemoteAddr().String() {
+		t.Fatalf("flow %+v", flow)
+	}
+	got := ""
+	for _, chunk := range sessions[0].Chunks {
+		got += chunk.Direction + ":" + string(chunk.Data) + " "
+	}
+	if got != "src:hello dst:HELLO src:bye dst:BYE " {
+		t.Fatalf("chunks %s", got)
+	}
+	if flow.ClientBytes != 8 || flow.ServerBytes != 8 || flow.MissingBytes != 0 {
+		t.Fatalf("flow %+v", flow)
+	}
+}
+
+func TestCaptureOnlyRecordsWhileRunning(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	capture := NewCapture()
+	conn := capture.Wrap(client, "client", true)
+	// nothing is written before Start, and the connection still works
+	if _, err := conn.Write([]byte("early")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "late.pcapng")
+	capture.Start(path)
+	conn.Write([]byte("late"))
+	capture.Stop()
+	conn.Write([]byte("after"))
+	conn.Close()
+
+	sessions, err := LoadPcap(path, PcapFilter{})
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("sessions %+v, %v", sessions, err)
+	}
+	if chunks := sessions[0].Chunks; len(chunks) != 1 || string(chunks[0].Data) != "late" {
+		t.Fatalf("chunks %+v", chunks)
+	}
+	if (*Capture)(nil).Wrap(client, "", true) != client {
+		t.Fatal("a nil capture wrapped the connection")
+	}
+}
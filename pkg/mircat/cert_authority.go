@@ -0,0 +1,167 @@
+package mircat
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// CA_CERT_FILE is the certificate of the MirCat root CA, stored next to config.json.
+	CA_CERT_FILE = "mircat-ca.pem"
+	// CA_KEY_FILE is the private key of the MirCat root CA, stored next to config.json.
+	CA_KEY_FILE = "mircat-ca-key.pem"
+)
+
+// CertAuthority is the local root CA minting the certificates presented to TLS clients in MITM mode.
+// It is generated on first use and kept in dir, so test machines trust it once.
+type CertAuthority struct {
+	dir     string
+	mutex   sync.Mutex
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	leaves  map[string]*tls.Certificate
+}
+
+// NewCertAuthority returns the CA stored in dir, it is only read or generated when first used.
+func NewCertAuthority(dir string) *CertAuthority {
+	return &CertAuthority{dir: dir, leaves: map[string]*tls.Certificate{}}
+}
+
+// Certificate returns the PEM certificate of the CA, to install on test machines.
+func (a *CertAuthority) Certificate() ([]byte, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.load(); err != nil {
+		return nil, err
+	}
+	return a.certPEM, nil
+}
+
+// ServerConfig returns a TLS configuration presenting, for each client, a certificate for the
+// name it asked through SNI, or for the address it connected to when it sent none.
+func (a *CertAuthority) ServerConfig() (*tls.Config, error) {
+	a.mutex.Lock()
+	err := a.load()
+	a.mutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" && hello.Conn != nil {
+				name, _, _ = net.SplitHostPort(hello.Conn.LocalAddr().String())
+			}
+			return a.leaf(name)
+		},
+	}, nil
+}
+
+// leaf returns the certificate of name, minting it on first request.
+func (a *CertAuthority) leaf(name string) (*tls.Certificate, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if leaf, ok := a.leaves[name]; ok {
+		return leaf, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := certificateTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	leaf, err := signCertificate(template, a.cert, key, a.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf.Certificate = append(leaf.Certificate, a.cert.Raw)
+	a.leaves[name] = &leaf
+	return &leaf, nil
+}
+
+// load reads the CA from its files or generates and saves a new one, the caller holds the mutex.
+func (a *CertAuthority) load() error {
+	if a.cert != nil {
+		return nil
+	}
+	certPath := filepath.Join(a.dir, CA_CERT_FILE)
+	keyPath := filepath.Join(a.dir, CA_KEY_FILE)
+	certPEM, err := os.ReadFile(certPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return a.generate(certPath, keyPath)
+	}
+	if err != nil {
+		return err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	keyBlock, _ := pem.Decode(keyPEM)
+	if certBlock == nil || keyBlock == nil {
+		return fmt.Errorf("invalid pem in %s or %s", certPath, keyPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return err
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return err
+	}
+	a.cert, a.key, a.certPEM = cert, key, certPEM
+	return nil
+}
+
+func (a *CertAuthority) generate(certPath string, keyPath string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	template, err := certificateTemplate("MirCat Root CA")
+	if err != nil {
+		return err
+	}
+	template.NotAfter = time.Now().AddDate(10, 0, 0)
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = nil
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	ca, err := signCertificate(template, template, key, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]})
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return err
+	}
+	a.cert, a.key, a.certPEM = ca.Leaf, key, certPEM
+	return nil
+}
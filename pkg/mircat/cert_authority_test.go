@@ -0,0 +1,120 @@
+package mircat
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// caPool returns a pool trusting the root CA of a.
+func caPool(t *testing.T, a *CertAuthority) *x509.CertPool {
+	t.Helper()
+	pem, err := a.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		t.Fatal("no certificate in the CA PEM")
+	}
+	return pool
+}
+
+func TestCertAuthority(t *testing.T) {
+	dir := t.TempDir()
+	a := NewCertAuthority(dir)
+	pem, err := a.Certificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, CA_KEY_FILE)); err != nil {
+		t.Fatal(err)
+	}
+	// the CA is generated once and read back afterwards
+	again, err := NewCertAuthority(dir).Certificate()
+	if err != nil || string(again) != string(pem) {
+		t.Fatalf("reloaded %v", err)
+	}
+
+	config, err := a.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+	pool := caPool(t, a)
+	// the certificate is minted for the name asked, or for the address without SNI
+	for _, name := range []string{"game.example", "login.example", "127.0.0.1"} {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: pool, ServerName: name})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		conn.Close()
+	}
+	first, _ := a.leaf("game.example")
+	if second, _ := a.leaf("game.example"); first != second {
+		t.Fatal("the certificate was minted twice")
+	}
+
+	broken := t.TempDir()
+	os.WriteFile(filepath.Join(broken, CA_CERT_FILE), []byte("not pem"), 0644)
+	os.WriteFile(filepath.Join(broken, CA_KEY_FILE), []byte("not pem"), 0600)
+	if _, err := NewCertAuthority(broken).ServerConfig(); err == nil {
+		t.Fatal("loaded an invalid CA")
+	}
+}
+
+func TestTransferMitm(t *testing.T) {
+	addr, _, names := tlsEchoServer(t)
+	host, port, _ := net.SplitHostPort(addr)
+	rec := NewEventRecorder()
+	cfg := &Config{Transfer: TransferConfig{
+		SrcAddr: "127.0.0.1", SrcPort: "0", DstAddr: host, DstPort: port,
+		SrcTLS: TLSServerConfig{Enabled: true, Mitm: true},
+	}}
+	m := NewConnManager(NewAppWithSink(rec), cfg)
+	m.ca = NewCertAuthority(t.TempDir())
+
+	// without dst TLS the decrypted traffic would go out in clear
+	if m.TransferTcpStart() {
+		m.TransferTcpStop()
+		t.Fatal("started a mitm transfer without dst tls")
+	}
+	if errors := rec.Events("transfer-tcp-error"); len(errors) != 1 || errors[0].Data[1] != "invalid src tls settings: mitm needs dst tls enabled" {
+		t.Fatalf("errors %+v", errors)
+	}
+
+	cfg.Transfer.DstTLS = TLSClientConfig{Enabled: true, InsecureSkipVerify: true}
+	if !m.TransferTcpStart() {
+		t.Fatalf("start failed: %+v", rec.Events("transfer-tcp-error"))
+	}
+	defer m.TransferTcpStop()
+	client, err := tls.Dial("tcp", m.transfer.Addr().String(), &tls.Config{RootCAs: caPool(t, m.ca), ServerName: "game.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+	if got := receive(t, client, 4); got != "ping" {
+		t.Fatalf("client received %q", got)
+	}
+	if name := <-names; name != "game.example" {
+		t.Fatalf("SNI %q", name)
+	}
+}
@@ -10,6 +10,20 @@ import (
 
 const FILE_NAME = "config.json"
 
+// API_ADDR is the default address of the HTTP API, reachable from the local machine only.
+const API_ADDR = "127.0.0.1:8686"
+
+// ApiConfig represents the configuration for the HTTP API.
+type ApiConfig struct {
+	// Enabled starts the HTTP API and the WebSocket event stream with the application.
+	Enabled bool `json:"enabled"`
+	// Addr is the address the API listens on, 127.0.0.1:8686 when empty.
+	Addr string `json:"addr"`
+	// Token must be sent by every request as a bearer token or a "token" query parameter,
+	// a random one is generated and printed at start when empty.
+	Token string `json:"token"`
+}
+
 // ServerConfig represents the configuration for the server.
 type ServerConfig struct {
 	// TcpAddr is the TCP IP address of the server.
@@ -20,6 +34,14 @@ type ServerConfig struct {
 	UdpAddr string `json:"udpAddr"`
 	// UdpPort is the UDP port of the server.
 	UdpPort string `json:"udpPort"`
+	// Framer cuts the data received from clients into messages.
+	Framer FramerConfig `json:"framer"`
+	// Mock makes the TCP server answer with the responses of a capture.
+	Mock MockConfig `json:"mock"`
+	// Mir makes the TCP server play a minimal Mir login, character-select and game server.
+	Mir MirServerConfig `json:"mir"`
+	// TLS terminates TLS on the connections of the clients.
+	TLS TLSServerConfig `json:"tls"`
 }
 
 // TransferConfig represents the configuration for data transfer.
@@ -32,6 +54,40 @@ type TransferConfig struct {
 	DstAddr string `json:"dstAddr"`
 	// DstPort is the destination port for data transfer.
 	DstPort string `json:"dstPort"`
+	// UdpSrcAddr is the source IP address for UDP data transfer.
+	UdpSrcAddr string `json:"udpSrcAddr"`
+	// UdpSrcPort is the source port for UDP data transfer.
+	UdpSrcPort string `json:"udpSrcPort"`
+	// UdpDstAddr is the destination IP address for UDP data transfer.
+	UdpDstAddr string `json:"udpDstAddr"`
+	// UdpDstPort is the destination port for UDP data transfer.
+	UdpDstPort string `json:"udpDstPort"`
+	// UdpIdleTimeout is the number of seconds a UDP session is kept without traffic, 0 means 60.
+	UdpIdleTimeout int `json:"udpIdleTimeout"`
+	// ForwardMode is the default forward mode of new sessions, "auto" (default) or "manual".
+	ForwardMode string `json:"forwardMode"`
+	// SrcFramer cuts the data sent by the client into the messages of the events and of the intercept rules.
+	// The data is relayed as it is read while the direction is not intercepted.
+	SrcFramer FramerConfig `json:"srcFramer"`
+	// DstFramer cuts the data sent by the server into the messages of the events, of the intercept rules and
+	// of the redirect rewriting. The data is relayed as it is read while the direction is neither intercepted nor redirected.
+	DstFramer FramerConfig `json:"dstFramer"`
+	// SeqRewrite renumbers the counter digit of Mir client packets, which keeps the server in step when
+	// packets are injected or dropped. Only enable it for Mir traffic, other protocols may carry "#<digit>".
+	SeqRewrite bool `json:"seqRewrite"`
+	// Redirect makes the transfer follow the server addresses handed to the client.
+	Redirect RedirectConfig `json:"redirect"`
+	// SrcTLS terminates TLS on the connections of the clients.
+	SrcTLS TLSServerConfig `json:"srcTls"`
+	// DstTLS opens the connections to the destination with TLS.
+	DstTLS TLSClientConfig `json:"dstTls"`
+	// Proxy makes the src listener a SOCKS5 and HTTP CONNECT proxy relaying each client to the destination
+	// it asks for, DstAddr and DstPort are then unused.
+	Proxy bool `json:"proxy"`
+	// Upstream are the proxies the connections to the destination go through, in order.
+	Upstream []UpstreamProxy `json:"upstream"`
+	// Shaping simulates network conditions on the sessions, it can be changed per session at runtime.
+	Shaping TrafficShaping `json:"shaping"`
 }
 
 // ClientConfig represents the configuration for the client.
@@ -40,6 +96,14 @@ type ClientConfig struct {
 	ServerIp string `json:"ServerIp"`
 	// ServerPort is the port number of the server that the client connects to.
 	ServerPort string `json:"ServerPort"`
+	// UdpServerPort is the UDP port number of the server that the client sends to, on ServerIp.
+	UdpServerPort string `json:"UdpServerPort"`
+	// Framer cuts the data received from the server into messages.
+	Framer FramerConfig `json:"Framer"`
+	// TLS opens the connections to the server with TLS.
+	TLS TLSClientConfig `json:"TLS"`
+	// Upstream are the proxies the connections to the server go through, in order.
+	Upstream []UpstreamProxy `json:"Upstream"`
 }
 
 // Config represents the overall configuration for the application.
@@ -50,6 +114,18 @@ type Config struct {
 	Transfer TransferConfig `json:"Transfer"`
 	// Client is the configuration for the client.
 	Client ClientConfig `json:"Client"`
+	// Replay is the configuration for replaying a recorded session against a server.
+	Replay ReplayConfig `json:"Replay"`
+	// Servers are additional TCP servers run side by side, keyed by their instance ID.
+	Servers map[string]ServerConfig `json:"Servers"`
+	// Transfers are additional transfers run side by side, keyed by their instance ID.
+	Transfers map[string]TransferConfig `json:"Transfers"`
+	// Api is the configuration for the HTTP API.
+	Api ApiConfig `json:"Api"`
+	// Bot is the configuration for the scripted bot client.
+	Bot BotConfig `json:"Bot"`
+	// CatalogFile is the JSON or YAML file naming the Mir messages, the built-in catalog is used when empty.
+	CatalogFile string `json:"CatalogFile"`
 }
 
 func NewConfig() *Config {
@@ -3,31 +3,68 @@ package mircat
 import (
 	"encoding/base64"
 	"fmt"
+	"mir-cat/pkg/codec"
+	"os"
+	"sync"
+	"time"
 )
 
 type ConnManager struct {
-	app      *App
-	clients  []*TcpClient
-	server   *TCPServer
-	transfer *TCPTransfer
-	cfg      *Config
+	app         *App
+	clients     []*TcpClient
+	server      *TCPServer
+	transfer    *TCPTransfer
+	udpClients  []*UdpClient
+	udpServer   *UDPServer
+	udpTransfer *UDPTransfer
+	capture     *Capture
+	replayer    *Replayer
+	tracker     *GameTracker
+	bot         *Bot
+	ca          *CertAuthority
+	servers     map[string]*TCPServer
+	transfers   map[string]*TCPTransfer
+	instances   sync.Mutex
+	cfg         *Config
 }
 
 func NewConnManager(app *App, cfg *Config) *ConnManager {
-	return &ConnManager{
-		app:      app,
-		clients:  []*TcpClient{},
-		server:   NewTCPServer(app),
-		transfer: NewTCPTransfer(app),
-		cfg:      cfg,
+	capture := NewCapture()
+	cwd, _ := os.Getwd()
+	c := &ConnManager{
+		app:         app,
+		clients:     []*TcpClient{},
+		server:      NewTCPServer(capture, app),
+		transfer:    NewTCPTransfer(capture, app),
+		udpClients:  []*UdpClient{},
+		udpServer:   NewUDPServer(app),
+		udpTransfer: NewUDPTransfer(app),
+		capture:     capture,
+		replayer:    NewReplayer(capture, app),
+		tracker:     NewGameTracker(app),
+		ca:          NewCertAuthority(cwd),
+		servers:     map[string]*TCPServer{},
+		transfers:   map[string]*TCPTransfer{},
+		cfg:         cfg,
 	}
+	c.transfer.SetTracker(c.tracker)
+	c.bot = NewBot(capture, c.tracker, app)
+	if cfg.CatalogFile != "" {
+		c.CatalogReload()
+	}
+	return c
 }
 
 // ClientTcpOpen opens a new TCP client connection and returns its index.
 // Returns:
 // - int: the index of the newly opened client connection.
 func (c *ConnManager) ClientTcpOpen() int {
-	tcpClient, err := NewTcpClient(c.cfg.Client.ServerIp+":"+c.cfg.Client.ServerPort, c.app)
+	dialer, err := NewDialer(c.cfg.Client.TLS, c.cfg.Client.Upstream)
+	if err != nil {
+		c.app.EventsEmit("client-tcp-error", -1, fmt.Sprintf("invalid dial settings: %v", err))
+		return -1
+	}
+	tcpClient, err := NewTcpClient(c.cfg.Client.ServerIp+":"+c.cfg.Client.ServerPort, c.cfg.Client.Framer, dialer, c.capture, c.app)
 	if err != nil {
 		c.app.EventsEmit("client-tcp-error", -1, fmt.Sprintf("%v", err))
 		fmt.Printf("Failed to connect: %v\n", err)
@@ -82,28 +119,17 @@ func (c *ConnManager) ClientTcpCloseAll() {
 // It takes the server's address from the configuration file, starts the server, and returns a boolean indicating success or failure.
 // If the server fails to start, it emits a "server-tcp-error" event with the error message and returns false.
 // If the server starts successfully, it emits a "server-tcp-info" event with the server's address and returns true.
+// When Server.mock is enabled the recorded sessions are loaded first, and every client message is answered with the
+// responses recorded for the best-matching request; a message without match emits a "server-tcp-error" event.
 func (c *ConnManager) ServerTcpStart() bool {
-	address := c.cfg.Server.TcpAddr + ":" + c.cfg.Server.TcpPort
-	err := c.server.Start(address)
-	if err != nil {
-		c.app.EventsEmit("server-tcp-error", "server", fmt.Sprintf("failed to listen on %s: %v", address, err))
-		fmt.Printf("Failed to start tcp server : %v\n", err)
-		return false
-	}
-	c.app.EventsEmit("server-tcp-info", "server", fmt.Sprintf("listening on %s", address))
-	return true
+	return c.startServer(c.server, c.cfg.Server, c.app)
 }
 
 // ServerTcpStop stops the TCP server for the connection manager.
 // It checks if the server is running and stops it if it is, then emits a "server-tcp-info" event indicating the server has stopped and returns true.
 // If the server is not running, it returns false.
 func (c *ConnManager) ServerTcpStop() bool {
-	if c.server == nil {
-		return false
-	}
-	c.server.Stop()
-	c.app.EventsEmit("server-tcp-info", "server", "tcp server stopped")
-	return true
+	return c.stopServer(c.server, c.app)
 }
 
 // ServerSendMessage sends a message to a specific client over TCP connection.
@@ -116,16 +142,7 @@ func (c *ConnManager) ServerTcpStop() bool {
 // through the app instance.
 // If the base64Data is not a valid base64-encoded string, an error event will also be emitted.
 func (c *ConnManager) ServerSendMessage(client string, base64Data string) {
-	if c.server == nil || c.server.listener == nil {
-		c.app.EventsEmit("server-tcp-error", client, "server not started")
-		return
-	}
-	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
-	if err != nil {
-		c.app.EventsEmit("server-tcp-error", client, base64Data+" decode failed")
-		return
-	}
-	c.server.SendMessage(client, decodedBytes)
+	c.serverSendMessage(c.server, c.app, client, base64Data)
 }
 
 // ServerBroadcastMessage broadcasts a message to all connected clients over TCP connection.
@@ -137,16 +154,7 @@ func (c *ConnManager) ServerSendMessage(client string, base64Data string) {
 // through the app instance.
 // If the base64Data is not a valid base64-encoded string, an error event will also be emitted.
 func (c *ConnManager) ServerBroadcastMessage(base64Data string) {
-	if c.server == nil || c.server.listener == nil {
-		c.app.EventsEmit("server-tcp-error", "server", "server not started")
-		return
-	}
-	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
-	if err != nil {
-		c.app.EventsEmit("server-tcp-error", "server", base64Data+" decode failed")
-		return
-	}
-	c.server.BroadcastMessage(decodedBytes)
+	c.serverBroadcastMessage(c.server, c.app, base64Data)
 }
 
 // TransferTcpStart starts the TCP transfer between the source and destination addresses specified in the configuration file of the connection manager.
@@ -157,27 +165,122 @@ func (c *ConnManager) ServerBroadcastMessage(base64Data string) {
 // - srcAddress: a string that represents the source IP address and port for the TCP transfer
 // - dstAddress: a string that represents the destination IP address and port for the TCP transfer
 func (c *ConnManager) TransferTcpStart() bool {
-	srcAddress := c.cfg.Transfer.SrcAddr + ":" + c.cfg.Transfer.SrcPort
-	dstAddress := c.cfg.Transfer.DstAddr + ":" + c.cfg.Transfer.DstPort
-	err := c.transfer.Start(srcAddress, dstAddress)
-	if err != nil {
-		c.app.EventsEmit("transfer-tcp-error", "server", fmt.Sprintf("failed to listen on %s: %v", srcAddress, err))
-		fmt.Printf("Failed to start transfer server : %v\n", err)
-		return false
-	}
-	c.app.EventsEmit("transfer-tcp-info", "server", fmt.Sprintf("listening on %s", srcAddress))
-	return true
+	return c.startTransfer(c.transfer, c.cfg.Transfer, c.app)
 }
 
 // TransferTcpStop stops the transfer TCP server if it is currently running.
 // It returns a boolean indicating whether the server was stopped successfully or not.
 func (c *ConnManager) TransferTcpStop() bool {
-	if c.transfer == nil {
-		return false
-	}
-	c.transfer.Stop()
-	c.app.EventsEmit("transfer-tcp-info", "server", "transfer server stopped")
-	return true
+	return c.stopTransfer(c.transfer, c.app)
+}
+
+// TransferSetForwardMode switches how a transfer session relays its traffic.
+// In "auto" mode every chunk is forwarded natively in both directions and the UI receives copies through
+// "transfer-src-data" / "transfer-dst-data". In "manual" mode nothing is relayed unless the UI calls
+// TransferSendToServer / TransferSendToClient.
+// It emits a "transfer-tcp-error" event and returns false if the mode is unknown or the client does not exist.
+//
+// Parameters:
+// - client: the session key of the client, or an empty string to change the mode of sessions accepted afterwards.
+// - mode: "auto" or "manual".
+func (c *ConnManager) TransferSetForwardMode(client string, mode string) bool {
+	return c.transferSetForwardMode(c.transfer, c.app, client, mode)
+}
+
+// TransferGetForwardMode returns the forward mode of a transfer session.
+// It returns an empty string and emits a "transfer-tcp-error" event if the client does not exist.
+//
+// Parameters:
+// - client: the session key of the client, or an empty string for the default mode of new sessions.
+func (c *ConnManager) TransferGetForwardMode(client string) string {
+	return c.transferGetForwardMode(c.transfer, c.app, client)
+}
+
+// TransferShapingSet changes the network conditions simulated on a transfer session, to reproduce lag.
+// Each direction gets a latency and a random jitter in milliseconds, a bandwidth cap in bytes per second,
+// and the probabilities of stalls and of connection resets per relayed chunk. Chunks keep their order,
+// data injected with TransferSendToServer / TransferSendToClient included.
+// It emits a "transfer-tcp-error" event and returns false if a setting is invalid or the client does not exist.
+//
+// Parameters:
+// - client: the session key of the client, or an empty string for the conditions of sessions accepted afterwards.
+// - shaping: the settings of the src and dst directions, zero values turn shaping off.
+func (c *ConnManager) TransferShapingSet(client string, shaping TrafficShaping) bool {
+	return c.transferShapingSet(c.transfer, c.app, client, shaping)
+}
+
+// TransferShapingGet returns the network conditions simulated on a transfer session.
+// It emits a "transfer-tcp-error" event if the client does not exist.
+//
+// Parameters:
+// - client: the session key of the client, or an empty string for the conditions of new sessions.
+func (c *ConnManager) TransferShapingGet(client string) TrafficShaping {
+	return c.transferShapingGet(c.transfer, c.app, client)
+}
+
+// TransferInterceptSet changes the intercept settings of a transfer session.
+// Packets of the enabled directions that match one of the rules (or every packet when there are no rules) are held
+// in a per-session queue and announced with a "transfer-intercept-held" event until they are forwarded, edited or dropped.
+// Packets held in a direction that is disabled by the new settings are forwarded.
+// It emits a "transfer-tcp-error" event and returns false if a rule is invalid or the client does not exist.
+//
+// Parameters:
+// - client: the session key of the client, or an empty string for the settings of sessions without their own.
+// - settings: the directions to intercept and the match rules.
+func (c *ConnManager) TransferInterceptSet(client string, settings InterceptSettings) bool {
+	return c.transferInterceptSet(c.transfer, c.app, client, settings)
+}
+
+// TransferInterceptGet returns the intercept settings of a transfer session.
+//
+// Parameters:
+// - client: the session key of the client, or an empty string for the settings of sessions without their own.
+func (c *ConnManager) TransferInterceptGet(client string) InterceptSettings {
+	return c.transfer.Intercept(client)
+}
+
+// TransferInterceptList returns the packets currently held by the intercept mode, ordered by ID.
+//
+// Parameters:
+// - client: the session key of the client, or an empty string for every session.
+func (c *ConnManager) TransferInterceptList(client string) []InterceptedPacket {
+	return c.transfer.InterceptList(client)
+}
+
+// TransferInterceptForward forwards a held packet unchanged.
+// It emits a "transfer-intercept-resolved" event on success, otherwise a "transfer-tcp-error" event and returns false.
+//
+// Parameters:
+// - id: the ID of the held packet.
+func (c *ConnManager) TransferInterceptForward(id int) bool {
+	return c.transferInterceptResolve(c.transfer, c.app, id, INTERCEPT_ACTION_FORWARD, "")
+}
+
+// TransferInterceptDrop discards a held packet.
+// It emits a "transfer-intercept-resolved" event on success, otherwise a "transfer-tcp-error" event and returns false.
+//
+// Parameters:
+// - id: the ID of the held packet.
+func (c *ConnManager) TransferInterceptDrop(id int) bool {
+	return c.transferInterceptResolve(c.transfer, c.app, id, INTERCEPT_ACTION_DROP, "")
+}
+
+// TransferInterceptEdit replaces the content of a held packet and forwards it.
+// It emits a "transfer-intercept-resolved" event on success, otherwise a "transfer-tcp-error" event and returns false.
+//
+// Parameters:
+// - id: the ID of the held packet.
+// - base64Data: the new content of the packet, encoded in base64 format.
+func (c *ConnManager) TransferInterceptEdit(id int, base64Data string) bool {
+	return c.transferInterceptResolve(c.transfer, c.app, id, INTERCEPT_ACTION_EDIT, base64Data)
+}
+
+// TransferInterceptForwardAll forwards every held packet unchanged.
+//
+// Parameters:
+// - client: the session key of the client, or an empty string for every session.
+func (c *ConnManager) TransferInterceptForwardAll(client string) {
+	c.transfer.InterceptRelease(client)
 }
 
 // TransferSendToServer transfers base64 encoded data to the server via TCP connection.
@@ -189,16 +292,7 @@ func (c *ConnManager) TransferTcpStop() bool {
 // - client: a string representing the ID of the client sending the data
 // - base64Data: a string representing the base64 encoded data to be sent to the server
 func (c *ConnManager) TransferSendToServer(client string, base64Data string) {
-	if c.transfer == nil || c.transfer.listener == nil {
-		c.app.EventsEmit("transfer-tcp-error", client, "transfer server not started")
-		return
-	}
-	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
-	if err != nil {
-		c.app.EventsEmit("transfer-tcp-error", client, base64Data+" decode failed")
-		return
-	}
-	c.transfer.SendToServer(client, decodedBytes)
+	c.transferSendToServer(c.transfer, c.app, client, base64Data)
 }
 
 // TransferSendToClient transfers the decoded data to a specific client via a transfer server.
@@ -208,16 +302,7 @@ func (c *ConnManager) TransferSendToServer(client string, base64Data string) {
 // - client: a string representing the identifier of the client that will receive the data.
 // - base64Data: a string representing the data to be transferred, encoded in base64 format.
 func (c *ConnManager) TransferSendToClient(client string, base64Data string) {
-	if c.transfer == nil || c.transfer.listener == nil {
-		c.app.EventsEmit("transfer-tcp-error", client, "transfer server not started")
-		return
-	}
-	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
-	if err != nil {
-		c.app.EventsEmit("transfer-tcp-error", client, base64Data+" decode failed")
-		return
-	}
-	c.transfer.SendToClient(client, decodedBytes)
+	c.transferSendToClient(c.transfer, c.app, client, base64Data)
 }
 
 // TransferBroadcastToServer transfers a base64 encoded string to the server using the connection manager's transfer object.
@@ -228,32 +313,428 @@ func (c *ConnManager) TransferSendToClient(client string, base64Data string) {
 // Parameters:
 // - base64Data: A base64 encoded string to be transferred to the server.
 func (c *ConnManager) TransferBroadcastToServer(base64Data string) {
-	if c.transfer == nil || c.transfer.listener == nil {
-		c.app.EventsEmit("transfer-tcp-error", "server", "transfer server not started")
+	c.transferBroadcastToServer(c.transfer, c.app, base64Data)
+}
+
+// TransferBroadcastToClient transfers a base64 encoded string to the connected clients.
+// If the server or listener is not started, a "transfer-tcp-error" event will be emitted with the corresponding error message.
+// If the base64 decoding fails, a "transfer-tcp-error" event will be emitted with the corresponding error message.
+// Parameters:
+// - base64Data: the base64 encoded string to be transferred to the clients.
+func (c *ConnManager) TransferBroadcastToClient(base64Data string) {
+	c.transferBroadcastToClient(c.transfer, c.app, base64Data)
+}
+
+// ClientUdpOpen opens a new UDP client socket towards the server configured by ServerIp and UdpServerPort
+// and returns its index. Datagrams received on the socket are emitted as "client-udp-data" events.
+// Returns:
+// - int: the index of the newly opened client socket, or -1 on failure.
+func (c *ConnManager) ClientUdpOpen() int {
+	udpClient, err := NewUdpClient(c.cfg.Client.ServerIp+":"+c.cfg.Client.UdpServerPort, c.app)
+	if err != nil {
+		c.app.EventsEmit("client-udp-error", -1, fmt.Sprintf("%v", err))
+		fmt.Printf("Failed to open udp client: %v\n", err)
+		return -1
+	}
+	c.udpClients = append(c.udpClients, udpClient)
+	udpClient.index = len(c.udpClients) - 1
+	c.app.EventsEmit("client-udp-info", udpClient.index, "connection opened")
+	return udpClient.index
+}
+
+// ClientUdpSend sends a datagram from a specified UDP client.
+// Parameters:
+// - index (int): the index of the UDP client.
+// - base64Data (string): the data to send, encoded in base64 format.
+func (c *ConnManager) ClientUdpSend(index int, base64Data string) {
+	if index >= len(c.udpClients) || index < 0 {
+		c.app.EventsEmit("client-udp-error", index, "invalid client index")
 		return
 	}
 	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
 	if err != nil {
-		c.app.EventsEmit("transfer-tcp-error", "server", base64Data+" decode failed")
+		c.app.EventsEmit("client-udp-error", index, base64Data+" decode failed")
 		return
 	}
-	c.transfer.BroadcastToServer(decodedBytes)
+	c.udpClients[index].Send(decodedBytes)
 }
 
-// TransferBroadcastToClient transfers a base64 encoded string to the connected clients.
-// If the server or listener is not started, a "transfer-tcp-error" event will be emitted with the corresponding error message.
-// If the base64 decoding fails, a "transfer-tcp-error" event will be emitted with the corresponding error message.
+// ClientUdpClose closes a specified UDP client socket.
 // Parameters:
-// - base64Data: the base64 encoded string to be transferred to the clients.
-func (c *ConnManager) TransferBroadcastToClient(base64Data string) {
-	if c.server == nil || c.server.listener == nil {
-		c.app.EventsEmit("transfer-tcp-error", "server", "transfer server not started")
+// - index (int): the index of the UDP client to close.
+func (c *ConnManager) ClientUdpClose(index int) {
+	if index >= len(c.udpClients) || index < 0 {
+		c.app.EventsEmit("client-udp-error", index, "invalid client index")
 		return
 	}
+	c.udpClients[index].Shutdown()
+}
+
+// ClientUdpCloseAll closes all currently open UDP client sockets.
+func (c *ConnManager) ClientUdpCloseAll() {
+	for _, client := range c.udpClients {
+		client.Shutdown()
+	}
+	c.udpClients = []*UdpClient{}
+}
+
+// ServerUdpStart starts the UDP server on the UdpAddr and UdpPort of the server configuration.
+// Every remote address that sends a datagram is tracked as a peer until it stays idle for 60 seconds.
+// It emits a "server-udp-error" event and returns false if the server fails to start,
+// otherwise it emits a "server-udp-info" event and returns true.
+func (c *ConnManager) ServerUdpStart() bool {
+	address := c.cfg.Server.UdpAddr + ":" + c.cfg.Server.UdpPort
+	err := c.udpServer.Start(address)
+	if err != nil {
+		c.app.EventsEmit("server-udp-error", "server", fmt.Sprintf("failed to listen on %s: %v", address, err))
+		fmt.Printf("Failed to start udp server : %v\n", err)
+		return false
+	}
+	c.app.EventsEmit("server-udp-info", "server", fmt.Sprintf("listening on %s", address))
+	return true
+}
+
+// ServerUdpStop stops the UDP server and forgets its peers.
+func (c *ConnManager) ServerUdpStop() bool {
+	if c.udpServer == nil {
+		return false
+	}
+	c.udpServer.Stop()
+	c.app.EventsEmit("server-udp-info", "server", "udp server stopped")
+	return true
+}
+
+// ServerUdpPeers returns the addresses of the peers currently tracked by the UDP server.
+func (c *ConnManager) ServerUdpPeers() []string {
+	return c.udpServer.Peers()
+}
+
+// ServerUdpSendMessage sends a datagram to a peer of the UDP server.
+//
+// Parameters:
+// - peer: the address of the target peer.
+// - base64Data: the message data encoded in base64 format.
+func (c *ConnManager) ServerUdpSendMessage(peer string, base64Data string) {
 	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
 	if err != nil {
-		c.app.EventsEmit("transfer-tcp-error", "server", base64Data+" decode failed")
+		c.app.EventsEmit("server-udp-error", peer, base64Data+" decode failed")
 		return
 	}
-	c.transfer.BroadcastToClient(decodedBytes)
+	err = c.udpServer.SendMessage(peer, decodedBytes)
+	if err != nil {
+		c.app.EventsEmit("server-udp-error", peer, fmt.Sprintf("%v", err))
+	}
+}
+
+// ServerUdpBroadcastMessage sends a datagram to every peer of the UDP server.
+//
+// Parameters:
+// - base64Data: the message data encoded in base64 format.
+func (c *ConnManager) ServerUdpBroadcastMessage(base64Data string) {
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		c.app.EventsEmit("server-udp-error", "server", base64Data+" decode failed")
+		return
+	}
+	c.udpServer.BroadcastMessage(decodedBytes)
+}
+
+// TransferUdpStart starts relaying UDP datagrams between the UdpSrcAddr/UdpSrcPort and UdpDstAddr/UdpDstPort
+// of the transfer configuration. Every client address gets its own socket towards the destination, which is
+// closed after UdpIdleTimeout seconds without traffic. Relayed datagrams are emitted as
+// "transfer-udp-src-data" and "transfer-udp-dst-data" events.
+// It returns true if the relay was successfully started, otherwise it emits a "transfer-udp-error" event and returns false.
+func (c *ConnManager) TransferUdpStart() bool {
+	srcAddress := c.cfg.Transfer.UdpSrcAddr + ":" + c.cfg.Transfer.UdpSrcPort
+	dstAddress := c.cfg.Transfer.UdpDstAddr + ":" + c.cfg.Transfer.UdpDstPort
+	err := c.udpTransfer.Start(srcAddress, dstAddress, time.Duration(c.cfg.Transfer.UdpIdleTimeout)*time.Second)
+	if err != nil {
+		c.app.EventsEmit("transfer-udp-error", "server", fmt.Sprintf("failed to listen on %s: %v", srcAddress, err))
+		fmt.Printf("Failed to start udp transfer : %v\n", err)
+		return false
+	}
+	c.app.EventsEmit("transfer-udp-info", "server", fmt.Sprintf("listening on %s", srcAddress))
+	return true
+}
+
+// TransferUdpStop stops the UDP relay and closes all of its sessions.
+func (c *ConnManager) TransferUdpStop() bool {
+	if c.udpTransfer == nil {
+		return false
+	}
+	c.udpTransfer.Stop()
+	c.app.EventsEmit("transfer-udp-info", "server", "udp transfer stopped")
+	return true
+}
+
+// TransferUdpSendToServer injects a datagram into a UDP relay session towards the destination.
+//
+// Parameters:
+// - client: the address of the client owning the session.
+// - base64Data: the datagram encoded in base64 format.
+func (c *ConnManager) TransferUdpSendToServer(client string, base64Data string) {
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		c.app.EventsEmit("transfer-udp-error", client, base64Data+" decode failed")
+		return
+	}
+	err = c.udpTransfer.SendToServer(client, decodedBytes)
+	if err != nil {
+		c.app.EventsEmit("transfer-udp-error", client, fmt.Sprintf("%v", err))
+	}
+}
+
+// TransferUdpSendToClient injects a datagram into a UDP relay session towards the client.
+//
+// Parameters:
+// - client: the address of the client owning the session.
+// - base64Data: the datagram encoded in base64 format.
+func (c *ConnManager) TransferUdpSendToClient(client string, base64Data string) {
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		c.app.EventsEmit("transfer-udp-error", client, base64Data+" decode failed")
+		return
+	}
+	err = c.udpTransfer.SendToClient(client, decodedBytes)
+	if err != nil {
+		c.app.EventsEmit("transfer-udp-error", client, fmt.Sprintf("%v", err))
+	}
+}
+
+// EncodeMirPacket builds a Legend of Mir packet: '#', the counter digit when Seq is between 1 and 9,
+// the 6-bit encoded DefaultMessage and body, and '!'. The body is taken from Body, or from Text when Body is empty.
+// Returns:
+// - string: the packet encoded in base64 format.
+func (c *ConnManager) EncodeMirPacket(packet codec.Packet) string {
+	return base64.StdEncoding.EncodeToString(codec.EncodePacket(&packet))
+}
+
+// DecodeMirPacket decodes a single Legend of Mir packet, with or without its '#' and '!' delimiters.
+//
+// Parameters:
+// - base64Data: the packet encoded in base64 format.
+func (c *ConnManager) DecodeMirPacket(base64Data string) (*codec.Packet, error) {
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s decode failed", base64Data)
+	}
+	return codec.DecodePacket(decodedBytes)
+}
+
+// CaptureStart starts recording the traffic of the TCP client, server and transfer into a pcapng file.
+// Every chunk read or written is stored with synthesized Ethernet/IP/TCP headers, and the first packet of every
+// connection carries a comment naming the component and its addresses. Blocks are written as they are produced.
+// It emits a "capture-error" event and returns false if the file cannot be created or a capture is already running.
+//
+// Parameters:
+// - path: the pcapng file to write.
+func (c *ConnManager) CaptureStart(path string) bool {
+	err := c.capture.Start(path)
+	if err != nil {
+		c.app.EventsEmit("capture-error", path, fmt.Sprintf("%v", err))
+		return false
+	}
+	c.app.EventsEmit("capture-info", path, "capture started")
+	return true
+}
+
+// CaptureStop stops the running capture and closes its file.
+// It emits a "capture-error" event and returns false if no capture is running.
+func (c *ConnManager) CaptureStop() bool {
+	path := c.capture.Path()
+	err := c.capture.Stop()
+	if err != nil {
+		c.app.EventsEmit("capture-error", path, fmt.Sprintf("%v", err))
+		return false
+	}
+	c.app.EventsEmit("capture-info", path, "capture stopped")
+	return true
+}
+
+// CapturePath returns the file of the running capture, or an empty string when no capture is running.
+func (c *ConnManager) CapturePath() string {
+	return c.capture.Path()
+}
+
+// PcapFlows lists the TCP connections of a pcap or pcapng file so one can be selected for PcapImport.
+// It emits a "transfer-tcp-error" event and returns an empty list if the file cannot be read.
+//
+// Parameters:
+// - path: the capture file.
+func (c *ConnManager) PcapFlows(path string) []PcapFlow {
+	flows, err := PcapFlows(path)
+	if err != nil {
+		c.app.EventsEmit("transfer-tcp-error", path, fmt.Sprintf("%v", err))
+		return []PcapFlow{}
+	}
+	return flows
+}
+
+// PcapImport loads a pcap or pcapng file, reassembles its TCP connections and emits them as
+// "transfer-src-data" and "transfer-dst-data" events keyed by the client address, cut by the framers of the
+// transfer configuration and decoded like live traffic.
+// It returns the number of imported connections, or -1 and emits a "transfer-tcp-error" event if the file cannot be read.
+//
+// Parameters:
+// - path: the capture file.
+// - filter: the client and server addresses and the time range to import, empty fields match everything.
+func (c *ConnManager) PcapImport(path string, filter PcapFilter) int {
+	sessions, err := LoadPcap(path, filter)
+	if err != nil {
+		c.app.EventsEmit("transfer-tcp-error", path, fmt.Sprintf("%v", err))
+		return -1
+	}
+	for _, session := range sessions {
+		c.app.EventsEmit("transfer-tcp-info", session.Flow.Client, fmt.Sprintf("imported %s <-> %s from %s", session.Flow.Client, session.Flow.Server, path))
+		if session.Flow.MissingBytes > 0 {
+			c.app.EventsEmit("transfer-tcp-error", session.Flow.Client, fmt.Sprintf("%d bytes are missing, the capture was cut by its snapshot length", session.Flow.MissingBytes))
+		}
+		err = emitCapturedSession(c.app, c.tracker, session, c.cfg.Transfer.SrcFramer, c.cfg.Transfer.DstFramer)
+		if err != nil {
+			c.app.EventsEmit("transfer-tcp-error", session.Flow.Client, fmt.Sprintf("%v", err))
+			return -1
+		}
+	}
+	return len(sessions)
+}
+
+// ReplayStart replays the client side of a recorded session against a server, using the replay configuration.
+// The client messages are sent through a new TCP client either with their recorded spacing divided by the speed
+// ("timing" mode), or each once the recorded number of responses to the previous one has arrived ("response" mode).
+// A "replay-result" event compares the responses to every message with the recorded ones, and a final
+// "replay-info" event counts the matching steps.
+// It emits a "replay-error" event and returns false if the session cannot be loaded or the server cannot be reached.
+func (c *ConnManager) ReplayStart() bool {
+	err := c.replayer.Start(c.cfg.Replay)
+	if err != nil {
+		c.app.EventsEmit("replay-error", c.cfg.Replay.Target, fmt.Sprintf("%v", err))
+		return false
+	}
+	return true
+}
+
+// ReplayStop interrupts the running replay.
+// It returns false if no replay is running.
+func (c *ConnManager) ReplayStop() bool {
+	return c.replayer.Stop()
+}
+
+// CatalogGet returns the catalog naming the Mir messages of the decoded events.
+// Returns:
+// - codec.Catalog: the identifiers, names and layouts of the messages.
+func (c *ConnManager) CatalogGet() codec.Catalog {
+	return *codec.CurrentCatalog()
+}
+
+// CatalogSet replaces the catalog, the messages decoded afterwards are named and parsed with it.
+// The catalog is also written to the catalog file of the configuration when there is one.
+// It emits a "catalog-error" event and returns false, keeping the previous catalog, if a layout is invalid
+// or the file cannot be written.
+//
+// Parameters:
+// - catalog: the identifiers, names and layouts of the messages.
+func (c *ConnManager) CatalogSet(catalog codec.Catalog) bool {
+	// the catalog is only applied once it is saved, so the file and the decoders never disagree
+	err := catalog.Validate()
+	if err == nil && c.cfg.CatalogFile != "" {
+		err = catalog.Save(c.cfg.CatalogFile)
+	}
+	if err == nil {
+		err = codec.SetCatalog(&catalog)
+	}
+	if err != nil {
+		c.app.EventsEmit("catalog-error", c.cfg.CatalogFile, fmt.Sprintf("%v", err))
+		return false
+	}
+	c.app.EventsEmit("catalog-info", c.cfg.CatalogFile, fmt.Sprintf("%d messages", len(catalog.Messages)))
+	return true
+}
+
+// CatalogReload reads the catalog file of the configuration again, or restores the built-in catalog
+// when the configuration has none. The previous catalog is kept if the file is invalid.
+// It emits a "catalog-error" event and returns false if the file cannot be loaded.
+func (c *ConnManager) CatalogReload() bool {
+	var catalog *codec.Catalog
+	var err error
+	if c.cfg.CatalogFile == "" {
+		catalog, err = codec.DefaultCatalog()
+	} else {
+		catalog, err = codec.LoadCatalog(c.cfg.CatalogFile)
+	}
+	if err == nil {
+		err = codec.SetCatalog(catalog)
+	}
+	if err != nil {
+		c.app.EventsEmit("catalog-error", c.cfg.CatalogFile, fmt.Sprintf("%v", err))
+		fmt.Printf("Failed to load the catalog: %v\n", err)
+		return false
+	}
+	c.app.EventsEmit("catalog-info", c.cfg.CatalogFile, fmt.Sprintf("%d messages", len(catalog.Messages)))
+	return true
+}
+
+// BotStart runs the bot of the configuration: it logs into the login server with the account, selects the
+// server, enters the game with the character through the character-select server, then plays the script.
+// A step sends its action and passes when the expected server message arrives in time. Every step emits a
+// "bot-result" event, and a "bot-finished" event summarizes the run. The game of the bot is tracked under
+// the session "bot <account>".
+// It emits a "bot-error" event and returns false if the configuration is invalid or a bot is already running.
+func (c *ConnManager) BotStart() bool {
+	err := c.bot.Start(c.cfg.Bot)
+	if err != nil {
+		c.app.EventsEmit("bot-error", c.cfg.Bot.Account, fmt.Sprintf("%v", err))
+		return false
+	}
+	return true
+}
+
+// BotStop interrupts the running bot.
+// It returns false if no bot is running.
+func (c *ConnManager) BotStop() bool {
+	return c.bot.Stop()
+}
+
+// TlsCaExport returns the certificate of the MirCat root CA, which signs the certificates presented to
+// clients in TLS MITM mode. It is generated next to config.json on first use; install it as a trusted root
+// on the test machines.
+// Returns:
+// - string: the PEM certificate, empty on error.
+func (c *ConnManager) TlsCaExport() string {
+	cert, err := c.ca.Certificate()
+	if err != nil {
+		c.app.EventsEmit("transfer-tcp-error", "server", fmt.Sprintf("failed to load the root CA: %v", err))
+		return ""
+	}
+	return string(cert)
+}
+
+// GameSessions lists the transfer sessions, live or imported, whose game is tracked. The sessions whose
+// connection ended are marked closed in their state and forgotten 10 minutes later.
+// Returns:
+// - []string: the client addresses of the sessions, sorted.
+func (c *ConnManager) GameSessions() []string {
+	return c.tracker.Sessions()
+}
+
+// GameStateGet returns what the decoded traffic of a session tells about the game: the position, map and
+// statistics of the player, the objects it sees, its inventory and the chat log.
+// It emits a "game-error" event and returns an empty state if the session is unknown.
+//
+// Parameters:
+// - session: the client address of the transfer session.
+func (c *ConnManager) GameStateGet(session string) GameState {
+	state, ok := c.tracker.Snapshot(session)
+	if !ok {
+		c.app.EventsEmit("game-error", session, "unknown session")
+		return GameState{Session: session, Objects: []GameObject{}, Inventory: []codec.ClientItem{}, Chat: []ChatLine{}}
+	}
+	return state
+}
+
+// GameStateReset forgets the game of a session, or of every session when session is empty.
+//
+// Parameters:
+// - session: the client address of the transfer session.
+func (c *ConnManager) GameStateReset(session string) {
+	c.tracker.Reset(session)
 }
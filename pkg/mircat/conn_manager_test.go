@@ -0,0 +1,39 @@
+package mircat
+
+import (
+	"mir-cat/pkg/codec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCatalogSet(t *testing.T) {
+	defer codec.SetCatalog(codec.CurrentCatalog())
+	rec := NewEventRecorder()
+	dir := t.TempDir()
+	cfg := &Config{}
+	m := NewConnManager(NewAppWithSink(rec), cfg)
+	cfg.CatalogFile = filepath.Join(dir, "catalog.json")
+	catalog := codec.Catalog{Messages: []codec.MessageSpec{{Ident: 1, Name: "SM_ONE"}}}
+
+	if !m.CatalogSet(catalog) {
+		t.Fatalf("set failed: %+v", rec.Events("catalog-error"))
+	}
+	if saved, err := codec.LoadCatalog(cfg.CatalogFile); err != nil || saved.Find("SM_ONE") == nil {
+		t.Fatalf("saved %+v, %v", saved, err)
+	}
+
+	// a catalog that cannot be saved is not applied either
+	cfg.CatalogFile = dir
+	if m.CatalogSet(codec.Catalog{Messages: []codec.MessageSpec{{Ident: 2, Name: "SM_TWO"}}}) {
+		t.Fatal("set succeeded without saving")
+	}
+	if current := m.CatalogGet(); current.Find("SM_ONE") == nil || current.Find("SM_TWO") != nil {
+		t.Fatalf("current catalog %+v", current.Messages)
+	}
+	if m.CatalogSet(codec.Catalog{Messages: []codec.MessageSpec{{Ident: 3}}}) {
+		t.Fatal("set an invalid catalog")
+	}
+	if errors := rec.Events("catalog-error"); len(errors) != 2 {
+		t.Fatalf("errors %+v", errors)
+	}
+}
@@ -0,0 +1,196 @@
+package mircat
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventSink receives the events of the clients, servers and transfers.
+// Emit may be called from many goroutines at once.
+type EventSink interface {
+	Emit(eventName string, optionalData ...interface{})
+}
+
+// EventSinkFunc lets an ordinary function be used as an EventSink.
+type EventSinkFunc func(eventName string, optionalData ...interface{})
+
+func (f EventSinkFunc) Emit(eventName string, optionalData ...interface{}) {
+	f(eventName, optionalData...)
+}
+
+// taggedSink appends a tag, such as an instance ID, to the data of every event.
+type taggedSink struct {
+	sink EventSink
+	tag  string
+}
+
+func (t *taggedSink) Emit(eventName string, optionalData ...interface{}) {
+	t.sink.Emit(eventName, append(optionalData[:len(optionalData):len(optionalData)], t.tag)...)
+}
+
+// WailsSink delivers events to the front-end through the Wails runtime.
+type WailsSink struct {
+	app *App
+}
+
+// NewWailsSink creates a sink using the context the Wails runtime gives to app at startup.
+func NewWailsSink(app *App) *WailsSink {
+	return &WailsSink{app: app}
+}
+
+// Emit sends the event to the front-end, events emitted before startup are dropped.
+func (w *WailsSink) Emit(eventName string, optionalData ...interface{}) {
+	if w.app.ctx == nil {
+		return
+	}
+	runtime.EventsEmit(w.app.ctx, eventName, optionalData)
+}
+
+// RecordedEvent is an event kept by an EventRecorder.
+type RecordedEvent struct {
+	Time time.Time
+	Name string
+	Data []interface{}
+}
+
+// EventRecorder keeps every event in memory, for tests and for programs embedding the package.
+type EventRecorder struct {
+	mutex  sync.Mutex
+	events []RecordedEvent
+	notify chan bool
+}
+
+func NewEventRecorder() *EventRecorder {
+	return &EventRecorder{
+		events: []RecordedEvent{},
+		notify: make(chan bool),
+	}
+}
+
+func (r *EventRecorder) Emit(eventName string, optionalData ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.events = append(r.events, RecordedEvent{Time: time.Now(), Name: eventName, Data: optionalData})
+	close(r.notify)
+	r.notify = make(chan bool)
+}
+
+// Events returns the recorded events, or only those named eventName when it is not empty.
+func (r *EventRecorder) Events(eventName string) []RecordedEvent {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	events := []RecordedEvent{}
+	for _, event := range r.events {
+		if eventName == "" || event.Name == eventName {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Wait blocks until count events named eventName have been recorded, and returns them.
+// It returns false if they did not arrive within timeout.
+func (r *EventRecorder) Wait(eventName string, count int, timeout time.Duration) ([]RecordedEvent, bool) {
+	deadline := time.After(timeout)
+	for {
+		r.mutex.Lock()
+		notify := r.notify
+		r.mutex.Unlock()
+		events := r.Events(eventName)
+		if len(events) >= count {
+			return events, true
+		}
+		select {
+		case <-notify:
+		case <-deadline:
+			return events, false
+		}
+	}
+}
+
+// Reset forgets the recorded events.
+func (r *EventRecorder) Reset() {
+	r.mutex.Lock()
+	r.events = []RecordedEvent{}
+	r.mutex.Unlock()
+}
+
+// EventLine is the JSON object written by a JSONLinesSink for every event.
+type EventLine struct {
+	Time  time.Time     `json:"time"`
+	Event string        `json:"event"`
+	Data  []interface{} `json:"data"`
+}
+
+// JSONLinesSink writes every event as a line of JSON. Binary data is encoded in base64.
+type JSONLinesSink struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func NewJSONLinesSink(out io.Writer) *JSONLinesSink {
+	return &JSONLinesSink{out: out}
+}
+
+func (j *JSONLinesSink) Emit(eventName string, optionalData ...interface{}) {
+	now := time.Now()
+	line, err := json.Marshal(EventLine{Time: now, Event: eventName, Data: optionalData})
+	if err != nil {
+		line, _ = json.Marshal(EventLine{Time: now, Event: eventName, Data: []interface{}{fmt.Sprintf("%v", err)}})
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.out.Write(append(line, '\n'))
+}
+
+// MultiSink delivers every event to several sinks, in order.
+type MultiSink struct {
+	mutex  sync.RWMutex
+	sinks  []EventSink
+	ids    []int
+	nextId int
+}
+
+func NewMultiSink(sinks ...EventSink) *MultiSink {
+	m := &MultiSink{}
+	for _, sink := range sinks {
+		m.Add(sink)
+	}
+	return m
+}
+
+// Add appends a sink, it receives the events emitted from now on.
+// It returns a function that detaches the sink again.
+func (m *MultiSink) Add(sink EventSink) func() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.nextId++
+	id := m.nextId
+	m.sinks = append(m.sinks, sink)
+	m.ids = append(m.ids, id)
+	return func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		for i := range m.ids {
+			if m.ids[i] == id {
+				// copy, Emit may still be iterating over the previous slices
+				m.sinks = append(m.sinks[:i:i], m.sinks[i+1:]...)
+				m.ids = append(m.ids[:i:i], m.ids[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (m *MultiSink) Emit(eventName string, optionalData ...interface{}) {
+	m.mutex.RLock()
+	sinks := m.sinks
+	m.mutex.RUnlock()
+	for _, sink := range sinks {
+		sink.Emit(eventName, optionalData...)
+	}
+}
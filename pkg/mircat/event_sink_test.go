@@ -0,0 +1,125 @@
+package mircat
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestEventRecorder(t *testing.T) {
+	rec := NewEventRecorder()
+	rec.Emit("a", "key", 1)
+	rec.Emit("b", "key")
+
+	if events := rec.Events(""); len(events) != 2 {
+		t.Fatalf("recorded %d events, want 2", len(events))
+	}
+	events := rec.Events("a")
+	if len(events) != 1 || events[0].Data[0] != "key" || events[0].Data[1] != 1 {
+		t.Fatalf("events named a: %+v", events)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rec.Emit("a", "later")
+	}()
+	if events, ok := rec.Wait("a", 2, time.Second); !ok || events[1].Data[0] != "later" {
+		t.Fatalf("wait returned %+v, %v", events, ok)
+	}
+	if _, ok := rec.Wait("c", 1, 10*time.Millisecond); ok {
+		t.Fatal("wait returned an event that was never emitted")
+	}
+
+	rec.Reset()
+	if events := rec.Events(""); len(events) != 0 {
+		t.Fatalf("recorded %d events after reset", len(events))
+	}
+}
+
+func TestTaggedSinkKeepsCallerData(t *testing.T) {
+	rec := NewEventRecorder()
+	sink := &taggedSink{sink: rec, tag: "game"}
+	data := make([]interface{}, 1, 4)
+	data[0] = "key"
+	sink.Emit("event", data...)
+	sink.Emit("event", data[:1]...)
+
+	events := rec.Events("event")
+	for _, event := range events {
+		if len(event.Data) != 2 || event.Data[0] != "key" || event.Data[1] != "game" {
+			t.Fatalf("tagged data %v", event.Data)
+		}
+	}
+	if data[:2][1] != nil {
+		t.Fatalf("the tag was written into the array of the caller: %v", data[:2])
+	}
+}
+
+func TestMultiSink(t *testing.T) {
+	first, second := NewEventRecorder(), NewEventRecorder()
+	multi := NewMultiSink(first)
+	detach := multi.Add(second)
+	multi.Emit("both")
+	detach()
+	multi.Emit("first only")
+	detach()
+
+	if len(first.Events("")) != 2 {
+		t.Errorf("first sink got %d events, want 2", len(first.Events("")))
+	}
+	if events := second.Events(""); len(events) != 1 || events[0].Name != "both" {
+		t.Errorf("detached sink got %+v", events)
+	}
+}
+
+func TestJSONLinesSink(t *testing.T) {
+	out := &bytes.Buffer{}
+	sink := NewJSONLinesSink(out)
+	sink.Emit("transfer-src-data", "127.0.0.1:1", []byte("#1!"))
+	sink.Emit("bad", func() {})
+
+	lines := bytes.Split(bytes.TrimSuffix(out.Bytes(), []byte("\n")), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("wrote %q", out.Bytes())
+	}
+	line := EventLine{}
+	if err := json.Unmarshal(lines[0], &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Event != "transfer-src-data" || line.Data[0] != "127.0.0.1:1" || line.Data[1] != "IzEh" {
+		t.Errorf("first line %+v, want the data in base64", line)
+	}
+	// an event that cannot be marshalled is still written, with the error as its data
+	if err := json.Unmarshal(lines[1], &line); err != nil || line.Event != "bad" || len(line.Data) != 1 {
+		t.Errorf("second line %s: %v", lines[1], err)
+	}
+}
+
+func TestConnManagerWithoutWails(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	rec := NewEventRecorder()
+	m := NewConnManager(NewAppWithSink(rec), &Config{Server: ServerConfig{TcpAddr: "127.0.0.1", TcpPort: port}})
+	if !m.ServerTcpStart() {
+		t.Fatalf("server did not start: %+v", rec.Events(""))
+	}
+	defer m.ServerTcpStop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+	events, ok := rec.Wait("server-tcp-data", 1, 2*time.Second)
+	if !ok || string(events[0].Data[1].([]byte)) != "hello" {
+		t.Fatalf("data events %+v", events)
+	}
+}
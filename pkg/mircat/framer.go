@@ -0,0 +1,167 @@
+package mircat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// FRAMER_RAW emits every read as it arrives.
+	FRAMER_RAW = "raw"
+	// FRAMER_LENGTH cuts messages by a length field in their header.
+	FRAMER_LENGTH = "length"
+	// FRAMER_DELIMITER cuts messages after a delimiter, e.g. "!" for Legend of Mir.
+	FRAMER_DELIMITER = "delimiter"
+	// FRAMER_FIXED cuts messages of a fixed size.
+	FRAMER_FIXED = "fixed"
+)
+
+// MAX_FRAME_SIZE is the default limit of a single message.
+const MAX_FRAME_SIZE = 1024 * 1024
+
+// FramerConfig selects how a byte stream is cut into messages.
+type FramerConfig struct {
+	// Type is "raw" (default), "length", "delimiter" or "fixed".
+	Type string `json:"type"`
+	// LengthSize is the size of the length field, 1, 2 or 4 bytes.
+	LengthSize int `json:"lengthSize"`
+	// BigEndian reads the length field as big-endian instead of little-endian.
+	BigEndian bool `json:"bigEndian"`
+	// LengthOffset is the offset of the length field in the header.
+	LengthOffset int `json:"lengthOffset"`
+	// LengthIncludesHeader tells whether the length counts the header up to the end of the length field.
+	LengthIncludesHeader bool `json:"lengthIncludesHeader"`
+	// Delimiter ends every message, it is kept at the end of the message.
+	Delimiter string `json:"delimiter"`
+	// FixedSize is the size of every message.
+	FixedSize int `json:"fixedSize"`
+	// MaxSize is the largest accepted message, 0 means 1 MiB.
+	MaxSize int `json:"maxSize"`
+}
+
+// Framer cuts a byte stream into complete messages.
+type Framer interface {
+	// Feed appends a chunk of the stream and returns the messages completed by it.
+	// When the stream cannot be framed the buffered bytes are returned as a single
+	// message along with an error, so no data is lost.
+	Feed(data []byte) ([][]byte, error)
+	// Flush returns the bytes of an incomplete message, when the stream ends, and forgets them.
+	Flush() []byte
+}
+
+type rawFramer struct{}
+
+func (f *rawFramer) Feed(data []byte) ([][]byte, error) {
+	return [][]byte{append([]byte{}, data...)}, nil
+}
+
+func (f *rawFramer) Flush() []byte {
+	return nil
+}
+
+type streamFramer struct {
+	buffer  []byte
+	maxSize int
+	next    func(buffer []byte) (int, error)
+}
+
+func (f *streamFramer) Feed(data []byte) ([][]byte, error) {
+	f.buffer = append(f.buffer, data...)
+	frames := [][]byte{}
+	for len(f.buffer) > 0 {
+		size, err := f.next(f.buffer)
+		if err == nil && size > f.maxSize {
+			err = fmt.Errorf("message of %d bytes exceeds %d bytes", size, f.maxSize)
+		}
+		if err == nil && size == 0 && len(f.buffer) > f.maxSize {
+			err = fmt.Errorf("no message end within %d bytes", f.maxSize)
+		}
+		if err != nil {
+			frames = append(frames, f.buffer)
+			f.buffer = nil
+			return frames, fmt.Errorf("stream flushed unframed: %v", err)
+		}
+		if size == 0 || size > len(f.buffer) {
+			break
+		}
+		frames = append(frames, append([]byte{}, f.buffer[:size]...))
+		f.buffer = f.buffer[size:]
+	}
+	if len(f.buffer) == 0 {
+		f.buffer = nil
+	}
+	return frames, nil
+}
+
+func (f *streamFramer) Flush() []byte {
+	rest := f.buffer
+	f.buffer = nil
+	return rest
+}
+
+// NewFramer creates a framer for one direction of a connection.
+func NewFramer(cfg FramerConfig) (Framer, error) {
+	maxSize := cfg.MaxSize
+	if maxSize <= 0 {
+		maxSize = MAX_FRAME_SIZE
+	}
+	switch cfg.Type {
+	case "", FRAMER_RAW:
+		return &rawFramer{}, nil
+	case FRAMER_FIXED:
+		if cfg.FixedSize <= 0 {
+			return nil, fmt.Errorf("fixed framer needs a positive size")
+		}
+		return &streamFramer{maxSize: maxSize, next: func(buffer []byte) (int, error) {
+			return cfg.FixedSize, nil
+		}}, nil
+	case FRAMER_DELIMITER:
+		if cfg.Delimiter == "" {
+			return nil, fmt.Errorf("delimiter framer needs a delimiter")
+		}
+		delimiter := []byte(cfg.Delimiter)
+		return &streamFramer{maxSize: maxSize, next: func(buffer []byte) (int, error) {
+			i := bytes.Index(buffer, delimiter)
+			if i < 0 {
+				return 0, nil
+			}
+			return i + len(delimiter), nil
+		}}, nil
+	case FRAMER_LENGTH:
+		if cfg.LengthSize != 1 && cfg.LengthSize != 2 && cfg.LengthSize != 4 {
+			return nil, fmt.Errorf("length framer needs a length size of 1, 2 or 4 bytes")
+		}
+		if cfg.LengthOffset < 0 {
+			return nil, fmt.Errorf("length framer needs a non-negative offset")
+		}
+		var order binary.ByteOrder = binary.LittleEndian
+		if cfg.BigEndian {
+			order = binary.BigEndian
+		}
+		headerSize := cfg.LengthOffset + cfg.LengthSize
+		return &streamFramer{maxSize: maxSize, next: func(buffer []byte) (int, error) {
+			if len(buffer) < headerSize {
+				return 0, nil
+			}
+			field := buffer[cfg.LengthOffset:headerSize]
+			length := 0
+			switch cfg.LengthSize {
+			case 1:
+				length = int(field[0])
+			case 2:
+				length = int(order.Uint16(field))
+			case 4:
+				length = int(order.Uint32(field))
+			}
+			if cfg.LengthIncludesHeader {
+				if length < headerSize {
+					return 0, fmt.Errorf("length %d is shorter than the header", length)
+				}
+				return length, nil
+			}
+			return headerSize + length, nil
+		}}, nil
+	}
+	return nil, fmt.Errorf("unknown framer %s", cfg.Type)
+}
@@ -0,0 +1,95 @@
+package mircat
+
+import (
+	"strings"
+	"testing"
+)
+
+// feed feeds the chunks to a new framer and returns the messages of each chunk joined by "|", and what Flush left.
+func feed(t *testing.T, cfg FramerConfig, chunks ...string) ([]string, string) {
+	t.Helper()
+	framer, err := NewFramer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, chunk := range chunks {
+		messages, err := framer.Feed([]byte(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		joined := []string{}
+		for _, message := range messages {
+			joined = append(joined, string(message))
+		}
+		got = append(got, strings.Join(joined, "|"))
+	}
+	rest := string(framer.Flush())
+	if again := framer.Flush(); len(again) != 0 {
+		t.Fatalf("second flush gave %q", again)
+	}
+	return got, rest
+}
+
+func TestFramers(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    FramerConfig
+		chunks []string
+		want   []string
+		rest   string
+	}{
+		{"raw", FramerConfig{}, []string{"ab", "c"}, []string{"ab", "c"}, ""},
+		{"fixed", FramerConfig{Type: FRAMER_FIXED, FixedSize: 2}, []string{"abc", "de"}, []string{"ab", "cd"}, "e"},
+		{"mir delimiter", FramerConfig{Type: FRAMER_DELIMITER, Delimiter: "!"}, []string{"#1a!#2b", "!#3c"}, []string{"#1a!", "#2b!"}, "#3c"},
+		{"delimiter across reads", FramerConfig{Type: FRAMER_DELIMITER, Delimiter: "\r\n"}, []string{"a\r", "\nb\r\n"}, []string{"", "a\r\n|b\r\n"}, ""},
+		{"1 byte length", FramerConfig{Type: FRAMER_LENGTH, LengthSize: 1}, []string{"\x02ab\x01", "c\x03"}, []string{"\x02ab", "\x01c"}, "\x03"},
+		{"big-endian length", FramerConfig{Type: FRAMER_LENGTH, LengthSize: 2, BigEndian: true}, []string{"\x00\x01a\x00"}, []string{"\x00\x01a"}, "\x00"},
+		{"4 byte length", FramerConfig{Type: FRAMER_LENGTH, LengthSize: 4}, []string{"\x01\x00\x00\x00a"}, []string{"\x01\x00\x00\x00a"}, ""},
+		{"length counting the header", FramerConfig{Type: FRAMER_LENGTH, LengthSize: 1, LengthOffset: 1, LengthIncludesHeader: true},
+			[]string{"x\x03yx\x02"}, []string{"x\x03y|x\x02"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rest := feed(t, tt.cfg, tt.chunks...)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("messages %q, want %q", got, tt.want)
+			}
+			if rest != tt.rest {
+				t.Errorf("flush gave %q, want %q", rest, tt.rest)
+			}
+		})
+	}
+}
+
+func TestFramerFlushesUnframedStreams(t *testing.T) {
+	// a stream that cannot be framed comes out whole with an error, nothing is lost
+	for cfg, data := range map[FramerConfig]string{
+		{Type: FRAMER_LENGTH, LengthSize: 1, MaxSize: 4}:                 "\x09abc",
+		{Type: FRAMER_DELIMITER, Delimiter: "!", MaxSize: 4}:             "abcdef",
+		{Type: FRAMER_LENGTH, LengthSize: 1, LengthIncludesHeader: true}: "\x00abc",
+	} {
+		framer, _ := NewFramer(cfg)
+		messages, err := framer.Feed([]byte(data))
+		if err == nil || len(messages) != 1 || string(messages[0]) != data {
+			t.Errorf("%+v gave %q, %v", cfg, messages, err)
+		}
+		if rest := framer.Flush(); len(rest) != 0 {
+			t.Errorf("%+v kept %q", cfg, rest)
+		}
+	}
+}
+
+func TestNewFramerRejectsIncompleteSettings(t *testing.T) {
+	for _, cfg := range []FramerConfig{
+		{Type: "lines"},
+		{Type: FRAMER_FIXED},
+		{Type: FRAMER_DELIMITER},
+		{Type: FRAMER_LENGTH, LengthSize: 3},
+		{Type: FRAMER_LENGTH, LengthSize: 2, LengthOffset: -1},
+	} {
+		if _, err := NewFramer(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
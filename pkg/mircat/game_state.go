@@ -0,0 +1,406 @@
+package mircat
+
+import (
+	"mir-cat/pkg/codec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CHAT_LOG_SIZE is the number of chat lines kept per session.
+const CHAT_LOG_SIZE = 200
+
+// GAME_SESSION_EXPIRY is how long the model of a closed session is kept.
+const GAME_SESSION_EXPIRY = 10 * time.Minute
+
+// PlayerState is what the traffic tells about the player of a session.
+type PlayerState struct {
+	Id        int32          `json:"id"`
+	Character string         `json:"character"`
+	Map       string         `json:"map"`
+	MapTitle  string         `json:"mapTitle"`
+	X         int            `json:"x"`
+	Y         int            `json:"y"`
+	Direction int            `json:"direction"`
+	HP        int            `json:"hp"`
+	MP        int            `json:"mp"`
+	MaxHP     int            `json:"maxHp"`
+	MaxMP     int            `json:"maxMp"`
+	Level     int            `json:"level"`
+	Exp       int            `json:"exp"`
+	Gold      int            `json:"gold"`
+	Ability   *codec.Ability `json:"ability"`
+}
+
+// GameObject is a character, monster or NPC seen by the player.
+type GameObject struct {
+	Id        int32     `json:"id"`
+	Name      string    `json:"name"`
+	X         int       `json:"x"`
+	Y         int       `json:"y"`
+	Direction int       `json:"direction"`
+	Race      int       `json:"race"`
+	Feature   int32     `json:"feature"`
+	Status    int32     `json:"status"`
+	HP        int       `json:"hp"`
+	MaxHP     int       `json:"maxHp"`
+	Dead      bool      `json:"dead"`
+	Seen      time.Time `json:"seen"`
+}
+
+// ChatLine is a chat message, Kind being the name of the message carrying it such as SM_HEAR or CM_SAY.
+type ChatLine struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Actor   int32     `json:"actor"`
+	Message string    `json:"message"`
+}
+
+// GameState is a snapshot of the game as reconstructed from the traffic of a transfer session.
+type GameState struct {
+	Session   string             `json:"session"`
+	Player    PlayerState        `json:"player"`
+	Objects   []GameObject       `json:"objects"`
+	Inventory []codec.ClientItem `json:"inventory"`
+	Chat      []ChatLine         `json:"chat"`
+	Updated   time.Time          `json:"updated"`
+	// Closed tells that the connection of the session ended, the model is forgotten GAME_SESSION_EXPIRY later.
+	Closed bool `json:"closed"`
+}
+
+type gameSession struct {
+	state    GameState
+	objects  map[int32]*GameObject
+	closedAt time.Time
+}
+
+type gameChange struct {
+	kind string
+	data interface{}
+}
+
+// GameTracker follows the decoded Mir packets of the transfer sessions and keeps a model of the game
+// of each one. It relies on the message names and field names of the built-in catalog, a catalog of a
+// fork has to keep them for the messages it renumbers. Every change is emitted as a "game-state" event
+// carrying the session, the kind of change and the changed data.
+type GameTracker struct {
+	mutex    sync.Mutex
+	sessions map[string]*gameSession
+	events   EventSink
+}
+
+func NewGameTracker(events EventSink) *GameTracker {
+	return &GameTracker{
+		sessions: make(map[string]*gameSession),
+		events:   events,
+	}
+}
+
+// Feed applies the packets of a session, in either direction.
+func (t *GameTracker) Feed(session string, packets []*codec.Packet) {
+	changes := []gameChange{}
+	t.mutex.Lock()
+	for _, p := range packets {
+		if p.Name == "" {
+			continue
+		}
+		s, ok := t.sessions[session]
+		if ok && s.state.Closed {
+			// the last packets of an ended connection, flushed after it closed
+			continue
+		}
+		if !ok {
+			s = &gameSession{state: GameState{Session: session}, objects: make(map[int32]*GameObject)}
+			t.sessions[session] = s
+		}
+		applied := s.apply(p)
+		if len(applied) > 0 {
+			s.state.Updated = time.Now()
+			changes = append(changes, applied...)
+		}
+	}
+	t.mutex.Unlock()
+	for _, change := range changes {
+		t.events.Emit("game-state", session, change.kind, change.data)
+	}
+}
+
+// Close marks the connection of a session as ended, its model is kept for GAME_SESSION_EXPIRY.
+func (t *GameTracker) Close(session string) {
+	t.mutex.Lock()
+	s, ok := t.sessions[session]
+	if ok {
+		s.state.Closed = true
+		s.closedAt = time.Now()
+	}
+	t.expire()
+	t.mutex.Unlock()
+	if ok {
+		t.events.Emit("game-state", session, "closed", nil)
+	}
+}
+
+// expire forgets the sessions closed for longer than GAME_SESSION_EXPIRY, the caller holds the mutex.
+func (t *GameTracker) expire() {
+	for session, s := range t.sessions {
+		if s.state.Closed && time.Since(s.closedAt) > GAME_SESSION_EXPIRY {
+			delete(t.sessions, session)
+		}
+	}
+}
+
+// Sessions lists the sessions the tracker has a model for.
+func (t *GameTracker) Sessions() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.expire()
+	sessions := []string{}
+	for session := range t.sessions {
+		sessions = append(sessions, session)
+	}
+	sort.Strings(sessions)
+	return sessions
+}
+
+// Snapshot returns a copy of the model of a session, false if the session is unknown.
+func (t *GameTracker) Snapshot(session string) (GameState, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	s, ok := t.sessions[session]
+	if !ok {
+		return GameState{}, false
+	}
+	state := s.state
+	state.Objects = []GameObject{}
+	for _, object := range s.objects {
+		state.Objects = append(state.Objects, *object)
+	}
+	sort.Slice(state.Objects, func(i, j int) bool { return state.Objects[i].Id < state.Objects[j].Id })
+	state.Inventory = append([]codec.ClientItem{}, s.state.Inventory...)
+	state.Chat = append([]ChatLine{}, s.state.Chat...)
+	if s.state.Player.Ability != nil {
+		ability := *s.state.Player.Ability
+		state.Player.Ability = &ability
+	}
+	return state, true
+}
+
+// Reset forgets a session, or every session when session is empty.
+func (t *GameTracker) Reset(session string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if session == "" {
+		t.sessions = make(map[string]*gameSession)
+		return
+	}
+	delete(t.sessions, session)
+}
+
+func (s *gameSession) apply(p *codec.Packet) []gameChange {
+	player := &s.state.Player
+	fields := p.Fields
+	switch p.Name {
+	case "CM_SELCHR":
+		player.Character = stringField(fields, "name")
+		return s.playerChanged()
+	case "CM_TURN", "CM_WALK", "CM_RUN":
+		// the client moves first and reports its new position
+		player.X, _ = intField(fields, "x")
+		player.Y, _ = intField(fields, "y")
+		player.Direction, _ = intField(fields, "direction")
+		return s.playerChanged()
+	case "CM_SAY":
+		return s.chat(p.Name, player.Id, stringField(fields, "message"))
+	case "SM_LOGON":
+		id, _ := intField(fields, "actor")
+		player.Id = int32(id)
+		player.X, _ = intField(fields, "x")
+		player.Y, _ = intField(fields, "y")
+		player.Direction, _ = intField(fields, "direction")
+		return s.playerChanged()
+	case "SM_NEWMAP", "SM_CHANGEMAP":
+		if id, ok := intField(fields, "actor"); ok && id != 0 {
+			player.Id = int32(id)
+		}
+		player.Map = stringField(fields, "map")
+		player.X, _ = intField(fields, "x")
+		player.Y, _ = intField(fields, "y")
+		s.objects = make(map[int32]*GameObject)
+		return append(s.playerChanged(), gameChange{"objects-cleared", nil})
+	case "SM_MAPDESCRIPTION":
+		player.MapTitle = stringField(fields, "title")
+		return s.playerChanged()
+	case "SM_CLEAROBJECTS":
+		s.objects = make(map[int32]*GameObject)
+		return []gameChange{{"objects-cleared", nil}}
+	case "SM_TURN", "SM_WALK", "SM_RUN", "SM_SITDOWN", "SM_HIT", "SM_HEAVYHIT", "SM_BIGHIT", "SM_SPELL",
+		"SM_POWERHIT", "SM_LONGHIT", "SM_DIGUP", "SM_DIGDOWN", "SM_FLYAXE", "SM_LIGHTING", "SM_WIDEHIT":
+		return s.actorMoved(fields, false)
+	case "SM_DEATH", "SM_NOWDEATH", "SM_SKELETON":
+		return s.actorMoved(fields, true)
+	case "SM_DISAPPEAR":
+		id, _ := intField(fields, "actor")
+		object, ok := s.objects[int32(id)]
+		if !ok {
+			return nil
+		}
+		delete(s.objects, int32(id))
+		return []gameChange{{"object-removed", *object}}
+	case "SM_USERNAME":
+		id, _ := intField(fields, "actor")
+		if int32(id) == player.Id {
+			return nil
+		}
+		object := s.object(int32(id))
+		object.Name = stringField(fields, "name")
+		return []gameChange{{"object", *object}}
+	case "SM_HEALTHSPELLCHANGED", "SM_STRUCK":
+		id, _ := intField(fields, "actor")
+		hp, _ := intField(fields, "hp")
+		maxHp, ok := intField(fields, "maxHp")
+		if int32(id) == player.Id {
+			player.HP = hp
+			if ok {
+				player.MaxHP = maxHp
+			}
+			if mp, ok := intField(fields, "mp"); ok {
+				player.MP = mp
+			}
+			return s.playerChanged()
+		}
+		object := s.object(int32(id))
+		object.HP = hp
+		if ok {
+			object.MaxHP = maxHp
+		}
+		return []gameChange{{"object", *object}}
+	case "SM_ABILITY":
+		player.Gold, _ = intField(fields, "gold")
+		if ability, ok := fields["ability"].(codec.Ability); ok {
+			player.Ability = &ability
+			player.Level = int(ability.Level)
+			player.HP, player.MP = int(ability.HP), int(ability.MP)
+			player.MaxHP, player.MaxMP = int(ability.MaxHP), int(ability.MaxMP)
+			player.Exp = int(ability.Exp)
+		}
+		return s.playerChanged()
+	case "SM_LEVELUP":
+		player.Exp, _ = intField(fields, "exp")
+		player.Level, _ = intField(fields, "level")
+		return s.playerChanged()
+	case "SM_WINEXP":
+		player.Exp, _ = intField(fields, "exp")
+		return s.playerChanged()
+	case "SM_BAGITEMS":
+		s.state.Inventory = []codec.ClientItem{}
+		records, _ := fields["items"].([]map[string]interface{})
+		for _, record := range records {
+			if item, ok := record["item"].(codec.ClientItem); ok {
+				s.state.Inventory = append(s.state.Inventory, item)
+			}
+		}
+		return s.inventoryChanged()
+	case "SM_ADDITEM", "SM_UPDATEITEM", "SM_DELITEM":
+		item, ok := fields["item"].(codec.ClientItem)
+		if !ok {
+			return nil
+		}
+		inventory := []codec.ClientItem{}
+		for _, other := range s.state.Inventory {
+			if other.MakeIndex != item.MakeIndex {
+				inventory = append(inventory, other)
+			}
+		}
+		if p.Name != "SM_DELITEM" {
+			inventory = append(inventory, item)
+		}
+		s.state.Inventory = inventory
+		return s.inventoryChanged()
+	case "SM_HEAR", "SM_SYSMESSAGE", "SM_CRY", "SM_WHISPER", "SM_GROUPMESSAGE", "SM_GUILDMESSAGE":
+		id, _ := intField(fields, "actor")
+		return s.chat(p.Name, int32(id), stringField(fields, "message"))
+	}
+	return nil
+}
+
+// actorMoved applies the action messages carrying the position of an actor.
+func (s *gameSession) actorMoved(fields map[string]interface{}, dead bool) []gameChange {
+	id, _ := intField(fields, "actor")
+	x, _ := intField(fields, "x")
+	y, _ := intField(fields, "y")
+	direction, _ := intField(fields, "direction")
+	if player := &s.state.Player; player.Id != 0 && int32(id) == player.Id {
+		player.X, player.Y, player.Direction = x, y, direction
+		return s.playerChanged()
+	}
+	object := s.object(int32(id))
+	object.X, object.Y, object.Direction = x, y, direction
+	object.Dead = dead
+	if desc, ok := fields["desc"].(codec.CharDesc); ok {
+		object.Feature, object.Status, object.Race = desc.Feature, desc.Status, int(desc.Race)
+	}
+	// the name is followed by its color
+	if name := stringField(fields, "name"); name != "" {
+		object.Name = strings.SplitN(name, "/", 2)[0]
+	}
+	return []gameChange{{"object", *object}}
+}
+
+func (s *gameSession) object(id int32) *GameObject {
+	object, ok := s.objects[id]
+	if !ok {
+		object = &GameObject{Id: id}
+		s.objects[id] = object
+	}
+	object.Seen = time.Now()
+	return object
+}
+
+func (s *gameSession) playerChanged() []gameChange {
+	player := s.state.Player
+	if player.Ability != nil {
+		ability := *player.Ability
+		player.Ability = &ability
+	}
+	return []gameChange{{"player", player}}
+}
+
+func (s *gameSession) inventoryChanged() []gameChange {
+	return []gameChange{{"inventory", append([]codec.ClientItem{}, s.state.Inventory...)}}
+}
+
+func (s *gameSession) chat(kind string, actor int32, message string) []gameChange {
+	line := ChatLine{Time: time.Now(), Kind: kind, Actor: actor, Message: message}
+	s.state.Chat = append(s.state.Chat, line)
+	if len(s.state.Chat) > CHAT_LOG_SIZE {
+		s.state.Chat = append([]ChatLine{}, s.state.Chat[len(s.state.Chat)-CHAT_LOG_SIZE:]...)
+	}
+	return []gameChange{{"chat", line}}
+}
+
+// intField returns a numeric field of a packet whatever its integer type.
+func intField(fields map[string]interface{}, name string) (int, bool) {
+	switch v := fields[name].(type) {
+	case int:
+		return v, true
+	case int8:
+		return int(v), true
+	case uint8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	}
+	return 0, false
+}
+
+func stringField(fields map[string]interface{}, name string) string {
+	value, _ := fields[name].(string)
+	return value
+}
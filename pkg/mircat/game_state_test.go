@@ -0,0 +1,156 @@
+package mircat
+
+import (
+	"fmt"
+	"mir-cat/pkg/codec"
+	"testing"
+	"time"
+)
+
+func packet(name string, fields map[string]interface{}) *codec.Packet {
+	return &codec.Packet{Name: name, Fields: fields}
+}
+
+func TestGameTrackerPlayer(t *testing.T) {
+	rec := NewEventRecorder()
+	tracker := NewGameTracker(rec)
+	tracker.Feed("a", []*codec.Packet{
+		packet("CM_SELCHR", map[string]interface{}{"name": "hero"}),
+		packet("SM_LOGON", map[string]interface{}{"actor": int32(7), "x": uint16(10), "y": uint16(20), "direction": uint8(2)}),
+		packet("SM_WALK", map[string]interface{}{"actor": int32(9), "x": uint16(11), "y": uint16(21)}),
+		packet("SM_NEWMAP", map[string]interface{}{"actor": int32(0), "map": "0", "x": uint16(300), "y": uint16(301)}),
+		packet("SM_MAPDESCRIPTION", map[string]interface{}{"title": "Bichon"}),
+		packet("CM_WALK", map[string]interface{}{"x": uint16(301), "y": uint16(301), "direction": uint8(3)}),
+		// the server echoing the player's own actions moves the player, not an object
+		packet("SM_RUN", map[string]interface{}{"actor": int32(7), "x": uint16(303), "y": uint16(301), "direction": uint8(2)}),
+		packet("SM_ABILITY", map[string]interface{}{"gold": int32(1000), "ability": codec.Ability{Level: 12, HP: 80, MaxHP: 100, MP: 5, MaxMP: 30, Exp: 555}}),
+		packet("SM_HEALTHSPELLCHANGED", map[string]interface{}{"actor": int32(7), "hp": uint16(70), "mp": uint16(4), "maxHp": uint16(110)}),
+		packet("SM_WINEXP", map[string]interface{}{"exp": uint32(600)}),
+		// packets the catalog does not know are skipped
+		{Message: &codec.DefaultMessage{Ident: 9999}},
+	})
+
+	state, ok := tracker.Snapshot("a")
+	if !ok {
+		t.Fatal("no session")
+	}
+	want := PlayerState{Id: 7, Character: "hero", Map: "0", MapTitle: "Bichon", X: 303, Y: 301, Direction: 2,
+		HP: 70, MP: 4, MaxHP: 110, MaxMP: 30, Level: 12, Exp: 600, Gold: 1000}
+	got := state.Player
+	got.Ability = nil
+	if got != want || state.Player.Ability == nil || state.Player.Ability.Level != 12 {
+		t.Fatalf("player %+v", state.Player)
+	}
+	// the object seen before the map change is gone
+	if len(state.Objects) != 0 {
+		t.Fatalf("objects %+v", state.Objects)
+	}
+	events := rec.Events("game-state")
+	if len(events) != 11 || events[0].Data[0] != "a" || events[0].Data[1] != "player" || events[4].Data[1] != "objects-cleared" {
+		t.Fatalf("events %+v", events)
+	}
+
+	// the snapshot is a copy
+	state.Player.Ability.Level = 99
+	if again, _ := tracker.Snapshot("a"); again.Player.Ability.Level != 12 {
+		t.Fatal("the snapshot shares the ability")
+	}
+}
+
+func TestGameTrackerObjects(t *testing.T) {
+	tracker := NewGameTracker(NewEventRecorder())
+	tracker.Feed("a", []*codec.Packet{
+		packet("SM_LOGON", map[string]interface{}{"actor": int32(1)}),
+		packet("SM_TURN", map[string]interface{}{"actor": int32(5), "x": uint16(3), "y": uint16(4), "direction": uint8(6),
+			"desc": codec.CharDesc{Feature: 0x0301, Status: 2, Race: 1}, "name": "Oma/255"}),
+		packet("SM_WALK", map[string]interface{}{"actor": int32(6), "x": uint16(8), "y": uint16(9)}),
+		packet("SM_USERNAME", map[string]interface{}{"actor": int32(6), "name": "bob"}),
+		packet("SM_STRUCK", map[string]interface{}{"actor": int32(6), "hp": uint16(5), "maxHp": uint16(50)}),
+		packet("SM_DEATH", map[string]interface{}{"actor": int32(5), "x": uint16(3), "y": uint16(5)}),
+		packet("SM_WALK", map[string]interface{}{"actor": int32(8)}),
+		packet("SM_DISAPPEAR", map[string]interface{}{"actor": int32(8)}),
+		packet("SM_DISAPPEAR", map[string]interface{}{"actor": int32(99)}),
+	})
+	state, _ := tracker.Snapshot("a")
+	if len(state.Objects) != 2 {
+		t.Fatalf("objects %+v", state.Objects)
+	}
+	oma, bob := state.Objects[0], state.Objects[1]
+	if oma.Id != 5 || oma.Name != "Oma" || !oma.Dead || oma.X != 3 || oma.Y != 5 || oma.Race != 1 || oma.Feature != 0x0301 || oma.Status != 2 {
+		t.Fatalf("object %+v", oma)
+	}
+	if bob.Id != 6 || bob.Name != "bob" || bob.Dead || bob.X != 8 || bob.HP != 5 || bob.MaxHP != 50 {
+		t.Fatalf("object %+v", bob)
+	}
+
+	tracker.Feed("a", []*codec.Packet{packet("SM_CLEAROBJECTS", nil)})
+	if state, _ := tracker.Snapshot("a"); len(state.Objects) != 0 {
+		t.Fatalf("objects %+v", state.Objects)
+	}
+}
+
+func TestGameTrackerInventory(t *testing.T) {
+	item := func(index int32, name string, dura uint16) codec.ClientItem {
+		return codec.ClientItem{Item: codec.StdItem{Name: name}, MakeIndex: index, Dura: dura}
+	}
+	tracker := NewGameTracker(NewEventRecorder())
+	tracker.Feed("a", []*codec.Packet{
+		packet("SM_BAGITEMS", map[string]interface{}{"items": []map[string]interface{}{
+			{"item": item(1, "sword", 10)}, {"item": item(2, "potion", 1)},
+		}}),
+		packet("SM_ADDITEM", map[string]interface{}{"item": item(3, "ring", 5)}),
+		packet("SM_UPDATEITEM", map[string]interface{}{"item": item(1, "sword", 9)}),
+		packet("SM_DELITEM", map[string]interface{}{"item": item(2, "potion", 1)}),
+		packet("SM_ADDITEM", map[string]interface{}{}),
+	})
+	state, _ := tracker.Snapshot("a")
+	if len(state.Inventory) != 2 || state.Inventory[0].MakeIndex != 3 || state.Inventory[1].Dura != 9 {
+		t.Fatalf("inventory %+v", state.Inventory)
+	}
+}
+
+func TestGameTrackerChat(t *testing.T) {
+	tracker := NewGameTracker(NewEventRecorder())
+	packets := []*codec.Packet{}
+	for i := 0; i < CHAT_LOG_SIZE+5; i++ {
+		packets = append(packets, packet("SM_HEAR", map[string]interface{}{"actor": int32(2), "message": fmt.Sprint(i)}))
+	}
+	packets = append(packets, packet("CM_SAY", map[string]interface{}{"message": "mine"}))
+	tracker.Feed("a", packets)
+	state, _ := tracker.Snapshot("a")
+	if len(state.Chat) != CHAT_LOG_SIZE || state.Chat[0].Message != "6" || state.Chat[0].Kind != "SM_HEAR" || state.Chat[0].Actor != 2 {
+		t.Fatalf("first line %+v of %d", state.Chat[0], len(state.Chat))
+	}
+	if last := state.Chat[CHAT_LOG_SIZE-1]; last.Kind != "CM_SAY" || last.Message != "mine" {
+		t.Fatalf("last line %+v", last)
+	}
+}
+
+func TestGameTrackerClose(t *testing.T) {
+	rec := NewEventRecorder()
+	tracker := NewGameTracker(rec)
+	tracker.Feed("a", []*codec.Packet{packet("SM_WINEXP", map[string]interface{}{"exp": uint32(1)})})
+	tracker.Feed("b", []*codec.Packet{packet("SM_WINEXP", map[string]interface{}{"exp": uint32(2)})})
+	tracker.Close("a")
+	tracker.Close("unknown")
+	if closed := rec.Events("game-state"); closed[len(closed)-1].Data[0] != "a" || closed[len(closed)-1].Data[1] != "closed" {
+		t.Fatalf("events %+v", closed)
+	}
+
+	// packets flushed after the close are ignored
+	tracker.Feed("a", []*codec.Packet{packet("SM_WINEXP", map[string]interface{}{"exp": uint32(3)})})
+	if state, _ := tracker.Snapshot("a"); !state.Closed || state.Player.Exp != 1 {
+		t.Fatalf("state %+v", state)
+	}
+
+	tracker.mutex.Lock()
+	tracker.sessions["a"].closedAt = time.Now().Add(-GAME_SESSION_EXPIRY - time.Second)
+	tracker.mutex.Unlock()
+	if sessions := tracker.Sessions(); len(sessions) != 1 || sessions[0] != "b" {
+		t.Fatalf("sessions %q", sessions)
+	}
+	tracker.Reset("")
+	if _, ok := tracker.Snapshot("b"); ok {
+		t.Fatal("reset kept a session")
+	}
+}
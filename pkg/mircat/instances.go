@@ -0,0 +1,669 @@
+package mircat
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// serverInstance returns the named TCP server, creating it on first use. Its events carry the instance ID as last argument.
+func (c *ConnManager) serverInstance(id string) *TCPServer {
+	c.instances.Lock()
+	defer c.instances.Unlock()
+	server, ok := c.servers[id]
+	if !ok {
+		server = NewTCPServer(c.capture, &taggedSink{sink: c.app, tag: id})
+		c.servers[id] = server
+	}
+	return server
+}
+
+// transferInstance returns the named transfer, creating it on first use. Its events carry the instance ID as last argument.
+func (c *ConnManager) transferInstance(id string) *TCPTransfer {
+	c.instances.Lock()
+	defer c.instances.Unlock()
+	transfer, ok := c.transfers[id]
+	if !ok {
+		transfer = NewTCPTransfer(c.capture, &taggedSink{sink: c.app, tag: id})
+		transfer.SetTracker(c.tracker)
+		c.transfers[id] = transfer
+	}
+	return transfer
+}
+
+// runningServer returns the named TCP server if it was started once, or nil.
+func (c *ConnManager) runningServer(id string) *TCPServer {
+	c.instances.Lock()
+	defer c.instances.Unlock()
+	return c.servers[id]
+}
+
+// runningTransfer returns the named transfer if it was started once, or nil.
+func (c *ConnManager) runningTransfer(id string) *TCPTransfer {
+	c.instances.Lock()
+	defer c.instances.Unlock()
+	return c.transfers[id]
+}
+
+// ServerInstances lists the IDs of the TCP servers configured in the Servers section.
+// Returns:
+// - []string: the instance IDs, sorted.
+func (c *ConnManager) ServerInstances() []string {
+	ids := []string{}
+	for id := range c.cfg.Servers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// ServerTcpStartInstance starts the TCP server configured under id in the Servers section, like ServerTcpStart.
+// Every event of the instance carries its ID as an additional last argument.
+// It emits a "server-tcp-error" event and returns false if the instance is not configured or fails to start.
+//
+// Parameters:
+// - id: the instance ID.
+func (c *ConnManager) ServerTcpStartInstance(id string) bool {
+	cfg, ok := c.cfg.Servers[id]
+	if !ok {
+		c.app.EventsEmit("server-tcp-error", "server", fmt.Sprintf("server instance %s not configured", id), id)
+		return false
+	}
+	return c.startServer(c.serverInstance(id), cfg, &taggedSink{sink: c.app, tag: id})
+}
+
+// ServerTcpStopInstance stops the TCP server instance id.
+// It returns false if the instance was never started.
+//
+// Parameters:
+// - id: the instance ID.
+func (c *ConnManager) ServerTcpStopInstance(id string) bool {
+	server := c.runningServer(id)
+	if server == nil {
+		return false
+	}
+	return c.stopServer(server, &taggedSink{sink: c.app, tag: id})
+}
+
+// ServerSendMessageInstance sends a message to a client of the TCP server instance id, like ServerSendMessage.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the identifier of the target client.
+// - base64Data: the message data encoded in base64 format.
+func (c *ConnManager) ServerSendMessageInstance(id string, client string, base64Data string) {
+	c.serverSendMessage(c.runningServer(id), &taggedSink{sink: c.app, tag: id}, client, base64Data)
+}
+
+// ServerBroadcastMessageInstance broadcasts a message to all clients of the TCP server instance id, like ServerBroadcastMessage.
+//
+// Parameters:
+// - id: the instance ID.
+// - base64Data: the message data encoded in base64 format.
+func (c *ConnManager) ServerBroadcastMessageInstance(id string, base64Data string) {
+	c.serverBroadcastMessage(c.runningServer(id), &taggedSink{sink: c.app, tag: id}, base64Data)
+}
+
+// TransferInstances lists the IDs of the transfers configured in the Transfers section.
+// Returns:
+// - []string: the instance IDs, sorted.
+func (c *ConnManager) TransferInstances() []string {
+	ids := []string{}
+	for id := range c.cfg.Transfers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// TransferTcpStartInstance starts the transfer configured under id in the Transfers section, like TransferTcpStart.
+// Every event of the instance carries its ID as an additional last argument.
+// It emits a "transfer-tcp-error" event and returns false if the instance is not configured or fails to start.
+//
+// Parameters:
+// - id: the instance ID.
+func (c *ConnManager) TransferTcpStartInstance(id string) bool {
+	cfg, ok := c.cfg.Transfers[id]
+	if !ok {
+		c.app.EventsEmit("transfer-tcp-error", "server", fmt.Sprintf("transfer instance %s not configured", id), id)
+		return false
+	}
+	return c.startTransfer(c.transferInstance(id), cfg, &taggedSink{sink: c.app, tag: id})
+}
+
+// TransferTcpStopInstance stops the transfer instance id.
+// It returns false if the instance was never started.
+//
+// Parameters:
+// - id: the instance ID.
+func (c *ConnManager) TransferTcpStopInstance(id string) bool {
+	transfer := c.runningTransfer(id)
+	if transfer == nil {
+		return false
+	}
+	return c.stopTransfer(transfer, &taggedSink{sink: c.app, tag: id})
+}
+
+// TransferSendToServerInstance sends data to the server of a client session of the transfer instance id, like TransferSendToServer.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client.
+// - base64Data: the data encoded in base64 format.
+func (c *ConnManager) TransferSendToServerInstance(id string, client string, base64Data string) {
+	c.transferSendToServer(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, client, base64Data)
+}
+
+// TransferSendToClientInstance sends data to a client of the transfer instance id, like TransferSendToClient.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client.
+// - base64Data: the data encoded in base64 format.
+func (c *ConnManager) TransferSendToClientInstance(id string, client string, base64Data string) {
+	c.transferSendToClient(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, client, base64Data)
+}
+
+// TransferBroadcastToServerInstance sends data to the server of every session of the transfer instance id.
+//
+// Parameters:
+// - id: the instance ID.
+// - base64Data: the data encoded in base64 format.
+func (c *ConnManager) TransferBroadcastToServerInstance(id string, base64Data string) {
+	c.transferBroadcastToServer(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, base64Data)
+}
+
+// TransferBroadcastToClientInstance sends data to every client of the transfer instance id.
+//
+// Parameters:
+// - id: the instance ID.
+// - base64Data: the data encoded in base64 format.
+func (c *ConnManager) TransferBroadcastToClientInstance(id string, base64Data string) {
+	c.transferBroadcastToClient(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, base64Data)
+}
+
+// TransferSetForwardModeInstance switches how a session of the transfer instance id relays its traffic,
+// like TransferSetForwardMode. The hops spawned by redirects are the instances "redirect <ip:port>".
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client, or an empty string to change the mode of sessions accepted afterwards.
+// - mode: "auto" or "manual".
+func (c *ConnManager) TransferSetForwardModeInstance(id string, client string, mode string) bool {
+	return c.transferSetForwardMode(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, client, mode)
+}
+
+// TransferGetForwardModeInstance returns the forward mode of a session of the transfer instance id, like TransferGetForwardMode.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client, or an empty string for the default mode of new sessions.
+func (c *ConnManager) TransferGetForwardModeInstance(id string, client string) string {
+	return c.transferGetForwardMode(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, client)
+}
+
+// TransferInterceptSetInstance changes the intercept settings of a session of the transfer instance id, like TransferInterceptSet.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client, or an empty string for the settings of sessions without their own.
+// - settings: the directions to intercept and the match rules.
+func (c *ConnManager) TransferInterceptSetInstance(id string, client string, settings InterceptSettings) bool {
+	return c.transferInterceptSet(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, client, settings)
+}
+
+// TransferInterceptGetInstance returns the intercept settings of a session of the transfer instance id, like TransferInterceptGet.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client, or an empty string for the settings of sessions without their own.
+func (c *ConnManager) TransferInterceptGetInstance(id string, client string) InterceptSettings {
+	transfer := c.runningTransfer(id)
+	if transfer == nil {
+		return InterceptSettings{}
+	}
+	return transfer.Intercept(client)
+}
+
+// TransferInterceptListInstance returns the packets held by the transfer instance id, like TransferInterceptList.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client, or an empty string for every session.
+func (c *ConnManager) TransferInterceptListInstance(id string, client string) []InterceptedPacket {
+	transfer := c.runningTransfer(id)
+	if transfer == nil {
+		return []InterceptedPacket{}
+	}
+	return transfer.InterceptList(client)
+}
+
+// TransferInterceptForwardInstance forwards a packet held by the transfer instance id unchanged, like TransferInterceptForward.
+//
+// Parameters:
+// - id: the instance ID.
+// - packet: the ID of the held packet.
+func (c *ConnManager) TransferInterceptForwardInstance(id string, packet int) bool {
+	return c.transferInterceptResolve(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, packet, INTERCEPT_ACTION_FORWARD, "")
+}
+
+// TransferInterceptDropInstance discards a packet held by the transfer instance id, like TransferInterceptDrop.
+//
+// Parameters:
+// - id: the instance ID.
+// - packet: the ID of the held packet.
+func (c *ConnManager) TransferInterceptDropInstance(id string, packet int) bool {
+	return c.transferInterceptResolve(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, packet, INTERCEPT_ACTION_DROP, "")
+}
+
+// TransferInterceptEditInstance replaces a packet held by the transfer instance id and forwards it, like TransferInterceptEdit.
+//
+// Parameters:
+// - id: the instance ID.
+// - packet: the ID of the held packet.
+// - base64Data: the new content of the packet, encoded in base64 format.
+func (c *ConnManager) TransferInterceptEditInstance(id string, packet int, base64Data string) bool {
+	return c.transferInterceptResolve(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, packet, INTERCEPT_ACTION_EDIT, base64Data)
+}
+
+// TransferInterceptForwardAllInstance forwards every packet held by the transfer instance id, like TransferInterceptForwardAll.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client, or an empty string for every session.
+func (c *ConnManager) TransferInterceptForwardAllInstance(id string, client string) {
+	if transfer := c.runningTransfer(id); transfer != nil {
+		transfer.InterceptRelease(client)
+	}
+}
+
+// TransferShapingSetInstance changes the network conditions simulated on a session of the transfer instance id,
+// like TransferShapingSet.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client, or an empty string for the conditions of sessions accepted afterwards.
+// - shaping: the settings of the src and dst directions, zero values turn shaping off.
+func (c *ConnManager) TransferShapingSetInstance(id string, client string, shaping TrafficShaping) bool {
+	return c.transferShapingSet(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, client, shaping)
+}
+
+// TransferShapingGetInstance returns the network conditions simulated on a session of the transfer instance id,
+// like TransferShapingGet.
+//
+// Parameters:
+// - id: the instance ID.
+// - client: the session key of the client, or an empty string for the conditions of new sessions.
+func (c *ConnManager) TransferShapingGetInstance(id string, client string) TrafficShaping {
+	return c.transferShapingGet(c.runningTransfer(id), &taggedSink{sink: c.app, tag: id}, client)
+}
+
+// startServer starts server with cfg and reports to events, see ServerTcpStart.
+func (c *ConnManager) startServer(server *TCPServer, cfg ServerConfig, events EventSink) bool {
+	address := cfg.TcpAddr + ":" + cfg.TcpPort
+	var mock *MockResponder
+	if cfg.Mock.Enabled {
+		var err error
+		mock, err = NewMockResponder(cfg.Mock, cfg.Framer)
+		if err != nil {
+			events.Emit("server-tcp-error", "server", fmt.Sprintf("failed to load mock sessions from %s: %v", cfg.Mock.Path, err))
+			return false
+		}
+		events.Emit("server-tcp-info", "server", fmt.Sprintf("mock mode with %d recorded requests from %s", mock.Exchanges(), cfg.Mock.Path))
+	}
+	var mir *MirServer
+	if cfg.Mir.Enabled {
+		if mock != nil {
+			events.Emit("server-tcp-error", "server", "mock and mir modes cannot be enabled together")
+			return false
+		}
+		var err error
+		mir, err = NewMirServer(cfg.Mir)
+		if err != nil {
+			events.Emit("server-tcp-error", "server", fmt.Sprintf("failed to start the mir server: %v", err))
+			return false
+		}
+		events.Emit("server-tcp-info", "server", fmt.Sprintf("mir mode with %d accounts", mir.Accounts()))
+	}
+	tlsConfig, err := c.serverTLS(cfg.TLS)
+	if err != nil {
+		events.Emit("server-tcp-error", "server", fmt.Sprintf("invalid tls settings: %v", err))
+		return false
+	}
+	if tlsConfig != nil {
+		events.Emit("server-tcp-info", "server", cfg.TLS.describe())
+	}
+	server.SetMock(mock)
+	server.SetMirServer(mir)
+	server.SetTLS(tlsConfig)
+	err = server.Start(address, cfg.Framer)
+	if err != nil {
+		events.Emit("server-tcp-error", "server", fmt.Sprintf("failed to listen on %s: %v", address, err))
+		fmt.Printf("Failed to start tcp server : %v\n", err)
+		return false
+	}
+	events.Emit("server-tcp-info", "server", fmt.Sprintf("listening on %s", address))
+	return true
+}
+
+// stopServer stops server and reports to events, see ServerTcpStop.
+func (c *ConnManager) stopServer(server *TCPServer, events EventSink) bool {
+	if server == nil {
+		return false
+	}
+	server.Stop()
+	events.Emit("server-tcp-info", "server", "tcp server stopped")
+	return true
+}
+
+// serverSendMessage sends a message to a client of server, see ServerSendMessage.
+func (c *ConnManager) serverSendMessage(server *TCPServer, events EventSink, client string, base64Data string) {
+	if server == nil || server.listener == nil {
+		events.Emit("server-tcp-error", client, "server not started")
+		return
+	}
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		events.Emit("server-tcp-error", client, base64Data+" decode failed")
+		return
+	}
+	server.SendMessage(client, decodedBytes)
+}
+
+// serverBroadcastMessage sends a message to every client of server, see ServerBroadcastMessage.
+func (c *ConnManager) serverBroadcastMessage(server *TCPServer, events EventSink, base64Data string) {
+	if server == nil || server.listener == nil {
+		events.Emit("server-tcp-error", "server", "server not started")
+		return
+	}
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		events.Emit("server-tcp-error", "server", base64Data+" decode failed")
+		return
+	}
+	server.BroadcastMessage(decodedBytes)
+}
+
+// startTransfer starts transfer with cfg and reports to events, see TransferTcpStart.
+func (c *ConnManager) startTransfer(transfer *TCPTransfer, cfg TransferConfig, events EventSink) bool {
+	var redirect *redirector
+	if cfg.Redirect.Enabled {
+		var err error
+		redirect, err = newRedirector(cfg.Redirect, cfg.SrcAddr, func(target string) (string, error) {
+			return c.spawnRedirect(cfg, target, redirect)
+		})
+		if err != nil {
+			events.Emit("transfer-tcp-error", "server", fmt.Sprintf("%v", err))
+			return false
+		}
+		redirect.owner = transfer
+	}
+	transfer.SetRedirect(redirect)
+	return c.runTransfer(transfer, cfg, events)
+}
+
+// runTransfer applies the forward mode of cfg and starts listening.
+func (c *ConnManager) runTransfer(transfer *TCPTransfer, cfg TransferConfig, events EventSink) bool {
+	srcAddress := cfg.SrcAddr + ":" + cfg.SrcPort
+	dstAddress := cfg.DstAddr + ":" + cfg.DstPort
+	transfer.SetSeqRewrite(cfg.SeqRewrite)
+	if cfg.ForwardMode != "" {
+		err := transfer.SetForwardMode("", cfg.ForwardMode)
+		if err != nil {
+			events.Emit("transfer-tcp-error", "server", fmt.Sprintf("%v", err))
+			return false
+		}
+	}
+	if cfg.SrcTLS.Enabled && cfg.SrcTLS.Mitm && !cfg.DstTLS.Enabled {
+		// the traffic decrypted for the client would reach the destination in clear
+		events.Emit("transfer-tcp-error", "server", "invalid src tls settings: mitm needs dst tls enabled")
+		return false
+	}
+	srcTLS, err := c.serverTLS(cfg.SrcTLS)
+	if err != nil {
+		events.Emit("transfer-tcp-error", "server", fmt.Sprintf("invalid src tls settings: %v", err))
+		return false
+	}
+	dialer, err := NewDialer(cfg.DstTLS, cfg.Upstream)
+	if err != nil {
+		events.Emit("transfer-tcp-error", "server", fmt.Sprintf("invalid dst dial settings: %v", err))
+		return false
+	}
+	if srcTLS != nil {
+		events.Emit("transfer-tcp-info", "server", cfg.SrcTLS.describe())
+	}
+	transfer.SetTLS(srcTLS, dialer)
+	transfer.SetProxy(cfg.Proxy)
+	if err := transfer.SetShaping("", cfg.Shaping); err != nil {
+		events.Emit("transfer-tcp-error", "server", fmt.Sprintf("invalid shaping: %v", err))
+		return false
+	}
+	err = transfer.Start(srcAddress, dstAddress, cfg.SrcFramer, cfg.DstFramer)
+	if err != nil {
+		events.Emit("transfer-tcp-error", "server", fmt.Sprintf("failed to listen on %s: %v", srcAddress, err))
+		fmt.Printf("Failed to start transfer server : %v\n", err)
+		return false
+	}
+	events.Emit("transfer-tcp-info", "server", fmt.Sprintf("listening on %s", transfer.Addr()))
+	return true
+}
+
+// spawnRedirect starts the transfer instance "redirect <target>" relaying to target with the settings of cfg,
+// on a free port of the redirect listen address, and returns the address it listens on. It is never a proxy.
+// The new transfer shares redirect, so the next hop of the login is followed as well.
+func (c *ConnManager) spawnRedirect(cfg TransferConfig, target string, redirect *redirector) (string, error) {
+	id := "redirect " + target
+	transfer := c.transferInstance(id)
+	transfer.SetRedirect(redirect)
+	if addr := transfer.Addr(); addr != nil {
+		return addr.String(), nil
+	}
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", err
+	}
+	cfg.SrcPort = "0"
+	if cfg.Redirect.ListenAddr != "" {
+		cfg.SrcAddr = cfg.Redirect.ListenAddr
+	}
+	cfg.DstAddr, cfg.DstPort = host, port
+	// the client connects straight to the rewritten address, without a proxy handshake
+	cfg.Proxy = false
+	if !c.runTransfer(transfer, cfg, &taggedSink{sink: c.app, tag: id}) {
+		return "", fmt.Errorf("failed to start the transfer to %s", target)
+	}
+	if addr := transfer.Addr(); addr != nil {
+		return addr.String(), nil
+	}
+	return "", fmt.Errorf("the transfer to %s was stopped", target)
+}
+
+// stopTransfer stops transfer and reports to events, see TransferTcpStop.
+func (c *ConnManager) stopTransfer(transfer *TCPTransfer, events EventSink) bool {
+	if transfer == nil {
+		return false
+	}
+	transfer.Stop()
+	events.Emit("transfer-tcp-info", "server", "transfer server stopped")
+	transfer.mutex.RLock()
+	redirect := transfer.redirect
+	transfer.mutex.RUnlock()
+	if redirect != nil && redirect.owner == transfer {
+		// the listeners spawned for the login hops go away with the transfer they were spawned for
+		for _, target := range redirect.release() {
+			id := "redirect " + target
+			if spawned := c.runningTransfer(id); spawned != nil && spawned.Addr() != nil {
+				c.stopTransfer(spawned, &taggedSink{sink: c.app, tag: id})
+			}
+		}
+	}
+	return true
+}
+
+// transferSetForwardMode switches the forward mode of a session of transfer, see TransferSetForwardMode.
+func (c *ConnManager) transferSetForwardMode(transfer *TCPTransfer, events EventSink, client string, mode string) bool {
+	if transfer == nil {
+		events.Emit("transfer-tcp-error", client, "transfer server not started")
+		return false
+	}
+	err := transfer.SetForwardMode(client, mode)
+	if err != nil {
+		events.Emit("transfer-tcp-error", client, fmt.Sprintf("%v", err))
+		return false
+	}
+	events.Emit("transfer-tcp-info", client, fmt.Sprintf("forward mode set to %s", mode))
+	return true
+}
+
+// transferGetForwardMode returns the forward mode of a session of transfer, see TransferGetForwardMode.
+func (c *ConnManager) transferGetForwardMode(transfer *TCPTransfer, events EventSink, client string) string {
+	if transfer == nil {
+		events.Emit("transfer-tcp-error", client, "transfer server not started")
+		return ""
+	}
+	mode, err := transfer.ForwardMode(client)
+	if err != nil {
+		events.Emit("transfer-tcp-error", client, fmt.Sprintf("%v", err))
+		return ""
+	}
+	return mode
+}
+
+// transferInterceptSet changes the intercept settings of a session of transfer, see TransferInterceptSet.
+func (c *ConnManager) transferInterceptSet(transfer *TCPTransfer, events EventSink, client string, settings InterceptSettings) bool {
+	if transfer == nil {
+		events.Emit("transfer-tcp-error", client, "transfer server not started")
+		return false
+	}
+	err := transfer.SetIntercept(client, settings)
+	if err != nil {
+		events.Emit("transfer-tcp-error", client, fmt.Sprintf("%v", err))
+		return false
+	}
+	events.Emit("transfer-tcp-info", client, fmt.Sprintf("intercept src: %v, dst: %v, rules: %d", settings.Src, settings.Dst, len(settings.Rules)))
+	return true
+}
+
+// transferShapingSet changes the simulated network conditions of a session of transfer, see TransferShapingSet.
+func (c *ConnManager) transferShapingSet(transfer *TCPTransfer, events EventSink, client string, shaping TrafficShaping) bool {
+	if transfer == nil {
+		events.Emit("transfer-tcp-error", client, "transfer server not started")
+		return false
+	}
+	err := transfer.SetShaping(client, shaping)
+	if err != nil {
+		events.Emit("transfer-tcp-error", client, fmt.Sprintf("%v", err))
+		return false
+	}
+	events.Emit("transfer-tcp-info", client, fmt.Sprintf("shaping src: %+v, dst: %+v", shaping.Src, shaping.Dst))
+	return true
+}
+
+// transferShapingGet returns the simulated network conditions of a session of transfer, see TransferShapingGet.
+func (c *ConnManager) transferShapingGet(transfer *TCPTransfer, events EventSink, client string) TrafficShaping {
+	if transfer == nil {
+		events.Emit("transfer-tcp-error", client, "transfer server not started")
+		return TrafficShaping{}
+	}
+	shaping, err := transfer.Shaping(client)
+	if err != nil {
+		events.Emit("transfer-tcp-error", client, fmt.Sprintf("%v", err))
+	}
+	return shaping
+}
+
+// transferInterceptResolve forwards, drops or, with base64Data, edits the held packet id of transfer.
+// Errors are reported on the session of the packet, or on the packet ID when it is not held.
+func (c *ConnManager) transferInterceptResolve(transfer *TCPTransfer, events EventSink, id int, action string, base64Data string) bool {
+	key := strconv.Itoa(id)
+	if transfer == nil {
+		events.Emit("transfer-tcp-error", key, "transfer server not started")
+		return false
+	}
+	if packet, ok := transfer.InterceptPacket(id); ok {
+		key = packet.Client
+	}
+	var data []byte
+	if action == INTERCEPT_ACTION_EDIT {
+		var err error
+		data, err = base64.StdEncoding.DecodeString(base64Data)
+		if err != nil {
+			events.Emit("transfer-tcp-error", key, base64Data+" decode failed")
+			return false
+		}
+	}
+	packet, err := transfer.InterceptResolve(id, action, data)
+	if err != nil {
+		events.Emit("transfer-tcp-error", key, fmt.Sprintf("%v", err))
+		return false
+	}
+	events.Emit("transfer-intercept-resolved", packet.Client, packet.Id, action)
+	return true
+}
+
+// transferSendToServer sends data to the server of a session of transfer, see TransferSendToServer.
+func (c *ConnManager) transferSendToServer(transfer *TCPTransfer, events EventSink, client string, base64Data string) {
+	if transfer == nil || transfer.Addr() == nil {
+		events.Emit("transfer-tcp-error", client, "transfer server not started")
+		return
+	}
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		events.Emit("transfer-tcp-error", client, base64Data+" decode failed")
+		return
+	}
+	transfer.SendToServer(client, decodedBytes)
+}
+
+// transferSendToClient sends data to a client of transfer, see TransferSendToClient.
+func (c *ConnManager) transferSendToClient(transfer *TCPTransfer, events EventSink, client string, base64Data string) {
+	if transfer == nil || transfer.Addr() == nil {
+		events.Emit("transfer-tcp-error", client, "transfer server not started")
+		return
+	}
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		events.Emit("transfer-tcp-error", client, base64Data+" decode failed")
+		return
+	}
+	transfer.SendToClient(client, decodedBytes)
+}
+
+// transferBroadcastToServer sends data to the server of every session of transfer, see TransferBroadcastToServer.
+func (c *ConnManager) transferBroadcastToServer(transfer *TCPTransfer, events EventSink, base64Data string) {
+	if transfer == nil || transfer.Addr() == nil {
+		events.Emit("transfer-tcp-error", "server", "transfer server not started")
+		return
+	}
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		events.Emit("transfer-tcp-error", "server", base64Data+" decode failed")
+		return
+	}
+	transfer.BroadcastToServer(decodedBytes)
+}
+
+// transferBroadcastToClient sends data to every client of transfer, see TransferBroadcastToClient.
+func (c *ConnManager) transferBroadcastToClient(transfer *TCPTransfer, events EventSink, base64Data string) {
+	if transfer == nil || transfer.Addr() == nil {
+		events.Emit("transfer-tcp-error", "server", "transfer server not started")
+		return
+	}
+	decodedBytes, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		events.Emit("transfer-tcp-error", "server", base64Data+" decode failed")
+		return
+	}
+	transfer.BroadcastToClient(decodedBytes)
+}
+
+// serverTLS returns the TLS configuration of a listener, with the certificates of the root CA in MITM mode.
+func (c *ConnManager) serverTLS(cfg TLSServerConfig) (*tls.Config, error) {
+	if cfg.Enabled && cfg.Mitm {
+		return c.ca.ServerConfig()
+	}
+	return cfg.config()
+}
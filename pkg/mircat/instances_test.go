@@ -0,0 +1,113 @@
+package mircat
+
+import (
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerInstances(t *testing.T) {
+	rec := NewEventRecorder()
+	m := NewConnManager(NewAppWithSink(rec), &Config{Servers: map[string]ServerConfig{
+		"login": {TcpAddr: "127.0.0.1", TcpPort: "0"},
+		"game":  {TcpAddr: "127.0.0.1", TcpPort: "0"},
+	}})
+	if ids := m.ServerInstances(); len(ids) != 2 || ids[0] != "game" || ids[1] != "login" {
+		t.Fatalf("instances %q", ids)
+	}
+	if m.ServerTcpStartInstance("shop") {
+		t.Fatal("started an instance that is not configured")
+	}
+	if errors := rec.Events("server-tcp-error"); len(errors) != 1 || errors[0].Data[2] != "shop" {
+		t.Fatalf("errors %+v", errors)
+	}
+	if !m.ServerTcpStartInstance("login") || !m.ServerTcpStartInstance("game") {
+		t.Fatalf("start failed: %+v", rec.Events("server-tcp-error"))
+	}
+	defer m.ServerTcpStopInstance("login")
+	defer m.ServerTcpStopInstance("game")
+
+	game := m.runningServer("game")
+	client, err := net.Dial("tcp", game.listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("hi"))
+	// the events of an instance carry its ID last
+	events, ok := rec.Wait("server-tcp-data", 1, 2*time.Second)
+	if !ok || events[0].Data[len(events[0].Data)-1] != "game" {
+		t.Fatalf("data events %+v", events)
+	}
+
+	m.ServerBroadcastMessageInstance("game", base64.StdEncoding.EncodeToString([]byte("all")))
+	if got := receive(t, client, 3); got != "all" {
+		t.Fatalf("client received %q", got)
+	}
+	// the main server was never started
+	m.ServerBroadcastMessage(base64.StdEncoding.EncodeToString([]byte("lost")))
+	silent(t, client)
+
+	if !m.ServerTcpStopInstance("game") || m.ServerTcpStopInstance("shop") {
+		t.Fatal("stop reported the wrong instances")
+	}
+}
+
+func TestTransferInstances(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	rec := NewEventRecorder()
+	m := NewConnManager(NewAppWithSink(rec), &Config{Transfers: map[string]TransferConfig{
+		"login": {SrcAddr: "127.0.0.1", SrcPort: "0", DstAddr: host, DstPort: port},
+	}})
+	if ids := m.TransferInstances(); len(ids) != 1 || ids[0] != "login" {
+		t.Fatalf("instances %q", ids)
+	}
+	if m.TransferTcpStopInstance("login") {
+		t.Fatal("stopped an instance that never started")
+	}
+	if !m.TransferTcpStartInstance("login") {
+		t.Fatalf("start failed: %+v", rec.Events("transfer-tcp-error"))
+	}
+	defer m.TransferTcpStopInstance("login")
+
+	transfer := m.runningTransfer("login")
+	client, err := net.Dial("tcp", transfer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var server net.Conn
+	select {
+	case server = <-accepted:
+		defer server.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("the transfer did not connect to the server")
+	}
+	client.Write([]byte("ping"))
+	if got := receive(t, server, 4); got != "ping" {
+		t.Fatalf("server received %q", got)
+	}
+
+	key := client.LocalAddr().String()
+	m.TransferSendToClientInstance("login", key, base64.StdEncoding.EncodeToString([]byte("push")))
+	if got := receive(t, client, 4); got != "push" {
+		t.Fatalf("client received %q", got)
+	}
+	m.TransferSendToServerInstance("login", key, "not base64")
+	if errors := rec.Events("transfer-tcp-error"); len(errors) != 1 || errors[0].Data[0] != key || errors[0].Data[2] != "login" {
+		t.Fatalf("errors %+v", errors)
+	}
+}
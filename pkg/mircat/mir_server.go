@@ -0,0 +1,537 @@
+package mircat
+
+import (
+	"fmt"
+	"math/rand"
+	"mir-cat/pkg/codec"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MIR_FIRST_ACTOR is the actor ID of the first player entering the mock Mir server.
+const MIR_FIRST_ACTOR = 0x10000
+
+// MIR_TICKET_EXPIRY is how long a certification issued at login lets the client reach the game server.
+const MIR_TICKET_EXPIRY = 5 * time.Minute
+
+// mirSteps are the offsets of the eight Mir directions, starting north and turning clockwise.
+var mirSteps = [8][2]int{{0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}}
+
+// MirServerConfig turns the TCP server into a minimal Mir server speaking the login, character-select
+// and game-entry handshake. One listener plays the three servers, it hands its own address to the client.
+type MirServerConfig struct {
+	// Enabled switches the TCP server to Mir mode.
+	Enabled bool `json:"enabled"`
+	// ServerName is the server listed after login, "MirCat" when empty.
+	ServerName string `json:"serverName"`
+	// AdvertiseAddr is the host handed to the client for the select and game servers,
+	// the address the client connected to when empty.
+	AdvertiseAddr string `json:"advertiseAddr"`
+	// Accounts are the accounts accepted by the login server.
+	Accounts []MirAccount `json:"accounts"`
+	// Map is the map every character enters.
+	Map MirMap `json:"map"`
+	// Notice is the notice shown before entering the game.
+	Notice string `json:"notice"`
+	// Responses replace the answers to client messages, keyed by the name of the client message in the catalog.
+	// An empty list leaves the message unanswered.
+	Responses map[string][]MirResponse `json:"responses"`
+}
+
+// MirAccount is an account of the mock Mir server and its characters.
+type MirAccount struct {
+	Account    string         `json:"account"`
+	Password   string         `json:"password"`
+	Characters []MirCharacter `json:"characters"`
+}
+
+// MirCharacter is a character of an account.
+type MirCharacter struct {
+	Name  string `json:"name"`
+	Job   int    `json:"job"`
+	Hair  int    `json:"hair"`
+	Level int    `json:"level"`
+	Sex   int    `json:"sex"`
+	Gold  int    `json:"gold"`
+}
+
+// MirMap is the static map of the mock Mir server. Width and Height bound the walks of the
+// characters, 0 leaves them unbounded.
+type MirMap struct {
+	Name   string `json:"name"`
+	Title  string `json:"title"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	StartX int    `json:"startX"`
+	StartY int    `json:"startY"`
+}
+
+// MirResponse is a message sent in answer to a client message. Fields set the header values by
+// their names in the catalog, Body is the text body. Both may use the placeholders {account},
+// {character}, {certification}, {host}, {port}, {map}, {x}, {y}, {actor} and {text}, the text
+// body of the client message.
+type MirResponse struct {
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields"`
+	Body    string            `json:"body"`
+}
+
+// mirTicket is what a certification grants, the account after login and the character after selection.
+type mirTicket struct {
+	account   string
+	character string
+	issued    time.Time
+}
+
+// MirServer holds the state shared by the connections of a mock Mir server.
+type MirServer struct {
+	cfg      MirServerConfig
+	mutex    sync.Mutex
+	accounts map[string]*MirAccount
+	tickets  map[int32]*mirTicket
+	actors   int32
+}
+
+// NewMirServer checks cfg and returns a server knowing its accounts.
+func NewMirServer(cfg MirServerConfig) (*MirServer, error) {
+	if len(cfg.Accounts) == 0 {
+		return nil, fmt.Errorf("no accounts")
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = "MirCat"
+	}
+	if cfg.Map.Name == "" {
+		cfg.Map.Name = "0"
+	}
+	m := &MirServer{
+		cfg:      cfg,
+		accounts: map[string]*MirAccount{},
+		tickets:  map[int32]*mirTicket{},
+		actors:   MIR_FIRST_ACTOR,
+	}
+	catalog := codec.CurrentCatalog()
+	for name, responses := range cfg.Responses {
+		if catalog.Find(name) == nil {
+			return nil, fmt.Errorf("responses: unknown message %s", name)
+		}
+		for _, response := range responses {
+			if catalog.Find(response.Message) == nil {
+				return nil, fmt.Errorf("responses to %s: unknown message %s", name, response.Message)
+			}
+		}
+	}
+	for i := range cfg.Accounts {
+		account := cfg.Accounts[i]
+		account.Characters = append([]MirCharacter{}, account.Characters...)
+		m.accounts[account.Account] = &account
+	}
+	return m, nil
+}
+
+// Accounts returns the number of accounts.
+func (m *MirServer) Accounts() int {
+	return len(m.accounts)
+}
+
+// newSession starts the state of a connection reached at local.
+func (m *MirServer) newSession(local net.Addr) *mirSession {
+	host, port, _ := net.SplitHostPort(local.String())
+	if m.cfg.AdvertiseAddr != "" {
+		host = m.cfg.AdvertiseAddr
+	}
+	return &mirSession{server: m, host: host, port: port, decoder: &codec.Decoder{Direction: codec.FROM_CLIENT}}
+}
+
+// mirSession is the state of a connection to the mock Mir server.
+type mirSession struct {
+	server        *MirServer
+	host          string
+	port          string
+	decoder       *codec.Decoder
+	account       string
+	character     *MirCharacter
+	certification int32
+	actor         int32
+	x             int
+	y             int
+	direction     int
+}
+
+// Answer decodes the data of a client and returns the frames to send back.
+func (s *mirSession) Answer(data []byte) ([][]byte, error) {
+	frames := [][]byte{}
+	for _, p := range s.decoder.Feed(data) {
+		answer, err := s.answer(p)
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, answer...)
+	}
+	return frames, nil
+}
+
+func (s *mirSession) answer(p *codec.Packet) ([][]byte, error) {
+	if p.Message == nil {
+		if strings.HasPrefix(p.Text, "**") {
+			return s.gameLogin(strings.TrimPrefix(p.Text, "**"))
+		}
+		return nil, nil
+	}
+	if p.Name == "" {
+		return nil, fmt.Errorf("unknown message %d", p.Message.Ident)
+	}
+	if responses, ok := s.server.cfg.Responses[p.Name]; ok {
+		return s.respond(responses, p)
+	}
+	switch p.Name {
+	case "CM_IDPASSWORD":
+		return s.idPassword(stringField(p.Fields, "account"), stringField(p.Fields, "password"))
+	case "CM_SELECTSERVER":
+		if s.account == "" {
+			return s.send("SM_CERTIFICATION_FAIL", nil, nil)
+		}
+		certification := s.certification
+		return s.send("SM_SELECTSERVER_OK", map[string]int64{"certification": int64(certification)},
+			map[string]string{"host": s.host, "port": s.port, "certification": strconv.Itoa(int(certification))})
+	case "CM_QUERYCHR":
+		return s.queryCharacters(stringField(p.Fields, "account"), stringField(p.Fields, "certification"))
+	case "CM_NEWCHR":
+		return s.newCharacter(p.Fields)
+	case "CM_DELCHR":
+		return s.deleteCharacter(stringField(p.Fields, "name"))
+	case "CM_SELCHR":
+		return s.selectCharacter(stringField(p.Fields, "name"))
+	case "CM_LOGINNOTICEOK":
+		return s.enterGame()
+	case "CM_QUERYBAGITEMS":
+		return s.send("SM_BAGITEMS", map[string]int64{"count": 0}, nil)
+	case "CM_SAY":
+		if s.character == nil {
+			return nil, nil
+		}
+		return s.send("SM_HEAR", map[string]int64{"actor": int64(s.actor)},
+			map[string]string{"message": s.character.Name + ": " + stringField(p.Fields, "message")})
+	case "CM_TURN", "CM_WALK", "CM_RUN":
+		direction, _ := intField(p.Fields, "direction")
+		return s.move(p.Name, direction)
+	}
+	if _, ok := p.Fields["direction"]; ok && s.character != nil {
+		// the other actions only need to be acknowledged
+		return [][]byte{actionResult(true)}, nil
+	}
+	return nil, nil
+}
+
+// send builds a message of the catalog from the values of its header and of its text body.
+func (s *mirSession) send(name string, header map[string]int64, body map[string]string) ([][]byte, error) {
+	spec := codec.CurrentCatalog().Find(name)
+	if spec == nil {
+		return nil, fmt.Errorf("the catalog has no message %s", name)
+	}
+	text := ""
+	if body != nil {
+		text = spec.Text(body)
+	}
+	return [][]byte{codec.EncodeMessage(spec.Message(header), []byte(text))}, nil
+}
+
+// sendBinary builds a message of the catalog from the values of its header and a binary body.
+func (s *mirSession) sendBinary(name string, header map[string]int64, body []byte) ([][]byte, error) {
+	spec := codec.CurrentCatalog().Find(name)
+	if spec == nil {
+		return nil, fmt.Errorf("the catalog has no message %s", name)
+	}
+	return [][]byte{codec.EncodeMessage(spec.Message(header), body)}, nil
+}
+
+// respond sends the configured responses to a client message.
+func (s *mirSession) respond(responses []MirResponse, p *codec.Packet) ([][]byte, error) {
+	replacer := s.replacer(p.Text)
+	frames := [][]byte{}
+	for _, response := range responses {
+		spec := codec.CurrentCatalog().Find(response.Message)
+		if spec == nil {
+			return frames, fmt.Errorf("the catalog has no message %s", response.Message)
+		}
+		header := map[string]int64{}
+		for name, value := range response.Fields {
+			n, err := strconv.ParseInt(replacer.Replace(value), 10, 64)
+			if err != nil {
+				return frames, fmt.Errorf("%s: %s is not a number", response.Message, value)
+			}
+			header[name] = n
+		}
+		frames = append(frames, codec.EncodeMessage(spec.Message(header), []byte(replacer.Replace(response.Body))))
+	}
+	return frames, nil
+}
+
+func (s *mirSession) replacer(text string) *strings.Replacer {
+	character := ""
+	if s.character != nil {
+		character = s.character.Name
+	}
+	return strings.NewReplacer(
+		"{account}", s.account,
+		"{character}", character,
+		"{certification}", strconv.Itoa(int(s.certification)),
+		"{host}", s.host,
+		"{port}", s.port,
+		"{map}", s.server.cfg.Map.Name,
+		"{x}", strconv.Itoa(s.x),
+		"{y}", strconv.Itoa(s.y),
+		"{actor}", strconv.Itoa(int(s.actor)),
+		"{text}", text,
+	)
+}
+
+func (s *mirSession) idPassword(account string, password string) ([][]byte, error) {
+	m := s.server
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	a, ok := m.accounts[account]
+	if !ok {
+		return s.send("SM_ID_NOTFOUND", nil, nil)
+	}
+	if a.Password != password {
+		return s.send("SM_PASSWD_FAIL", map[string]int64{"reason": -1}, nil)
+	}
+	s.account = account
+	s.certification = m.issue(&mirTicket{account: account, issued: time.Now()})
+	return s.send("SM_PASSOK_SELECTSERVER", map[string]int64{"certification": int64(s.certification)},
+		map[string]string{"name": m.cfg.ServerName, "status": "1"})
+}
+
+// issue returns a new certification for ticket, the caller holds the mutex.
+func (m *MirServer) issue(ticket *mirTicket) int32 {
+	for certification, issued := range m.tickets {
+		if time.Since(issued.issued) > MIR_TICKET_EXPIRY {
+			delete(m.tickets, certification)
+		}
+	}
+	for {
+		certification := rand.Int31n(1<<30) + 1
+		if _, ok := m.tickets[certification]; !ok {
+			m.tickets[certification] = ticket
+			return certification
+		}
+	}
+}
+
+// ticket returns the ticket of a certification that has not expired, the caller holds the mutex.
+func (m *MirServer) ticket(certification int32) (*mirTicket, bool) {
+	ticket, ok := m.tickets[certification]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(ticket.issued) > MIR_TICKET_EXPIRY {
+		delete(m.tickets, certification)
+		return nil, false
+	}
+	return ticket, true
+}
+
+func (s *mirSession) queryCharacters(account string, certification string) ([][]byte, error) {
+	m := s.server
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	n, _ := strconv.Atoi(certification)
+	ticket, ok := m.ticket(int32(n))
+	if !ok || ticket.account != account {
+		return s.send("SM_CERTIFICATION_FAIL", nil, nil)
+	}
+	s.account = account
+	s.certification = int32(n)
+	records := []map[string]string{}
+	for i, c := range m.accounts[account].Characters {
+		name := c.Name
+		// the client selects the character marked with '*'
+		if i == 0 {
+			name = "*" + name
+		}
+		records = append(records, map[string]string{
+			"name":  name,
+			"job":   strconv.Itoa(c.Job),
+			"hair":  strconv.Itoa(c.Hair),
+			"level": strconv.Itoa(c.Level),
+			"sex":   strconv.Itoa(c.Sex),
+		})
+	}
+	spec := codec.CurrentCatalog().Find("SM_QUERYCHR")
+	if spec == nil {
+		return nil, fmt.Errorf("the catalog has no message SM_QUERYCHR")
+	}
+	msg := spec.Message(map[string]int64{"count": int64(len(records))})
+	return [][]byte{codec.EncodeMessage(msg, []byte(spec.Text(records...)))}, nil
+}
+
+func (s *mirSession) newCharacter(fields map[string]interface{}) ([][]byte, error) {
+	m := s.server
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if s.account == "" {
+		return s.send("SM_NEWCHR_FAIL", map[string]int64{"reason": 4}, nil)
+	}
+	name := stringField(fields, "name")
+	job, _ := intField(fields, "job")
+	hair, _ := intField(fields, "hair")
+	sex, _ := intField(fields, "sex")
+	for _, a := range m.accounts {
+		for _, c := range a.Characters {
+			if c.Name == name {
+				return s.send("SM_NEWCHR_FAIL", map[string]int64{"reason": 2}, nil)
+			}
+		}
+	}
+	a := m.accounts[s.account]
+	a.Characters = append(a.Characters, MirCharacter{
+		Name:  name,
+		Job:   job,
+		Hair:  hair,
+		Sex:   sex,
+		Level: 1,
+	})
+	return s.send("SM_NEWCHR_SUCCESS", nil, nil)
+}
+
+func (s *mirSession) deleteCharacter(name string) ([][]byte, error) {
+	m := s.server
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if s.account != "" {
+		a := m.accounts[s.account]
+		for i, c := range a.Characters {
+			if c.Name == name {
+				a.Characters = append(a.Characters[:i], a.Characters[i+1:]...)
+				return s.send("SM_DELCHR_SUCCESS", nil, nil)
+			}
+		}
+	}
+	return s.send("SM_DELCHR_FAIL", map[string]int64{"reason": 1}, nil)
+}
+
+func (s *mirSession) selectCharacter(name string) ([][]byte, error) {
+	m := s.server
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	ticket, ok := m.ticket(s.certification)
+	if !ok || s.account == "" {
+		return s.send("SM_STARTFAIL", nil, nil)
+	}
+	for _, c := range m.accounts[s.account].Characters {
+		if c.Name == name {
+			ticket.character = name
+			return s.send("SM_STARTPLAY", nil, map[string]string{"host": s.host, "port": s.port})
+		}
+	}
+	return s.send("SM_STARTFAIL", nil, nil)
+}
+
+// gameLogin checks the login string "account/character/certification/version/..." of the game server.
+func (s *mirSession) gameLogin(login string) ([][]byte, error) {
+	values := strings.Split(login, "/")
+	if len(values) < 3 {
+		return s.send("SM_CERTIFICATION_FAIL", nil, nil)
+	}
+	n, _ := strconv.Atoi(values[2])
+	m := s.server
+	m.mutex.Lock()
+	ticket, ok := m.ticket(int32(n))
+	if !ok || ticket.account != values[0] || ticket.character != values[1] {
+		m.mutex.Unlock()
+		return s.send("SM_CERTIFICATION_FAIL", nil, nil)
+	}
+	// a certification enters the game once
+	delete(m.tickets, int32(n))
+	for _, c := range m.accounts[ticket.account].Characters {
+		if c.Name == ticket.character {
+			character := c
+			s.character = &character
+		}
+	}
+	m.actors++
+	s.actor = m.actors
+	m.mutex.Unlock()
+	if s.character == nil {
+		return s.send("SM_CERTIFICATION_FAIL", nil, nil)
+	}
+	s.account = ticket.account
+	s.certification = int32(n)
+	s.x, s.y = m.cfg.Map.StartX, m.cfg.Map.StartY
+	s.direction = 4
+	return s.send("SM_SENDNOTICE", nil, map[string]string{"notice": m.cfg.Notice})
+}
+
+// enterGame places the character on the map, like the game server after the notice.
+func (s *mirSession) enterGame() ([][]byte, error) {
+	if s.character == nil {
+		return nil, nil
+	}
+	m := s.server
+	position := map[string]int64{"actor": int64(s.actor), "x": int64(s.x), "y": int64(s.y), "direction": int64(s.direction)}
+	level := s.character.Level
+	if level < 1 {
+		level = 1
+	}
+	ability := codec.Ability{
+		Level: uint16(level), HP: 100, MP: 100, MaxHP: 100, MaxMP: 100,
+		MaxExp: 100, MaxWeight: 100, MaxWearWeight: 15, MaxHandWeight: 15,
+	}
+	out := &mirFrames{}
+	out.add(s.send("SM_NEWMAP", position, map[string]string{"map": m.cfg.Map.Name}))
+	out.add(s.sendBinary("SM_LOGON", position, codec.MessageBodyWL{}.Bytes()))
+	out.add(s.send("SM_MAPDESCRIPTION", nil, map[string]string{"title": m.cfg.Map.Title}))
+	out.add(s.sendBinary("SM_ABILITY", map[string]int64{"gold": int64(s.character.Gold), "job": int64(s.character.Job)}, ability.Bytes()))
+	return out.frames, out.err
+}
+
+// mirFrames collects the frames of several messages and keeps the first error.
+type mirFrames struct {
+	frames [][]byte
+	err    error
+}
+
+func (f *mirFrames) add(frames [][]byte, err error) {
+	if f.err != nil {
+		return
+	}
+	f.frames = append(f.frames, frames...)
+	f.err = err
+}
+
+// move turns, walks or runs the character, steps leaving the map fail.
+func (s *mirSession) move(name string, direction int) ([][]byte, error) {
+	if s.character == nil {
+		return nil, nil
+	}
+	if direction < 0 || direction >= len(mirSteps) {
+		return [][]byte{actionResult(false)}, nil
+	}
+	steps := 0
+	switch name {
+	case "CM_WALK":
+		steps = 1
+	case "CM_RUN":
+		steps = 2
+	}
+	x := s.x + mirSteps[direction][0]*steps
+	y := s.y + mirSteps[direction][1]*steps
+	mirMap := s.server.cfg.Map
+	if x < 0 || y < 0 || (mirMap.Width > 0 && x >= mirMap.Width) || (mirMap.Height > 0 && y >= mirMap.Height) {
+		return [][]byte{actionResult(false)}, nil
+	}
+	s.x, s.y, s.direction = x, y, direction
+	return [][]byte{actionResult(true)}, nil
+}
+
+// actionResult builds the "+GOOD/tick" or "+FAIL/tick" answer to a player action.
+func actionResult(good bool) []byte {
+	result := "+FAIL/"
+	if good {
+		result = "+GOOD/"
+	}
+	return codec.EncodePacket(&codec.Packet{Text: result + strconv.FormatInt(time.Now().UnixMilli()&0x7FFFFFFF, 10)})
+}
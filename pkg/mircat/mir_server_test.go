@@ -0,0 +1,180 @@
+package mircat
+
+import (
+	"mir-cat/pkg/codec"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mirClient talks to a mock Mir server like the game client.
+type mirClient struct {
+	t       *testing.T
+	conn    net.Conn
+	in      codec.Decoder
+	packets []*codec.Packet
+}
+
+func dialMir(t *testing.T, address string) *mirClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &mirClient{t: t, conn: conn, in: codec.Decoder{Direction: codec.FROM_SERVER}}
+}
+
+// send sends the client message name built from its header values and text body.
+func (c *mirClient) send(name string, header map[string]int64, body map[string]string) {
+	c.t.Helper()
+	spec := codec.CurrentCatalog().Find(name)
+	text := ""
+	if body != nil {
+		text = spec.Text(body)
+	}
+	c.conn.Write(codec.EncodeMessage(spec.Message(header), []byte(text)))
+}
+
+// read returns the next packet from the server.
+func (c *mirClient) read() *codec.Packet {
+	c.t.Helper()
+	buffer := make([]byte, 4096)
+	for len(c.packets) == 0 {
+		c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := c.conn.Read(buffer)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		c.packets = append(c.packets, c.in.Feed(buffer[:n])...)
+	}
+	p := c.packets[0]
+	c.packets = c.packets[1:]
+	return p
+}
+
+// expect reads the next packet and checks its name.
+func (c *mirClient) expect(name string) *codec.Packet {
+	c.t.Helper()
+	p := c.read()
+	if p.Name != name {
+		c.t.Fatalf("expected %s, got %s %+v", name, p.Name, p.Fields)
+	}
+	return p
+}
+
+func TestMirServerHandshake(t *testing.T) {
+	cfg := heroServer()
+	cfg.Map.Width, cfg.Map.Height = 12, 12
+	cfg.Notice = "welcome"
+	addr := startMirServer(t, cfg)
+	_, port, _ := net.SplitHostPort(addr)
+
+	login := dialMir(t, addr)
+	login.send("CM_IDPASSWORD", nil, map[string]string{"account": "nobody", "password": "secret"})
+	login.expect("SM_ID_NOTFOUND")
+	login.send("CM_IDPASSWORD", nil, map[string]string{"account": "hero", "password": "secret"})
+	if servers := login.expect("SM_PASSOK_SELECTSERVER").Fields["servers"].([]map[string]interface{}); servers[0]["name"] != "MirCat" {
+		t.Fatalf("servers %v", servers)
+	}
+	login.send("CM_SELECTSERVER", nil, map[string]string{"name": "MirCat"})
+	selected := login.expect("SM_SELECTSERVER_OK")
+	// the listener plays the select server too
+	if selected.Fields["host"] != "127.0.0.1" || selected.Fields["port"] != port {
+		t.Fatalf("select server %v", selected.Fields)
+	}
+	certification := selected.Fields["certification"].(string)
+
+	selection := dialMir(t, addr)
+	selection.send("CM_QUERYCHR", nil, map[string]string{"account": "hero", "certification": "1"})
+	selection.expect("SM_CERTIFICATION_FAIL")
+	selection.send("CM_QUERYCHR", nil, map[string]string{"account": "hero", "certification": certification})
+	chars := selection.expect("SM_QUERYCHR").Fields["chars"].([]map[string]interface{})
+	if len(chars) != 1 || chars[0]["name"] != "*Hero" || chars[0]["level"] != 3 {
+		t.Fatalf("characters %v", chars)
+	}
+	selection.send("CM_NEWCHR", nil, map[string]string{"account": "hero", "name": "Hero", "hair": "1", "job": "1", "sex": "0"})
+	selection.expect("SM_NEWCHR_FAIL")
+	selection.send("CM_NEWCHR", nil, map[string]string{"account": "hero", "name": "Mage", "hair": "1", "job": "1", "sex": "0"})
+	selection.expect("SM_NEWCHR_SUCCESS")
+	selection.send("CM_DELCHR", nil, map[string]string{"name": "Ghost"})
+	selection.expect("SM_DELCHR_FAIL")
+	selection.send("CM_SELCHR", nil, map[string]string{"account": "hero", "name": "Mage"})
+	selection.expect("SM_STARTPLAY")
+
+	game := dialMir(t, addr)
+	gameLogin := "**hero/Mage/" + certification + "/20/0"
+	game.conn.Write(codec.EncodePacket(&codec.Packet{Body: []byte(gameLogin)}))
+	if notice := game.expect("SM_SENDNOTICE"); notice.Fields["notice"] != "welcome" {
+		t.Fatalf("notice %v", notice.Fields)
+	}
+	game.send("CM_LOGINNOTICEOK", nil, nil)
+	newMap := game.expect("SM_NEWMAP")
+	if newMap.Fields["map"] != "3" || newMap.Fields["x"] != uint16(10) || newMap.Fields["actor"] != int32(MIR_FIRST_ACTOR+1) {
+		t.Fatalf("new map %v", newMap.Fields)
+	}
+	game.expect("SM_LOGON")
+	game.expect("SM_MAPDESCRIPTION")
+	if ability := game.expect("SM_ABILITY").Fields["ability"].(codec.Ability); ability.Level != 1 || ability.MaxHP != 100 {
+		t.Fatalf("ability %+v", ability)
+	}
+
+	// walking east twice leaves the 12 wide map
+	game.send("CM_WALK", map[string]int64{"direction": 2}, nil)
+	if p := game.read(); !strings.HasPrefix(p.Text, "+GOOD/") {
+		t.Fatalf("first step %q", p.Text)
+	}
+	game.send("CM_RUN", map[string]int64{"direction": 2}, nil)
+	if p := game.read(); !strings.HasPrefix(p.Text, "+FAIL/") {
+		t.Fatalf("second step %q", p.Text)
+	}
+	game.send("CM_SAY", nil, map[string]string{"message": "hi"})
+	if hear := game.expect("SM_HEAR"); hear.Fields["message"] != "Mage: hi" {
+		t.Fatalf("hear %v", hear.Fields)
+	}
+
+	// a certification enters the game once
+	again := dialMir(t, addr)
+	again.conn.Write(codec.EncodePacket(&codec.Packet{Body: []byte(gameLogin)}))
+	again.expect("SM_CERTIFICATION_FAIL")
+}
+
+func TestMirServerResponses(t *testing.T) {
+	cfg := heroServer()
+	cfg.Responses = map[string][]MirResponse{
+		"CM_IDPASSWORD": {{Message: "SM_PASSOK_SELECTSERVER", Fields: map[string]string{"certification": "42"}, Body: "Mock/1"}},
+		"CM_SAY":        {{Message: "SM_HEAR", Fields: map[string]string{"actor": "{certification}"}, Body: "{text}!"}},
+	}
+	c := dialMir(t, startMirServer(t, cfg))
+	// the account is not checked, the answer is the configured one
+	c.send("CM_IDPASSWORD", nil, map[string]string{"account": "hero", "password": "guess"})
+	p := c.expect("SM_PASSOK_SELECTSERVER")
+	if servers := p.Fields["servers"].([]map[string]interface{}); p.Fields["certification"] != int32(42) || servers[0]["name"] != "Mock" {
+		t.Fatalf("fields %v", p.Fields)
+	}
+	c.send("CM_SAY", nil, map[string]string{"message": "hi"})
+	if p := c.expect("SM_HEAR"); p.Fields["actor"] != int32(0) || p.Fields["message"] != "hi!" {
+		t.Fatalf("fields %v", p.Fields)
+	}
+}
+
+func TestNewMirServerRejectsBadSettings(t *testing.T) {
+	for _, cfg := range []MirServerConfig{
+		{},
+		{Accounts: heroServer().Accounts, Responses: map[string][]MirResponse{"CM_NOPE": nil}},
+		{Accounts: heroServer().Accounts, Responses: map[string][]MirResponse{"CM_SAY": {{Message: "SM_NOPE"}}}},
+	} {
+		if _, err := NewMirServer(cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	m, err := NewMirServer(heroServer())
+	if err != nil || m.Accounts() != 1 || m.cfg.ServerName != "MirCat" {
+		t.Fatalf("server %+v, %v", m, err)
+	}
+	certification := m.issue(&mirTicket{issued: time.Now().Add(-MIR_TICKET_EXPIRY - time.Second)})
+	if _, ok := m.ticket(certification); ok {
+		t.Fatal("an expired certification is still valid")
+	}
+}
@@ -0,0 +1,165 @@
+package mircat
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"mir-cat/pkg/codec"
+	"strings"
+)
+
+const (
+	// MOCK_MATCH_EXACT answers only requests identical to a recorded one.
+	MOCK_MATCH_EXACT = "exact"
+	// MOCK_MATCH_OPCODE also answers requests whose Mir header has the same Ident as a recorded one.
+	MOCK_MATCH_OPCODE = "opcode"
+	// MOCK_MATCH_MASK also answers requests equal to a recorded one on the bits set in the mask.
+	MOCK_MATCH_MASK = "mask"
+)
+
+// MockConfig turns the TCP server into a fake server answering with recorded responses.
+type MockConfig struct {
+	// Enabled switches the TCP server to mock mode.
+	Enabled bool `json:"enabled"`
+	// Path is the pcap or pcapng file holding the recorded sessions.
+	Path string `json:"path"`
+	// Filter selects the recorded sessions, every matching connection is used.
+	Filter PcapFilter `json:"filter"`
+	// Match is "exact" (default), "opcode" or "mask". Exact matches are always preferred.
+	Match string `json:"match"`
+	// Mask is the hex mask compared against requests in mask mode, bytes beyond the mask are ignored.
+	Mask string `json:"mask"`
+}
+
+type mockExchange struct {
+	request   []byte
+	ident     int
+	responses [][]byte
+}
+
+// MockResponder finds the recorded responses to a client message.
+type MockResponder struct {
+	match     string
+	mask      []byte
+	greeting  [][]byte
+	exchanges []*mockExchange
+}
+
+// NewMockResponder loads the recorded sessions of cfg and cuts their client streams with framer,
+// the framer of the server, so recorded requests compare with live ones.
+func NewMockResponder(cfg MockConfig, framer FramerConfig) (*MockResponder, error) {
+	m := &MockResponder{match: cfg.Match, exchanges: []*mockExchange{}}
+	if m.match == "" {
+		m.match = MOCK_MATCH_EXACT
+	}
+	switch m.match {
+	case MOCK_MATCH_EXACT, MOCK_MATCH_OPCODE:
+	case MOCK_MATCH_MASK:
+		var err error
+		m.mask, err = hex.DecodeString(strings.ReplaceAll(cfg.Mask, " ", ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown match mode %s", cfg.Match)
+	}
+	sessions, err := LoadPcap(cfg.Path, cfg.Filter)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		messages, err := frameSession(session, framer, FramerConfig{}, nil)
+		if err != nil {
+			return nil, err
+		}
+		steps := replaySteps(messages)
+		if m.greeting == nil && len(steps[0].expected) > 0 {
+			m.greeting = steps[0].expected
+		}
+		for _, step := range steps[1:] {
+			m.exchanges = append(m.exchanges, &mockExchange{
+				request:   step.send,
+				ident:     mirIdent(step.send),
+				responses: step.expected,
+			})
+		}
+	}
+	if len(m.exchanges) == 0 && m.greeting == nil {
+		return nil, fmt.Errorf("no recorded session matches the filter")
+	}
+	return m, nil
+}
+
+// Exchanges returns the number of recorded requests.
+func (m *MockResponder) Exchanges() int {
+	return len(m.exchanges)
+}
+
+// Greeting returns what the recorded server sent before the first client message.
+func (m *MockResponder) Greeting() [][]byte {
+	return m.greeting
+}
+
+// Answer returns the responses recorded for the request that best matches the given one, and the
+// index of that request, or -1 when none matches. Among equal matches the first one at or after
+// cursor is preferred, so a connection that passes the previous index + 1 walks through repeated
+// requests in their recorded order.
+func (m *MockResponder) Answer(request []byte, cursor int) ([][]byte, int) {
+	if i := m.find(cursor, func(e *mockExchange) bool { return bytes.Equal(e.request, request) }); i >= 0 {
+		return m.exchanges[i].responses, i
+	}
+	var i int
+	switch m.match {
+	case MOCK_MATCH_OPCODE:
+		ident := mirIdent(request)
+		if ident < 0 {
+			return nil, -1
+		}
+		i = m.find(cursor, func(e *mockExchange) bool { return e.ident == ident })
+	case MOCK_MATCH_MASK:
+		i = m.find(cursor, func(e *mockExchange) bool { return maskEqual(e.request, request, m.mask) })
+	default:
+		return nil, -1
+	}
+	if i < 0 {
+		return nil, -1
+	}
+	return m.exchanges[i].responses, i
+}
+
+// find returns the index of the first exchange from cursor on, wrapping around, that matches.
+func (m *MockResponder) find(cursor int, match func(e *mockExchange) bool) int {
+	n := len(m.exchanges)
+	if cursor < 0 || cursor >= n {
+		cursor = 0
+	}
+	for k := 0; k < n; k++ {
+		i := (cursor + k) % n
+		if match(m.exchanges[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mirIdent returns the Ident of the Mir header of a message, or -1 when it has none.
+func mirIdent(message []byte) int {
+	packet, err := codec.DecodePacket(message)
+	if err != nil || packet.Message == nil {
+		return -1
+	}
+	return int(packet.Message.Ident)
+}
+
+// maskEqual compares two messages of the same length on the bits set in mask.
+func maskEqual(a []byte, b []byte, mask []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a) && i < len(mask); i++ {
+		if a[i]&mask[i] != b[i]&mask[i] {
+			return false
+		}
+	}
+	return true
+}
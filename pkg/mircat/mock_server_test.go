@@ -0,0 +1,117 @@
+package mircat
+
+import (
+	"mir-cat/pkg/codec"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// recordMock writes a session where the server greets, then answers "#1a!" twice with
+// different responses and a login packet once.
+func recordMock(t *testing.T) (string, []byte) {
+	t.Helper()
+	login := codec.EncodeMessage(codec.DefaultMessage{Ident: 2001}, []byte("user"))
+	f := newPcapFile(65535)
+	f.add(true, 100, tcpFlagSyn, "")
+	f.add(false, 900, tcpFlagSyn|tcpFlagAck, "")
+	f.add(false, 901, tcpFlagPsh|tcpFlagAck, "#hi!")
+	f.add(true, 101, tcpFlagPsh|tcpFlagAck, "#1a!")
+	f.add(false, 905, tcpFlagPsh|tcpFlagAck, "#first!")
+	f.add(true, 105, tcpFlagPsh|tcpFlagAck, "#1a!")
+	f.add(false, 912, tcpFlagPsh|tcpFlagAck, "#second!")
+	f.add(true, 109, tcpFlagPsh|tcpFlagAck, string(login))
+	f.add(false, 920, tcpFlagPsh|tcpFlagAck, "#ok!")
+	path := filepath.Join(t.TempDir(), "recorded.pcap")
+	if err := os.WriteFile(path, f.data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, login
+}
+
+func answer(m *MockResponder, request []byte, cursor int) (string, int) {
+	responses, i := m.Answer(request, cursor)
+	got := ""
+	for _, response := range responses {
+		got += string(response)
+	}
+	return got, i
+}
+
+func TestMockResponderExact(t *testing.T) {
+	path, login := recordMock(t)
+	m, err := NewMockResponder(MockConfig{Path: path}, FramerConfig{Type: FRAMER_DELIMITER, Delimiter: "!"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Exchanges() != 3 || len(m.Greeting()) != 1 || string(m.Greeting()[0]) != "#hi!" {
+		t.Fatalf("%d exchanges, greeting %q", m.Exchanges(), m.Greeting())
+	}
+
+	// repeated requests are answered in their recorded order, then wrap around
+	got, i := answer(m, []byte("#1a!"), 0)
+	if got != "#first!" || i != 0 {
+		t.Fatalf("first answer %q at %d", got, i)
+	}
+	if got, i = answer(m, []byte("#1a!"), i+1); got != "#second!" || i != 1 {
+		t.Fatalf("second answer %q at %d", got, i)
+	}
+	if got, i = answer(m, []byte("#1a!"), i+1); got != "#first!" || i != 0 {
+		t.Fatalf("third answer %q at %d", got, i)
+	}
+	if got, i = answer(m, login, 0); got != "#ok!" || i != 2 {
+		t.Fatalf("login answer %q at %d", got, i)
+	}
+
+	// exact mode does not answer another login
+	other := codec.EncodeMessage(codec.DefaultMessage{Ident: 2001}, []byte("other"))
+	if _, i := m.Answer(other, 0); i != -1 {
+		t.Fatalf("answered with exchange %d", i)
+	}
+}
+
+func TestMockResponderLooseMatches(t *testing.T) {
+	path, login := recordMock(t)
+	framer := FramerConfig{Type: FRAMER_DELIMITER, Delimiter: "!"}
+
+	opcode, err := NewMockResponder(MockConfig{Path: path, Match: MOCK_MATCH_OPCODE}, framer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := codec.EncodeMessage(codec.DefaultMessage{Ident: 2001}, []byte("other"))
+	if got, i := answer(opcode, other, 0); got != "#ok!" || i != 2 {
+		t.Fatalf("opcode answer %q at %d", got, i)
+	}
+	if _, i := opcode.Answer([]byte("#1b!"), 0); i != -1 {
+		t.Fatalf("answered a message without a header with exchange %d", i)
+	}
+
+	// the mask compares the first two bytes only
+	mask, err := NewMockResponder(MockConfig{Path: path, Match: MOCK_MATCH_MASK, Mask: "ff ff"}, framer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, i := answer(mask, []byte("#1z!"), 0); got != "#first!" || i != 0 {
+		t.Fatalf("mask answer %q at %d", got, i)
+	}
+	if _, i := mask.Answer([]byte("#1zz!"), 0); i != -1 {
+		t.Fatalf("answered a longer message with exchange %d", i)
+	}
+	// exact matches win over the mask
+	if got, _ := answer(mask, login, 0); got != "#ok!" {
+		t.Fatalf("login answer %q", got)
+	}
+}
+
+func TestNewMockResponderRejectsBadSettings(t *testing.T) {
+	path, _ := recordMock(t)
+	for _, cfg := range []MockConfig{
+		{Path: path, Match: "fuzzy"},
+		{Path: path, Match: MOCK_MATCH_MASK, Mask: "zz"},
+		{Path: path, Filter: PcapFilter{Server: "10.0.0.9:1"}},
+	} {
+		if _, err := NewMockResponder(cfg, FramerConfig{}); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,607 @@
+package mircat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"mir-cat/pkg/codec"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	linkTypeNull     = 0
+	linkTypeEthernet = 1
+	linkTypeRaw      = 101
+	linkTypeRawAlt1  = 12
+	linkTypeRawAlt2  = 14
+	linkTypeLoop     = 108
+	linkTypeSll      = 113
+	linkTypeSll2     = 276
+
+	// reassemblyWindow is how many out-of-order segments are buffered before a gap is skipped.
+	reassemblyWindow = 1024
+)
+
+// PcapFlow describes a TCP connection found in a capture file.
+type PcapFlow struct {
+	// Client is the address of the side that opened the connection.
+	Client string `json:"client"`
+	// Server is the address of the side that accepted the connection.
+	Server string `json:"server"`
+	// Start is the time of the first packet in unix milliseconds.
+	Start int64 `json:"start"`
+	// End is the time of the last packet in unix milliseconds.
+	End int64 `json:"end"`
+	// Packets is the number of TCP packets of the flow.
+	Packets int `json:"packets"`
+	// ClientBytes is the size of the reassembled client stream.
+	ClientBytes int `json:"clientBytes"`
+	// ServerBytes is the size of the reassembled server stream.
+	ServerBytes int `json:"serverBytes"`
+	// MissingBytes counts the stream bytes the capture cut off with its snapshot length,
+	// the data around them is kept with a hole.
+	MissingBytes int `json:"missingBytes"`
+}
+
+// PcapFilter selects flows and packets of a capture file.
+type PcapFilter struct {
+	// Client matches the client address (ip:port), empty matches any.
+	Client string `json:"client"`
+	// Server matches the server address (ip:port), empty matches any.
+	Server string `json:"server"`
+	// From skips packets before this unix millisecond time, 0 means no limit.
+	From int64 `json:"from"`
+	// To skips packets after this unix millisecond time, 0 means no limit.
+	To int64 `json:"to"`
+}
+
+// StreamChunk is a piece of a reassembled TCP stream.
+type StreamChunk struct {
+	// Direction is DIRECTION_SRC for client data and DIRECTION_DST for server data.
+	Direction string
+	Time      time.Time
+	Data      []byte
+}
+
+// CapturedSession is a reassembled TCP connection, its chunks are ordered by time.
+type CapturedSession struct {
+	Flow   PcapFlow
+	Chunks []StreamChunk
+}
+
+type pcapPacket struct {
+	time     time.Time
+	linkType int
+	data     []byte
+	// origLen is the length of the packet on the wire, longer than data when the snapshot length cut it.
+	origLen int
+}
+
+type tcpSegment struct {
+	time    time.Time
+	src     *net.TCPAddr
+	dst     *net.TCPAddr
+	seq     uint32
+	flags   byte
+	payload []byte
+	// missing is the number of payload bytes cut off by the snapshot length.
+	missing int
+}
+
+// readPcap reads the packets of a pcap or pcapng file.
+func readPcap(path string) ([]pcapPacket, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("%s is not a capture file", path)
+	}
+	if binary.LittleEndian.Uint32(data) == pcapngSectionHeader {
+		return readPcapng(data)
+	}
+	return readClassicPcap(data)
+}
+
+func readClassicPcap(data []byte) ([]pcapPacket, error) {
+	if len(data) < 24 {
+		return nil, fmt.Errorf("truncated pcap header")
+	}
+	var order binary.ByteOrder
+	nano := false
+	switch binary.LittleEndian.Uint32(data) {
+	case 0xa1b2c3d4:
+		order = binary.LittleEndian
+	case 0xa1b23c4d:
+		order, nano = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		order = binary.BigEndian
+	case 0x4d3cb2a1:
+		order, nano = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("unknown capture file format")
+	}
+	linkType := int(order.Uint32(data[20:]) & 0x0FFFFFFF)
+	packets := []pcapPacket{}
+	for offset := 24; offset+16 <= len(data); {
+		sec := int64(order.Uint32(data[offset:]))
+		frac := int64(order.Uint32(data[offset+4:]))
+		capLen := int(order.Uint32(data[offset+8:]))
+		origLen := int(order.Uint32(data[offset+12:]))
+		offset += 16
+		if offset+capLen > len(data) {
+			break
+		}
+		ts := time.Unix(sec, frac*1000)
+		if nano {
+			ts = time.Unix(sec, frac)
+		}
+		packets = append(packets, pcapPacket{time: ts, linkType: linkType, data: data[offset : offset+capLen], origLen: origLen})
+		offset += capLen
+	}
+	return packets, nil
+}
+
+type pcapngInterfaceInfo struct {
+	linkType int
+	// units is the number of timestamp units per second.
+	units uint64
+}
+
+func readPcapng(data []byte) ([]pcapPacket, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	interfaces := []pcapngInterfaceInfo{}
+	packets := []pcapPacket{}
+	for offset := 0; offset+12 <= len(data); {
+		blockType := order.Uint32(data[offset:])
+		if blockType == pcapngSectionHeader {
+			if offset+12 > len(data) {
+				break
+			}
+			if binary.LittleEndian.Uint32(data[offset+8:]) == pcapngByteOrderMagic {
+				order = binary.LittleEndian
+			} else {
+				order = binary.BigEndian
+			}
+			interfaces = interfaces[:0]
+		}
+		length := int(order.Uint32(data[offset+4:]))
+		if length < 12 || offset+length > len(data) {
+			break
+		}
+		body := data[offset+8 : offset+length-4]
+		switch blockType {
+		case pcapngInterface:
+			if len(body) < 8 {
+				break
+			}
+			info := pcapngInterfaceInfo{linkType: int(order.Uint16(body)), units: 1000000}
+			for opts := body[8:]; len(opts) >= 4; {
+				code, size := order.Uint16(opts), int(order.Uint16(opts[2:]))
+				if code == pcapngOptEnd || 4+size > len(opts) {
+					break
+				}
+				if code == pcapngOptIfTsResol && size >= 1 {
+					resol := opts[4]
+					if resol&0x80 != 0 {
+						info.units = 1 << (resol & 0x7F)
+					} else {
+						info.units = 1
+						for i := byte(0); i < resol; i++ {
+							info.units *= 10
+						}
+					}
+				}
+				opts = opts[4+(size+3)/4*4:]
+			}
+			interfaces = append(interfaces, info)
+		case pcapngEnhancedPacket:
+			if len(body) < 20 {
+				break
+			}
+			id := int(order.Uint32(body))
+			if id >= len(interfaces) {
+				break
+			}
+			ts := uint64(order.Uint32(body[4:]))<<32 | uint64(order.Uint32(body[8:]))
+			capLen := int(order.Uint32(body[12:]))
+			if 20+capLen > len(body) {
+				break
+			}
+			units := interfaces[id].units
+			packets = append(packets, pcapPacket{
+				time:     time.Unix(int64(ts/units), int64(ts%units*1000000000/units)),
+				linkType: interfaces[id].linkType,
+				data:     body[20 : 20+capLen],
+				origLen:  int(order.Uint32(body[16:])),
+			})
+		case 3: // simple packet block, it has no timestamp
+			if len(body) < 4 || len(interfaces) == 0 {
+				break
+			}
+			capLen := len(body) - 4
+			orig := int(order.Uint32(body))
+			if orig < capLen {
+				capLen = orig
+			}
+			packets = append(packets, pcapPacket{linkType: interfaces[0].linkType, data: body[4 : 4+capLen], origLen: orig})
+		}
+		offset += length
+	}
+	return packets, nil
+}
+
+// parseSegment extracts the TCP segment of a captured packet.
+func parseSegment(packet pcapPacket) *tcpSegment {
+	data := packet.data
+	etherType := 0
+	switch packet.linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil
+		}
+		etherType = int(binary.BigEndian.Uint16(data[12:]))
+		data = data[14:]
+		for (etherType == 0x8100 || etherType == 0x88A8) && len(data) >= 4 {
+			etherType = int(binary.BigEndian.Uint16(data[2:]))
+			data = data[4:]
+		}
+	case linkTypeNull, linkTypeLoop:
+		if len(data) < 4 {
+			return nil
+		}
+		data = data[4:]
+	case linkTypeRaw, linkTypeRawAlt1, linkTypeRawAlt2:
+	case linkTypeSll:
+		if len(data) < 16 {
+			return nil
+		}
+		etherType = int(binary.BigEndian.Uint16(data[14:]))
+		data = data[16:]
+	case linkTypeSll2:
+		if len(data) < 20 {
+			return nil
+		}
+		etherType = int(binary.BigEndian.Uint16(data[0:]))
+		data = data[20:]
+	default:
+		return nil
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	if etherType == 0 {
+		switch data[0] >> 4 {
+		case 4:
+			etherType = 0x0800
+		case 6:
+			etherType = 0x86DD
+		}
+	}
+
+	var srcIP, dstIP net.IP
+	switch etherType {
+	case 0x0800:
+		if len(data) < 20 || data[9] != 6 {
+			return nil
+		}
+		ihl := int(data[0]&0x0F) * 4
+		total := int(binary.BigEndian.Uint16(data[2:]))
+		if ihl < 20 || total < ihl || len(data) < ihl {
+			return nil
+		}
+		if total < len(data) {
+			data = data[:total]
+		}
+		srcIP, dstIP = net.IP(data[12:16]), net.IP(data[16:20])
+		data = data[ihl:]
+	case 0x86DD:
+		if len(data) < 40 {
+			return nil
+		}
+		next := data[6]
+		payloadLen := int(binary.BigEndian.Uint16(data[4:]))
+		srcIP, dstIP = net.IP(data[8:24]), net.IP(data[24:40])
+		data = data[40:]
+		if payloadLen < len(data) {
+			data = data[:payloadLen]
+		}
+		for next == 0 || next == 43 || next == 60 {
+			if len(data) < 8 {
+				return nil
+			}
+			size := (int(data[1]) + 1) * 8
+			if size > len(data) {
+				return nil
+			}
+			next = data[0]
+			data = data[size:]
+		}
+		if next != 6 {
+			return nil
+		}
+	default:
+		return nil
+	}
+
+	if len(data) < 20 {
+		return nil
+	}
+	offset := int(data[12]>>4) * 4
+	if offset < 20 || offset > len(data) {
+		return nil
+	}
+	segment := &tcpSegment{
+		time:    packet.time,
+		src:     &net.TCPAddr{IP: srcIP, Port: int(binary.BigEndian.Uint16(data[0:]))},
+		dst:     &net.TCPAddr{IP: dstIP, Port: int(binary.BigEndian.Uint16(data[2:]))},
+		seq:     binary.BigEndian.Uint32(data[4:]),
+		flags:   data[13],
+		payload: data[offset:],
+	}
+	// the snapshot length cuts the end of the packet, that is the end of the payload
+	if packet.origLen > len(packet.data) {
+		segment.missing = packet.origLen - len(packet.data)
+	}
+	return segment
+}
+
+// streamReassembler orders the segments of one direction of a TCP connection.
+type streamReassembler struct {
+	started bool
+	next    uint32
+	pending map[uint32]*tcpSegment
+	// missing counts the bytes skipped because the snapshot length cut them off.
+	missing int
+}
+
+// add returns the segments that became contiguous, trimmed of retransmitted bytes. The bytes a segment
+// misses because of the snapshot length are skipped, so the stream goes on after them.
+func (r *streamReassembler) add(segment *tcpSegment) []*tcpSegment {
+	if !r.started {
+		r.started = true
+		r.next = segment.seq
+		r.pending = make(map[uint32]*tcpSegment)
+		if segment.flags&tcpFlagSyn != 0 {
+			r.next++
+			return nil
+		}
+	}
+	if len(segment.payload)+segment.missing == 0 {
+		return nil
+	}
+	r.pending[segment.seq] = segment
+	delivered := r.drain()
+	if len(r.pending) > reassemblyWindow {
+		delivered = append(delivered, r.skipGap()...)
+	}
+	return delivered
+}
+
+func (r *streamReassembler) drain() []*tcpSegment {
+	delivered := []*tcpSegment{}
+	for len(r.pending) > 0 {
+		progress := false
+		for seq, segment := range r.pending {
+			diff := int32(r.next - seq)
+			if diff < 0 {
+				continue
+			}
+			delete(r.pending, seq)
+			size := len(segment.payload) + segment.missing
+			if int(diff) >= size {
+				progress = true
+				continue
+			}
+			kept := 0
+			if int(diff) < len(segment.payload) {
+				trimmed := *segment
+				trimmed.payload = segment.payload[diff:]
+				delivered = append(delivered, &trimmed)
+				kept = len(trimmed.payload)
+			}
+			r.missing += size - int(diff) - kept
+			r.next += uint32(size - int(diff))
+			progress = true
+		}
+		if !progress {
+			break
+		}
+	}
+	return delivered
+}
+
+// skipGap jumps over missing data to the closest buffered segment.
+func (r *streamReassembler) skipGap() []*tcpSegment {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	closest := uint32(0)
+	first := true
+	for seq := range r.pending {
+		if first || int32(seq-r.next) < int32(closest-r.next) {
+			closest = seq
+			first = false
+		}
+	}
+	r.next = closest
+	return r.drain()
+}
+
+// flush delivers the buffered segments, skipping the gaps between them.
+func (r *streamReassembler) flush() []*tcpSegment {
+	delivered := []*tcpSegment{}
+	for len(r.pending) > 0 {
+		delivered = append(delivered, r.skipGap()...)
+	}
+	return delivered
+}
+
+type flowState struct {
+	session *CapturedSession
+	client  string
+	streams map[string]*streamReassembler
+}
+
+// LoadPcap reads a pcap or pcapng file and reassembles the TCP connections matching the filter.
+func LoadPcap(path string, filter PcapFilter) ([]*CapturedSession, error) {
+	packets, err := readPcap(path)
+	if err != nil {
+		return nil, err
+	}
+	flows := map[string]*flowState{}
+	order := []*flowState{}
+	for _, packet := range packets {
+		segment := parseSegment(packet)
+		if segment == nil {
+			continue
+		}
+		ms := segment.time.UnixMilli()
+		if (filter.From > 0 && ms < filter.From) || (filter.To > 0 && ms > filter.To) {
+			continue
+		}
+		src, dst := segment.src.String(), segment.dst.String()
+		key := src + "|" + dst
+		if src > dst {
+			key = dst + "|" + src
+		}
+		flow, ok := flows[key]
+		if !ok {
+			client, server := src, dst
+			isSyn := segment.flags&tcpFlagSyn != 0
+			isSynAck := isSyn && segment.flags&tcpFlagAck != 0
+			if isSynAck || (!isSyn && segment.src.Port < segment.dst.Port) {
+				client, server = dst, src
+			}
+			flow = &flowState{
+				session: &CapturedSession{Flow: PcapFlow{Client: client, Server: server, Start: ms}},
+				client:  client,
+				streams: map[string]*streamReassembler{DIRECTION_SRC: {}, DIRECTION_DST: {}},
+			}
+			flows[key] = flow
+			order = append(order, flow)
+		}
+		session := flow.session
+		session.Flow.Packets++
+		session.Flow.End = ms
+		direction := DIRECTION_DST
+		if src == flow.client {
+			direction = DIRECTION_SRC
+		}
+		// the data is in the stream once the packet completing it arrives
+		for _, delivered := range flow.streams[direction].add(segment) {
+			session.Chunks = append(session.Chunks, StreamChunk{Direction: direction, Time: segment.time, Data: delivered.payload})
+		}
+	}
+
+	sessions := []*CapturedSession{}
+	for _, flow := range order {
+		session := flow.session
+		if (filter.Client != "" && filter.Client != session.Flow.Client) || (filter.Server != "" && filter.Server != session.Flow.Server) {
+			continue
+		}
+		for _, direction := range []string{DIRECTION_SRC, DIRECTION_DST} {
+			last := time.Time{}
+			for _, chunk := range session.Chunks {
+				if chunk.Direction == direction && chunk.Time.After(last) {
+					last = chunk.Time
+				}
+			}
+			// what is left after a gap follows the data already delivered
+			for _, delivered := range flow.streams[direction].flush() {
+				if delivered.time.After(last) {
+					last = delivered.time
+				}
+				session.Chunks = append(session.Chunks, StreamChunk{Direction: direction, Time: last, Data: delivered.payload})
+			}
+			session.Flow.MissingBytes += flow.streams[direction].missing
+		}
+		sort.SliceStable(session.Chunks, func(i, j int) bool { return session.Chunks[i].Time.Before(session.Chunks[j].Time) })
+		for _, chunk := range session.Chunks {
+			if chunk.Direction == DIRECTION_SRC {
+				session.Flow.ClientBytes += len(chunk.Data)
+			} else {
+				session.Flow.ServerBytes += len(chunk.Data)
+			}
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// PcapFlows lists the TCP connections of a capture file.
+func PcapFlows(path string) ([]PcapFlow, error) {
+	sessions, err := LoadPcap(path, PcapFilter{})
+	if err != nil {
+		return nil, err
+	}
+	flows := make([]PcapFlow, 0, len(sessions))
+	for _, session := range sessions {
+		flows = append(flows, session.Flow)
+	}
+	return flows, nil
+}
+
+// StreamMessage is a framed message of a recorded session.
+type StreamMessage struct {
+	Direction string
+	Time      time.Time
+	Data      []byte
+}
+
+// frameSession cuts the reassembled streams of a session into messages, in the order they were captured.
+// Framing errors are reported to onError, the unframed bytes are kept as a message.
+func frameSession(session *CapturedSession, srcFramer FramerConfig, dstFramer FramerConfig, onError func(err error)) ([]StreamMessage, error) {
+	framers := map[string]Framer{}
+	var err error
+	if framers[DIRECTION_SRC], err = NewFramer(srcFramer); err != nil {
+		return nil, err
+	}
+	if framers[DIRECTION_DST], err = NewFramer(dstFramer); err != nil {
+		return nil, err
+	}
+	messages := []StreamMessage{}
+	for _, chunk := range session.Chunks {
+		frames, err := framers[chunk.Direction].Feed(chunk.Data)
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		for _, frame := range frames {
+			messages = append(messages, StreamMessage{Direction: chunk.Direction, Time: chunk.Time, Data: frame})
+		}
+	}
+	if len(session.Chunks) > 0 {
+		// the incomplete messages left at the end of the capture are kept as they are
+		end := session.Chunks[len(session.Chunks)-1].Time
+		for _, direction := range []string{DIRECTION_SRC, DIRECTION_DST} {
+			if rest := framers[direction].Flush(); len(rest) > 0 {
+				messages = append(messages, StreamMessage{Direction: direction, Time: end, Data: rest})
+			}
+		}
+	}
+	return messages, nil
+}
+
+// emitCapturedSession feeds a reassembled session through the framers and
+// decoders of the transfer and emits it as transfer-src-data / transfer-dst-data events.
+// The decoded packets also go to the tracker when there is one.
+func emitCapturedSession(events EventSink, tracker *GameTracker, session *CapturedSession, srcFramer FramerConfig, dstFramer FramerConfig) error {
+	clientKey := session.Flow.Client
+	messages, err := frameSession(session, srcFramer, dstFramer, func(err error) {
+		events.Emit("transfer-tcp-error", clientKey, fmt.Sprintf("%v", err))
+	})
+	if err != nil {
+		return err
+	}
+	decoders := map[string]*codec.Decoder{DIRECTION_SRC: {Direction: codec.FROM_CLIENT}, DIRECTION_DST: {Direction: codec.FROM_SERVER}}
+	for _, message := range messages {
+		packets := decoders[message.Direction].Feed(message.Data)
+		events.Emit("transfer-"+message.Direction+"-data", clientKey, message.Data, packets)
+		if tracker != nil {
+			tracker.Feed(clientKey, packets)
+		}
+	}
+	return nil
+}
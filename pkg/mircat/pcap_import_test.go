@@ -0,0 +1,156 @@
+package mircat
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pcapFile builds a classic pcap file of raw IPv4 packets between 10.0.0.1 and 10.0.0.2.
+type pcapFile struct {
+	data    []byte
+	snaplen int
+	time    uint32
+}
+
+func newPcapFile(snaplen int) *pcapFile {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header, 0xa1b2c3d4)
+	binary.LittleEndian.PutUint32(header[16:], uint32(snaplen))
+	binary.LittleEndian.PutUint32(header[20:], linkTypeRaw)
+	return &pcapFile{data: header, snaplen: snaplen}
+}
+
+// add records a segment, fromClient sends it from 10.0.0.1:50000 to 10.0.0.2:7000.
+func (f *pcapFile) add(fromClient bool, seq uint32, flags byte, payload string) {
+	packet := make([]byte, 40, 40+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:], uint16(40+len(payload)))
+	packet[9] = 6
+	src, dst, srcPort, dstPort := []byte{10, 0, 0, 1}, []byte{10, 0, 0, 2}, 50000, 7000
+	if !fromClient {
+		src, dst, srcPort, dstPort = dst, src, dstPort, srcPort
+	}
+	copy(packet[12:], src)
+	copy(packet[16:], dst)
+	binary.BigEndian.PutUint16(packet[20:], uint16(srcPort))
+	binary.BigEndian.PutUint16(packet[22:], uint16(dstPort))
+	binary.BigEndian.PutUint32(packet[24:], seq)
+	packet[32] = 5 << 4
+	packet[33] = flags
+	packet = append(packet, payload...)
+
+	origLen := len(packet)
+	if len(packet) > f.snaplen {
+		packet = packet[:f.snaplen]
+	}
+	f.time++
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], f.time)
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(origLen))
+	f.data = append(append(f.data, record...), packet...)
+}
+
+func (f *pcapFile) load(t *testing.T, filter PcapFilter) []*CapturedSession {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	if err := os.WriteFile(path, f.data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := LoadPcap(path, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessions
+}
+
+func chunkString(chunks []StreamChunk) string {
+	parts := []string{}
+	for _, chunk := range chunks {
+		parts = append(parts, chunk.Direction+":"+string(chunk.Data))
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestLoadPcapReassemblesStreams(t *testing.T) {
+	f := newPcapFile(65535)
+	f.add(true, 100, tcpFlagSyn, "")
+	f.add(false, 900, tcpFlagSyn|tcpFlagAck, "")
+	f.add(true, 101, tcpFlagPsh|tcpFlagAck, "#1ab")
+	// out of order, then a retransmission that overlaps what was delivered
+	f.add(true, 107, tcpFlagPsh|tcpFlagAck, "#2")
+	f.add(true, 105, tcpFlagPsh|tcpFlagAck, "c!")
+	f.add(true, 103, tcpFlagPsh|tcpFlagAck, "abc!")
+	f.add(false, 901, tcpFlagPsh|tcpFlagAck, "#ok!")
+
+	sessions := f.load(t, PcapFilter{})
+	if len(sessions) != 1 {
+		t.Fatalf("%d sessions", len(sessions))
+	}
+	session := sessions[0]
+	if session.Flow.Client != "10.0.0.1:50000" || session.Flow.Server != "10.0.0.2:7000" || session.Flow.Packets != 7 {
+		t.Fatalf("flow %+v", session.Flow)
+	}
+	if got := chunkString(session.Chunks); got != "src:#1ab src:c! src:#2 dst:#ok!" {
+		t.Fatalf("chunks %s", got)
+	}
+	if session.Flow.ClientBytes != 8 || session.Flow.ServerBytes != 4 {
+		t.Fatalf("flow %+v", session.Flow)
+	}
+
+	messages, err := frameSession(session, FramerConfig{Type: FRAMER_DELIMITER, Delimiter: "!"}, FramerConfig{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, message := range messages {
+		got = append(got, string(message.Data))
+	}
+	// the incomplete message left at the end is kept
+	if strings.Join(got, " ") != "#1abc! #ok! #2" {
+		t.Fatalf("messages %q", got)
+	}
+}
+
+func TestLoadPcapSkipsSnapshotGaps(t *testing.T) {
+	f := newPcapFile(60)
+	f.add(true, 1000, tcpFlagPsh|tcpFlagAck, "hello")
+	// only the first 20 of 36 payload bytes fit the snapshot
+	f.add(true, 1005, tcpFlagPsh|tcpFlagAck, "0123456789abcdefghijklmnopqrstuvwxyz")
+	f.add(true, 1041, tcpFlagPsh|tcpFlagAck, "world")
+
+	sessions := f.load(t, PcapFilter{})
+	if len(sessions) != 1 {
+		t.Fatalf("%d sessions", len(sessions))
+	}
+	if got := chunkString(sessions[0].Chunks); got != "src:hello src:0123456789abcdefghij src:world" {
+		t.Fatalf("chunks %s", got)
+	}
+	// without a SYN the lower port is taken as the server
+	if flow := sessions[0].Flow; flow.MissingBytes != 16 || flow.Client != "10.0.0.1:50000" {
+		t.Fatalf("flow %+v", flow)
+	}
+}
+
+func TestLoadPcapFilter(t *testing.T) {
+	f := newPcapFile(65535)
+	f.add(true, 1, tcpFlagPsh|tcpFlagAck, "first")
+	f.add(true, 6, tcpFlagPsh|tcpFlagAck, "second")
+
+	if sessions := f.load(t, PcapFilter{Client: "10.0.0.9:50000"}); len(sessions) != 0 {
+		t.Fatalf("sessions %+v", sessions)
+	}
+	sessions := f.load(t, PcapFilter{Server: "10.0.0.2:7000", From: 2000})
+	if len(sessions) != 1 || chunkString(sessions[0].Chunks) != "src:second" {
+		t.Fatalf("sessions %+v", sessions)
+	}
+
+	path := filepath.Join(t.TempDir(), "empty.pcap")
+	os.WriteFile(path, []byte("not a capture"), 0644)
+	if _, err := LoadPcap(path, PcapFilter{}); err == nil {
+		t.Fatal("loaded a file that is not a capture")
+	}
+}
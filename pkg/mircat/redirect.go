@@ -0,0 +1,230 @@
+package mircat
+
+import (
+	"bytes"
+	"fmt"
+	"mir-cat/pkg/codec"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// SM_STARTPLAY hands the client the address of the game server, its body is "ip/port".
+	SM_STARTPLAY = 525
+	// SM_SELECTSERVER_OK hands the client the address of the character-select server, its body is "ip/port/certification".
+	SM_SELECTSERVER_OK = 530
+)
+
+// RedirectConfig makes a transfer follow the server hops of a multi-stage login. Messages of the
+// server carrying the address of the next server are rewritten to point at a new transfer listener
+// relaying to that address, so the client stays behind the proxy chain.
+type RedirectConfig struct {
+	// Enabled turns redirect rewriting on.
+	Enabled bool `json:"enabled"`
+	// Idents are the Mir messages whose body starts with "ip/port", SM_SELECTSERVER_OK and SM_STARTPLAY when empty.
+	// The transfer relays the framed messages of the server rather than its raw reads, and holds back a packet
+	// cut across reads until its end arrives, so it is rewritten whole.
+	Idents []int `json:"idents"`
+	// Pattern is a regular expression matched against the raw server messages, for protocols other than Mir.
+	// Its first group is the host and its second group the port, both are replaced. An address cut across
+	// messages is not found, the dst framer has to keep it in one message.
+	Pattern string `json:"pattern"`
+	// ListenAddr is the address the new listeners bind to, the source address of the transfer when empty.
+	ListenAddr string `json:"listenAddr"`
+	// AdvertiseAddr is the host written into the rewritten messages, ListenAddr when empty,
+	// or 127.0.0.1 when that is unspecified.
+	AdvertiseAddr string `json:"advertiseAddr"`
+}
+
+// redirector rewrites the address-carrying messages of the transfers of a proxy chain. Every
+// address is relayed by a single listener, shared by the transfers of the chain.
+type redirector struct {
+	idents  map[uint16]bool
+	pattern *regexp.Regexp
+	host    string
+	// spawn starts a transfer relaying to target, unless it is running, and returns the address it listens on.
+	spawn func(target string) (string, error)
+	// owner is the transfer the chain starts from, stopping it stops the spawned transfers.
+	owner   *TCPTransfer
+	mutex   sync.Mutex
+	proxied map[string]string
+}
+
+func newRedirector(cfg RedirectConfig, srcAddr string, spawn func(target string) (string, error)) (*redirector, error) {
+	r := &redirector{
+		idents:  map[uint16]bool{},
+		spawn:   spawn,
+		proxied: map[string]string{},
+	}
+	idents := cfg.Idents
+	if len(idents) == 0 {
+		idents = []int{SM_SELECTSERVER_OK, SM_STARTPLAY}
+	}
+	for _, ident := range idents {
+		r.idents[uint16(ident)] = true
+	}
+	if cfg.Pattern != "" {
+		var err error
+		r.pattern, err = regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redirect pattern: %v", err)
+		}
+		if r.pattern.NumSubexp() < 2 {
+			return nil, fmt.Errorf("redirect pattern needs a host and a port group")
+		}
+	}
+	r.host = cfg.AdvertiseAddr
+	if r.host == "" {
+		r.host = cfg.ListenAddr
+	}
+	if r.host == "" {
+		r.host = srcAddr
+	}
+	if ip := net.ParseIP(r.host); r.host == "" || (ip != nil && ip.IsUnspecified()) {
+		r.host = "127.0.0.1"
+	}
+	return r, nil
+}
+
+// proxy returns the local address relaying to target, starting the transfer the first time and again
+// when it was stopped meanwhile, so the client is never sent to a dead listener.
+func (r *redirector) proxy(target string) (string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	local, err := r.spawn(target)
+	if err != nil {
+		delete(r.proxied, target)
+		return "", err
+	}
+	r.proxied[target] = local
+	return local, nil
+}
+
+// release returns the targets relayed by the spawned transfers and forgets them.
+func (r *redirector) release() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	targets := make([]string, 0, len(r.proxied))
+	for target := range r.proxied {
+		targets = append(targets, target)
+	}
+	r.proxied = map[string]string{}
+	return targets
+}
+
+// rewrite returns the message with the server addresses replaced by proxy addresses, and the
+// addresses that were replaced as "target -> local" descriptions.
+func (r *redirector) rewrite(message []byte) ([]byte, []string, error) {
+	rewritten := []string{}
+	if r.pattern != nil {
+		var err error
+		message, err = r.rewritePattern(message, &rewritten)
+		if err != nil {
+			return message, rewritten, err
+		}
+	}
+	if bytes.IndexByte(message, codec.PACKET_START) < 0 {
+		return message, rewritten, nil
+	}
+	out := bytes.Buffer{}
+	rest := message
+	for {
+		start := bytes.IndexByte(rest, codec.PACKET_START)
+		if start < 0 {
+			break
+		}
+		end := bytes.IndexByte(rest[start:], codec.PACKET_END)
+		if end < 0 {
+			break
+		}
+		end += start + 1
+		out.Write(rest[:start])
+		packet, err := r.rewritePacket(rest[start:end], &rewritten)
+		if err != nil {
+			return message, rewritten, err
+		}
+		out.Write(packet)
+		rest = rest[end:]
+	}
+	out.Write(rest)
+	return out.Bytes(), rewritten, nil
+}
+
+// cut splits the data of the server at the start of a Mir packet it leaves incomplete, the rest has to wait
+// for the next read. Nothing is held back with a pattern, or beyond codec.MAX_PACKET_SIZE.
+func (r *redirector) cut(data []byte) ([]byte, []byte) {
+	if r.pattern != nil {
+		return data, nil
+	}
+	start := bytes.LastIndexByte(data, codec.PACKET_START)
+	if start < 0 || bytes.IndexByte(data[start:], codec.PACKET_END) >= 0 || len(data)-start > codec.MAX_PACKET_SIZE {
+		return data, nil
+	}
+	return data[:start], data[start:]
+}
+
+// rewritePacket replaces the address at the start of the body of a redirect packet.
+func (r *redirector) rewritePacket(frame []byte, rewritten *[]string) ([]byte, error) {
+	packet, err := codec.DecodePacket(frame)
+	if err != nil || packet.Message == nil || !r.idents[packet.Message.Ident] {
+		return frame, nil
+	}
+	fields := strings.SplitN(string(packet.Body), "/", 3)
+	if len(fields) < 2 {
+		return frame, nil
+	}
+	target := net.JoinHostPort(strings.TrimSpace(fields[0]), strings.TrimSpace(fields[1]))
+	host, port, err := r.local(target)
+	if err != nil {
+		return frame, err
+	}
+	fields[0], fields[1] = host, port
+	*rewritten = append(*rewritten, fmt.Sprintf("%s -> %s", target, net.JoinHostPort(host, port)))
+	return codec.EncodePacket(&codec.Packet{Seq: packet.Seq, Message: packet.Message, Body: []byte(strings.Join(fields, "/"))}), nil
+}
+
+func (r *redirector) rewritePattern(message []byte, rewritten *[]string) ([]byte, error) {
+	matches := r.pattern.FindAllSubmatchIndex(message, -1)
+	if len(matches) == 0 {
+		return message, nil
+	}
+	out := bytes.Buffer{}
+	last := 0
+	for _, m := range matches {
+		if m[2] < 0 || m[4] < 0 || m[4] < m[3] {
+			continue
+		}
+		target := net.JoinHostPort(string(message[m[2]:m[3]]), string(message[m[4]:m[5]]))
+		host, port, err := r.local(target)
+		if err != nil {
+			return message, err
+		}
+		out.Write(message[last:m[2]])
+		out.WriteString(host)
+		out.Write(message[m[3]:m[4]])
+		out.WriteString(port)
+		last = m[5]
+		*rewritten = append(*rewritten, fmt.Sprintf("%s -> %s", target, net.JoinHostPort(host, port)))
+	}
+	out.Write(message[last:])
+	return out.Bytes(), nil
+}
+
+// local returns the advertised host and the port of the proxy relaying to target.
+func (r *redirector) local(target string) (string, string, error) {
+	if _, err := strconv.Atoi(target[strings.LastIndexByte(target, ':')+1:]); err != nil {
+		return "", "", fmt.Errorf("invalid redirect address %s", target)
+	}
+	local, err := r.proxy(target)
+	if err != nil {
+		return "", "", err
+	}
+	_, port, err := net.SplitHostPort(local)
+	if err != nil {
+		return "", "", err
+	}
+	return r.host, port, nil
+}
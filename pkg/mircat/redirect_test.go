@@ -0,0 +1,260 @@
+package mircat
+
+import (
+	"fmt"
+	"mir-cat/pkg/codec"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubSpawn returns a spawn function listening on 9000 plus the number of targets seen so far.
+func stubSpawn(spawned *[]string) func(target string) (string, error) {
+	return func(target string) (string, error) {
+		if strings.HasPrefix(target, "fail") {
+			return "", fmt.Errorf("cannot listen")
+		}
+		*spawned = append(*spawned, target)
+		return fmt.Sprintf("0.0.0.0:%d", 9000+len(*spawned)), nil
+	}
+}
+
+func mirPacket(ident uint16, body string) []byte {
+	return codec.EncodeMessage(codec.DefaultMessage{Ident: ident}, []byte(body))
+}
+
+func TestRedirectRewritePacket(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     RedirectConfig
+		message []byte
+		want    []byte
+		spawned []string
+	}{
+		{"select server", RedirectConfig{}, mirPacket(SM_SELECTSERVER_OK, "10.0.0.1/7100/42"),
+			mirPacket(SM_SELECTSERVER_OK, "127.0.0.1/9001/42"), []string{"10.0.0.1:7100"}},
+		{"start play", RedirectConfig{AdvertiseAddr: "192.168.1.2"}, mirPacket(SM_STARTPLAY, "10.0.0.2/7200"),
+			mirPacket(SM_STARTPLAY, "192.168.1.2/9001"), []string{"10.0.0.2:7200"}},
+		{"listen address", RedirectConfig{ListenAddr: "10.1.1.1"}, mirPacket(SM_STARTPLAY, "10.0.0.2/7200"),
+			mirPacket(SM_STARTPLAY, "10.1.1.1/9001"), []string{"10.0.0.2:7200"}},
+		{"other ident", RedirectConfig{}, mirPacket(1, "10.0.0.1/7100"), mirPacket(1, "10.0.0.1/7100"), nil},
+		{"configured ident", RedirectConfig{Idents: []int{1}}, mirPacket(1, "10.0.0.1/7100"),
+			mirPacket(1, "127.0.0.1/9001"), []string{"10.0.0.1:7100"}},
+		{"packets around", RedirectConfig{}, []byte("*" + string(mirPacket(SM_STARTPLAY, "h/1")) + "#+GOOD!"),
+			[]byte("*" + string(mirPacket(SM_STARTPLAY, "127.0.0.1/9001")) + "#+GOOD!"), []string{"h:1"}},
+		{"incomplete packet", RedirectConfig{}, mirPacket(SM_STARTPLAY, "h/1")[:10], mirPacket(SM_STARTPLAY, "h/1")[:10], nil},
+		{"pattern", RedirectConfig{Pattern: `host=([\w.]+) port=(\d+)`}, []byte("go host=db.local port=5432 now"),
+			[]byte("go host=127.0.0.1 port=9001 now"), []string{"db.local:5432"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spawned := []string{}
+			r, err := newRedirector(tt.cfg, "0.0.0.0", stubSpawn(&spawned))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, rewritten, err := r.rewrite(tt.message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(tt.want) {
+				t.Errorf("rewrote %q, want %q", got, tt.want)
+			}
+			if len(rewritten) != len(tt.spawned) || strings.Join(spawned, ",") != strings.Join(tt.spawned, ",") {
+				t.Errorf("spawned %q, rewritten %q, want %q", spawned, rewritten, tt.spawned)
+			}
+		})
+	}
+}
+
+func TestRedirectRewriteError(t *testing.T) {
+	// a bad port or a listener that cannot start leaves the message as it was
+	for _, message := range [][]byte{mirPacket(SM_STARTPLAY, "10.0.0.1/port"), mirPacket(SM_STARTPLAY, "fail/7100")} {
+		spawned := []string{}
+		r, _ := newRedirector(RedirectConfig{}, "", stubSpawn(&spawned))
+		got, _, err := r.rewrite(message)
+		if err == nil || string(got) != string(message) {
+			t.Errorf("%q rewrote to %q, %v", message, got, err)
+		}
+	}
+}
+
+func TestRedirectInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"(", `host=(\w+)`} {
+		if _, err := newRedirector(RedirectConfig{Pattern: pattern}, "", nil); err == nil {
+			t.Errorf("pattern %q accepted", pattern)
+		}
+	}
+}
+
+func TestRedirectRelease(t *testing.T) {
+	spawned := []string{}
+	r, _ := newRedirector(RedirectConfig{}, "", stubSpawn(&spawned))
+	r.rewrite(mirPacket(SM_SELECTSERVER_OK, "a/1/1"))
+	r.rewrite(mirPacket(SM_STARTPLAY, "b/2"))
+	// every redirect asks spawn again, so a stopped listener is restarted
+	r.rewrite(mirPacket(SM_STARTPLAY, "b/2"))
+	if len(spawned) != 3 {
+		t.Fatalf("spawned %q, want a spawn per redirect", spawned)
+	}
+	targets := r.release()
+	sort.Strings(targets)
+	if strings.Join(targets, ",") != "a:1,b:2" {
+		t.Fatalf("released %q", targets)
+	}
+	if targets := r.release(); len(targets) != 0 {
+		t.Fatalf("released %q again", targets)
+	}
+}
+
+func TestRedirectCut(t *testing.T) {
+	r, _ := newRedirector(RedirectConfig{}, "", nil)
+	packet := string(mirPacket(SM_STARTPLAY, "h/1"))
+	sent, rest := r.cut([]byte("#+GOOD!" + packet[:6]))
+	if string(sent) != "#+GOOD!" || string(rest) != packet[:6] {
+		t.Fatalf("sent %q rest %q", sent, rest)
+	}
+	if sent, rest = r.cut([]byte(packet)); string(sent) != packet || rest != nil {
+		t.Fatalf("a whole packet gave %q and %q", sent, rest)
+	}
+	// other protocols are not held back
+	r, _ = newRedirector(RedirectConfig{Pattern: `(\w+):(\d+)`}, "", nil)
+	if sent, rest = r.cut([]byte("#go db")); string(sent) != "#go db" || rest != nil {
+		t.Fatalf("pattern mode gave %q and %q", sent, rest)
+	}
+}
+
+func TestTCPTransferRewritesSplitRedirect(t *testing.T) {
+	spawned := []string{}
+	redirect, _ := newRedirector(RedirectConfig{}, "127.0.0.1", stubSpawn(&spawned))
+	transfer := NewTCPTransfer(nil, NewEventRecorder())
+	transfer.SetRedirect(redirect)
+	client, server := startTransfer(t, transfer, FramerConfig{}, FramerConfig{})
+
+	// the packet arrives in two reads, the first half waits for the second
+	packet := mirPacket(SM_STARTPLAY, "10.0.0.2/7200")
+	server.Write(packet[:10])
+	silent(t, client)
+	server.Write(append(packet[10:], "#+GOOD!"...))
+	want := string(mirPacket(SM_STARTPLAY, "127.0.0.1/9001")) + "#+GOOD!"
+	if got := receive(t, client, len(want)); got != want {
+		t.Fatalf("client received %q", got)
+	}
+
+	// a packet the server never finishes goes out as it is when the server leaves
+	server.Write([]byte("#unfinished"))
+	silent(t, client)
+	server.Close()
+	if got := receive(t, client, 11); got != "#unfinished" {
+		t.Fatalf("client received %q", got)
+	}
+}
+
+func TestRedirectChain(t *testing.T) {
+	game, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer game.Close()
+	login, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer login.Close()
+	gameHost, gamePort, _ := net.SplitHostPort(game.Addr().String())
+	go func() {
+		conn, err := login.Accept()
+		if err == nil {
+			conn.Write(mirPacket(SM_STARTPLAY, gameHost+"/"+gamePort))
+			defer conn.Close()
+		}
+	}()
+	go func() {
+		conn, err := game.Accept()
+		if err == nil {
+			conn.Write([]byte("#welcome!"))
+			defer conn.Close()
+		}
+	}()
+
+	loginHost, loginPort, _ := net.SplitHostPort(login.Addr().String())
+	rec := NewEventRecorder()
+	m := NewConnManager(NewAppWithSink(rec), &Config{Transfer: TransferConfig{
+		SrcAddr: "127.0.0.1", SrcPort: "0", DstAddr: loginHost, DstPort: loginPort,
+		Redirect: RedirectConfig{Enabled: true},
+	}})
+	if !m.TransferTcpStart() {
+		t.Fatalf("start failed: %+v", rec.Events("transfer-tcp-error"))
+	}
+	client, err := net.Dial("tcp", m.transfer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, 256)
+	n, err := client.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := codec.DecodePacket(b[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Split(string(p.Body), "/")
+	if len(fields) != 2 || fields[0] != "127.0.0.1" || fields[1] == gamePort {
+		t.Fatalf("the client was sent to %q", p.Body)
+	}
+
+	// the client reaches the game server through the spawned transfer, which stops with the first one
+	next, err := net.Dial("tcp", net.JoinHostPort(fields[0], fields[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer next.Close()
+	if got := receive(t, next, 9); got != "#welcome!" {
+		t.Fatalf("client received %q", got)
+	}
+	spawned := m.runningTransfer("redirect " + game.Addr().String())
+	if spawned == nil || spawned.Addr() == nil {
+		t.Fatal("the redirect transfer is not running")
+	}
+	m.TransferTcpStop()
+	if spawned.Addr() != nil {
+		t.Fatal("the redirect transfer outlived its transfer")
+	}
+}
+
+func TestTCPTransferStopEndsSessions(t *testing.T) {
+	transfer := NewTCPTransfer(nil, NewEventRecorder())
+	client, _ := startTransfer(t, transfer, FramerConfig{}, FramerConfig{})
+	transfer.Stop()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.Read(make([]byte, 1)); err == nil {
+		t.Fatal("the session survived the stop")
+	}
+	if transfer.Addr() != nil {
+		t.Fatal("the stopped transfer has an address")
+	}
+	// a broadcast to a stopped transfer is dropped instead of blocking
+	done := make(chan bool)
+	go func() {
+		transfer.BroadcastToClient([]byte("late"))
+		transfer.BroadcastToServer([]byte("late"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the broadcast blocked")
+	}
+
+	// the transfer starts again with a fresh event loop
+	client, server := startTransfer(t, transfer, FramerConfig{}, FramerConfig{})
+	client.Write([]byte("again"))
+	if got := receive(t, server, 5); got != "again" {
+		t.Fatalf("server received %q", got)
+	}
+}
@@ -0,0 +1,234 @@
+package mircat
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// REPLAY_MODE_TIMING sends the client messages with their original spacing.
+	REPLAY_MODE_TIMING = "timing"
+	// REPLAY_MODE_RESPONSE sends the next client message once the recorded number of responses has arrived.
+	REPLAY_MODE_RESPONSE = "response"
+
+	// REPLAY_RESPONSE_TIMEOUT is the default time to wait for responses.
+	REPLAY_RESPONSE_TIMEOUT = 5 * time.Second
+)
+
+// ReplayConfig describes a replay of a recorded session against a server.
+type ReplayConfig struct {
+	// Path is the pcap or pcapng file holding the recorded session.
+	Path string `json:"path"`
+	// Filter selects the recorded session, the first matching connection is replayed.
+	Filter PcapFilter `json:"filter"`
+	// Target is the address of the server to test, empty uses the recorded server.
+	Target string `json:"target"`
+	// Mode is "timing" (default) or "response".
+	Mode string `json:"mode"`
+	// Speed divides the recorded delays in timing mode, 0 means 1.
+	Speed float64 `json:"speed"`
+	// ResponseTimeout is the number of milliseconds to wait for responses, 0 means 5000.
+	ResponseTimeout int `json:"responseTimeout"`
+	// SrcFramer cuts the recorded client stream into the messages to send.
+	SrcFramer FramerConfig `json:"srcFramer"`
+	// DstFramer cuts the recorded and the received server streams into responses.
+	DstFramer FramerConfig `json:"dstFramer"`
+}
+
+// ReplayResult compares the responses to a replayed client message with the recorded ones.
+type ReplayResult struct {
+	// Step is the index of the client message, step 0 without Sent holds what the server sent first.
+	Step     int      `json:"step"`
+	Sent     []byte   `json:"sent"`
+	Expected [][]byte `json:"expected"`
+	Received [][]byte `json:"received"`
+	// Match tells whether the received responses are identical to the recorded ones.
+	Match bool `json:"match"`
+}
+
+type replayStep struct {
+	send []byte
+	// delay is the time between the previous client message, or the start of the session, and send.
+	delay    time.Duration
+	expected [][]byte
+}
+
+// replaySteps groups the server messages of a session behind the client message they answer.
+func replaySteps(messages []StreamMessage) []*replayStep {
+	steps := []*replayStep{{}}
+	var last time.Time
+	if len(messages) > 0 {
+		last = messages[0].Time
+	}
+	for _, message := range messages {
+		if message.Direction == DIRECTION_DST {
+			current := steps[len(steps)-1]
+			current.expected = append(current.expected, message.Data)
+			continue
+		}
+		step := &replayStep{send: message.Data, delay: message.Time.Sub(last)}
+		last = message.Time
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+type Replayer struct {
+	mutex   sync.Mutex
+	stop    chan bool
+	capture *Capture
+	events  EventSink
+}
+
+func NewReplayer(capture *Capture, events EventSink) *Replayer {
+	return &Replayer{
+		capture: capture,
+		events:  events,
+	}
+}
+
+// Start loads the recorded session and replays it in the background.
+func (r *Replayer) Start(cfg ReplayConfig) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stop != nil {
+		return fmt.Errorf("replay already running")
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = REPLAY_MODE_TIMING
+	}
+	if cfg.Mode != REPLAY_MODE_TIMING && cfg.Mode != REPLAY_MODE_RESPONSE {
+		return fmt.Errorf("unknown replay mode %s", cfg.Mode)
+	}
+	if cfg.Speed <= 0 {
+		cfg.Speed = 1
+	}
+	sessions, err := LoadPcap(cfg.Path, cfg.Filter)
+	if err != nil {
+		return err
+	}
+	if len(sessions) == 0 {
+		return fmt.Errorf("no recorded session matches the filter")
+	}
+	messages, err := frameSession(sessions[0], cfg.SrcFramer, cfg.DstFramer, nil)
+	if err != nil {
+		return err
+	}
+	target := cfg.Target
+	if target == "" {
+		target = sessions[0].Flow.Server
+	}
+	if missing := sessions[0].Flow.MissingBytes; missing > 0 {
+		r.events.Emit("replay-error", target, fmt.Sprintf("%d bytes are missing, the capture was cut by its snapshot length", missing))
+	}
+
+	received := make(chan []byte, 1024)
+	closed := make(chan error, 1)
+	done := make(chan bool)
+	client, err := newTcpClient(target, cfg.DstFramer, nil, r.capture, &tcpClientHandler{
+		onData: func(frame []byte) {
+			select {
+			case received <- frame:
+			case <-done:
+			}
+		},
+		onClose: func(err error) { closed <- err },
+	}, r.events)
+	if err != nil {
+		return err
+	}
+	r.stop = make(chan bool)
+	r.events.Emit("replay-info", target, fmt.Sprintf("replaying %s <-> %s from %s", sessions[0].Flow.Client, sessions[0].Flow.Server, cfg.Path))
+	go r.run(cfg, target, client, replaySteps(messages), received, closed, r.stop, done)
+	return nil
+}
+
+// Stop interrupts the running replay.
+func (r *Replayer) Stop() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.stop == nil {
+		return false
+	}
+	close(r.stop)
+	r.stop = nil
+	return true
+}
+
+func (r *Replayer) run(cfg ReplayConfig, target string, client *TcpClient, steps []*replayStep, received chan []byte, closed chan error, stop chan bool, done chan bool) {
+	timeout := REPLAY_RESPONSE_TIMEOUT
+	if cfg.ResponseTimeout > 0 {
+		timeout = time.Duration(cfg.ResponseTimeout) * time.Millisecond
+	}
+	results := make([]*ReplayResult, len(steps))
+	for i, step := range steps {
+		results[i] = &ReplayResult{Step: i, Sent: step.send, Expected: step.expected, Received: [][]byte{}}
+	}
+	passed := 0
+	finish := func(i int) {
+		result := results[i]
+		result.Match = len(result.Expected) == len(result.Received)
+		for j := 0; result.Match && j < len(result.Expected); j++ {
+			result.Match = bytes.Equal(result.Expected[j], result.Received[j])
+		}
+		if result.Match {
+			passed++
+		}
+		r.events.Emit("replay-result", target, *result)
+	}
+	defer func() {
+		close(done)
+		client.Shutdown()
+		r.mutex.Lock()
+		if r.stop == stop {
+			r.stop = nil
+		}
+		r.mutex.Unlock()
+	}()
+
+	// collect attributes responses to the current step until the wait is over.
+	// It returns false when the replay has to end.
+	collect := func(current int, wait <-chan time.Time, untilComplete bool) bool {
+		result := results[current]
+		for {
+			if untilComplete && len(result.Received) >= len(result.Expected) {
+				return true
+			}
+			select {
+			case frame := <-received:
+				result.Received = append(result.Received, frame)
+			case <-wait:
+				return true
+			case err := <-closed:
+				// keep what was already received before giving up
+				for len(received) > 0 {
+					result.Received = append(result.Received, <-received)
+				}
+				r.events.Emit("replay-error", target, fmt.Sprintf("connection closed: %v", err))
+				return false
+			case <-stop:
+				r.events.Emit("replay-info", target, "replay stopped")
+				return false
+			}
+		}
+	}
+
+	for i := 0; i < len(steps); i++ {
+		if i > 0 {
+			client.Send(steps[i].send)
+		}
+		var ok bool
+		if i+1 < len(steps) && cfg.Mode == REPLAY_MODE_TIMING {
+			ok = collect(i, time.After(time.Duration(float64(steps[i+1].delay)/cfg.Speed)), false)
+		} else {
+			ok = collect(i, time.After(timeout), true)
+		}
+		finish(i)
+		if !ok {
+			return
+		}
+	}
+	r.events.Emit("replay-info", target, fmt.Sprintf("replay finished: %d of %d steps matched", passed, len(steps)))
+}
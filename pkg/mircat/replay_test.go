@@ -0,0 +1,119 @@
+package mircat
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplaySteps(t *testing.T) {
+	start := time.Now()
+	steps := replaySteps([]StreamMessage{
+		{Direction: DIRECTION_DST, Time: start, Data: []byte("hello")},
+		{Direction: DIRECTION_SRC, Time: start.Add(time.Second), Data: []byte("a")},
+		{Direction: DIRECTION_DST, Time: start.Add(2 * time.Second), Data: []byte("A1")},
+		{Direction: DIRECTION_DST, Time: start.Add(2 * time.Second), Data: []byte("A2")},
+		{Direction: DIRECTION_SRC, Time: start.Add(4 * time.Second), Data: []byte("b")},
+	})
+	if len(steps) != 3 {
+		t.Fatalf("%d steps", len(steps))
+	}
+	// the greeting belongs to step 0, the answers to the message they follow
+	if steps[0].send != nil || len(steps[0].expected) != 1 || string(steps[0].expected[0]) != "hello" {
+		t.Fatalf("step 0 %+v", steps[0])
+	}
+	if string(steps[1].send) != "a" || steps[1].delay != time.Second || len(steps[1].expected) != 2 {
+		t.Fatalf("step 1 %+v", steps[1])
+	}
+	if string(steps[2].send) != "b" || steps[2].delay != 3*time.Second || len(steps[2].expected) != 0 {
+		t.Fatalf("step 2 %+v", steps[2])
+	}
+}
+
+func TestReplayComparesResponses(t *testing.T) {
+	f := newPcapFile(65535)
+	f.add(true, 100, tcpFlagSyn, "")
+	f.add(false, 900, tcpFlagSyn|tcpFlagAck, "")
+	f.add(false, 901, tcpFlagPsh|tcpFlagAck, "#hi!")
+	f.add(true, 101, tcpFlagPsh|tcpFlagAck, "#1a!")
+	f.add(false, 905, tcpFlagPsh|tcpFlagAck, "#A!")
+	f.add(true, 105, tcpFlagPsh|tcpFlagAck, "#2b!")
+	f.add(false, 908, tcpFlagPsh|tcpFlagAck, "#B!")
+	path := filepath.Join(t.TempDir(), "recorded.pcap")
+	if err := os.WriteFile(path, f.data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the server under test answers the second message differently
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("#hi!"))
+		answers := map[string]string{"#1a!": "#A!", "#2b!": "#X!"}
+		b := make([]byte, 4)
+		for {
+			if _, err := conn.Read(b); err != nil {
+				return
+			}
+			conn.Write([]byte(answers[string(b)]))
+		}
+	}()
+
+	rec := NewEventRecorder()
+	replayer := NewReplayer(nil, rec)
+	framer := FramerConfig{Type: FRAMER_DELIMITER, Delimiter: "!"}
+	cfg := ReplayConfig{Path: path, Target: listener.Addr().String(), Mode: REPLAY_MODE_RESPONSE, ResponseTimeout: 2000, SrcFramer: framer, DstFramer: framer}
+	if err := replayer.Start(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := replayer.Start(cfg); err == nil {
+		t.Fatal("started a second replay")
+	}
+
+	events, ok := rec.Wait("replay-result", 3, 5*time.Second)
+	if !ok {
+		t.Fatalf("results %+v", events)
+	}
+	for i, match := range []bool{true, true, false} {
+		result := events[i].Data[1].(ReplayResult)
+		if result.Step != i || result.Match != match {
+			t.Errorf("step %d %+v", i, result)
+		}
+	}
+	if last := events[2].Data[1].(ReplayResult); string(last.Received[0]) != "#X!" || string(last.Expected[0]) != "#B!" {
+		t.Errorf("last step %+v", last)
+	}
+	rec.Wait("replay-info", 2, time.Second)
+	if info := rec.Events("replay-info"); len(info) != 2 || info[1].Data[1] != "replay finished: 2 of 3 steps matched" {
+		t.Fatalf("info %+v", info)
+	}
+}
+
+func TestReplayRejectsBadSettings(t *testing.T) {
+	f := newPcapFile(65535)
+	f.add(true, 1, tcpFlagPsh|tcpFlagAck, "x")
+	path := filepath.Join(t.TempDir(), "recorded.pcap")
+	os.WriteFile(path, f.data, 0644)
+
+	replayer := NewReplayer(nil, NewEventRecorder())
+	for _, cfg := range []ReplayConfig{
+		{Path: path, Mode: "fast"},
+		{Path: path, Filter: PcapFilter{Client: "10.0.0.9:1"}},
+		{Path: filepath.Join(t.TempDir(), "missing.pcap")},
+	} {
+		if err := replayer.Start(cfg); err == nil {
+			replayer.Stop()
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
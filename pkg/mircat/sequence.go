@@ -0,0 +1,77 @@
+package mircat
+
+import (
+	"mir-cat/pkg/codec"
+	"sync"
+)
+
+const (
+	sequenceUnknown = iota
+	sequenceMir
+	sequenceOff
+)
+
+// sequencer renumbers the rolling counter digit that follows '#' in Mir client packets.
+// Every packet written to the server gets the counter following the previous one, starting
+// from the first counter the client sent, so injected and dropped packets keep the stream in
+// step with what the server expects. A stream that does not start like a Mir client stream
+// ('#' or the '*' keep-alive) is left untouched.
+type sequencer struct {
+	mutex sync.Mutex
+	state int
+	// last is the previous counter sent, 0 before the first one.
+	last int
+	// pending tells whether the previous chunk ended with '#'.
+	pending bool
+}
+
+// renumber returns data with the counters of its packets replaced. Data is not modified,
+// a copy is returned when a counter changes.
+func (q *sequencer) renumber(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+	if q.state == sequenceUnknown {
+		q.state = sequenceOff
+		if data[0] == codec.PACKET_START || data[0] == codec.KEEP_ALIVE {
+			q.state = sequenceMir
+		}
+	}
+	if q.state != sequenceMir {
+		return data
+	}
+	out := data
+	for i, b := range data {
+		if b == codec.PACKET_START {
+			q.pending = true
+			continue
+		}
+		if !q.pending {
+			continue
+		}
+		q.pending = false
+		if b < '1' || b > '9' {
+			continue
+		}
+		counter := int(b - '0')
+		if q.last > 0 {
+			counter = q.last%9 + 1
+		}
+		q.last = counter
+		if byte('0'+counter) != b {
+			if &out[0] == &data[0] {
+				out = append([]byte{}, data...)
+			}
+			out[i] = byte('0' + counter)
+		}
+	}
+	return out
+}
+
+// reset forgets the previous counter, the next packet keeps the counter the client gave it.
+func (q *sequencer) reset() {
+	q.mutex.Lock()
+	q.last = 0
+	q.pending = false
+	q.mutex.Unlock()
+}
@@ -0,0 +1,71 @@
+package mircat
+
+import (
+	"testing"
+)
+
+func TestSequencerRenumber(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+	}{
+		{"keeps the first counter", []string{"#5a!"}, []string{"#5a!"}},
+		{"follows the previous counter", []string{"#5a!#5b!", "#1c!"}, []string{"#5a!#6b!", "#7c!"}},
+		{"wraps after 9", []string{"#8a!#8b!#8c!"}, []string{"#8a!#9b!#1c!"}},
+		{"keep-alive first", []string{"*#3a!", "*#3b!"}, []string{"*#3a!", "*#4b!"}},
+		{"counter in the next chunk", []string{"#2a!#", "2b!"}, []string{"#2a!#", "3b!"}},
+		{"packet without counter", []string{"#2a!#+b!#2c!"}, []string{"#2a!#+b!#3c!"}},
+		{"not a mir stream", []string{"\x01#1a!", "#1b!"}, []string{"\x01#1a!", "#1b!"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &sequencer{}
+			for i, chunk := range tt.chunks {
+				data := []byte(chunk)
+				if got := string(q.renumber(data)); got != tt.want[i] {
+					t.Errorf("chunk %d gave %q, want %q", i, got, tt.want[i])
+				}
+				if string(data) != chunk {
+					t.Errorf("chunk %d was modified to %q", i, data)
+				}
+			}
+		})
+	}
+}
+
+func TestSequencerReset(t *testing.T) {
+	q := &sequencer{}
+	q.renumber([]byte("#4a!"))
+	q.reset()
+	if got := string(q.renumber([]byte("#1b!"))); got != "#1b!" {
+		t.Fatalf("after reset got %q, want the counter of the client", got)
+	}
+	if got := string(q.renumber([]byte("#1c!"))); got != "#2c!" {
+		t.Fatalf("got %q, want #2c!", got)
+	}
+}
+
+func TestTCPTransferSeqRewrite(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		transfer := NewTCPTransfer(nil, NewEventRecorder())
+		transfer.SetSeqRewrite(enabled)
+		client, server := startTransfer(t, transfer, FramerConfig{}, FramerConfig{})
+		key := client.LocalAddr().String()
+
+		client.Write([]byte("#1a!"))
+		if got := receive(t, server, 4); got != "#1a!" {
+			t.Fatalf("server received %q", got)
+		}
+		// the injected packet takes the next counter, and the client packets after it move along
+		want := map[bool][]string{true: {"#2x!", "#3b!"}, false: {"#1x!", "#2b!"}}[enabled]
+		transfer.SendToServer(key, []byte("#1x!"))
+		if got := receive(t, server, 4); got != want[0] {
+			t.Fatalf("rewrite %v: server received %q, want %q", enabled, got, want[0])
+		}
+		client.Write([]byte("#2b!"))
+		if got := receive(t, server, 4); got != want[1] {
+			t.Fatalf("rewrite %v: server received %q, want %q", enabled, got, want[1])
+		}
+	}
+}
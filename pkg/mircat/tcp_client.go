@@ -2,35 +2,63 @@ package mircat
 
 import (
 	"fmt"
+	"mir-cat/pkg/codec"
 	"net"
+	"sync"
 	"time"
 )
 
 const RECONNECT_INTERVAL = time.Second
 
 type TcpClient struct {
-	address    string      // 连接的地址
-	conn       net.Conn    // 实际的网络连接对象
-	sendChan   chan []byte // 发送数据的通道
-	recvChan   chan []byte // 接收数据的通道
-	isShutdown bool        // 是否关闭
+	address    string        // 连接的地址
+	conn       net.Conn      // 实际的网络连接对象
+	sendChan   chan []byte   // 发送数据的通道
+	recvChan   chan []byte   // 接收数据的通道
+	isShutdown bool          // 是否关闭
+	done       chan struct{} // 不再发送时关闭
+	stopOnce   sync.Once
 	index      int
-	app        *App
+	framer     FramerConfig // 接收数据的分帧方式
+	capture    *Capture     // 抓包记录
+	handler    *tcpClientHandler
+	dialer     *Dialer
+	events     EventSink
 }
 
-func NewTcpClient(address string, app *App) (*TcpClient, error) {
-	conn, err := net.Dial("tcp", address)
+// tcpClientHandler lets another component, such as the replay engine, consume
+// the frames of a TcpClient instead of the client-tcp-data events. A client
+// with a handler does not reconnect, it reports the end of the connection to onClose.
+type tcpClientHandler struct {
+	onData  func(frame []byte)
+	onClose func(err error)
+}
+
+func NewTcpClient(address string, framer FramerConfig, dialer *Dialer, capture *Capture, events EventSink) (*TcpClient, error) {
+	return newTcpClient(address, framer, dialer, capture, nil, events)
+}
+
+func newTcpClient(address string, framer FramerConfig, dialer *Dialer, capture *Capture, handler *tcpClientHandler, events EventSink) (*TcpClient, error) {
+	if _, err := NewFramer(framer); err != nil {
+		return nil, err
+	}
+	conn, err := dialer.Dial(address)
 	if err != nil {
 		return nil, err
 	}
 	c := &TcpClient{
 		address:    address,
-		conn:       conn,
+		conn:       capture.Wrap(conn, "client", true),
 		sendChan:   make(chan []byte),
 		recvChan:   make(chan []byte),
+		done:       make(chan struct{}),
 		isShutdown: false,
 		index:      -1,
-		app:        app,
+		framer:     framer,
+		capture:    capture,
+		handler:    handler,
+		dialer:     dialer,
+		events:     events,
 	}
 	go c.startSending()
 	go c.startReceiving()
@@ -46,16 +74,31 @@ func (c *TcpClient) startSending() {
 				if c.isShutdown {
 					return
 				}
+				if c.handler != nil {
+					// a client with a handler does not reconnect, closing the connection reports the end to onClose
+					c.stop()
+					c.conn.Close()
+					return
+				}
 				c.reconnect()
 				return
 			}
-		case <-time.After(time.Second):
-			// do nothing
+		case <-c.done:
+			return
 		}
 	}
 }
 
+// stop makes the pending and later Send calls return instead of waiting for the sending loop.
+func (c *TcpClient) stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+	})
+}
+
 func (c *TcpClient) startReceiving() {
+	framer, _ := NewFramer(c.framer)
+	decoder := codec.Decoder{Direction: codec.FROM_SERVER}
 	buffer := make([]byte, 4096)
 	for {
 		n, err := c.conn.Read(buffer)
@@ -63,13 +106,26 @@ func (c *TcpClient) startReceiving() {
 			if c.isShutdown {
 				return
 			}
-			c.app.EventsEmit("client-tcp-error", c.index, fmt.Sprintf("connection closed: %v", err))
+			if c.handler != nil {
+				c.stop()
+				c.handler.onClose(err)
+				return
+			}
+			c.events.Emit("client-tcp-error", c.index, fmt.Sprintf("connection closed: %v", err))
 			c.reconnect()
 			return
 		}
-		dst := make([]byte, n)
-		copy(dst, buffer[:n])
-		c.app.EventsEmit("client-tcp-data", c.index, dst)
+		frames, err := framer.Feed(buffer[:n])
+		if err != nil {
+			c.events.Emit("client-tcp-error", c.index, fmt.Sprintf("%v", err))
+		}
+		for _, frame := range frames {
+			if c.handler != nil {
+				c.handler.onData(frame)
+				continue
+			}
+			c.events.Emit("client-tcp-data", c.index, frame, decoder.Feed(frame))
+		}
 		fmt.Printf("Recv data: %v\n", buffer[:n])
 		//c.recvChan <- buffer[:n]
 	}
@@ -77,10 +133,14 @@ func (c *TcpClient) startReceiving() {
 
 func (c *TcpClient) Send(data []byte) {
 	if c.isShutdown {
-		c.app.EventsEmit("client-tcp-error", c.index, "connection closed")
+		c.events.Emit("client-tcp-error", c.index, "connection closed")
 		return
 	}
-	c.sendChan <- data
+	select {
+	case c.sendChan <- data:
+	case <-c.done:
+		c.events.Emit("client-tcp-error", c.index, "connection closed")
+	}
 }
 
 //func (c *TcpClient) Recv() ([]byte, error) {
@@ -98,10 +158,12 @@ func (c *TcpClient) Send(data []byte) {
 func (c *TcpClient) Shutdown() {
 	if !c.isShutdown {
 		c.isShutdown = true
+		c.stop()
 		c.conn.Close()
-		close(c.sendChan)
 		close(c.recvChan)
-		c.app.EventsEmit("client-tcp-info", c.index, "connection closed")
+		if c.handler == nil {
+			c.events.Emit("client-tcp-info", c.index, "connection closed")
+		}
 	}
 }
 
@@ -110,15 +172,15 @@ func (c *TcpClient) reconnect() {
 		if c.isShutdown {
 			return
 		}
-		conn, err := net.Dial("tcp", c.address)
+		conn, err := c.dialer.Dial(c.address)
 		if err == nil {
-			c.conn = conn
+			c.conn = c.capture.Wrap(conn, "client", true)
 			go c.startSending()
 			go c.startReceiving()
-			c.app.EventsEmit("client-tcp-info", c.index, "connection reconnected")
+			c.events.Emit("client-tcp-info", c.index, "connection reconnected")
 			return
 		}
-		c.app.EventsEmit("client-tcp-info", c.index, "trying to reconnect...")
+		c.events.Emit("client-tcp-info", c.index, "trying to reconnect...")
 		time.Sleep(RECONNECT_INTERVAL)
 	}
 }
@@ -1,6 +1,7 @@
 package mircat
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -17,27 +18,40 @@ type TCPServer struct {
 	addClient    chan net.Conn
 	removeClient chan net.Conn
 	shutdown     chan bool
-	app          *App
+	framer       FramerConfig
+	capture      *Capture
+	mock         *MockResponder
+	mir          *MirServer
+	tls          *tls.Config
+	events       EventSink
 }
 
-func NewTCPServer(app *App) *TCPServer {
+func NewTCPServer(capture *Capture, events EventSink) *TCPServer {
 	return &TCPServer{
 		clients:      make(map[string]net.Conn),
 		broadcast:    make(chan []byte),
 		addClient:    make(chan net.Conn),
 		removeClient: make(chan net.Conn),
 		shutdown:     make(chan bool),
-		app:          app,
+		capture:      capture,
+		events:       events,
 	}
 }
 
-func (s *TCPServer) Start(address string) error {
+func (s *TCPServer) Start(address string, framer FramerConfig) error {
+	if _, err := NewFramer(framer); err != nil {
+		return err
+	}
+	s.framer = framer
 	var err error
 	s.address = address
 	s.listener, err = net.Listen("tcp", s.address)
 	if err != nil {
 		return err
 	}
+	if s.tls != nil {
+		s.listener = tls.NewListener(s.listener, s.tls)
+	}
 	fmt.Printf("Listening on %s\n", s.address)
 
 	go s.handleEvents()
@@ -47,20 +61,41 @@ func (s *TCPServer) Start(address string) error {
 			conn, err := s.listener.Accept()
 			if err != nil {
 				if !errors.Is(err, net.ErrClosed) {
-					s.app.EventsEmit("server-tcp-error", "server", fmt.Sprintf("error accepting connection: %v", err))
+					s.events.Emit("server-tcp-error", "server", fmt.Sprintf("error accepting connection: %v", err))
 				}
 				fmt.Printf("Error accepting connection: %s\n", err.Error())
 				return
 			}
-			s.app.EventsEmit("server-tcp-info", conn.RemoteAddr().String(), fmt.Sprintf("client connected: %s", conn.RemoteAddr()))
+			s.events.Emit("server-tcp-info", conn.RemoteAddr().String(), fmt.Sprintf("client connected: %s", conn.RemoteAddr()))
 			fmt.Printf("New client connected: %s\n", conn.RemoteAddr())
 
-			s.addClient <- conn
+			s.addClient <- s.capture.Wrap(conn, "server", false)
 		}
 	}()
 	return nil
 }
 
+// SetMock makes the server answer client messages with the responses of mock, nil turns mock mode off.
+// It applies to the connections accepted afterwards.
+func (s *TCPServer) SetMock(mock *MockResponder) {
+	s.mutex.Lock()
+	s.mock = mock
+	s.mutex.Unlock()
+}
+
+// SetMirServer makes the server play the Mir server mir, nil turns Mir mode off.
+// It applies to the connections accepted afterwards.
+func (s *TCPServer) SetMirServer(mir *MirServer) {
+	s.mutex.Lock()
+	s.mir = mir
+	s.mutex.Unlock()
+}
+
+// SetTLS makes the server terminate TLS with config, nil serves plain TCP. It has to be called before Start.
+func (s *TCPServer) SetTLS(config *tls.Config) {
+	s.tls = config
+}
+
 func (s *TCPServer) Stop() {
 	if s.listener != nil {
 		s.listener.Close()
@@ -72,28 +107,89 @@ func (s *TCPServer) Stop() {
 func (s *TCPServer) handleConnection(conn net.Conn) {
 	defer func() {
 		conn.Close()
-		s.app.EventsEmit("server-tcp-info", conn.RemoteAddr().String(), fmt.Sprintf("client disconnected: %s", conn.RemoteAddr()))
+		s.events.Emit("server-tcp-info", conn.RemoteAddr().String(), fmt.Sprintf("client disconnected: %s", conn.RemoteAddr()))
 		fmt.Printf("Client disconnected: %s\n", conn.RemoteAddr())
 
 		s.removeClient <- conn
 	}()
 
+	s.mutex.RLock()
+	mock := s.mock
+	mir := s.mir
+	s.mutex.RUnlock()
+	client := conn.RemoteAddr().String()
+	cursor := 0
+	var session *mirSession
+	if mir != nil {
+		session = mir.newSession(conn.LocalAddr())
+	}
+	if mock != nil {
+		for _, message := range mock.Greeting() {
+			if err := s.SendMessage(client, message); err != nil {
+				s.events.Emit("server-tcp-error", client, fmt.Sprintf("error sending to client %s : %v", client, err))
+			}
+		}
+	}
+
+	framer, _ := NewFramer(s.framer)
 	buffer := make([]byte, 4096)
 	for {
 		n, err := conn.Read(buffer)
 		if err != nil {
 			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
-				s.app.EventsEmit("server-tcp-error", conn.RemoteAddr().String(), fmt.Sprintf("error reading from client %s : %v", conn.RemoteAddr(), err))
+				s.events.Emit("server-tcp-error", conn.RemoteAddr().String(), fmt.Sprintf("error reading from client %s : %v", conn.RemoteAddr(), err))
 			}
 			fmt.Printf("Error reading from client %s: %s\n", conn.RemoteAddr(), err.Error())
 			return
 		}
-		message := append([]byte{}, buffer[:n]...)
-		s.app.EventsEmit("server-tcp-data", conn.RemoteAddr().String(), message)
+		messages, err := framer.Feed(buffer[:n])
+		if err != nil {
+			s.events.Emit("server-tcp-error", conn.RemoteAddr().String(), fmt.Sprintf("%v", err))
+		}
+		for _, message := range messages {
+			s.events.Emit("server-tcp-data", conn.RemoteAddr().String(), message)
+			if mock != nil {
+				cursor = s.answer(mock, client, message, cursor)
+			}
+			if session != nil {
+				s.answerMir(session, client, message)
+			}
+		}
 		//s.broadcast <- message
 	}
 }
 
+// answer sends the recorded responses to a client message and returns the cursor for the next one.
+func (s *TCPServer) answer(mock *MockResponder, client string, message []byte, cursor int) int {
+	responses, i := mock.Answer(message, cursor)
+	if i < 0 {
+		s.events.Emit("server-tcp-error", client, "no recorded request matches the message")
+		return cursor
+	}
+	for _, response := range responses {
+		if err := s.SendMessage(client, response); err != nil {
+			s.events.Emit("server-tcp-error", client, fmt.Sprintf("error sending to client %s : %v", client, err))
+			break
+		}
+	}
+	s.events.Emit("server-tcp-info", client, fmt.Sprintf("answered with recorded exchange %d (%d messages)", i, len(responses)))
+	return i + 1
+}
+
+// answerMir sends the answers of the Mir server to a client message.
+func (s *TCPServer) answerMir(session *mirSession, client string, message []byte) {
+	responses, err := session.Answer(message)
+	for _, response := range responses {
+		if err := s.SendMessage(client, response); err != nil {
+			s.events.Emit("server-tcp-error", client, fmt.Sprintf("error sending to client %s : %v", client, err))
+			return
+		}
+	}
+	if err != nil {
+		s.events.Emit("server-tcp-error", client, fmt.Sprintf("mir server: %v", err))
+	}
+}
+
 func (s *TCPServer) handleEvents() {
 	for {
 		select {
@@ -101,7 +197,7 @@ func (s *TCPServer) handleEvents() {
 			s.mutex.Lock()
 			for addr, client := range s.clients {
 				client.Close()
-				s.app.EventsEmit("server-tcp-info", addr, fmt.Sprintf("close connection %s", addr))
+				s.events.Emit("server-tcp-info", addr, fmt.Sprintf("close connection %s", addr))
 				fmt.Printf("Close connection %s\n", addr)
 			}
 			s.clients = make(map[string]net.Conn)
@@ -125,7 +221,7 @@ func (s *TCPServer) handleEvents() {
 			for addr, client := range s.clients {
 				_, err := client.Write(message)
 				if err != nil {
-					s.app.EventsEmit("server-tcp-error", addr, fmt.Sprintf("error broadcasting message to client %s : %v", addr, err))
+					s.events.Emit("server-tcp-error", addr, fmt.Sprintf("error broadcasting message to client %s : %v", addr, err))
 					fmt.Printf("Error broadcasting message to client %s: %s\n", addr, err.Error())
 				}
 			}
@@ -139,7 +235,7 @@ func (s *TCPServer) SendMessage(client string, message []byte) error {
 	conn, ok := s.clients[client]
 	s.mutex.RUnlock()
 	if !ok {
-		s.app.EventsEmit("server-tcp-error", client, fmt.Sprintf("client %s not found", client))
+		s.events.Emit("server-tcp-error", client, fmt.Sprintf("client %s not found", client))
 		return fmt.Errorf("client %s not found", client)
 	}
 
@@ -1,91 +1,239 @@
 package mircat
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"mir-cat/pkg/codec"
 	"net"
 	"sync"
 	"time"
 )
 
+const (
+	// FORWARD_MODE_AUTO relays every chunk natively, the UI only receives copies.
+	FORWARD_MODE_AUTO = "auto"
+	// FORWARD_MODE_MANUAL leaves relaying to the UI through TransferSendToServer / TransferSendToClient.
+	FORWARD_MODE_MANUAL = "manual"
+)
+
 type TransferConn struct {
-	clientConn net.Conn
-	serverConn net.Conn
+	clientConn  net.Conn
+	serverConn  net.Conn
+	forwardMode string
+	sequence    *sequencer
+	serverName  string
+	dstAddress  string
+	shaping     TrafficShaping
+	lanes       map[string]*shapingLane
+}
+
+// writeServer sends data to the server, renumbering the Mir client counters when enabled.
+func (t *TransferConn) writeServer(conn net.Conn, data []byte) (int, error) {
+	if t.sequence == nil {
+		return conn.Write(data)
+	}
+	t.sequence.mutex.Lock()
+	defer t.sequence.mutex.Unlock()
+	return conn.Write(t.sequence.renumber(data))
 }
 
 type TCPTransfer struct {
 	srcAddress      string
 	dstAddress      string
 	listener        net.Listener
-	clients         map[string]TransferConn
+	clients         map[string]*TransferConn
+	forwardMode     string
+	srcFramer       FramerConfig
+	dstFramer       FramerConfig
+	intercept       *interceptor
+	redirect        *redirector
+	seqRewrite      bool
+	tracker         *GameTracker
+	tls             *tls.Config
+	dialer          *Dialer
+	proxy           bool
+	shaping         TrafficShaping
+	capture         *Capture
 	mutex           sync.RWMutex
 	broadcastServer chan []byte
 	broadcastClient chan []byte
-	addClient       chan net.Conn
+	addClient       chan *TransferConn
 	removeClient    chan net.Conn
 	shutdown        chan bool
-	app             *App
+	events          EventSink
 }
 
-func NewTCPTransfer(app *App) *TCPTransfer {
+func NewTCPTransfer(capture *Capture, events EventSink) *TCPTransfer {
 	return &TCPTransfer{
-		clients:         make(map[string]TransferConn),
+		clients:         make(map[string]*TransferConn),
+		forwardMode:     FORWARD_MODE_AUTO,
+		intercept:       newInterceptor(),
 		broadcastServer: make(chan []byte),
 		broadcastClient: make(chan []byte),
-		addClient:       make(chan net.Conn),
+		addClient:       make(chan *TransferConn),
 		removeClient:    make(chan net.Conn),
-		shutdown:        make(chan bool),
-		app:             app,
+		capture:         capture,
+		events:          events,
 	}
 }
 
-func (s *TCPTransfer) Start(srcAddress string, dstAddress string) error {
-	var err error
+func (s *TCPTransfer) Start(srcAddress string, dstAddress string, srcFramer FramerConfig, dstFramer FramerConfig) error {
+	if _, err := NewFramer(srcFramer); err != nil {
+		return fmt.Errorf("src framer: %v", err)
+	}
+	if _, err := NewFramer(dstFramer); err != nil {
+		return fmt.Errorf("dst framer: %v", err)
+	}
+	s.srcFramer = srcFramer
+	s.dstFramer = dstFramer
 	s.srcAddress = srcAddress
 	s.dstAddress = dstAddress
-	s.listener, err = net.Listen("tcp", s.srcAddress)
+	listener, err := net.Listen("tcp", s.srcAddress)
 	if err != nil {
 		return err
 	}
 	fmt.Printf("Listening on %s\n", s.srcAddress)
+	shutdown := make(chan bool)
+	s.mutex.Lock()
+	s.listener = listener
+	s.shutdown = shutdown
+	s.mutex.Unlock()
 
-	go s.handleEvents()
+	go s.handleEvents(shutdown)
 
 	go func() {
 		for {
-			conn, err := s.listener.Accept()
+			conn, err := listener.Accept()
 			if err != nil {
 				if !errors.Is(err, net.ErrClosed) {
-					s.app.EventsEmit("transfer-tcp-error", "server", fmt.Sprintf("error accepting connection: %v", err))
+					s.events.Emit("transfer-tcp-error", "server", fmt.Sprintf("error accepting connection: %v", err))
 				}
 				fmt.Printf("Error accepting connection: %s\n", err.Error())
 				return
 			}
-			s.app.EventsEmit("transfer-tcp-info", conn.RemoteAddr().String(), fmt.Sprintf("client connected: %s", conn.RemoteAddr()))
+			s.events.Emit("transfer-tcp-info", conn.RemoteAddr().String(), fmt.Sprintf("client connected: %s", conn.RemoteAddr()))
 			fmt.Printf("New client connected: %s\n", conn.RemoteAddr())
 
-			s.addClient <- conn
+			go s.accept(conn, shutdown)
 		}
 	}()
 	return nil
 }
 
+// accept completes the proxy and TLS handshakes of a client and opens its dst connection, to the destination
+// and with the SNI the client asked for. It runs per client, so a slow destination only delays its own client.
+func (s *TCPTransfer) accept(conn net.Conn, shutdown chan bool) {
+	client := conn.RemoteAddr().String()
+	dstAddress := s.dstAddress
+	var request *proxyRequest
+	if s.proxy {
+		proxied, requested, err := proxyHandshake(conn)
+		if err != nil {
+			s.events.Emit("transfer-tcp-error", client, fmt.Sprintf("proxy handshake failed: %v", err))
+			conn.Close()
+			return
+		}
+		conn, request, dstAddress = proxied, requested, requested.target
+		s.events.Emit("transfer-tcp-info", client+"->"+dstAddress, fmt.Sprintf("client %s asked for %s", client, dstAddress))
+	}
+	// the dst side is connected before the client is told its tunnel is open, its TLS handshake
+	// waits for the SNI of the client
+	serverConn, err := s.dialer.connect(dstAddress)
+	if err != nil {
+		if request != nil {
+			request.refuse()
+			client += "->" + dstAddress
+		}
+		s.events.Emit("transfer-tcp-info", client, fmt.Sprintf("failed to connect to %s: %v", dstAddress, err))
+		conn.Close()
+		return
+	}
+	if request != nil {
+		if err := request.accept(); err != nil {
+			conn.Close()
+			serverConn.Close()
+			return
+		}
+	}
+	if s.tls != nil {
+		tlsConn := tls.Server(conn, s.tls)
+		tlsConn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+		if err := tlsConn.Handshake(); err != nil {
+			s.events.Emit("transfer-tcp-error", client, fmt.Sprintf("tls handshake failed: %v", err))
+			conn.Close()
+			serverConn.Close()
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
+	conn = s.capture.Wrap(conn, "transfer-src", false)
+	if request != nil {
+		conn = &targetConn{Conn: conn, target: dstAddress}
+	}
+	clientKey := transferKey(conn)
+	serverName := tlsServerName(conn)
+	serverConn, err = s.dialer.handshake(serverConn, dstAddress, serverName)
+	if err != nil {
+		s.events.Emit("transfer-tcp-info", clientKey, fmt.Sprintf("failed to connect to %s: %v", dstAddress, err))
+		conn.Close()
+		return
+	}
+	serverConn = s.capture.Wrap(serverConn, "transfer-dst", true)
+	select {
+	case s.addClient <- &TransferConn{clientConn: conn, serverConn: serverConn, serverName: serverName, dstAddress: dstAddress}:
+	case <-shutdown:
+		// the transfer stopped during the handshakes
+		conn.Close()
+		serverConn.Close()
+	}
+}
+
 func (s *TCPTransfer) Stop() {
-	if s.listener != nil {
-		s.listener.Close()
-		s.listener = nil
-		s.shutdown <- true
+	s.mutex.Lock()
+	listener, shutdown := s.listener, s.shutdown
+	s.listener, s.shutdown = nil, nil
+	s.mutex.Unlock()
+	if listener != nil {
+		listener.Close()
+		close(shutdown)
+	}
+}
+
+// Addr returns the address the transfer listens on, or nil when it is stopped.
+func (s *TCPTransfer) Addr() net.Addr {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.listener == nil {
+		return nil
 	}
+	return s.listener.Addr()
 }
 
-func (s *TCPTransfer) handleClientConnection(conn net.Conn) {
+func (s *TCPTransfer) handleClientConnection(conn net.Conn, shutdown chan bool) {
+	clientKey := transferKey(conn)
+	framer, _ := NewFramer(s.srcFramer)
+	decoder := &codec.Decoder{Direction: codec.FROM_CLIENT}
+
+	relay := &relayState{}
+
 	defer func() {
 		conn.Close()
-		s.app.EventsEmit("transfer-tcp-info", conn.RemoteAddr().String(), fmt.Sprintf("client disconnected: %s", conn.RemoteAddr()))
+		rest := framer.Flush()
+		s.emitMessages(clientKey, decoder, [][]byte{rest}, DIRECTION_SRC)
+		if unsent := relay.rest(rest); len(unsent) > 0 {
+			s.forward(clientKey, unsent, DIRECTION_SRC)
+		}
+		s.events.Emit("transfer-tcp-info", clientKey, fmt.Sprintf("client disconnected: %s", conn.RemoteAddr()))
 		fmt.Printf("Client disconnected: %s\n", conn.RemoteAddr())
 
-		s.removeClient <- conn
+		select {
+		case s.removeClient <- conn:
+		case <-shutdown:
+			// the shutdown closed every session already
+		}
 	}()
 
 	buffer := make([]byte, 4096)
@@ -93,23 +241,34 @@ func (s *TCPTransfer) handleClientConnection(conn net.Conn) {
 		n, err := conn.Read(buffer)
 		if err != nil {
 			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
-				s.app.EventsEmit("transfer-tcp-error", conn.RemoteAddr().String(), fmt.Sprintf("error reading from client %s : %v", conn.RemoteAddr(), err))
+				s.events.Emit("transfer-tcp-error", clientKey, fmt.Sprintf("error reading from client %s : %v", conn.RemoteAddr(), err))
 			}
 			fmt.Printf("Error reading from client %s: %s\n", conn.RemoteAddr(), err.Error())
 			return
 		}
-		message := append([]byte{}, buffer[:n]...)
-		s.app.EventsEmit("transfer-src-data", conn.RemoteAddr().String(), message)
-		//s.broadcast <- message
+		chunk := append([]byte{}, buffer[:n]...)
+		messages, err := framer.Feed(chunk)
+		if err != nil {
+			s.events.Emit("transfer-tcp-error", clientKey, fmt.Sprintf("%v", err))
+		}
+		s.emitMessages(clientKey, decoder, messages, DIRECTION_SRC)
+		for _, data := range relay.next(chunk, messages, s.intercept.active(clientKey, DIRECTION_SRC)) {
+			s.forward(clientKey, data, DIRECTION_SRC)
+		}
 	}
 }
 
 func (s *TCPTransfer) handleServerConnection(clientConn net.Conn, serverConn net.Conn) {
-	clientKey := clientConn.RemoteAddr().String()
+	clientKey := transferKey(clientConn)
+	framer, _ := NewFramer(s.dstFramer)
+	decoder := &codec.Decoder{Direction: codec.FROM_SERVER}
+	relay := &relayState{}
+	// pending is the start of a packet waiting for its end to be rewritten
+	var pending []byte
 
 	defer func() {
 		serverConn.Close()
-		s.app.EventsEmit("transfer-tcp-info", clientKey, fmt.Sprintf("dst disconnected: %s", serverConn.RemoteAddr()))
+		s.events.Emit("transfer-tcp-info", clientKey, fmt.Sprintf("dst disconnected: %s", serverConn.RemoteAddr()))
 		fmt.Printf("Dst client disconnected: %s\n", serverConn.RemoteAddr())
 	}()
 
@@ -118,18 +277,359 @@ func (s *TCPTransfer) handleServerConnection(clientConn net.Conn, serverConn net
 		n, err := serverConn.Read(buffer)
 		if err != nil {
 			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
-				s.app.EventsEmit("transfer-tcp-error", clientKey, fmt.Sprintf("error reading from client %s : %v", serverConn.RemoteAddr(), err))
+				s.events.Emit("transfer-tcp-error", clientKey, fmt.Sprintf("error reading from client %s : %v", serverConn.RemoteAddr(), err))
 			}
 			fmt.Printf("Error reading from client %s: %s\n", serverConn.RemoteAddr(), err.Error())
+			// the end of the stream completes no message, the bytes left are shown as they are
+			rest := framer.Flush()
+			s.emitMessages(clientKey, decoder, [][]byte{rest}, DIRECTION_DST)
+			if unsent := relay.rest(rest); len(unsent) > 0 || len(pending) > 0 {
+				s.forward(clientKey, s.rewriteRedirect(clientKey, &pending, unsent, true), DIRECTION_DST)
+			}
 			if s.getTransferConn(clientKey) == nil {
 				return
 			}
-			s.reconnect(clientKey)
+			conn := s.reconnect(clientKey)
+			if conn == nil {
+				return
+			}
+			serverConn = conn
+			decoder = &codec.Decoder{Direction: codec.FROM_SERVER}
+			continue
+		}
+		chunk := append([]byte{}, buffer[:n]...)
+		messages, err := framer.Feed(chunk)
+		if err != nil {
+			s.events.Emit("transfer-tcp-error", clientKey, fmt.Sprintf("%v", err))
+		}
+		s.emitMessages(clientKey, decoder, messages, DIRECTION_DST)
+		// redirect rewriting works on complete packets, so the framed messages are relayed instead of the chunk
+		framed := s.redirecting() || s.intercept.active(clientKey, DIRECTION_DST)
+		for _, data := range relay.next(chunk, messages, framed) {
+			if data = s.rewriteRedirect(clientKey, &pending, data, false); len(data) > 0 {
+				s.forward(clientKey, data, DIRECTION_DST)
+			}
+		}
+	}
+}
+
+// relayState follows the bytes of one direction that the framer still buffers, so a session can switch
+// between relaying its reads and relaying its framed messages without sending a byte twice or losing one.
+type relayState struct {
+	// buffered is the number of bytes fed to the framer that are not part of a message yet.
+	buffered int
+	// relayed is how many of them already went out with their read.
+	relayed int
+}
+
+// next returns the data to relay for a read and the messages the framer completed with it. The read itself
+// is relayed unless framed is set or the bytes of an incomplete message are still waiting, then the completed
+// messages are relayed, without the bytes that went out with earlier reads.
+func (r *relayState) next(chunk []byte, messages [][]byte, framed bool) [][]byte {
+	out := [][]byte{}
+	if !framed && r.buffered == r.relayed {
+		out = append(out, chunk)
+		r.relayed += len(chunk)
+	}
+	r.buffered += len(chunk)
+	for _, message := range messages {
+		skip := len(message)
+		if r.relayed < skip {
+			skip = r.relayed
+		}
+		r.relayed -= skip
+		r.buffered -= len(message)
+		if skip < len(message) {
+			out = append(out, message[skip:])
+		}
+	}
+	return out
+}
+
+// rest returns the bytes of the incomplete message flushed by the framer that were not relayed yet.
+func (r *relayState) rest(flushed []byte) []byte {
+	skip := r.relayed
+	if skip > len(flushed) {
+		skip = len(flushed)
+	}
+	r.buffered, r.relayed = 0, 0
+	return flushed[skip:]
+}
+
+// emitMessages sends the framed messages of one direction of a session to the UI with their decoded packets.
+// The chunks themselves are relayed as they were read, unless the direction is intercepted or redirected,
+// then the messages are held and rewritten whole.
+func (s *TCPTransfer) emitMessages(clientKey string, decoder *codec.Decoder, messages [][]byte, direction string) {
+	for _, message := range messages {
+		if len(message) == 0 {
 			continue
 		}
-		message := append([]byte{}, buffer[:n]...)
-		s.app.EventsEmit("transfer-dst-data", clientConn.RemoteAddr().String(), message)
-		//s.broadcast <- message
+		packets := decoder.Feed(message)
+		s.events.Emit("transfer-"+direction+"-data", clientKey, message, packets)
+		s.track(clientKey, packets)
+	}
+}
+
+// SetTracker makes the transfer feed the decoded packets of its sessions to a game tracker, it has to be called before Start.
+func (s *TCPTransfer) SetTracker(tracker *GameTracker) {
+	s.tracker = tracker
+}
+
+// SetTLS makes the transfer terminate TLS on the src side with config and open the dst connections
+// with dialer, nil relays plain TCP. It has to be called before Start.
+func (s *TCPTransfer) SetTLS(config *tls.Config, dialer *Dialer) {
+	s.tls = config
+	s.dialer = dialer
+}
+
+// SetProxy makes the src listener a SOCKS5 and HTTP CONNECT proxy: each client is relayed to the destination
+// it asks for instead of the dst address, in a session keyed by "client->target". It has to be called before Start.
+func (s *TCPTransfer) SetProxy(enabled bool) {
+	s.proxy = enabled
+}
+
+func (s *TCPTransfer) track(clientKey string, packets []*codec.Packet) {
+	if s.tracker != nil {
+		s.tracker.Feed(clientKey, packets)
+	}
+}
+
+// untrack tells the game tracker that a session ended.
+func (s *TCPTransfer) untrack(clientKey string) {
+	if s.tracker != nil {
+		s.tracker.Close(clientKey)
+	}
+}
+
+// SetSeqRewrite turns the renumbering of the Mir client counters on or off for the sessions accepted afterwards.
+func (s *TCPTransfer) SetSeqRewrite(enabled bool) {
+	s.mutex.Lock()
+	s.seqRewrite = enabled
+	s.mutex.Unlock()
+}
+
+// SetRedirect makes the transfer rewrite the server addresses handed to its clients, nil turns it off.
+func (s *TCPTransfer) SetRedirect(redirect *redirector) {
+	s.mutex.Lock()
+	s.redirect = redirect
+	s.mutex.Unlock()
+}
+
+// redirecting tells whether the messages of the server are rewritten by a redirector.
+func (s *TCPTransfer) redirecting() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.redirect != nil
+}
+
+// rewriteRedirect points the server addresses carried by a message of the server at the proxy chain.
+// A packet the message leaves incomplete is kept in pending and rewritten with the next message,
+// final sends it as it is when the stream ends.
+func (s *TCPTransfer) rewriteRedirect(clientKey string, pending *[]byte, message []byte, final bool) []byte {
+	if len(*pending) > 0 {
+		message = append(append([]byte{}, *pending...), message...)
+		*pending = nil
+	}
+	s.mutex.RLock()
+	redirect := s.redirect
+	s.mutex.RUnlock()
+	if redirect == nil {
+		return message
+	}
+	if !final {
+		var rest []byte
+		message, rest = redirect.cut(message)
+		*pending = append(*pending, rest...)
+	}
+	message, rewritten, err := redirect.rewrite(message)
+	if err != nil {
+		s.events.Emit("transfer-tcp-error", clientKey, fmt.Sprintf("redirect failed: %v", err))
+	}
+	for _, description := range rewritten {
+		s.events.Emit("transfer-tcp-info", clientKey, fmt.Sprintf("redirect %s", description))
+	}
+	return message
+}
+
+// forward relays a chunk read from one side of the session to the other side
+// when the session is in auto-forward mode. In manual mode the chunk is only
+// delivered to the UI, which relays it with TransferSendToServer / TransferSendToClient.
+// Chunks matching the intercept settings of the session are held until the user resolves them.
+func (s *TCPTransfer) forward(clientKey string, message []byte, direction string) {
+	s.mutex.RLock()
+	transferConn, ok := s.clients[clientKey]
+	if !ok || transferConn.forwardMode != FORWARD_MODE_AUTO {
+		s.mutex.RUnlock()
+		return
+	}
+	s.mutex.RUnlock()
+
+	queued, held := s.intercept.hold(clientKey, direction, message)
+	if held != nil {
+		s.events.Emit("transfer-intercept-held", clientKey, *held)
+	}
+	if queued {
+		return
+	}
+	s.write(clientKey, message, direction)
+}
+
+// write sends a chunk to the server for DIRECTION_SRC or to the client for DIRECTION_DST,
+// through the traffic shaping of the direction once it was shaped.
+func (s *TCPTransfer) write(clientKey string, message []byte, direction string) {
+	s.mutex.RLock()
+	transferConn, ok := s.clients[clientKey]
+	var lane *shapingLane
+	if ok {
+		lane = transferConn.lanes[direction]
+	}
+	s.mutex.RUnlock()
+	if lane != nil {
+		lane.push(message)
+		return
+	}
+	s.send(clientKey, message, direction)
+}
+
+// send writes a chunk to the server for DIRECTION_SRC or to the client for DIRECTION_DST.
+func (s *TCPTransfer) send(clientKey string, message []byte, direction string) {
+	s.mutex.RLock()
+	transferConn, ok := s.clients[clientKey]
+	if !ok {
+		s.mutex.RUnlock()
+		return
+	}
+	conn := transferConn.clientConn
+	if direction == DIRECTION_SRC {
+		conn = transferConn.serverConn
+	}
+	s.mutex.RUnlock()
+
+	var err error
+	if direction == DIRECTION_SRC {
+		_, err = transferConn.writeServer(conn, message)
+	} else {
+		_, err = conn.Write(message)
+	}
+	if err != nil {
+		s.events.Emit("transfer-tcp-error", clientKey, fmt.Sprintf("error forwarding to %s : %v", conn.RemoteAddr(), err))
+		fmt.Printf("Error forwarding to %s: %s\n", conn.RemoteAddr(), err.Error())
+	}
+}
+
+func (s *TCPTransfer) writeIntercepted(packet InterceptedPacket) {
+	s.write(packet.Client, packet.Data, packet.Direction)
+}
+
+// SetIntercept changes the intercept settings of a session. An empty client
+// changes the settings used by sessions that have none of their own.
+// Packets held in a direction that is no longer intercepted are forwarded.
+func (s *TCPTransfer) SetIntercept(client string, settings InterceptSettings) error {
+	if client != "" && s.getTransferConn(client) == nil {
+		return fmt.Errorf("client %s not found", client)
+	}
+	err := s.intercept.set(client, settings)
+	if err != nil {
+		return err
+	}
+	directions := []string{}
+	if !settings.Src {
+		directions = append(directions, DIRECTION_SRC)
+	}
+	if !settings.Dst {
+		directions = append(directions, DIRECTION_DST)
+	}
+	if client != "" {
+		s.intercept.release(client, s.writeIntercepted, directions...)
+		return nil
+	}
+	for _, inheriting := range s.intercept.inheriting() {
+		s.intercept.release(inheriting, s.writeIntercepted, directions...)
+	}
+	return nil
+}
+
+// Intercept returns the intercept settings of a session.
+func (s *TCPTransfer) Intercept(client string) InterceptSettings {
+	return s.intercept.get(client)
+}
+
+// InterceptList returns the held packets of a session, or of every session when client is empty.
+func (s *TCPTransfer) InterceptList(client string) []InterceptedPacket {
+	return s.intercept.list(client)
+}
+
+// InterceptPacket returns the held packet id.
+func (s *TCPTransfer) InterceptPacket(id int) (InterceptedPacket, bool) {
+	return s.intercept.find(id)
+}
+
+// InterceptResolve forwards, drops or replaces a held packet.
+func (s *TCPTransfer) InterceptResolve(id int, action string, message []byte) (*InterceptedPacket, error) {
+	return s.intercept.resolve(id, action, message, s.writeIntercepted)
+}
+
+// InterceptRelease forwards every held packet of a session, or of every session when client is empty.
+func (s *TCPTransfer) InterceptRelease(client string) {
+	s.intercept.release(client, s.writeIntercepted, DIRECTION_SRC, DIRECTION_DST)
+}
+
+// SetShaping changes the simulated network conditions of a session. An empty client changes the
+// conditions given to sessions accepted afterwards.
+func (s *TCPTransfer) SetShaping(client string, shaping TrafficShaping) error {
+	if err := shaping.check(); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if client == "" {
+		s.shaping = shaping
+		return nil
+	}
+	transferConn, ok := s.clients[client]
+	if !ok {
+		return fmt.Errorf("client %s not found", client)
+	}
+	transferConn.shaping = shaping
+	s.applyShaping(client, transferConn)
+	return nil
+}
+
+// Shaping returns the simulated network conditions of a session, or the default ones when client is empty.
+func (s *TCPTransfer) Shaping(client string) (TrafficShaping, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if client == "" {
+		return s.shaping, nil
+	}
+	transferConn, ok := s.clients[client]
+	if !ok {
+		return TrafficShaping{}, fmt.Errorf("client %s not found", client)
+	}
+	return transferConn.shaping, nil
+}
+
+// applyShaping updates the lanes of a session to its shaping, the caller holds the mutex.
+func (s *TCPTransfer) applyShaping(clientKey string, transferConn *TransferConn) {
+	for _, direction := range []string{DIRECTION_SRC, DIRECTION_DST} {
+		settings := transferConn.shaping.direction(direction)
+		if lane, ok := transferConn.lanes[direction]; ok {
+			lane.set(settings)
+			continue
+		}
+		if !settings.active() {
+			continue
+		}
+		if transferConn.lanes == nil {
+			transferConn.lanes = map[string]*shapingLane{}
+		}
+		direction := direction
+		transferConn.lanes[direction] = newShapingLane(settings, func(data []byte) {
+			s.send(clientKey, data, direction)
+		}, func() {
+			s.events.Emit("transfer-tcp-info", clientKey, "simulated connection reset")
+			transferConn.clientConn.Close()
+		})
 	}
 }
 
@@ -140,74 +640,128 @@ func (s *TCPTransfer) getTransferConn(clientKey string) *TransferConn {
 	if !ok {
 		return nil
 	}
-	return &transferConn
+	return transferConn
+}
+
+// SetForwardMode switches a session between FORWARD_MODE_AUTO and FORWARD_MODE_MANUAL.
+// An empty client changes the mode given to sessions accepted afterwards.
+func (s *TCPTransfer) SetForwardMode(client string, mode string) error {
+	if mode != FORWARD_MODE_AUTO && mode != FORWARD_MODE_MANUAL {
+		return fmt.Errorf("unknown forward mode %s", mode)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if client == "" {
+		s.forwardMode = mode
+		return nil
+	}
+	transferConn, ok := s.clients[client]
+	if !ok {
+		return fmt.Errorf("client %s not found", client)
+	}
+	transferConn.forwardMode = mode
+	return nil
+}
+
+// ForwardMode returns the forward mode of a session, or the default mode when client is empty.
+func (s *TCPTransfer) ForwardMode(client string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if client == "" {
+		return s.forwardMode, nil
+	}
+	transferConn, ok := s.clients[client]
+	if !ok {
+		return "", fmt.Errorf("client %s not found", client)
+	}
+	return transferConn.forwardMode, nil
 }
 
-func (s *TCPTransfer) reconnect(clientKey string) {
+func (s *TCPTransfer) reconnect(clientKey string) net.Conn {
 	for {
 		transferConn := s.getTransferConn(clientKey)
 		if transferConn == nil {
-			return
+			return nil
 		}
-		conn, err := net.Dial("tcp", s.dstAddress)
+		conn, err := s.dialer.DialServerName(transferConn.dstAddress, transferConn.serverName)
 		if err == nil {
+			conn = s.capture.Wrap(conn, "transfer-dst", true)
 			s.mutex.Lock()
 			transferConn.serverConn = conn
-			s.clients[clientKey] = *transferConn
 			s.mutex.Unlock()
-			s.app.EventsEmit("transfer-tcp-info", clientKey, "connection reconnected")
-			return
+			if transferConn.sequence != nil {
+				// the new connection starts over from the next counter of the client
+				transferConn.sequence.reset()
+			}
+			s.events.Emit("transfer-tcp-info", clientKey, "connection reconnected")
+			return conn
 		}
-		s.app.EventsEmit("transfer-tcp-info", clientKey, "trying to reconnect...")
+		s.events.Emit("transfer-tcp-info", clientKey, "trying to reconnect...")
 		time.Sleep(RECONNECT_INTERVAL)
 	}
 }
 
-func (s *TCPTransfer) handleEvents() {
+func (s *TCPTransfer) handleEvents(shutdown chan bool) {
 	for {
 		select {
-		case <-s.shutdown:
+		case <-shutdown:
 			s.mutex.Lock()
 			for addr, client := range s.clients {
 				client.serverConn.Close()
 				client.clientConn.Close()
-				s.app.EventsEmit("transfer-tcp-info", addr, fmt.Sprintf("close connection %s", addr))
+				for _, lane := range client.lanes {
+					lane.stop()
+				}
+				s.untrack(addr)
+				s.events.Emit("transfer-tcp-info", addr, fmt.Sprintf("close connection %s", addr))
 				fmt.Printf("Close connection %s\n", addr)
 			}
-			s.clients = make(map[string]TransferConn)
+			s.clients = make(map[string]*TransferConn)
 			s.mutex.Unlock()
-			break
-		case clientConn := <-s.addClient:
-			if s.listener == nil {
-				clientConn.Close()
+			return
+		case transferConn := <-s.addClient:
+			clientConn := transferConn.clientConn
+			clientKey := transferKey(clientConn)
+			s.mutex.Lock()
+			if s.shutdown != shutdown {
+				// accepted by a run that was stopped since
+				s.mutex.Unlock()
+				transferConn.clientConn.Close()
+				transferConn.serverConn.Close()
 				break
 			}
-			serverConn, err := net.Dial("tcp", s.dstAddress)
-			if err != nil {
-				s.app.EventsEmit("transfer-tcp-info", clientConn.RemoteAddr().String(), fmt.Sprintf("failed to connect to %s: %v", s.dstAddress, err))
-				clientConn.Close()
-				break
+			transferConn.forwardMode = s.forwardMode
+			transferConn.shaping = s.shaping
+			if s.seqRewrite {
+				transferConn.sequence = &sequencer{}
 			}
-			s.mutex.Lock()
-			s.clients[clientConn.RemoteAddr().String()] = TransferConn{clientConn: clientConn, serverConn: serverConn}
+			s.clients[clientKey] = transferConn
+			s.applyShaping(clientKey, transferConn)
 			s.mutex.Unlock()
-			go s.handleClientConnection(clientConn)
-			go s.handleServerConnection(clientConn, serverConn)
+			go s.handleClientConnection(clientConn, shutdown)
+			go s.handleServerConnection(clientConn, transferConn.serverConn)
 		case conn := <-s.removeClient:
-			clientKey := conn.RemoteAddr().String()
+			clientKey := transferKey(conn)
 			s.mutex.Lock()
 			transferConn, ok := s.clients[clientKey]
 			delete(s.clients, clientKey)
 			if ok {
 				transferConn.serverConn.Close()
+				for _, lane := range transferConn.lanes {
+					lane.stop()
+				}
 			}
 			s.mutex.Unlock()
+			s.intercept.removeSession(clientKey)
+			if ok {
+				s.untrack(clientKey)
+			}
 		case message := <-s.broadcastClient:
 			s.mutex.RLock()
 			for addr, client := range s.clients {
 				_, err := client.clientConn.Write(message)
 				if err != nil {
-					s.app.EventsEmit("transfer-tcp-error", addr, fmt.Sprintf("error broadcasting message to client %s : %v", addr, err))
+					s.events.Emit("transfer-tcp-error", addr, fmt.Sprintf("error broadcasting message to client %s : %v", addr, err))
 					fmt.Printf("Error broadcasting message to client %s: %s\n", addr, err.Error())
 				}
 			}
@@ -215,9 +769,9 @@ func (s *TCPTransfer) handleEvents() {
 		case message := <-s.broadcastServer:
 			s.mutex.RLock()
 			for addr, client := range s.clients {
-				_, err := client.serverConn.Write(message)
+				_, err := client.writeServer(client.serverConn, message)
 				if err != nil {
-					s.app.EventsEmit("transfer-tcp-error", addr, fmt.Sprintf("error broadcasting message to client %s : %v", addr, err))
+					s.events.Emit("transfer-tcp-error", addr, fmt.Sprintf("error broadcasting message to client %s : %v", addr, err))
 					fmt.Printf("Error broadcasting message to client %s: %s\n", addr, err.Error())
 				}
 			}
@@ -226,42 +780,45 @@ func (s *TCPTransfer) handleEvents() {
 	}
 }
 
+// SendToServer injects a message towards the server of a session. It queues behind the shaped chunks
+// of the session, so it keeps its place in the stream.
 func (s *TCPTransfer) SendToServer(client string, message []byte) error {
-	s.mutex.RLock()
-	conn, ok := s.clients[client]
-	s.mutex.RUnlock()
-	if !ok {
-		s.app.EventsEmit("transfer-tcp-error", client, fmt.Sprintf("client %s not found", client))
-		return fmt.Errorf("client %s not found", client)
-	}
-
-	_, err := conn.serverConn.Write(message)
-	if err != nil {
-		return err
-	}
-	return nil
+	return s.inject(client, message, DIRECTION_SRC)
 }
 
+// SendToClient injects a message towards a client, like SendToServer.
 func (s *TCPTransfer) SendToClient(client string, message []byte) error {
-	s.mutex.RLock()
-	conn, ok := s.clients[client]
-	s.mutex.RUnlock()
-	if !ok {
-		s.app.EventsEmit("transfer-tcp-error", client, fmt.Sprintf("client %s not found", client))
-		return fmt.Errorf("client %s not found", client)
-	}
+	return s.inject(client, message, DIRECTION_DST)
+}
 
-	_, err := conn.clientConn.Write(message)
-	if err != nil {
-		return err
+func (s *TCPTransfer) inject(client string, message []byte, direction string) error {
+	if s.getTransferConn(client) == nil {
+		s.events.Emit("transfer-tcp-error", client, fmt.Sprintf("client %s not found", client))
+		return fmt.Errorf("client %s not found", client)
 	}
+	s.write(client, message, direction)
 	return nil
 }
 
+// BroadcastToServer sends a message to the server of every session, it is dropped when the transfer is stopped.
 func (s *TCPTransfer) BroadcastToServer(message []byte) {
-	s.broadcastServer <- message
+	s.broadcast(s.broadcastServer, message)
 }
 
+// BroadcastToClient sends a message to every client, like BroadcastToServer.
 func (s *TCPTransfer) BroadcastToClient(message []byte) {
-	s.broadcastClient <- message
+	s.broadcast(s.broadcastClient, message)
+}
+
+func (s *TCPTransfer) broadcast(queue chan []byte, message []byte) {
+	s.mutex.RLock()
+	shutdown := s.shutdown
+	s.mutex.RUnlock()
+	if shutdown == nil {
+		return
+	}
+	select {
+	case queue <- message:
+	case <-shutdown:
+	}
 }
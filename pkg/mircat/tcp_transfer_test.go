@@ -0,0 +1,227 @@
+package mircat
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// startTransfer starts transfer on a free port towards a server of its own and connects a client to it.
+// It returns the client and the server side of the session once the session is running.
+func startTransfer(t *testing.T, transfer *TCPTransfer, srcFramer FramerConfig, dstFramer FramerConfig) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	if err := transfer.Start("127.0.0.1:0", listener.Addr().String(), srcFramer, dstFramer); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(transfer.Stop)
+	client, err := net.Dial("tcp", transfer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	var server net.Conn
+	select {
+	case server = <-accepted:
+		t.Cleanup(func() { server.Close() })
+	case <-time.After(2 * time.Second):
+		t.Fatal("the transfer did not connect to the server")
+	}
+	for deadline := time.Now().Add(2 * time.Second); transfer.getTransferConn(client.LocalAddr().String()) == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("the session did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return client, server
+}
+
+// receive reads size bytes from conn.
+func receive(t *testing.T, conn net.Conn, size int) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	data := make([]byte, size)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		t.Fatalf("read %q: %v", data, err)
+	}
+	return string(data)
+}
+
+// silent fails if conn receives anything within a short while.
+func silent(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	b := make([]byte, 64)
+	if n, err := conn.Read(b); err == nil {
+		t.Fatalf("received %q", b[:n])
+	}
+}
+
+func TestTCPTransferForwardsBothWays(t *testing.T) {
+	rec := NewEventRecorder()
+	client, server := startTransfer(t, NewTCPTransfer(nil, rec), FramerConfig{}, FramerConfig{})
+
+	client.Write([]byte("ping"))
+	if got := receive(t, server, 4); got != "ping" {
+		t.Fatalf("server received %q", got)
+	}
+	server.Write([]byte("pong"))
+	if got := receive(t, client, 4); got != "pong" {
+		t.Fatalf("client received %q", got)
+	}
+	src, _ := rec.Wait("transfer-src-data", 1, time.Second)
+	dst, _ := rec.Wait("transfer-dst-data", 1, time.Second)
+	if len(src) != 1 || len(dst) != 1 || src[0].Data[0] != client.LocalAddr().String() {
+		t.Fatalf("data events src %+v dst %+v", src, dst)
+	}
+}
+
+func TestTCPTransferManualMode(t *testing.T) {
+	rec := NewEventRecorder()
+	transfer := NewTCPTransfer(nil, rec)
+	client, server := startTransfer(t, transfer, FramerConfig{}, FramerConfig{})
+	key := client.LocalAddr().String()
+	if err := transfer.SetForwardMode(key, FORWARD_MODE_MANUAL); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Write([]byte("held"))
+	if _, ok := rec.Wait("transfer-src-data", 1, time.Second); !ok {
+		t.Fatal("the UI got no copy of the data")
+	}
+	silent(t, server)
+
+	// in manual mode the UI relays the data itself
+	if err := transfer.SendToServer(key, []byte("sent")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, server, 4); got != "sent" {
+		t.Fatalf("server received %q", got)
+	}
+	if err := transfer.SendToClient(key, []byte("back")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, client, 4); got != "back" {
+		t.Fatalf("client received %q", got)
+	}
+
+	if err := transfer.SetForwardMode("127.0.0.1:1", FORWARD_MODE_MANUAL); err == nil {
+		t.Error("switched a session that does not exist")
+	}
+	if err := transfer.SetForwardMode(key, "paused"); err == nil {
+		t.Error("accepted an unknown mode")
+	}
+	if err := transfer.SendToServer("127.0.0.1:1", []byte("lost")); err == nil {
+		t.Error("sent to a session that does not exist")
+	}
+}
+
+func TestTransferSetForwardModeInstance(t *testing.T) {
+	rec := NewEventRecorder()
+	m := NewConnManager(NewAppWithSink(rec), &Config{})
+	if m.TransferSetForwardModeInstance("game", "", FORWARD_MODE_MANUAL) {
+		t.Fatal("switched an instance that never started")
+	}
+
+	transfer := m.transferInstance("game")
+	client, server := startTransfer(t, transfer, FramerConfig{}, FramerConfig{})
+	key := client.LocalAddr().String()
+	if !m.TransferSetForwardModeInstance("game", key, FORWARD_MODE_MANUAL) {
+		t.Fatalf("switching failed: %+v", rec.Events("transfer-tcp-error"))
+	}
+	if mode := m.TransferGetForwardModeInstance("game", key); mode != FORWARD_MODE_MANUAL {
+		t.Fatalf("mode %s", mode)
+	}
+	if mode, _ := m.transfer.ForwardMode(""); mode != FORWARD_MODE_AUTO {
+		t.Fatalf("the main transfer was switched to %s", mode)
+	}
+	client.Write([]byte("held"))
+	silent(t, server)
+}
+
+func TestTCPTransferRelaysReadsAndFramesEvents(t *testing.T) {
+	rec := NewEventRecorder()
+	client, server := startTransfer(t, NewTCPTransfer(nil, rec), FramerConfig{Type: FRAMER_DELIMITER, Delimiter: "!"}, FramerConfig{})
+
+	// an incomplete message is relayed as soon as it is read
+	client.Write([]byte("#1ab"))
+	if got := receive(t, server, 4); got != "#1ab" {
+		t.Fatalf("server received %q", got)
+	}
+	client.Write([]byte("c!#2d"))
+	if got := receive(t, server, 5); got != "c!#2d" {
+		t.Fatalf("server received %q", got)
+	}
+	client.Close()
+
+	// the events carry whole messages, and what is left when the client leaves
+	events, ok := rec.Wait("transfer-src-data", 2, 2*time.Second)
+	if !ok || string(events[0].Data[1].([]byte)) != "#1abc!" || string(events[1].Data[1].([]byte)) != "#2d" {
+		t.Fatalf("data events %+v", events)
+	}
+}
+
+func TestTCPTransferInterceptsFramedMessages(t *testing.T) {
+	rec := NewEventRecorder()
+	transfer := NewTCPTransfer(nil, rec)
+	client, server := startTransfer(t, transfer, FramerConfig{Type: FRAMER_DELIMITER, Delimiter: "!"}, FramerConfig{})
+	key := client.LocalAddr().String()
+	transfer.SetIntercept(key, InterceptSettings{Src: true, Rules: []InterceptRule{{Prefix: "2332"}}})
+
+	// the second packet of the read matches the prefix rule on its own
+	client.Write([]byte("#1a!#2b!#3"))
+	if got := receive(t, server, 4); got != "#1a!" {
+		t.Fatalf("server received %q", got)
+	}
+	events, ok := rec.Wait("transfer-intercept-held", 1, time.Second)
+	if !ok || string(events[0].Data[1].(InterceptedPacket).Data) != "#2b!" {
+		t.Fatalf("held %+v", events)
+	}
+	client.Write([]byte("c!"))
+	silent(t, server)
+
+	transfer.InterceptRelease(key)
+	if got := receive(t, server, 8); got != "#2b!#3c!" {
+		t.Fatalf("server received %q", got)
+	}
+}
+
+func TestRelayStateSwitchesWithoutDuplicates(t *testing.T) {
+	framer, _ := NewFramer(FramerConfig{Type: FRAMER_DELIMITER, Delimiter: "!"})
+	relay := &relayState{}
+	relayed := ""
+	read := func(chunk string, framed bool) {
+		messages, _ := framer.Feed([]byte(chunk))
+		for _, data := range relay.next([]byte(chunk), messages, framed) {
+			relayed += string(data) + "|"
+		}
+	}
+	read("#1a", false)
+	// intercept is turned on in the middle of a message, only what was not relayed yet follows it
+	read("b!#2", true)
+	read("c!", true)
+	// turned off with an incomplete message waiting, it goes out once complete, then reads are relayed again
+	read("#3", true)
+	read("d", false)
+	read("!", false)
+	read("#4", false)
+	if unsent := relay.rest(framer.Flush()); len(unsent) != 0 {
+		t.Fatalf("unsent %q", unsent)
+	}
+	if relayed != "#1a|b!|#2c!|#3d!|#4|" {
+		t.Fatalf("relayed %q", relayed)
+	}
+}
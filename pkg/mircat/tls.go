@@ -0,0 +1,238 @@
+package mircat
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// TLS_HANDSHAKE_TIMEOUT bounds the TLS handshake of outbound connections.
+const TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+// DIAL_TIMEOUT bounds the TCP connection to a destination or to the first upstream proxy.
+const DIAL_TIMEOUT = 10 * time.Second
+
+// TLSClientConfig makes outbound connections speak TLS.
+type TLSClientConfig struct {
+	// Enabled wraps the connections in TLS.
+	Enabled bool `json:"enabled"`
+	// ServerName is the name sent as SNI and checked against the certificate, the host dialed when empty.
+	ServerName string `json:"serverName"`
+	// CAFile is a PEM file of the certificate authorities trusted in addition to the system ones.
+	CAFile string `json:"caFile"`
+	// InsecureSkipVerify accepts any certificate, for test servers with self-signed ones.
+	InsecureSkipVerify bool `json:"insecureSkipVerify"`
+}
+
+// TLSServerConfig makes a listener terminate TLS.
+type TLSServerConfig struct {
+	// Enabled terminates TLS on the accepted connections.
+	Enabled bool `json:"enabled"`
+	// CertFile and KeyFile are the PEM certificate and key, a self-signed certificate is generated when empty.
+	CertFile string `json:"certFile"`
+	KeyFile  string `json:"keyFile"`
+	// Hosts are the names and addresses of the generated certificate, localhost and 127.0.0.1 when empty.
+	Hosts []string `json:"hosts"`
+	// Mitm presents to each client a certificate for the name it asked, minted by the MirCat root CA
+	// instead of CertFile. A transfer then opens the dst side with the same SNI, it refuses to start
+	// unless its DstTLS is enabled.
+	Mitm bool `json:"mitm"`
+}
+
+// config returns the TLS configuration of cfg, nil when TLS is disabled.
+func (cfg TLSClientConfig) config() (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	config := &tls.Config{ServerName: cfg.ServerName, InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// config returns the TLS configuration of cfg, nil when TLS is disabled.
+func (cfg TLSServerConfig) config() (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var certificate tls.Certificate
+	var err error
+	if cfg.CertFile != "" {
+		certificate, err = tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	} else {
+		certificate, err = selfSignedCertificate(cfg.Hosts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{certificate}}, nil
+}
+
+// describe tells which certificate a listener with cfg presents.
+func (cfg TLSServerConfig) describe() string {
+	if cfg.Mitm {
+		return "tls with certificates of the MirCat root CA"
+	}
+	if cfg.CertFile != "" {
+		return "tls with the certificate of " + cfg.CertFile
+	}
+	return "tls with a self-signed certificate"
+}
+
+// tlsServerName returns the SNI sent by the client of a TLS connection, empty for plain connections.
+func tlsServerName(conn net.Conn) string {
+	if proxied, ok := conn.(*targetConn); ok {
+		conn = proxied.Conn
+	}
+	if wrapped, ok := conn.(*captureConn); ok {
+		conn = wrapped.Conn
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		return tlsConn.ConnectionState().ServerName
+	}
+	return ""
+}
+
+// selfSignedCertificate generates a certificate for hosts signed by its own key.
+func selfSignedCertificate(hosts []string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1"}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template, err := certificateTemplate(hosts[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return signCertificate(template, template, key, key)
+}
+
+// certificateTemplate returns the template of a server certificate valid for a year.
+func certificateTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"MirCat"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, nil
+}
+
+// signCertificate signs template with the key of parent and returns it with the key of its owner.
+func signCertificate(template *x509.Certificate, parent *x509.Certificate, key *ecdsa.PrivateKey, parentKey *ecdsa.PrivateKey) (tls.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
+
+// Dialer opens the outbound connections of the TCP client and the transfer. A nil Dialer dials plain TCP.
+type Dialer struct {
+	// TLS, when set, wraps the connections in TLS.
+	TLS *tls.Config
+	// Proxies are the upstream proxies the connections go through, in order.
+	Proxies []UpstreamProxy
+}
+
+// NewDialer returns the dialer of an outbound TLS configuration and a chain of upstream proxies.
+func NewDialer(tlsConfig TLSClientConfig, proxies []UpstreamProxy) (*Dialer, error) {
+	config, err := tlsConfig.config()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkUpstream(proxies); err != nil {
+		return nil, err
+	}
+	return &Dialer{TLS: config, Proxies: proxies}, nil
+}
+
+// Dial connects to address, through the upstream proxies, and completes the TLS handshake when enabled.
+func (d *Dialer) Dial(address string) (net.Conn, error) {
+	return d.DialServerName(address, "")
+}
+
+// DialServerName is Dial sending serverName as SNI unless the configuration names the server,
+// the host of address is sent when both are empty.
+func (d *Dialer) DialServerName(address string, serverName string) (net.Conn, error) {
+	conn, err := d.connect(address)
+	if err != nil {
+		return nil, err
+	}
+	return d.handshake(conn, address, serverName)
+}
+
+// connect opens the connection to address through the upstream proxies, without TLS.
+func (d *Dialer) connect(address string) (net.Conn, error) {
+	if d == nil {
+		return net.DialTimeout("tcp", address, DIAL_TIMEOUT)
+	}
+	return dialUpstream(d.Proxies, address)
+}
+
+// handshake completes the TLS handshake of a connection opened by connect, when TLS is enabled.
+// The connection is closed on error.
+func (d *Dialer) handshake(conn net.Conn, address string, serverName string) (net.Conn, error) {
+	if d == nil || d.TLS == nil {
+		return conn, nil
+	}
+	var err error
+	config := d.TLS
+	if config.ServerName == "" {
+		if serverName == "" {
+			serverName, _, err = net.SplitHostPort(address)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		config = config.Clone()
+		config.ServerName = serverName
+	}
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("tls handshake with %s: %v", address, err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
@@ -0,0 +1,204 @@
+package mircat
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tlsEchoServer runs a TLS server with a self-signed certificate for localhost and 127.0.0.1 that echoes
+// what it receives. It returns its address, a PEM file of its certificate and the SNI of each connection.
+func tlsEchoServer(t *testing.T) (string, string, chan string) {
+	t.Helper()
+	config, err := TLSServerConfig{Enabled: true}.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	names := make(chan string, 8)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tlsConn := conn.(*tls.Conn)
+				if tlsConn.Handshake() != nil {
+					return
+				}
+				names <- tlsConn.ConnectionState().ServerName
+				buffer := make([]byte, 64)
+				for {
+					n, err := conn.Read(buffer)
+					if err != nil {
+						return
+					}
+					conn.Write(buffer[:n])
+				}
+			}()
+		}
+	}()
+	caFile := filepath.Join(t.TempDir(), "server.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: config.Certificates[0].Certificate[0]})
+	if err := os.WriteFile(caFile, certificate, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return listener.Addr().String(), caFile, names
+}
+
+func TestTLSServerConfig(t *testing.T) {
+	if config, err := (TLSServerConfig{}).config(); config != nil || err != nil {
+		t.Fatalf("disabled config %v, %v", config, err)
+	}
+	config, err := TLSServerConfig{Enabled: true, Hosts: []string{"mir.example", "10.1.2.3"}}.config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := config.Certificates[0].Leaf
+	if leaf.Subject.CommonName != "mir.example" || len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "mir.example" ||
+		len(leaf.IPAddresses) != 1 || leaf.IPAddresses[0].String() != "10.1.2.3" || leaf.VerifyHostname("mir.example") != nil {
+		t.Fatalf("certificate for %q %v", leaf.DNSNames, leaf.IPAddresses)
+	}
+	if _, err := (TLSServerConfig{Enabled: true, CertFile: "missing.pem", KeyFile: "missing.key"}).config(); err == nil {
+		t.Fatal("loaded a missing certificate")
+	}
+	tests := []struct {
+		cfg         TLSServerConfig
+		description string
+	}{
+		{TLSServerConfig{Enabled: true}, "tls with a self-signed certificate"},
+		{TLSServerConfig{Enabled: true, CertFile: "a.pem"}, "tls with the certificate of a.pem"},
+		{TLSServerConfig{Enabled: true, Mitm: true}, "tls with certificates of the MirCat root CA"},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.describe(); got != tt.description {
+			t.Errorf("%+v described as %q", tt.cfg, got)
+		}
+	}
+}
+
+func TestDialerTLS(t *testing.T) {
+	addr, caFile, names := tlsEchoServer(t)
+	_, port, _ := net.SplitHostPort(addr)
+
+	dialer, err := NewDialer(TLSClientConfig{Enabled: true, CAFile: caFile}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial("localhost:" + port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hi"))
+	if got := receive(t, conn, 2); got != "hi" {
+		t.Fatalf("received %q", got)
+	}
+	// the host dialed is the SNI
+	if name := <-names; name != "localhost" {
+		t.Fatalf("SNI %q", name)
+	}
+
+	// the certificate is checked against the configured name and the trusted authorities
+	named, _ := NewDialer(TLSClientConfig{Enabled: true, CAFile: caFile, ServerName: "mir.example"}, nil)
+	if _, err := named.Dial(addr); err == nil || !strings.Contains(err.Error(), "tls handshake") {
+		t.Fatalf("a certificate for another name was accepted: %v", err)
+	}
+	untrusted, _ := NewDialer(TLSClientConfig{Enabled: true}, nil)
+	if _, err := untrusted.Dial(addr); err == nil {
+		t.Fatal("a self-signed certificate was accepted")
+	}
+	insecure, _ := NewDialer(TLSClientConfig{Enabled: true, ServerName: "mir.example", InsecureSkipVerify: true}, nil)
+	conn, err = insecure.DialServerName(addr, "ignored.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if name := <-names; name != "mir.example" {
+		t.Fatalf("SNI %q", name)
+	}
+
+	if _, err := NewDialer(TLSClientConfig{Enabled: true, CAFile: filepath.Join(t.TempDir(), "none.pem")}, nil); err == nil {
+		t.Fatal("a missing CA file was accepted")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	os.WriteFile(empty, []byte("no certificate"), 0644)
+	if _, err := NewDialer(TLSClientConfig{Enabled: true, CAFile: empty}, nil); err == nil {
+		t.Fatal("a CA file without certificate was accepted")
+	}
+}
+
+func TestTCPServerTLS(t *testing.T) {
+	rec := NewEventRecorder()
+	server := NewTCPServer(nil, rec)
+	config, _ := TLSServerConfig{Enabled: true}.config()
+	server.SetTLS(config)
+	if err := server.Start("127.0.0.1:0", FramerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	conn, err := tls.Dial("tcp", server.listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("secret"))
+	events, ok := rec.Wait("server-tcp-data", 1, 2*time.Second)
+	if !ok || string(events[0].Data[1].([]byte)) != "secret" {
+		t.Fatalf("data events %+v", events)
+	}
+}
+
+func TestTCPTransferTLS(t *testing.T) {
+	addr, _, names := tlsEchoServer(t)
+	rec := NewEventRecorder()
+	transfer := NewTCPTransfer(nil, rec)
+	srcConfig, _ := TLSServerConfig{Enabled: true}.config()
+	dialer, _ := NewDialer(TLSClientConfig{Enabled: true, InsecureSkipVerify: true}, nil)
+	transfer.SetTLS(srcConfig, dialer)
+	if err := transfer.Start("127.0.0.1:0", addr, FramerConfig{}, FramerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	defer transfer.Stop()
+
+	// the dst side is opened with the SNI of the client
+	client, err := tls.Dial("tcp", transfer.Addr().String(), &tls.Config{InsecureSkipVerify: true, ServerName: "game.example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+	if got := receive(t, client, 4); got != "ping" {
+		t.Fatalf("client received %q", got)
+	}
+	if name := <-names; name != "game.example" {
+		t.Fatalf("SNI %q", name)
+	}
+	events, _ := rec.Wait("transfer-src-data", 1, time.Second)
+	if len(events) != 1 || string(events[0].Data[1].([]byte)) != "ping" {
+		t.Fatalf("src events %+v", events)
+	}
+
+	// a client speaking plain TCP is dropped
+	plain, err := net.Dial("tcp", transfer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	plain.Write([]byte("plain text, not a client hello"))
+	if _, ok := rec.Wait("transfer-tcp-error", 1, 2*time.Second); !ok {
+		t.Fatal("no handshake error")
+	}
+}
@@ -0,0 +1,357 @@
+package mircat
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DIRECTION_SRC is the traffic sent by the client to the server.
+	DIRECTION_SRC = "src"
+	// DIRECTION_DST is the traffic sent by the server to the client.
+	DIRECTION_DST = "dst"
+)
+
+const (
+	INTERCEPT_ACTION_FORWARD = "forward"
+	INTERCEPT_ACTION_DROP    = "drop"
+	INTERCEPT_ACTION_EDIT    = "edit"
+)
+
+// InterceptRule describes which packets are held. Every non-empty condition must match.
+type InterceptRule struct {
+	// Direction limits the rule to "src" or "dst", empty matches both.
+	Direction string `json:"direction"`
+	// Prefix is a hex string the packet must start with.
+	Prefix string `json:"prefix"`
+	// Contains is a hex string the packet must contain.
+	Contains string `json:"contains"`
+	// Regexp is a regular expression matched against the raw packet bytes.
+	Regexp string `json:"regexp"`
+	// MinLength is the minimum packet length, 0 means no limit.
+	MinLength int `json:"minLength"`
+	// MaxLength is the maximum packet length, 0 means no limit.
+	MaxLength int `json:"maxLength"`
+}
+
+// InterceptSettings controls the intercept mode of a transfer session.
+type InterceptSettings struct {
+	// Src holds packets sent by the client.
+	Src bool `json:"src"`
+	// Dst holds packets sent by the server.
+	Dst bool `json:"dst"`
+	// Rules restricts the held packets, an empty list holds every packet of the enabled directions.
+	Rules []InterceptRule `json:"rules"`
+}
+
+// InterceptedPacket is a packet waiting in a hold queue.
+type InterceptedPacket struct {
+	Id        int    `json:"id"`
+	Client    string `json:"client"`
+	Direction string `json:"direction"`
+	Data      []byte `json:"data"`
+	Time      int64  `json:"time"`
+}
+
+type compiledRule struct {
+	direction string
+	prefix    []byte
+	contains  []byte
+	regexp    *regexp.Regexp
+	minLength int
+	maxLength int
+}
+
+func (r *compiledRule) match(direction string, data []byte) bool {
+	if r.direction != "" && r.direction != direction {
+		return false
+	}
+	if r.minLength > 0 && len(data) < r.minLength {
+		return false
+	}
+	if r.maxLength > 0 && len(data) > r.maxLength {
+		return false
+	}
+	if len(r.prefix) > 0 && !bytes.HasPrefix(data, r.prefix) {
+		return false
+	}
+	if len(r.contains) > 0 && !bytes.Contains(data, r.contains) {
+		return false
+	}
+	if r.regexp != nil && !r.regexp.Match(data) {
+		return false
+	}
+	return true
+}
+
+type interceptSession struct {
+	settings InterceptSettings
+	rules    []*compiledRule
+}
+
+func newInterceptSession(settings InterceptSettings) (*interceptSession, error) {
+	session := &interceptSession{settings: settings}
+	for _, rule := range settings.Rules {
+		if rule.Direction != "" && rule.Direction != DIRECTION_SRC && rule.Direction != DIRECTION_DST {
+			return nil, fmt.Errorf("unknown direction %s", rule.Direction)
+		}
+		compiled := &compiledRule{direction: rule.Direction, minLength: rule.MinLength, maxLength: rule.MaxLength}
+		var err error
+		if compiled.prefix, err = hex.DecodeString(rule.Prefix); err != nil {
+			return nil, fmt.Errorf("invalid prefix %s: %v", rule.Prefix, err)
+		}
+		if compiled.contains, err = hex.DecodeString(rule.Contains); err != nil {
+			return nil, fmt.Errorf("invalid contains %s: %v", rule.Contains, err)
+		}
+		if rule.Regexp != "" {
+			if compiled.regexp, err = regexp.Compile(rule.Regexp); err != nil {
+				return nil, fmt.Errorf("invalid regexp %s: %v", rule.Regexp, err)
+			}
+		}
+		session.rules = append(session.rules, compiled)
+	}
+	return session, nil
+}
+
+func (i *interceptSession) match(direction string, data []byte) bool {
+	if (direction == DIRECTION_SRC && !i.settings.Src) || (direction == DIRECTION_DST && !i.settings.Dst) {
+		return false
+	}
+	if len(i.rules) == 0 {
+		return true
+	}
+	for _, rule := range i.rules {
+		if rule.match(direction, data) {
+			return true
+		}
+	}
+	return false
+}
+
+type heldPacket struct {
+	packet InterceptedPacket
+	held   bool
+	action string
+}
+
+// holdQueue keeps the packets of one session direction in order. Packets that
+// do not match any rule are queued behind held ones so the stream is never reordered.
+type holdQueue struct {
+	mutex   sync.Mutex
+	packets []*heldPacket
+}
+
+type interceptor struct {
+	mutex    sync.Mutex
+	nextId   int
+	sessions map[string]*interceptSession
+	queues   map[string]map[string]*holdQueue
+}
+
+func newInterceptor() *interceptor {
+	return &interceptor{
+		sessions: make(map[string]*interceptSession),
+		queues:   make(map[string]map[string]*holdQueue),
+	}
+}
+
+func (i *interceptor) set(client string, settings InterceptSettings) error {
+	session, err := newInterceptSession(settings)
+	if err != nil {
+		return err
+	}
+	i.mutex.Lock()
+	i.sessions[client] = session
+	i.mutex.Unlock()
+	return nil
+}
+
+func (i *interceptor) get(client string) InterceptSettings {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	session, ok := i.sessions[client]
+	if !ok {
+		return InterceptSettings{}
+	}
+	return session.settings
+}
+
+// active tells whether a direction of a session is intercepted, by its own settings or the default ones.
+func (i *interceptor) active(client string, direction string) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	session, ok := i.sessions[client]
+	if !ok {
+		session = i.sessions[""]
+	}
+	if session == nil {
+		return false
+	}
+	if direction == DIRECTION_SRC {
+		return session.settings.Src
+	}
+	return session.settings.Dst
+}
+
+// hold queues the packet if it matches the intercept settings of the session,
+// or if earlier packets of the same direction are still held. It reports whether
+// the packet was queued and returns it when it is held for the user.
+func (i *interceptor) hold(client string, direction string, data []byte) (bool, *InterceptedPacket) {
+	i.mutex.Lock()
+	session, ok := i.sessions[client]
+	if !ok {
+		session = i.sessions[""]
+	}
+	if i.queues[client] == nil {
+		i.queues[client] = map[string]*holdQueue{DIRECTION_SRC: {}, DIRECTION_DST: {}}
+	}
+	queue := i.queues[client][direction]
+	match := session != nil && session.match(direction, data)
+	id := 0
+	if match {
+		i.nextId++
+		id = i.nextId
+	}
+	i.mutex.Unlock()
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if !match && len(queue.packets) == 0 {
+		return false, nil
+	}
+	packet := &heldPacket{
+		packet: InterceptedPacket{Id: id, Client: client, Direction: direction, Data: data, Time: time.Now().UnixMilli()},
+		held:   match,
+	}
+	queue.packets = append(queue.packets, packet)
+	if !match {
+		return true, nil
+	}
+	held := packet.packet
+	return true, &held
+}
+
+// snapshot returns the queues of the given directions of a session, or of every
+// session when client is empty.
+func (i *interceptor) snapshot(client string, directions ...string) []*holdQueue {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	queues := []*holdQueue{}
+	for key, session := range i.queues {
+		if client == "" || key == client {
+			for _, direction := range directions {
+				queues = append(queues, session[direction])
+			}
+		}
+	}
+	return queues
+}
+
+// inheriting returns the sessions that use the default intercept settings.
+func (i *interceptor) inheriting() []string {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	clients := []string{}
+	for key := range i.queues {
+		if _, ok := i.sessions[key]; !ok {
+			clients = append(clients, key)
+		}
+	}
+	return clients
+}
+
+func (i *interceptor) list(client string) []InterceptedPacket {
+	packets := []InterceptedPacket{}
+	for _, queue := range i.snapshot(client, DIRECTION_SRC, DIRECTION_DST) {
+		queue.mutex.Lock()
+		for _, packet := range queue.packets {
+			if packet.held {
+				packets = append(packets, packet.packet)
+			}
+		}
+		queue.mutex.Unlock()
+	}
+	sort.Slice(packets, func(a, b int) bool { return packets[a].Id < packets[b].Id })
+	return packets
+}
+
+// find returns the held packet id.
+func (i *interceptor) find(id int) (InterceptedPacket, bool) {
+	for _, queue := range i.snapshot("", DIRECTION_SRC, DIRECTION_DST) {
+		queue.mutex.Lock()
+		for _, packet := range queue.packets {
+			if packet.held && packet.packet.Id == id {
+				queue.mutex.Unlock()
+				return packet.packet, true
+			}
+		}
+		queue.mutex.Unlock()
+	}
+	return InterceptedPacket{}, false
+}
+
+// resolve applies an action to a held packet and releases, in order, every
+// packet of its queue that is no longer blocked through the write callback.
+func (i *interceptor) resolve(id int, action string, data []byte, write func(packet InterceptedPacket)) (*InterceptedPacket, error) {
+	for _, queue := range i.snapshot("", DIRECTION_SRC, DIRECTION_DST) {
+		queue.mutex.Lock()
+		for _, packet := range queue.packets {
+			if packet.held && packet.packet.Id == id {
+				packet.held = false
+				packet.action = action
+				if action == INTERCEPT_ACTION_EDIT {
+					packet.packet.Data = data
+				}
+				resolved := packet.packet
+				queue.flush(write)
+				queue.mutex.Unlock()
+				return &resolved, nil
+			}
+		}
+		queue.mutex.Unlock()
+	}
+	return nil, fmt.Errorf("intercepted packet %d not found", id)
+}
+
+// release forwards every held packet of the given directions of a session, or
+// of every session when client is empty.
+func (i *interceptor) release(client string, write func(packet InterceptedPacket), directions ...string) {
+	for _, queue := range i.snapshot(client, directions...) {
+		queue.mutex.Lock()
+		for _, packet := range queue.packets {
+			if packet.held {
+				packet.held = false
+				packet.action = INTERCEPT_ACTION_FORWARD
+			}
+		}
+		queue.flush(write)
+		queue.mutex.Unlock()
+	}
+}
+
+func (i *interceptor) removeSession(client string) {
+	i.mutex.Lock()
+	delete(i.sessions, client)
+	delete(i.queues, client)
+	i.mutex.Unlock()
+}
+
+// flush must be called with the queue mutex held.
+func (q *holdQueue) flush(write func(packet InterceptedPacket)) {
+	n := 0
+	for _, packet := range q.packets {
+		if packet.held {
+			break
+		}
+		if packet.action != INTERCEPT_ACTION_DROP {
+			write(packet.packet)
+		}
+		n++
+	}
+	q.packets = q.packets[n:]
+}
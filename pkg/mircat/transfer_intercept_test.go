@@ -0,0 +1,152 @@
+package mircat
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestInterceptRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      InterceptRule
+		direction string
+		data      string
+		held      bool
+	}{
+		{"prefix", InterceptRule{Prefix: "2331"}, DIRECTION_SRC, "#1abc!", true},
+		{"prefix elsewhere", InterceptRule{Prefix: "2331"}, DIRECTION_SRC, "*#1abc!", false},
+		{"contains", InterceptRule{Contains: "6263"}, DIRECTION_SRC, "#1abc!", true},
+		{"regexp", InterceptRule{Regexp: `^#\d`}, DIRECTION_DST, "#5x!", true},
+		{"other direction", InterceptRule{Direction: DIRECTION_DST}, DIRECTION_SRC, "#1abc!", false},
+		{"too short", InterceptRule{MinLength: 10}, DIRECTION_SRC, "#1abc!", false},
+		{"too long", InterceptRule{MaxLength: 3}, DIRECTION_SRC, "#1abc!", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newInterceptor()
+			if err := i.set("c", InterceptSettings{Src: true, Dst: true, Rules: []InterceptRule{tt.rule}}); err != nil {
+				t.Fatal(err)
+			}
+			queued, held := i.hold("c", tt.direction, []byte(tt.data))
+			if queued != tt.held || (held != nil) != tt.held {
+				t.Fatalf("queued %v held %+v, want %v", queued, held, tt.held)
+			}
+		})
+	}
+}
+
+func TestInterceptInvalidRules(t *testing.T) {
+	for _, rule := range []InterceptRule{{Prefix: "zz"}, {Contains: "1"}, {Regexp: "("}, {Direction: "up"}} {
+		if err := newInterceptor().set("", InterceptSettings{Src: true, Rules: []InterceptRule{rule}}); err == nil {
+			t.Errorf("rule %+v accepted", rule)
+		}
+	}
+}
+
+func TestInterceptKeepsOrder(t *testing.T) {
+	i := newInterceptor()
+	i.set("c", InterceptSettings{Src: true, Rules: []InterceptRule{{Prefix: "41"}}})
+	_, first := i.hold("c", DIRECTION_SRC, []byte("A1"))
+	// the packets behind a held one wait even though they match no rule
+	if queued, held := i.hold("c", DIRECTION_SRC, []byte("b")); !queued || held != nil {
+		t.Fatalf("queued %v held %+v", queued, held)
+	}
+	_, second := i.hold("c", DIRECTION_SRC, []byte("A2"))
+	// the other direction is not blocked
+	if queued, _ := i.hold("c", DIRECTION_DST, []byte("A3")); queued {
+		t.Fatal("the dst direction was held")
+	}
+
+	written := []string{}
+	write := func(packet InterceptedPacket) { written = append(written, string(packet.Data)) }
+	if _, err := i.resolve(second.Id, INTERCEPT_ACTION_EDIT, []byte("A2'"), write); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 0 {
+		t.Fatalf("wrote %q before the first packet was resolved", written)
+	}
+	if _, err := i.resolve(first.Id, INTERCEPT_ACTION_DROP, nil, write); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 2 || written[0] != "b" || written[1] != "A2'" {
+		t.Fatalf("wrote %q, want b then the edited packet", written)
+	}
+	if _, err := i.resolve(first.Id, INTERCEPT_ACTION_FORWARD, nil, write); err == nil {
+		t.Fatal("resolved a packet twice")
+	}
+}
+
+func TestTCPTransferIntercept(t *testing.T) {
+	rec := NewEventRecorder()
+	transfer := NewTCPTransfer(nil, rec)
+	client, server := startTransfer(t, transfer, FramerConfig{}, FramerConfig{})
+	key := client.LocalAddr().String()
+
+	if err := transfer.SetIntercept("127.0.0.1:1", InterceptSettings{Src: true}); err == nil {
+		t.Fatal("intercepted a session that does not exist")
+	}
+	if err := transfer.SetIntercept(key, InterceptSettings{Src: true}); err != nil {
+		t.Fatal(err)
+	}
+	client.Write([]byte("login"))
+	events, ok := rec.Wait("transfer-intercept-held", 1, time.Second)
+	if !ok {
+		t.Fatal("nothing was held")
+	}
+	silent(t, server)
+
+	held := events[0].Data[1].(InterceptedPacket)
+	if _, err := transfer.InterceptResolve(held.Id, INTERCEPT_ACTION_EDIT, []byte("LOGIN")); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, server, 5); got != "LOGIN" {
+		t.Fatalf("server received %q", got)
+	}
+
+	// turning the direction off forwards what is still held
+	client.Write([]byte("more"))
+	rec.Wait("transfer-intercept-held", 2, time.Second)
+	if err := transfer.SetIntercept(key, InterceptSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, server, 4); got != "more" {
+		t.Fatalf("server received %q", got)
+	}
+}
+
+func TestTransferInterceptInstance(t *testing.T) {
+	rec := NewEventRecorder()
+	m := NewConnManager(NewAppWithSink(rec), &Config{})
+	client, server := startTransfer(t, m.transferInstance("game"), FramerConfig{}, FramerConfig{})
+	key := client.LocalAddr().String()
+
+	if !m.TransferInterceptSetInstance("game", key, InterceptSettings{Dst: true}) {
+		t.Fatalf("intercept failed: %+v", rec.Events("transfer-tcp-error"))
+	}
+	server.Write([]byte("hello"))
+	if _, ok := rec.Wait("transfer-intercept-held", 1, time.Second); !ok {
+		t.Fatal("nothing was held")
+	}
+	packets := m.TransferInterceptListInstance("game", "")
+	if len(packets) != 1 || len(m.TransferInterceptList("")) != 0 {
+		t.Fatalf("held packets %+v", packets)
+	}
+
+	// a failed edit is reported on the session of the packet
+	if m.TransferInterceptEditInstance("game", packets[0].Id, "not base64") {
+		t.Fatal("edited with invalid data")
+	}
+	if errors := rec.Events("transfer-tcp-error"); len(errors) != 1 || errors[0].Data[0] != key || errors[0].Data[2] != "game" {
+		t.Fatalf("errors %+v", errors)
+	}
+	if !m.TransferInterceptEditInstance("game", packets[0].Id, base64.StdEncoding.EncodeToString([]byte("HELLO"))) {
+		t.Fatal("edit failed")
+	}
+	if got := receive(t, client, 5); got != "HELLO" {
+		t.Fatalf("client received %q", got)
+	}
+	if m.TransferInterceptDropInstance("game", packets[0].Id) {
+		t.Fatal("dropped a packet that was already forwarded")
+	}
+}
@@ -0,0 +1,192 @@
+package mircat
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// PROXY_HANDSHAKE_TIMEOUT bounds the SOCKS5 or HTTP CONNECT handshake of a proxied client.
+const PROXY_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+const (
+	socksVersion      = 0x05
+	socksNoAuth       = 0x00
+	socksNoMethod     = 0xFF
+	socksConnect      = 0x01
+	socksIPv4         = 0x01
+	socksDomain       = 0x03
+	socksIPv6         = 0x04
+	socksSucceeded    = 0x00
+	socksRefused      = 0x05
+	socksNotSupported = 0x07
+	socksBadAddress   = 0x08
+)
+
+// targetConn is a client connection of a proxy transfer with the destination it asked for.
+// Its session is keyed by "client->target".
+type targetConn struct {
+	net.Conn
+	target string
+}
+
+// transferKey returns the session key of a client connection of the transfer, the client address,
+// followed by "->" and the destination for proxied clients.
+func transferKey(conn net.Conn) string {
+	if proxied, ok := conn.(*targetConn); ok {
+		return proxied.Conn.RemoteAddr().String() + "->" + proxied.target
+	}
+	return conn.RemoteAddr().String()
+}
+
+// bufferedConn reads what the handshake reader buffered before the rest of the connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// proxyRequest is the destination a proxied client asked for. The client is answered once the
+// destination was dialed, so it sees a refused request rather than a tunnel closing at once.
+type proxyRequest struct {
+	conn   net.Conn
+	target string
+	socks  bool
+}
+
+// accept tells the client its tunnel is open.
+func (r *proxyRequest) accept() error {
+	if r.socks {
+		return socksReply(r.conn, socksSucceeded)
+	}
+	_, err := r.conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	return err
+}
+
+// refuse tells the client its destination could not be reached.
+func (r *proxyRequest) refuse() {
+	if r.socks {
+		socksReply(r.conn, socksRefused)
+		return
+	}
+	r.conn.Write([]byte("HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n"))
+}
+
+// proxyHandshake reads the SOCKS5 or HTTP CONNECT request of a client and returns the connection to relay
+// and the request, to answer with accept or refuse.
+func proxyHandshake(conn net.Conn) (net.Conn, *proxyRequest, error) {
+	conn.SetDeadline(time.Now().Add(PROXY_HANDSHAKE_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+	reader := bufio.NewReader(conn)
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	request := &proxyRequest{conn: conn, socks: first[0] == socksVersion}
+	if request.socks {
+		request.target, err = socksHandshake(conn, reader)
+	} else {
+		request.target, err = connectHandshake(conn, reader)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, request, nil
+	}
+	return conn, request, nil
+}
+
+// socksHandshake reads a SOCKS5 CONNECT request without authentication.
+func socksHandshake(conn net.Conn, reader *bufio.Reader) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksNoMethod)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksNoMethod {
+		return "", fmt.Errorf("socks5 client offers no method without authentication")
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return "", err
+	}
+	if request[1] != socksConnect {
+		socksReply(conn, socksNotSupported)
+		return "", fmt.Errorf("unsupported socks5 command %d", request[1])
+	}
+	var host string
+	switch request[3] {
+	case socksIPv4, socksIPv6:
+		ip := make([]byte, net.IPv4len)
+		if request[3] == socksIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksDomain:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		domain := make([]byte, length)
+		if _, err := io.ReadFull(reader, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksBadAddress)
+		return "", fmt.Errorf("unsupported socks5 address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply answers a SOCKS5 request, the bound address is left empty.
+func socksReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// connectHandshake reads an HTTP CONNECT request.
+func connectHandshake(conn net.Conn, reader *bufio.Reader) (string, error) {
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		return "", err
+	}
+	if request.Method != http.MethodConnect {
+		conn.Write([]byte("HTTP/1.1 405 Method Not Allowed\r\nConnection: close\r\n\r\n"))
+		return "", fmt.Errorf("unsupported http method %s", request.Method)
+	}
+	if _, _, err := net.SplitHostPort(request.Host); err != nil {
+		conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n"))
+		return "", fmt.Errorf("invalid connect target %s", request.Host)
+	}
+	return request.Host, nil
+}
@@ -0,0 +1,198 @@
+package mircat
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func socksRequest(command byte, address ...byte) []byte {
+	return append([]byte{socksVersion, 1, socksNoAuth, socksVersion, command, 0x00}, address...)
+}
+
+func socksAnswer(status byte) []byte {
+	return []byte{socksVersion, status, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0}
+}
+
+func TestProxyHandshake(t *testing.T) {
+	accepted := append([]byte{socksVersion, socksNoAuth}, socksAnswer(socksSucceeded)...)
+	refused := append([]byte{socksVersion, socksNoAuth}, socksAnswer(socksRefused)...)
+	tests := []struct {
+		name    string
+		request []byte
+		refuse  bool
+		target  string
+		socks   bool
+		early   string
+		reply   []byte
+	}{
+		{"socks ipv4", socksRequest(socksConnect, socksIPv4, 10, 0, 0, 1, 0x1B, 0xBC), false, "10.0.0.1:7100", true, "", accepted},
+		{"socks ipv6", socksRequest(socksConnect, append(append([]byte{socksIPv6}, net.IPv6loopback...), 0x00, 0x50)...), false, "[::1]:80", true, "", accepted},
+		{"socks domain", append(socksRequest(socksConnect, socksDomain, 4, 'h', 'o', 's', 't', 0x1B, 0xBC), "early"...), false, "host:7100", true, "early", accepted},
+		{"socks refused", socksRequest(socksConnect, socksIPv4, 10, 0, 0, 1, 0x1B, 0xBC), true, "10.0.0.1:7100", true, "", refused},
+		{"connect", []byte("CONNECT host:7000 HTTP/1.1\r\nHost: host:7000\r\n\r\n"), false, "host:7000", false, "",
+			[]byte("HTTP/1.1 200 Connection established\r\n\r\n")},
+		{"connect early data", []byte("CONNECT host:7000 HTTP/1.1\r\nHost: host:7000\r\n\r\n#1abc!"), false, "host:7000", false, "#1abc!",
+			[]byte("HTTP/1.1 200 Connection established\r\n\r\n")},
+		{"connect refused", []byte("CONNECT host:7000 HTTP/1.1\r\nHost: host:7000\r\n\r\n"), true, "host:7000", false, "",
+			[]byte("HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			reply := make(chan []byte)
+			go client.Write(tt.request)
+			go func() {
+				b, _ := io.ReadAll(client)
+				reply <- b
+			}()
+
+			conn, request, err := proxyHandshake(server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if request.target != tt.target || request.socks != tt.socks {
+				t.Errorf("request %s socks %v, want %s socks %v", request.target, request.socks, tt.target, tt.socks)
+			}
+			if tt.refuse {
+				request.refuse()
+			} else if err := request.accept(); err != nil {
+				t.Fatal(err)
+			}
+			if tt.early != "" {
+				early := make([]byte, len(tt.early))
+				if _, err := io.ReadFull(conn, early); err != nil || string(early) != tt.early {
+					t.Errorf("early data %q %v, want %q", early, err, tt.early)
+				}
+			}
+			server.Close()
+			if got := <-reply; !bytes.Equal(got, tt.reply) {
+				t.Errorf("replied %q, want %q", got, tt.reply)
+			}
+		})
+	}
+}
+
+func TestProxyHandshakeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		request []byte
+		reply   []byte
+	}{
+		{"socks without no-auth method", []byte{socksVersion, 1, 0x02}, []byte{socksVersion, socksNoMethod}},
+		{"socks bind", socksRequest(0x02, socksIPv4, 10, 0, 0, 1, 0, 80),
+			append([]byte{socksVersion, socksNoAuth}, socksAnswer(socksNotSupported)...)},
+		{"socks address type", socksRequest(socksConnect, 0x02, 10, 0, 0, 1, 0, 80),
+			append([]byte{socksVersion, socksNoAuth}, socksAnswer(socksBadAddress)...)},
+		{"http get", []byte("GET / HTTP/1.1\r\nHost: host\r\n\r\n"), []byte("HTTP/1.1 405 Method Not Allowed\r\nConnection: close\r\n\r\n")},
+		{"connect without port", []byte("CONNECT host HTTP/1.1\r\nHost: host\r\n\r\n"), []byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			defer client.Close()
+			reply := make(chan []byte)
+			go client.Write(tt.request)
+			go func() {
+				b, _ := io.ReadAll(client)
+				reply <- b
+			}()
+
+			if _, _, err := proxyHandshake(server); err == nil {
+				t.Fatal("handshake succeeded")
+			}
+			server.Close()
+			if got := <-reply; !bytes.Equal(got, tt.reply) {
+				t.Errorf("replied %q, want %q", got, tt.reply)
+			}
+		})
+	}
+}
+
+func TestTransferKey(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	if key := transferKey(server); key != "pipe" {
+		t.Errorf("key %s, want the client address", key)
+	}
+	if key := transferKey(&targetConn{Conn: server, target: "host:7000"}); key != "pipe->host:7000" {
+		t.Errorf("key %s, want the client address and the target", key)
+	}
+}
+
+// socksTarget is the SOCKS5 address of a loopback IPv4 address.
+func socksTarget(t *testing.T, address string) []byte {
+	t.Helper()
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(append([]byte{socksIPv4}, addr.IP.To4()...), byte(addr.Port>>8), byte(addr.Port))
+}
+
+// echoServer runs a TCP server sending back what it receives and returns its address.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestTCPTransferProxy(t *testing.T) {
+	echo := echoServer(t)
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	unreachable := closed.Addr().String()
+	closed.Close()
+
+	rec := NewEventRecorder()
+	transfer := NewTCPTransfer(nil, rec)
+	transfer.SetProxy(true)
+	if err := transfer.Start("127.0.0.1:0", unreachable, FramerConfig{}, FramerConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	defer transfer.Stop()
+
+	client, err := net.Dial("tcp", transfer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// the client asks for the echo server instead of the dst address
+	client.Write(socksRequest(socksConnect, socksTarget(t, echo)...))
+	if got := receive(t, client, 12); got != string(append([]byte{socksVersion, socksNoAuth}, socksAnswer(socksSucceeded)...)) {
+		t.Fatalf("replied %q", got)
+	}
+	client.Write([]byte("ping"))
+	if got := receive(t, client, 4); got != "ping" {
+		t.Fatalf("received %q", got)
+	}
+	if transfer.getTransferConn(client.LocalAddr().String()+"->"+echo) == nil {
+		t.Fatal("the session is not keyed by the client and its target")
+	}
+
+	refused, err := net.Dial("tcp", transfer.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer refused.Close()
+	refused.Write([]byte("CONNECT " + unreachable + " HTTP/1.1\r\nHost: " + unreachable + "\r\n\r\n"))
+	reply := "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\n"
+	if got := receive(t, refused, len(reply)); got != reply {
+		t.Fatalf("replied %q", got)
+	}
+}
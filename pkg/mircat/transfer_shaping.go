@@ -0,0 +1,175 @@
+package mircat
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// SHAPING_QUEUE_SIZE is the number of chunks a shaped direction buffers before the reading side waits.
+const SHAPING_QUEUE_SIZE = 1024
+
+// ShapingSettings simulate a network condition on one direction of a transfer session.
+type ShapingSettings struct {
+	// Latency is the delay in milliseconds added to every chunk.
+	Latency int `json:"latency"`
+	// Jitter is the maximum random delay in milliseconds added on top of Latency.
+	Jitter int `json:"jitter"`
+	// Bandwidth caps the throughput in bytes per second, 0 leaves it unlimited.
+	Bandwidth int `json:"bandwidth"`
+	// StallRate is the probability, from 0 to 1, that the direction stops for Stall milliseconds before a chunk.
+	StallRate float64 `json:"stallRate"`
+	// Stall is how long in milliseconds a stall lasts.
+	Stall int `json:"stall"`
+	// ResetRate is the probability, from 0 to 1, that the session is closed instead of relaying a chunk.
+	ResetRate float64 `json:"resetRate"`
+}
+
+// TrafficShaping are the network conditions of the two directions of a transfer session.
+type TrafficShaping struct {
+	// Src shapes the traffic sent by the client to the server.
+	Src ShapingSettings `json:"src"`
+	// Dst shapes the traffic sent by the server to the client.
+	Dst ShapingSettings `json:"dst"`
+}
+
+func (s ShapingSettings) active() bool {
+	return s.Latency > 0 || s.Jitter > 0 || s.Bandwidth > 0 || (s.StallRate > 0 && s.Stall > 0) || s.ResetRate > 0
+}
+
+func (s ShapingSettings) check() error {
+	if s.Latency < 0 || s.Jitter < 0 || s.Bandwidth < 0 || s.Stall < 0 {
+		return fmt.Errorf("latency, jitter, bandwidth and stall cannot be negative")
+	}
+	if s.StallRate < 0 || s.StallRate > 1 || s.ResetRate < 0 || s.ResetRate > 1 {
+		return fmt.Errorf("rates must be between 0 and 1")
+	}
+	return nil
+}
+
+func (t TrafficShaping) check() error {
+	if err := t.Src.check(); err != nil {
+		return fmt.Errorf("src: %v", err)
+	}
+	if err := t.Dst.check(); err != nil {
+		return fmt.Errorf("dst: %v", err)
+	}
+	return nil
+}
+
+// direction returns the settings of DIRECTION_SRC or DIRECTION_DST.
+func (t TrafficShaping) direction(direction string) ShapingSettings {
+	if direction == DIRECTION_SRC {
+		return t.Src
+	}
+	return t.Dst
+}
+
+type shapedChunk struct {
+	data []byte
+	at   time.Time
+}
+
+// shapingLane delays the chunks of one direction of a session. Once a direction is shaped, its chunks
+// keep going through the lane even when the settings are cleared, so none overtakes the queued ones.
+type shapingLane struct {
+	mutex    sync.Mutex
+	settings ShapingSettings
+	chunks   chan shapedChunk
+	done     chan struct{}
+	send     func(data []byte)
+	reset    func()
+}
+
+func newShapingLane(settings ShapingSettings, send func(data []byte), reset func()) *shapingLane {
+	l := &shapingLane{
+		settings: settings,
+		chunks:   make(chan shapedChunk, SHAPING_QUEUE_SIZE),
+		done:     make(chan struct{}),
+		send:     send,
+		reset:    reset,
+	}
+	go l.run()
+	return l
+}
+
+func (l *shapingLane) set(settings ShapingSettings) {
+	l.mutex.Lock()
+	l.settings = settings
+	l.mutex.Unlock()
+}
+
+func (l *shapingLane) get() ShapingSettings {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.settings
+}
+
+// push queues a chunk, it waits while the queue is full.
+func (l *shapingLane) push(data []byte) {
+	select {
+	case l.chunks <- shapedChunk{data: data, at: time.Now()}:
+	case <-l.done:
+	}
+}
+
+// stop drops the queued chunks and ends the lane.
+func (l *shapingLane) stop() {
+	close(l.done)
+}
+
+func (l *shapingLane) run() {
+	var busy time.Time
+	for {
+		var chunk shapedChunk
+		select {
+		case chunk = <-l.chunks:
+		case <-l.done:
+			return
+		}
+		settings := l.get()
+		if settings.ResetRate > 0 && rand.Float64() < settings.ResetRate {
+			l.reset()
+			return
+		}
+		if settings.StallRate > 0 && rand.Float64() < settings.StallRate {
+			if !l.sleep(time.Duration(settings.Stall) * time.Millisecond) {
+				return
+			}
+		}
+		delay := time.Duration(settings.Latency) * time.Millisecond
+		if settings.Jitter > 0 {
+			delay += time.Duration(rand.Intn(settings.Jitter+1)) * time.Millisecond
+		}
+		if !l.sleep(time.Until(chunk.at.Add(delay))) {
+			return
+		}
+		if settings.Bandwidth > 0 {
+			// the chunk goes out once the link carried it, after the chunks before it
+			if now := time.Now(); busy.Before(now) {
+				busy = now
+			}
+			busy = busy.Add(time.Duration(len(chunk.data)) * time.Second / time.Duration(settings.Bandwidth))
+			if !l.sleep(time.Until(busy)) {
+				return
+			}
+		}
+		l.send(chunk.data)
+	}
+}
+
+// sleep waits for d and returns false if the lane was stopped meanwhile.
+func (l *shapingLane) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-l.done:
+		return false
+	}
+}
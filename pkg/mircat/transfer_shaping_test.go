@@ -0,0 +1,196 @@
+package mircat
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// sentChunk is a chunk relayed by a lane and when.
+type sentChunk struct {
+	data string
+	at   time.Time
+}
+
+// startLane runs a lane recording what it relays and whether it reset the session.
+func startLane(t *testing.T, settings ShapingSettings) (*shapingLane, chan sentChunk, chan bool) {
+	t.Helper()
+	sent := make(chan sentChunk, 16)
+	reset := make(chan bool, 1)
+	lane := newShapingLane(settings, func(data []byte) {
+		sent <- sentChunk{string(data), time.Now()}
+	}, func() { reset <- true })
+	t.Cleanup(lane.stop)
+	return lane, sent, reset
+}
+
+func next(t *testing.T, sent chan sentChunk) sentChunk {
+	t.Helper()
+	select {
+	case chunk := <-sent:
+		return chunk
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing relayed")
+	}
+	return sentChunk{}
+}
+
+func TestShapingLaneLatency(t *testing.T) {
+	lane, sent, _ := startLane(t, ShapingSettings{Latency: 50, Jitter: 20})
+	start := time.Now()
+	for _, data := range []string{"a", "b", "c"} {
+		lane.push([]byte(data))
+	}
+	// the jitter never reorders the chunks
+	for _, want := range []string{"a", "b", "c"} {
+		chunk := next(t, sent)
+		if chunk.data != want {
+			t.Fatalf("relayed %q, want %q", chunk.data, want)
+		}
+		if elapsed := chunk.at.Sub(start); elapsed < 50*time.Millisecond {
+			t.Fatalf("%s relayed after %v", chunk.data, elapsed)
+		}
+	}
+
+	// cleared settings relay at once, behind the lane
+	lane.set(ShapingSettings{})
+	start = time.Now()
+	lane.push([]byte("d"))
+	if chunk := next(t, sent); chunk.data != "d" || chunk.at.Sub(start) > 40*time.Millisecond {
+		t.Fatalf("relayed %q after %v", chunk.data, chunk.at.Sub(start))
+	}
+}
+
+func TestShapingLaneBandwidth(t *testing.T) {
+	lane, sent, _ := startLane(t, ShapingSettings{Bandwidth: 1000})
+	start := time.Now()
+	lane.push(make([]byte, 50))
+	lane.push(make([]byte, 50))
+	next(t, sent)
+	// the second chunk waits for the first one to go through the link
+	if elapsed := next(t, sent).at.Sub(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("100 bytes at 1000 B/s relayed after %v", elapsed)
+	}
+}
+
+func TestShapingLaneStallAndReset(t *testing.T) {
+	lane, sent, _ := startLane(t, ShapingSettings{StallRate: 1, Stall: 60})
+	start := time.Now()
+	lane.push([]byte("a"))
+	if elapsed := next(t, sent).at.Sub(start); elapsed < 60*time.Millisecond {
+		t.Fatalf("relayed after %v", elapsed)
+	}
+
+	lane, sent, reset := startLane(t, ShapingSettings{ResetRate: 1})
+	lane.push([]byte("a"))
+	select {
+	case <-reset:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reset")
+	}
+	select {
+	case chunk := <-sent:
+		t.Fatalf("relayed %q", chunk.data)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestShapingLaneStopDropsQueue(t *testing.T) {
+	sent := make(chan []byte, 1)
+	lane := newShapingLane(ShapingSettings{Latency: 1000}, func(data []byte) { sent <- data }, func() {})
+	lane.push([]byte("a"))
+	lane.stop()
+	select {
+	case data := <-sent:
+		t.Fatalf("relayed %q", data)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// pushing to a stopped lane does not block
+	lane.push([]byte("b"))
+}
+
+func TestShapingCheck(t *testing.T) {
+	for _, shaping := range []TrafficShaping{
+		{Src: ShapingSettings{Latency: -1}},
+		{Dst: ShapingSettings{Stall: -1}},
+		{Src: ShapingSettings{StallRate: 1.5}},
+		{Dst: ShapingSettings{ResetRate: -0.1}},
+	} {
+		if shaping.check() == nil {
+			t.Errorf("%+v accepted", shaping)
+		}
+	}
+	if (ShapingSettings{StallRate: 1}).active() || !(ShapingSettings{StallRate: 1, Stall: 1}).active() {
+		t.Fatal("a stall without duration is not a condition")
+	}
+}
+
+func TestTransferShaping(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	rec := NewEventRecorder()
+	m := NewConnManager(NewAppWithSink(rec), &Config{Transfers: map[string]TransferConfig{
+		"game": {SrcAddr: "127.0.0.1", SrcPort: "0", DstAddr: host, DstPort: port},
+	}})
+	if m.TransferShapingSetInstance("game", "", TrafficShaping{}) {
+		t.Fatal("shaped an instance that is not running")
+	}
+	if !m.TransferTcpStartInstance("game") {
+		t.Fatalf("start failed: %+v", rec.Events("transfer-tcp-error"))
+	}
+	defer m.TransferTcpStopInstance("game")
+	client, err := net.Dial("tcp", m.runningTransfer("game").Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var server net.Conn
+	select {
+	case server = <-accepted:
+		defer server.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("the transfer did not connect to the server")
+	}
+	key := client.LocalAddr().String()
+	for deadline := time.Now().Add(2 * time.Second); m.runningTransfer("game").getTransferConn(key) == nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the session did not start")
+		}
+	}
+
+	shaping := TrafficShaping{Src: ShapingSettings{Latency: 80}}
+	if !m.TransferShapingSetInstance("game", key, shaping) || m.TransferShapingGetInstance("game", key) != shaping {
+		t.Fatalf("shaping %+v", m.TransferShapingGetInstance("game", key))
+	}
+	// the main transfer and the other sessions keep their conditions
+	if m.TransferShapingGet(key) != (TrafficShaping{}) || m.TransferShapingGetInstance("game", "") != (TrafficShaping{}) {
+		t.Fatal("the shaping leaked to other sessions")
+	}
+	start := time.Now()
+	client.Write([]byte("ping"))
+	if got := receive(t, server, 4); got != "ping" || time.Since(start) < 80*time.Millisecond {
+		t.Fatalf("server received %q after %v", got, time.Since(start))
+	}
+	server.Write([]byte("pong"))
+	if got := receive(t, client, 4); got != "pong" {
+		t.Fatalf("client received %q", got)
+	}
+
+	if m.TransferShapingSetInstance("game", key, TrafficShaping{Dst: ShapingSettings{ResetRate: 2}}) {
+		t.Fatal("set an invalid rate")
+	}
+	errors := rec.Events("transfer-tcp-error")
+	if last := errors[len(errors)-1]; last.Data[len(last.Data)-1] != "game" {
+		t.Fatalf("error %+v", last)
+	}
+}
@@ -0,0 +1,81 @@
+package mircat
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+type UdpClient struct {
+	address    string
+	conn       *net.UDPConn
+	mutex      sync.Mutex
+	isShutdown bool
+	index      int
+	events     EventSink
+}
+
+func NewUdpClient(address string, events EventSink) (*UdpClient, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &UdpClient{
+		address: address,
+		conn:    conn,
+		index:   -1,
+		events:  events,
+	}
+	go c.startReceiving()
+	return c, nil
+}
+
+func (c *UdpClient) startReceiving() {
+	buffer := make([]byte, UDP_BUFFER_SIZE)
+	for {
+		n, err := c.conn.Read(buffer)
+		if err != nil {
+			if c.closed() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// ICMP port unreachable surfaces as a read error, the socket stays usable
+			c.events.Emit("client-udp-error", c.index, fmt.Sprintf("read failed: %v", err))
+			continue
+		}
+		dst := make([]byte, n)
+		copy(dst, buffer[:n])
+		c.events.Emit("client-udp-data", c.index, dst)
+	}
+}
+
+func (c *UdpClient) closed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.isShutdown
+}
+
+func (c *UdpClient) Send(data []byte) {
+	if c.closed() {
+		c.events.Emit("client-udp-error", c.index, "connection closed")
+		return
+	}
+	_, err := c.conn.Write(data)
+	if err != nil {
+		c.events.Emit("client-udp-error", c.index, fmt.Sprintf("send failed: %v", err))
+	}
+}
+
+func (c *UdpClient) Shutdown() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.isShutdown {
+		c.isShutdown = true
+		c.conn.Close()
+		c.events.Emit("client-udp-info", c.index, "connection closed")
+	}
+}
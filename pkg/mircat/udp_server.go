@@ -0,0 +1,167 @@
+package mircat
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// UDP_IDLE_TIMEOUT is how long a UDP peer or relay session is kept without traffic.
+const UDP_IDLE_TIMEOUT = 60 * time.Second
+
+const UDP_BUFFER_SIZE = 65535
+
+type udpPeer struct {
+	addr     *net.UDPAddr
+	lastSeen time.Time
+}
+
+type UDPServer struct {
+	address  string
+	conn     *net.UDPConn
+	peers    map[string]*udpPeer
+	mutex    sync.RWMutex
+	shutdown chan bool
+	events   EventSink
+}
+
+func NewUDPServer(events EventSink) *UDPServer {
+	return &UDPServer{
+		peers:  make(map[string]*udpPeer),
+		events: events,
+	}
+}
+
+func (s *UDPServer) Start(address string) error {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.address = address
+	s.conn = conn
+	s.shutdown = make(chan bool)
+	s.mutex.Unlock()
+	fmt.Printf("Listening on udp %s\n", address)
+
+	go s.expirePeers(s.shutdown)
+	go s.handleConnection(conn)
+	return nil
+}
+
+func (s *UDPServer) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		close(s.shutdown)
+		s.peers = make(map[string]*udpPeer)
+	}
+}
+
+func (s *UDPServer) handleConnection(conn *net.UDPConn) {
+	buffer := make([]byte, UDP_BUFFER_SIZE)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.events.Emit("server-udp-error", "server", fmt.Sprintf("error reading: %v", err))
+			}
+			fmt.Printf("Error reading udp: %s\n", err.Error())
+			return
+		}
+		key := addr.String()
+		s.mutex.Lock()
+		peer, ok := s.peers[key]
+		if !ok {
+			peer = &udpPeer{addr: addr}
+			s.peers[key] = peer
+		}
+		peer.lastSeen = time.Now()
+		s.mutex.Unlock()
+		if !ok {
+			s.events.Emit("server-udp-info", key, fmt.Sprintf("peer joined: %s", key))
+		}
+		message := append([]byte{}, buffer[:n]...)
+		s.events.Emit("server-udp-data", key, message)
+	}
+}
+
+func (s *UDPServer) expirePeers(shutdown chan bool) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdown:
+			return
+		case now := <-ticker.C:
+			expired := []string{}
+			s.mutex.Lock()
+			for key, peer := range s.peers {
+				if now.Sub(peer.lastSeen) > UDP_IDLE_TIMEOUT {
+					delete(s.peers, key)
+					expired = append(expired, key)
+				}
+			}
+			s.mutex.Unlock()
+			for _, key := range expired {
+				s.events.Emit("server-udp-info", key, fmt.Sprintf("peer expired: %s", key))
+			}
+		}
+	}
+}
+
+// Peers returns the addresses of the peers seen within UDP_IDLE_TIMEOUT.
+func (s *UDPServer) Peers() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	peers := make([]string, 0, len(s.peers))
+	for key := range s.peers {
+		peers = append(peers, key)
+	}
+	return peers
+}
+
+func (s *UDPServer) SendMessage(peer string, message []byte) error {
+	s.mutex.RLock()
+	conn := s.conn
+	p, ok := s.peers[peer]
+	s.mutex.RUnlock()
+	if conn == nil {
+		return fmt.Errorf("server not started")
+	}
+	var addr *net.UDPAddr
+	if ok {
+		addr = p.addr
+	} else {
+		var err error
+		addr, err = net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return fmt.Errorf("invalid peer %s: %v", peer, err)
+		}
+	}
+	_, err := conn.WriteToUDP(message, addr)
+	return err
+}
+
+func (s *UDPServer) BroadcastMessage(message []byte) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if s.conn == nil {
+		return
+	}
+	for key, peer := range s.peers {
+		_, err := s.conn.WriteToUDP(message, peer.addr)
+		if err != nil {
+			s.events.Emit("server-udp-error", key, fmt.Sprintf("error broadcasting message to peer %s : %v", key, err))
+			fmt.Printf("Error broadcasting message to peer %s: %s\n", key, err.Error())
+		}
+	}
+}
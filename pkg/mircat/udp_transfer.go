@@ -0,0 +1,209 @@
+package mircat
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// udpSession maps a client address on the listener to its own socket towards
+// the destination, the same way a NAT maps an inside address to an outside port.
+type udpSession struct {
+	clientAddr *net.UDPAddr
+	serverConn *net.UDPConn
+	lastSeen   time.Time
+}
+
+type UDPTransfer struct {
+	srcAddress  string
+	dstAddress  string
+	dstAddr     *net.UDPAddr
+	conn        *net.UDPConn
+	sessions    map[string]*udpSession
+	idleTimeout time.Duration
+	mutex       sync.RWMutex
+	shutdown    chan bool
+	events      EventSink
+}
+
+func NewUDPTransfer(events EventSink) *UDPTransfer {
+	return &UDPTransfer{
+		sessions:    make(map[string]*udpSession),
+		idleTimeout: UDP_IDLE_TIMEOUT,
+		events:      events,
+	}
+}
+
+func (s *UDPTransfer) Start(srcAddress string, dstAddress string, idleTimeout time.Duration) error {
+	srcAddr, err := net.ResolveUDPAddr("udp", srcAddress)
+	if err != nil {
+		return err
+	}
+	dstAddr, err := net.ResolveUDPAddr("udp", dstAddress)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", srcAddr)
+	if err != nil {
+		return err
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = UDP_IDLE_TIMEOUT
+	}
+	s.mutex.Lock()
+	s.srcAddress = srcAddress
+	s.dstAddress = dstAddress
+	s.dstAddr = dstAddr
+	s.conn = conn
+	s.idleTimeout = idleTimeout
+	s.shutdown = make(chan bool)
+	s.mutex.Unlock()
+	fmt.Printf("Listening on udp %s\n", srcAddress)
+
+	go s.expireSessions(s.shutdown)
+	go s.handleClientConnection(conn)
+	return nil
+}
+
+func (s *UDPTransfer) Stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+		close(s.shutdown)
+		for key, session := range s.sessions {
+			session.serverConn.Close()
+			s.events.Emit("transfer-udp-info", key, fmt.Sprintf("close session %s", key))
+		}
+		s.sessions = make(map[string]*udpSession)
+	}
+}
+
+func (s *UDPTransfer) handleClientConnection(conn *net.UDPConn) {
+	buffer := make([]byte, UDP_BUFFER_SIZE)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.events.Emit("transfer-udp-error", "server", fmt.Sprintf("error reading: %v", err))
+			}
+			fmt.Printf("Error reading udp: %s\n", err.Error())
+			return
+		}
+		clientKey := addr.String()
+		session, err := s.session(addr)
+		if err != nil {
+			s.events.Emit("transfer-udp-error", clientKey, fmt.Sprintf("failed to connect to %s: %v", s.dstAddress, err))
+			continue
+		}
+		message := append([]byte{}, buffer[:n]...)
+		s.events.Emit("transfer-udp-src-data", clientKey, message)
+		_, err = session.serverConn.Write(message)
+		if err != nil {
+			s.events.Emit("transfer-udp-error", clientKey, fmt.Sprintf("error forwarding to %s : %v", s.dstAddress, err))
+		}
+	}
+}
+
+// session returns the session of a client address, opening a new socket
+// towards the destination for addresses that have not been seen yet.
+func (s *UDPTransfer) session(addr *net.UDPAddr) (*udpSession, error) {
+	clientKey := addr.String()
+	s.mutex.Lock()
+	session, ok := s.sessions[clientKey]
+	if ok {
+		session.lastSeen = time.Now()
+		s.mutex.Unlock()
+		return session, nil
+	}
+	serverConn, err := net.DialUDP("udp", nil, s.dstAddr)
+	if err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+	session = &udpSession{clientAddr: addr, serverConn: serverConn, lastSeen: time.Now()}
+	s.sessions[clientKey] = session
+	s.mutex.Unlock()
+
+	s.events.Emit("transfer-udp-info", clientKey, fmt.Sprintf("session opened: %s <-> %s", clientKey, serverConn.LocalAddr()))
+	go s.handleServerConnection(clientKey, session)
+	return session, nil
+}
+
+func (s *UDPTransfer) handleServerConnection(clientKey string, session *udpSession) {
+	buffer := make([]byte, UDP_BUFFER_SIZE)
+	for {
+		n, err := session.serverConn.Read(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.events.Emit("transfer-udp-error", clientKey, fmt.Sprintf("error reading from %s : %v", s.dstAddress, err))
+			continue
+		}
+		s.mutex.Lock()
+		session.lastSeen = time.Now()
+		conn := s.conn
+		s.mutex.Unlock()
+		if conn == nil {
+			return
+		}
+		message := append([]byte{}, buffer[:n]...)
+		s.events.Emit("transfer-udp-dst-data", clientKey, message)
+		_, err = conn.WriteToUDP(message, session.clientAddr)
+		if err != nil {
+			s.events.Emit("transfer-udp-error", clientKey, fmt.Sprintf("error forwarding to %s : %v", clientKey, err))
+		}
+	}
+}
+
+func (s *UDPTransfer) expireSessions(shutdown chan bool) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdown:
+			return
+		case now := <-ticker.C:
+			expired := []string{}
+			s.mutex.Lock()
+			for key, session := range s.sessions {
+				if now.Sub(session.lastSeen) > s.idleTimeout {
+					session.serverConn.Close()
+					delete(s.sessions, key)
+					expired = append(expired, key)
+				}
+			}
+			s.mutex.Unlock()
+			for _, key := range expired {
+				s.events.Emit("transfer-udp-info", key, fmt.Sprintf("session expired: %s", key))
+			}
+		}
+	}
+}
+
+func (s *UDPTransfer) SendToServer(client string, message []byte) error {
+	s.mutex.RLock()
+	session, ok := s.sessions[client]
+	s.mutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("client %s not found", client)
+	}
+	_, err := session.serverConn.Write(message)
+	return err
+}
+
+func (s *UDPTransfer) SendToClient(client string, message []byte) error {
+	s.mutex.RLock()
+	session, ok := s.sessions[client]
+	conn := s.conn
+	s.mutex.RUnlock()
+	if !ok || conn == nil {
+		return fmt.Errorf("client %s not found", client)
+	}
+	_, err := conn.WriteToUDP(message, session.clientAddr)
+	return err
+}
@@ -0,0 +1,144 @@
+package mircat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"testing"
+	"time"
+)
+
+// udpEcho answers every datagram with its upper-case copy, and reports the address it came from.
+func udpEcho(t *testing.T) (*net.UDPConn, <-chan string) {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	from := make(chan string, 16)
+	go func() {
+		b := make([]byte, UDP_BUFFER_SIZE)
+		for {
+			n, addr, err := conn.ReadFromUDP(b)
+			if err != nil {
+				return
+			}
+			from <- addr.String()
+			conn.WriteToUDP(bytes.ToUpper(b[:n]), addr)
+		}
+	}()
+	return conn, from
+}
+
+func udpDial(t *testing.T, address string) *net.UDPConn {
+	t.Helper()
+	addr, _ := net.ResolveUDPAddr("udp", address)
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func udpReceive(t *testing.T, conn *net.UDPConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, UDP_BUFFER_SIZE)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b[:n])
+}
+
+func startUDPTransfer(t *testing.T, rec *EventRecorder, idleTimeout time.Duration) (*UDPTransfer, <-chan string) {
+	t.Helper()
+	server, from := udpEcho(t)
+	transfer := NewUDPTransfer(rec)
+	if err := transfer.Start("127.0.0.1:0", server.LocalAddr().String(), idleTimeout); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(transfer.Stop)
+	return transfer, from
+}
+
+func TestUDPTransferSessionPerClient(t *testing.T) {
+	rec := NewEventRecorder()
+	transfer, from := startUDPTransfer(t, rec, 0)
+	first := udpDial(t, transfer.conn.LocalAddr().String())
+	second := udpDial(t, transfer.conn.LocalAddr().String())
+
+	first.Write([]byte("one"))
+	if got := udpReceive(t, first); got != "ONE" {
+		t.Fatalf("first client received %q", got)
+	}
+	second.Write([]byte("two"))
+	if got := udpReceive(t, second); got != "TWO" {
+		t.Fatalf("second client received %q", got)
+	}
+	first.Write([]byte("three"))
+	udpReceive(t, first)
+
+	// like a NAT, each client keeps its own outside port
+	a, b, c := <-from, <-from, <-from
+	if a == b || a != c {
+		t.Fatalf("the server saw %s, %s and %s", a, b, c)
+	}
+	if src := rec.Events("transfer-udp-src-data"); len(src) != 3 || src[0].Data[0] != first.LocalAddr().String() {
+		t.Fatalf("src events %+v", src)
+	}
+
+	if err := transfer.SendToClient(second.LocalAddr().String(), []byte("injected")); err != nil {
+		t.Fatal(err)
+	}
+	if got := udpReceive(t, second); got != "injected" {
+		t.Fatalf("second client received %q", got)
+	}
+}
+
+func TestUDPTransferExpiresIdleSessions(t *testing.T) {
+	rec := NewEventRecorder()
+	transfer, _ := startUDPTransfer(t, rec, 100*time.Millisecond)
+	client := udpDial(t, transfer.conn.LocalAddr().String())
+	client.Write([]byte("hi"))
+	udpReceive(t, client)
+
+	events, ok := rec.Wait("transfer-udp-info", 2, 3*time.Second)
+	if !ok || events[1].Data[1] != "session expired: "+client.LocalAddr().String() {
+		t.Fatalf("info events %+v", events)
+	}
+	if err := transfer.SendToServer(client.LocalAddr().String(), []byte("late")); err == nil {
+		t.Fatal("sent through an expired session")
+	}
+	// the next datagram opens a new session
+	client.Write([]byte("again"))
+	if got := udpReceive(t, client); got != "AGAIN" {
+		t.Fatalf("client received %q", got)
+	}
+}
+
+func TestUdpSendErrorsAreReportedOnce(t *testing.T) {
+	rec := NewEventRecorder()
+	m := NewConnManager(NewAppWithSink(rec), &Config{
+		Server:   ServerConfig{UdpAddr: "127.0.0.1", UdpPort: "0"},
+		Transfer: TransferConfig{UdpSrcAddr: "127.0.0.1", UdpSrcPort: "0", UdpDstAddr: "127.0.0.1", UdpDstPort: "9"},
+	})
+	if !m.ServerUdpStart() || !m.TransferUdpStart() {
+		t.Fatalf("start failed: %+v", rec.Events(""))
+	}
+	defer m.ServerUdpStop()
+	defer m.TransferUdpStop()
+
+	data := base64.StdEncoding.EncodeToString([]byte("x"))
+	m.ServerUdpSendMessage("not an address", data)
+	if errors := rec.Events("server-udp-error"); len(errors) != 1 {
+		t.Fatalf("server errors %+v", errors)
+	}
+	m.TransferUdpSendToServer("127.0.0.1:1", data)
+	m.TransferUdpSendToClient("127.0.0.1:1", data)
+	if errors := rec.Events("transfer-udp-error"); len(errors) != 2 {
+		t.Fatalf("transfer errors %+v", errors)
+	}
+}
@@ -0,0 +1,203 @@
+package mircat
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// UPSTREAM_SOCKS5 is an upstream SOCKS5 proxy.
+	UPSTREAM_SOCKS5 = "socks5"
+	// UPSTREAM_HTTP is an upstream HTTP proxy reached with CONNECT.
+	UPSTREAM_HTTP = "http"
+)
+
+const (
+	socksUserPass    = 0x02
+	socksAuthVersion = 0x01
+)
+
+// UpstreamProxy is a proxy the outbound connections go through.
+type UpstreamProxy struct {
+	// Type is "socks5" or "http".
+	Type string `json:"type"`
+	// Addr is the host:port of the proxy.
+	Addr string `json:"addr"`
+	// Username and Password authenticate to the proxy when Username is set.
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// checkUpstream validates a chain of upstream proxies.
+func checkUpstream(proxies []UpstreamProxy) error {
+	for i, proxy := range proxies {
+		if proxy.Type != UPSTREAM_SOCKS5 && proxy.Type != UPSTREAM_HTTP {
+			return fmt.Errorf("upstream proxy %d: unknown type %q", i+1, proxy.Type)
+		}
+		if _, _, err := net.SplitHostPort(proxy.Addr); err != nil {
+			return fmt.Errorf("upstream proxy %d: %v", i+1, err)
+		}
+		if proxy.Type == UPSTREAM_SOCKS5 && (len(proxy.Username) > 255 || len(proxy.Password) > 255) {
+			return fmt.Errorf("upstream proxy %d: socks5 credentials are limited to 255 bytes", i+1)
+		}
+	}
+	return nil
+}
+
+// dialUpstream connects to address through a chain of proxies, each one reached through the previous ones.
+func dialUpstream(proxies []UpstreamProxy, address string) (net.Conn, error) {
+	if len(proxies) == 0 {
+		return net.DialTimeout("tcp", address, DIAL_TIMEOUT)
+	}
+	conn, err := net.DialTimeout("tcp", proxies[0].Addr, DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	for i, proxy := range proxies {
+		next := address
+		if i+1 < len(proxies) {
+			next = proxies[i+1].Addr
+		}
+		conn, err = proxy.connect(conn, next)
+		if err != nil {
+			return nil, fmt.Errorf("upstream proxy %s: %v", proxy.Addr, err)
+		}
+	}
+	return conn, nil
+}
+
+// connect asks the proxy reached by conn to open a tunnel to address, conn is closed on error.
+func (p UpstreamProxy) connect(conn net.Conn, address string) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(PROXY_HANDSHAKE_TIMEOUT))
+	var err error
+	if p.Type == UPSTREAM_HTTP {
+		conn, err = p.connectHTTP(conn, address)
+	} else {
+		err = p.connectSocks(conn, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func (p UpstreamProxy) connectSocks(conn net.Conn, address string) error {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil {
+		return fmt.Errorf("invalid port %s", portText)
+	}
+	// the lengths are sent in one byte
+	if len(host) > 255 {
+		return fmt.Errorf("host name longer than 255 bytes")
+	}
+	if len(p.Username) > 255 || len(p.Password) > 255 {
+		return fmt.Errorf("credentials longer than 255 bytes")
+	}
+	greeting := []byte{socksVersion, 1, socksNoAuth}
+	if p.Username != "" {
+		greeting = []byte{socksVersion, 2, socksNoAuth, socksUserPass}
+	}
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	switch reply[1] {
+	case socksNoAuth:
+	case socksUserPass:
+		if p.Username == "" {
+			return fmt.Errorf("the proxy asks for a username")
+		}
+		auth := []byte{socksAuthVersion, byte(len(p.Username))}
+		auth = append(auth, p.Username...)
+		auth = append(auth, byte(len(p.Password)))
+		auth = append(auth, p.Password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != socksSucceeded {
+			return fmt.Errorf("authentication failed")
+		}
+	default:
+		return fmt.Errorf("no acceptable authentication method")
+	}
+
+	request := []byte{socksVersion, socksConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		request = append(append(request, socksIPv4), ip.To4()...)
+	} else if ip != nil {
+		request = append(append(request, socksIPv6), ip.To16()...)
+	} else {
+		request = append(append(request, socksDomain, byte(len(host))), host...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != socksSucceeded {
+		return fmt.Errorf("connect to %s refused with status %d", address, header[1])
+	}
+	// skip the bound address and port
+	size := 0
+	switch header[3] {
+	case socksIPv4:
+		size = net.IPv4len
+	case socksIPv6:
+		size = net.IPv6len
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		size = int(length[0])
+	default:
+		return fmt.Errorf("unknown bound address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, size+2))
+	return err
+}
+
+func (p UpstreamProxy) connectHTTP(conn net.Conn, address string) (net.Conn, error) {
+	request := "CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n"
+	if p.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(p.Username + ":" + p.Password))
+		request += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+	if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+		return conn, err
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return conn, err
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("connect to %s refused: %s", address, response.Status)
+	}
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,181 @@
+package mircat
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// socksStub plays a SOCKS5 proxy on conn: it reads a greeting of greetingSize bytes and answers method,
+// reads authSize bytes of credentials when auth is set, reads the request and sends reply. The bytes
+// read are sent on the returned channel once the reply is written.
+func socksStub(conn net.Conn, method byte, authSize int, requestSize int, reply []byte) chan []byte {
+	received := make(chan []byte, 1)
+	go func() {
+		defer close(received)
+		greeting := make([]byte, 3)
+		if method == socksUserPass {
+			greeting = make([]byte, 4)
+		}
+		if _, err := io.ReadFull(conn, greeting); err != nil {
+			return
+		}
+		conn.Write([]byte{socksVersion, method})
+		read := greeting
+		if authSize > 0 {
+			auth := make([]byte, authSize)
+			if _, err := io.ReadFull(conn, auth); err != nil {
+				return
+			}
+			conn.Write([]byte{socksAuthVersion, socksSucceeded})
+			read = append(read, auth...)
+		}
+		request := make([]byte, requestSize)
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		received <- append(read, request...)
+		conn.Write(reply)
+	}()
+	return received
+}
+
+func TestCheckUpstream(t *testing.T) {
+	long := strings.Repeat("u", 256)
+	for _, proxies := range [][]UpstreamProxy{
+		{{Type: "socks4", Addr: "127.0.0.1:1080"}},
+		{{Type: UPSTREAM_HTTP, Addr: "127.0.0.1"}},
+		{{Type: UPSTREAM_SOCKS5, Addr: "127.0.0.1:1080", Username: long}},
+		{{Type: UPSTREAM_SOCKS5, Addr: "127.0.0.1:1080", Username: "u", Password: long}},
+	} {
+		if checkUpstream(proxies) == nil {
+			t.Errorf("%+v accepted", proxies)
+		}
+	}
+	if err := checkUpstream([]UpstreamProxy{{Type: UPSTREAM_HTTP, Addr: "127.0.0.1:8080", Username: long}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpstreamSocks(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	// the proxy answers with a bound domain name, then relays the first bytes of the server
+	reply := append([]byte{socksVersion, socksSucceeded, 0x00, socksDomain, 4, 'h', 'o', 's', 't', 0x1F, 0x90}, "hi"...)
+	received := socksStub(server, socksUserPass, 3+len("user")+len("pass"), 5+len("game.example")+2, reply)
+
+	proxy := UpstreamProxy{Type: UPSTREAM_SOCKS5, Addr: "proxy:1080", Username: "user", Password: "pass"}
+	conn, err := proxy.connect(client, "game.example:7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	want := "\x05\x02\x00\x02" + "\x01\x04user\x04pass" + "\x05\x01\x00\x03\x0cgame.example\x1b\x58"
+	if got := string(<-received); got != want {
+		t.Fatalf("sent %q, want %q", got, want)
+	}
+	if got := receive(t, conn, 2); got != "hi" {
+		t.Fatalf("received %q", got)
+	}
+}
+
+func TestUpstreamSocksErrors(t *testing.T) {
+	ipv4Request := 4 + net.IPv4len + 2
+	tests := []struct {
+		name    string
+		proxy   UpstreamProxy
+		address string
+		stub    bool
+		method  byte
+		reply   []byte
+		err     string
+	}{
+		{"refused", UpstreamProxy{}, "10.0.0.1:7000", true, socksNoAuth,
+			[]byte{socksVersion, socksRefused, 0x00, socksIPv4, 0, 0, 0, 0, 0, 0}, "connect to 10.0.0.1:7000 refused with status 5"},
+		{"bound address type", UpstreamProxy{}, "10.0.0.1:7000", true, socksNoAuth,
+			[]byte{socksVersion, socksSucceeded, 0x00, 0x02, 0, 0, 0, 0, 0, 0}, "unknown bound address type 2"},
+		{"no method", UpstreamProxy{}, "10.0.0.1:7000", true, socksNoMethod, nil, "no acceptable authentication method"},
+		{"long host", UpstreamProxy{}, strings.Repeat("h", 256) + ":7000", false, 0, nil, "host name longer than 255 bytes"},
+		{"long username", UpstreamProxy{Username: strings.Repeat("u", 256)}, "10.0.0.1:7000", false, 0, nil, "credentials longer than 255 bytes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer server.Close()
+			if tt.stub {
+				socksStub(server, tt.method, 0, ipv4Request, tt.reply)
+			}
+			tt.proxy.Type = UPSTREAM_SOCKS5
+			if _, err := tt.proxy.connect(client, tt.address); err == nil || err.Error() != tt.err {
+				t.Fatalf("error %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUpstreamHTTP(t *testing.T) {
+	for _, status := range []string{"200 Connection established", "407 Proxy Authentication Required"} {
+		client, server := net.Pipe()
+		go func() {
+			request, err := http.ReadRequest(bufio.NewReader(server))
+			if err != nil || request.Method != http.MethodConnect || request.Host != "game.example:7000" ||
+				request.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+				server.Close()
+				return
+			}
+			// the first bytes of the server may follow the response at once
+			server.Write([]byte("HTTP/1.1 " + status + "\r\n\r\nhi"))
+		}()
+		proxy := UpstreamProxy{Type: UPSTREAM_HTTP, Addr: "proxy:8080", Username: "user", Password: "pass"}
+		conn, err := proxy.connect(client, "game.example:7000")
+		if strings.HasPrefix(status, "407") {
+			if err == nil || !strings.Contains(err.Error(), "407") {
+				t.Fatalf("error %v", err)
+			}
+			server.Close()
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := receive(t, conn, 2); got != "hi" {
+			t.Fatalf("received %q", got)
+		}
+		conn.Close()
+		server.Close()
+	}
+}
+
+func TestDialUpstreamChain(t *testing.T) {
+	// transfers in proxy mode are the SOCKS5 and HTTP proxies of the chain
+	proxies := []UpstreamProxy{}
+	for _, kind := range []string{UPSTREAM_SOCKS5, UPSTREAM_HTTP} {
+		transfer := NewTCPTransfer(nil, NewEventRecorder())
+		transfer.SetProxy(true)
+		if err := transfer.Start("127.0.0.1:0", "127.0.0.1:1", FramerConfig{}, FramerConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		defer transfer.Stop()
+		proxies = append(proxies, UpstreamProxy{Type: kind, Addr: transfer.Addr().String()})
+	}
+	dialer, err := NewDialer(TLSClientConfig{}, proxies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialer.Dial(echoServer(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	if got := receive(t, conn, 4); got != "ping" {
+		t.Fatalf("received %q", got)
+	}
+
+	if _, err := dialUpstream(proxies, "127.0.0.1:1"); err == nil || !strings.HasPrefix(err.Error(), "upstream proxy "+proxies[1].Addr) {
+		t.Fatalf("error %v", err)
+	}
+}